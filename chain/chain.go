@@ -14,6 +14,7 @@ import (
 
 	"github.com/bittorrent/go-btfs/accounting"
 	"github.com/bittorrent/go-btfs/chain/config"
+	repocommon "github.com/bittorrent/go-btfs/repo/common"
 	"github.com/bittorrent/go-btfs/settlement"
 	"github.com/bittorrent/go-btfs/settlement/swap"
 	"github.com/bittorrent/go-btfs/settlement/swap/bttc"
@@ -141,12 +142,23 @@ func InitChain(
 	return &ChainObject, nil
 }
 
+// PriceOracleCacheTTLConfigKey is the config key holding how long prices and
+// rates from the price oracle are cached, e.g. "1m". "0s" disables the cache.
+const PriceOracleCacheTTLConfigKey = "Internal.PriceOracleCacheTTL"
+
+// PriceOracleCacheTTL reads PriceOracleCacheTTLConfigKey through
+// getConfigKey.
+func PriceOracleCacheTTL(getConfigKey func(string) (interface{}, error)) (time.Duration, error) {
+	return repocommon.DurationConfigKey(getConfigKey, PriceOracleCacheTTLConfigKey, priceoracle.DefaultCacheTTL)
+}
+
 func InitSettlement(
 	ctx context.Context,
 	stateStore storage.StateStorer,
 	chaininfo *ChainInfo,
 	deployGasPrice string,
 	chainID int64,
+	oracleCacheTTL time.Duration,
 ) (*SettleInfo, error) {
 	//InitVaultFactory
 	factory, err := initVaultFactory(chaininfo.Backend, chaininfo.ChainID, chaininfo.TransactionService,
@@ -222,6 +234,7 @@ func InitSettlement(
 		chaininfo.Chainconfig.PriceOracleAddress.String(),
 		chaininfo.ChainID,
 		chaininfo.TransactionService,
+		oracleCacheTTL,
 	)
 
 	if err != nil {
@@ -358,6 +371,7 @@ func initSwap(
 	priceOracleAddress string,
 	chainID int64,
 	transactionService transaction.Service,
+	oracleCacheTTL time.Duration,
 ) (*swap.Service, priceoracle.Service, error) {
 
 	var currentPriceOracleAddress common.Address
@@ -367,7 +381,7 @@ func initSwap(
 		currentPriceOracleAddress = common.HexToAddress(priceOracleAddress)
 	}
 
-	priceOracle := priceoracle.New(currentPriceOracleAddress, transactionService)
+	priceOracle := priceoracle.New(currentPriceOracleAddress, transactionService, oracleCacheTTL)
 	_, err := priceOracle.CheckNewPrice(tokencfg.GetWbttToken()) // CheckNewPrice when node starts
 	if err != nil {
 		return nil, nil, errors.New("CheckNewPrice error, it may happens when contract call failed if bttc chain rpc is down, please try again")
@@ -0,0 +1,364 @@
+package chain
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	config "github.com/bittorrent/go-btfs-config"
+	"github.com/bittorrent/go-btfs/chain/abi"
+	chainconfig "github.com/bittorrent/go-btfs/chain/config"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// FileMetaData describes an added file or directory as recorded in the
+// FileMeta contract. The owner fields are filled from the node identity.
+type FileMetaData struct {
+	FileName string
+	FileExt  string
+	IsDir    bool
+	FileSize int64
+}
+
+// DefaultFileMetaMaxAttempts is the number of times a FileMeta submission
+// is tried before giving up.
+const DefaultFileMetaMaxAttempts = 3
+
+// DefaultFileMetaPollInterval is how often WaitFileMeta polls the chain.
+const DefaultFileMetaPollInterval = 2 * time.Second
+
+// ErrFileMetaReverted is returned by WaitFileMeta when the FileMeta
+// transaction was mined but reverted.
+var ErrFileMetaReverted = errors.New("file meta transaction reverted")
+
+// ErrFileMetaNotFound is returned by GetFileMeta when the FileMeta contract
+// holds nothing for a CID.
+var ErrFileMetaNotFound = errors.New("no file meta recorded for this cid")
+
+// FileMetaStage is a stage of a FileMeta submission.
+type FileMetaStage string
+
+const (
+	FileMetaNonceFetched FileMetaStage = "nonce-fetched"
+	FileMetaSubmitted    FileMetaStage = "submitted"
+	FileMetaConfirmed    FileMetaStage = "confirmed"
+	FileMetaReverted     FileMetaStage = "reverted"
+)
+
+// FileMetaProgress is reported to FileMetaOptions.Progress as a FileMeta
+// submission goes through its stages. Nonce and Attempt are set from
+// FileMetaNonceFetched on, TxHash from FileMetaSubmitted on and BlockNumber,
+// the block the transaction was mined in, by the last two stages.
+type FileMetaProgress struct {
+	Stage       FileMetaStage
+	Attempt     int
+	Nonce       uint64
+	TxHash      common.Hash
+	BlockNumber uint64
+}
+
+// FileMetaOptions controls how SubmitFileMeta sends the transaction.
+type FileMetaOptions struct {
+	// MaxAttempts bounds the number of submissions. The pending nonce is
+	// refreshed before each attempt so that nonce races are recovered.
+	MaxAttempts int
+	// RetryInterval is the initial delay between attempts.
+	RetryInterval time.Duration
+	// GasPrice, in wei, is used as the gas price on legacy chains and as
+	// the max fee per gas on EIP-1559 chains. Nil means suggested by the node.
+	GasPrice *big.Int
+	// GasTipCap, in wei, is the max priority fee per gas on EIP-1559
+	// chains. It is ignored on legacy chains.
+	GasTipCap *big.Int
+	// Progress, if set, is called at each stage of the submission.
+	Progress func(FileMetaProgress)
+	// PollInterval is how often WaitFileMeta polls the chain.
+	PollInterval time.Duration
+}
+
+// GweiToWei converts a positive gwei amount to wei.
+func GweiToWei(gwei float64) (*big.Int, error) {
+	if gwei <= 0 {
+		return nil, fmt.Errorf("gwei amount must be positive, got %v", gwei)
+	}
+	wei, _ := new(big.Float).Mul(big.NewFloat(gwei), big.NewFloat(params.GWei)).Int(nil)
+	return wei, nil
+}
+
+// DefaultFileMetaOptions returns the options used when nil is passed.
+func DefaultFileMetaOptions() *FileMetaOptions {
+	return &FileMetaOptions{
+		MaxAttempts:   DefaultFileMetaMaxAttempts,
+		RetryInterval: backoff.DefaultInitialInterval,
+		PollInterval:  DefaultFileMetaPollInterval,
+	}
+}
+
+func (opts *FileMetaOptions) report(p FileMetaProgress) {
+	if opts.Progress != nil {
+		opts.Progress(p)
+	}
+}
+
+// SubmitFileMeta records the metadata of cid in the FileMeta contract of the
+// configured chain and returns the transaction hash.
+func SubmitFileMeta(ctx context.Context, cfg *config.Config, cid string, data FileMetaData, opts *FileMetaOptions) (common.Hash, error) {
+	cli, err := ethclient.Dial(cfg.ChainInfo.Endpoint)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	defer cli.Close()
+
+	return SubmitFileMetaWithBackend(ctx, cli, cfg, cid, data, opts)
+}
+
+// SubmitFileMetaWithBackend is like SubmitFileMeta but sends the
+// transaction through the given backend.
+func SubmitFileMetaWithBackend(ctx context.Context, backend bind.ContractBackend, cfg *config.Config, cid string, data FileMetaData, opts *FileMetaOptions) (common.Hash, error) {
+	if opts == nil {
+		opts = DefaultFileMetaOptions()
+	}
+	if opts.MaxAttempts < 1 {
+		return common.Hash{}, fmt.Errorf("max attempts must be at least 1, got %d", opts.MaxAttempts)
+	}
+
+	currChainCfg, ok := chainconfig.GetChainConfig(cfg.ChainInfo.ChainId)
+	if !ok {
+		return common.Hash{}, fmt.Errorf("chain %d is not supported yet", cfg.ChainInfo.ChainId)
+	}
+	contr, err := abi.NewFileMeta(currChainCfg.FileMetaAddress, backend)
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	privateKey, err := FileMetaPrivateKey(cfg)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	fromAddress := crypto.PubkeyToAddress(privateKey.PublicKey)
+	meta := abi.FileMetaFileMetaData{
+		OwnerPeerId: cfg.Identity.PeerID,
+		From:        common.HexToAddress(cfg.Identity.BttcAddr),
+		FileName:    data.FileName,
+		FileExt:     data.FileExt,
+		IsDir:       data.IsDir,
+		FileSize:    big.NewInt(data.FileSize),
+	}
+
+	var txHash common.Hash
+	attempt := 0
+	submit := func() error {
+		attempt++
+		nonce, err := backend.PendingNonceAt(ctx, fromAddress)
+		if err != nil {
+			return err
+		}
+		opts.report(FileMetaProgress{Stage: FileMetaNonceFetched, Attempt: attempt, Nonce: nonce})
+		auth, err := bind.NewKeyedTransactorWithChainID(privateKey, big.NewInt(cfg.ChainInfo.ChainId))
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		auth.Context = ctx
+		auth.Nonce = big.NewInt(int64(nonce))
+		auth.Value = big.NewInt(0)
+		if err := setFileMetaGas(ctx, backend, auth, opts); err != nil {
+			return err
+		}
+
+		tx, err := contr.AddFileMeta(auth, cid, meta)
+		if err != nil {
+			log.Warnf("submit file meta for %s, attempt %d/%d: %v", cid, attempt, opts.MaxAttempts, err)
+			return err
+		}
+		txHash = tx.Hash()
+		opts.report(FileMetaProgress{Stage: FileMetaSubmitted, Attempt: attempt, Nonce: nonce, TxHash: txHash})
+		return nil
+	}
+
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = opts.RetryInterval
+	bo.MaxElapsedTime = 0
+	err = backoff.Retry(submit, backoff.WithContext(backoff.WithMaxRetries(bo, uint64(opts.MaxAttempts-1)), ctx))
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("submit file meta failed after %d attempt(s): %w", attempt, err)
+	}
+	return txHash, nil
+}
+
+// GetFileMeta reads the metadata recorded for cid in the FileMeta contract of
+// the configured chain.
+func GetFileMeta(ctx context.Context, cfg *config.Config, cid string) (abi.FileMetaFileMetaData, error) {
+	cli, err := ethclient.Dial(cfg.ChainInfo.Endpoint)
+	if err != nil {
+		return abi.FileMetaFileMetaData{}, err
+	}
+	defer cli.Close()
+
+	return GetFileMetaWithBackend(ctx, cli, cfg, cid)
+}
+
+// GetFileMetaWithBackend is like GetFileMeta but calls the contract through
+// the given backend. The contract returns empty metadata for unknown CIDs,
+// which is reported as ErrFileMetaNotFound.
+func GetFileMetaWithBackend(ctx context.Context, backend bind.ContractCaller, cfg *config.Config, cid string) (abi.FileMetaFileMetaData, error) {
+	currChainCfg, ok := chainconfig.GetChainConfig(cfg.ChainInfo.ChainId)
+	if !ok {
+		return abi.FileMetaFileMetaData{}, fmt.Errorf("chain %d is not supported yet", cfg.ChainInfo.ChainId)
+	}
+	caller, err := abi.NewFileMetaCaller(currChainCfg.FileMetaAddress, backend)
+	if err != nil {
+		return abi.FileMetaFileMetaData{}, err
+	}
+	meta, err := caller.GetFileMeta(&bind.CallOpts{Context: ctx}, cid)
+	if err != nil {
+		return abi.FileMetaFileMetaData{}, err
+	}
+	if meta.OwnerPeerId == "" && meta.From == (common.Address{}) {
+		return abi.FileMetaFileMetaData{}, ErrFileMetaNotFound
+	}
+	return meta, nil
+}
+
+// FileMetaPrivateKey decodes the identity key of cfg, which signs the FileMeta
+// transactions.
+func FileMetaPrivateKey(cfg *config.Config) (*ecdsa.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(cfg.Identity.PrivKey)
+	if err != nil {
+		return nil, fmt.Errorf("identity private key is not valid base64: %w", err)
+	}
+	// skip the 4 bytes protobuf header of the key
+	if len(raw) < 4 {
+		return nil, errors.New("identity private key is not a valid ECDSA key: too short")
+	}
+	key, err := crypto.ToECDSA(raw[4:])
+	if err != nil {
+		return nil, fmt.Errorf("identity private key is not a valid ECDSA key: %w", err)
+	}
+	return key, nil
+}
+
+// FileMetaReceiptBackend is what WaitFileMetaWithBackend needs from the chain.
+type FileMetaReceiptBackend interface {
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+	BlockNumber(ctx context.Context) (uint64, error)
+}
+
+// WaitFileMeta waits for the FileMeta transaction txHash to be mined and
+// confirmed by the given number of blocks, counting the one it was mined in.
+// It returns ErrFileMetaReverted, with the receipt, if the transaction
+// reverted.
+func WaitFileMeta(ctx context.Context, cfg *config.Config, txHash common.Hash, confirmations uint64, opts *FileMetaOptions) (*types.Receipt, error) {
+	cli, err := ethclient.Dial(cfg.ChainInfo.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+
+	return WaitFileMetaWithBackend(ctx, cli, txHash, confirmations, opts)
+}
+
+// WaitFileMetaWithBackend is like WaitFileMeta but polls the given backend.
+func WaitFileMetaWithBackend(ctx context.Context, backend FileMetaReceiptBackend, txHash common.Hash, confirmations uint64, opts *FileMetaOptions) (*types.Receipt, error) {
+	if opts == nil {
+		opts = DefaultFileMetaOptions()
+	}
+	interval := opts.PollInterval
+	if interval <= 0 {
+		interval = DefaultFileMetaPollInterval
+	}
+	wait := func() error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+			return nil
+		}
+	}
+
+	var receipt *types.Receipt
+	for {
+		r, err := backend.TransactionReceipt(ctx, txHash)
+		if err == nil && r != nil {
+			receipt = r
+			break
+		}
+		if err != nil && !errors.Is(err, ethereum.NotFound) {
+			return nil, err
+		}
+		if err := wait(); err != nil {
+			return nil, err
+		}
+	}
+	mined := receipt.BlockNumber.Uint64()
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		opts.report(FileMetaProgress{Stage: FileMetaReverted, TxHash: txHash, BlockNumber: mined})
+		return receipt, ErrFileMetaReverted
+	}
+
+	for confirmations > 1 {
+		head, err := backend.BlockNumber(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if head+1 >= mined+confirmations {
+			break
+		}
+		if err := wait(); err != nil {
+			return nil, err
+		}
+	}
+	opts.report(FileMetaProgress{Stage: FileMetaConfirmed, TxHash: txHash, BlockNumber: mined})
+	return receipt, nil
+}
+
+// setFileMetaGas sets the fee fields of auth that match the fee market of
+// the chain: GasPrice on legacy chains, GasFeeCap and GasTipCap on EIP-1559
+// chains, whose headers carry a base fee. Fees not given in opts are
+// suggested by the backend, with a fee cap of twice the base fee plus the tip.
+func setFileMetaGas(ctx context.Context, backend bind.ContractBackend, auth *bind.TransactOpts, opts *FileMetaOptions) error {
+	head, err := backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if head.BaseFee == nil {
+		if opts.GasTipCap != nil {
+			log.Warnf("chain does not support EIP-1559, ignoring gas tip")
+		}
+		auth.GasPrice = opts.GasPrice
+		if auth.GasPrice == nil {
+			if auth.GasPrice, err = backend.SuggestGasPrice(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tip := opts.GasTipCap
+	if tip == nil {
+		if tip, err = backend.SuggestGasTipCap(ctx); err != nil {
+			return err
+		}
+	}
+	feeCap := opts.GasPrice
+	if feeCap == nil {
+		feeCap = new(big.Int).Add(tip, new(big.Int).Mul(head.BaseFee, big.NewInt(2)))
+	} else if feeCap.Cmp(head.BaseFee) < 0 {
+		log.Warnf("gas price %s wei is below the current base fee %s wei, the transaction may get stuck",
+			feeCap, head.BaseFee)
+	}
+	auth.GasFeeCap = feeCap
+	auth.GasTipCap = tip
+	return nil
+}
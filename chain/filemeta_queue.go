@@ -0,0 +1,279 @@
+package chain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	config "github.com/bittorrent/go-btfs-config"
+	"github.com/bittorrent/go-btfs/transaction/storage"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// FileMetaQueueObject is the queue of the running node, set by the daemon.
+var FileMetaQueueObject *FileMetaQueue
+
+const (
+	fileMetaQueueKeyPrefix = "keyFileMetaQueue-" // + entry id
+
+	// FileMetaQueuePending marks entries waiting to be submitted.
+	FileMetaQueuePending = "pending"
+	// FileMetaQueueFailed marks entries whose last submission failed. They
+	// are only submitted again after a retry.
+	FileMetaQueueFailed = "failed"
+
+	fileMetaQueueInterval = time.Minute
+)
+
+// FileMetaQueueEntry is a FileMeta submission persisted in the state store.
+type FileMetaQueueEntry struct {
+	ID        string
+	Cid       string
+	Data      FileMetaData
+	Status    string
+	Attempts  int
+	LastError string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	// MaxAttempts, GasPrice and GasTipCap are the FileMetaOptions the entry
+	// was enqueued with. Unset ones are taken from the queue defaults.
+	MaxAttempts int      `json:",omitempty"`
+	GasPrice    *big.Int `json:",omitempty"`
+	GasTipCap   *big.Int `json:",omitempty"`
+}
+
+// FileMetaQueue persists FileMeta submissions so that adds don't block on
+// the chain RPC, and drains them in the background.
+type FileMetaQueue struct {
+	store     storage.StateStorer
+	getConfig func() (*config.Config, error)
+	submit    func(ctx context.Context, cfg *config.Config, cid string, data FileMetaData, opts *FileMetaOptions) (common.Hash, error)
+	opts      *FileMetaOptions
+	watcher   *FileMetaWatcher
+
+	// mu serializes updates of entries between the worker and callers.
+	mu sync.Mutex
+	// lastID is the id of the last enqueued entry, ids are kept increasing
+	// even when the clock doesn't move between two entries.
+	lastID int64
+	wake   chan struct{}
+}
+
+// NewFileMetaQueue returns a queue backed by store. getConfig is called
+// before each submission so that config changes are picked up.
+func NewFileMetaQueue(store storage.StateStorer, getConfig func() (*config.Config, error)) *FileMetaQueue {
+	return &FileMetaQueue{
+		store:     store,
+		getConfig: getConfig,
+		submit:    SubmitFileMeta,
+		opts:      DefaultFileMetaOptions(),
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// SetWatcher makes the queue hand the transactions it submits to w, which
+// tracks their confirmation.
+func (q *FileMetaQueue) SetWatcher(w *FileMetaWatcher) {
+	q.watcher = w
+}
+
+func fileMetaQueueKey(id string) string {
+	return fileMetaQueueKeyPrefix + id
+}
+
+// Enqueue persists a pending submission and wakes the worker. The
+// MaxAttempts, GasPrice and GasTipCap of opts, if any, are kept with the entry
+// and used when it is submitted.
+func (q *FileMetaQueue) Enqueue(cid string, data FileMetaData, opts *FileMetaOptions) (*FileMetaQueueEntry, error) {
+	now := time.Now()
+	e := &FileMetaQueueEntry{
+		Cid:       cid,
+		Data:      data,
+		Status:    FileMetaQueuePending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if opts != nil {
+		e.MaxAttempts = opts.MaxAttempts
+		e.GasPrice = opts.GasPrice
+		e.GasTipCap = opts.GasTipCap
+	}
+
+	q.mu.Lock()
+	id := now.UnixNano()
+	if id <= q.lastID {
+		id = q.lastID + 1
+	}
+	q.lastID = id
+	// zero padded so that entries iterate in insertion order
+	e.ID = fmt.Sprintf("%020d", id)
+	err := q.store.Put(fileMetaQueueKey(e.ID), e)
+	q.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	q.notify()
+	return e, nil
+}
+
+// options returns the options e is submitted with.
+func (q *FileMetaQueue) options(e *FileMetaQueueEntry) *FileMetaOptions {
+	opts := *q.opts
+	if e.MaxAttempts > 0 {
+		opts.MaxAttempts = e.MaxAttempts
+	}
+	if e.GasPrice != nil {
+		opts.GasPrice = e.GasPrice
+	}
+	if e.GasTipCap != nil {
+		opts.GasTipCap = e.GasTipCap
+	}
+	return &opts
+}
+
+// List returns all queued entries, oldest first.
+func (q *FileMetaQueue) List() ([]*FileMetaQueueEntry, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.list()
+}
+
+func (q *FileMetaQueue) list() ([]*FileMetaQueueEntry, error) {
+	entries := make([]*FileMetaQueueEntry, 0)
+	err := q.store.Iterate(fileMetaQueueKeyPrefix, func(key, val []byte) (stop bool, err error) {
+		var e FileMetaQueueEntry
+		if err := json.Unmarshal(val, &e); err != nil {
+			return false, err
+		}
+		entries = append(entries, &e)
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+	return entries, nil
+}
+
+// Retry moves failed entries back to pending. An empty id retries all
+// failed entries. It returns the number of entries moved.
+func (q *FileMetaQueue) Retry(id string) (int, error) {
+	q.mu.Lock()
+	entries, err := q.list()
+	if err != nil {
+		q.mu.Unlock()
+		return 0, err
+	}
+	n := 0
+	found := false
+	for _, e := range entries {
+		if id != "" && e.ID != id {
+			continue
+		}
+		found = true
+		if e.Status != FileMetaQueueFailed {
+			continue
+		}
+		e.Status = FileMetaQueuePending
+		e.UpdatedAt = time.Now()
+		if err := q.store.Put(fileMetaQueueKey(e.ID), e); err != nil {
+			q.mu.Unlock()
+			return n, err
+		}
+		n++
+	}
+	q.mu.Unlock()
+
+	if id != "" && !found {
+		return 0, fmt.Errorf("no queued entry with id %s", id)
+	}
+	if n > 0 {
+		q.notify()
+	}
+	return n, nil
+}
+
+func (q *FileMetaQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run drains pending entries until ctx is done. Entries are processed when
+// enqueued or retried, and periodically to pick up anything left over from
+// a previous run.
+func (q *FileMetaQueue) Run(ctx context.Context) {
+	ticker := time.NewTicker(fileMetaQueueInterval)
+	defer ticker.Stop()
+
+	for {
+		q.drain(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (q *FileMetaQueue) drain(ctx context.Context) {
+	entries, err := q.List()
+	if err != nil {
+		log.Errorf("list file meta queue: %v", err)
+		return
+	}
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		if e.Status != FileMetaQueuePending {
+			continue
+		}
+		if err := q.process(ctx, e); err != nil {
+			log.Errorf("update file meta queue entry %s: %v", e.ID, err)
+		}
+	}
+}
+
+// process submits e and removes it on success, or marks it failed.
+func (q *FileMetaQueue) process(ctx context.Context, e *FileMetaQueueEntry) error {
+	cfg, err := q.getConfig()
+	if err == nil {
+		var txHash common.Hash
+		txHash, err = q.submit(ctx, cfg, e.Cid, e.Data, q.options(e))
+		if err == nil {
+			log.Infof("file meta of %s submitted, tx hash: %s", e.Cid, txHash.Hex())
+			if q.watcher != nil {
+				if _, werr := q.watcher.Watch(txHash, e.Cid, 1); werr != nil {
+					log.Errorf("watch file meta transaction %s: %v", txHash.Hex(), werr)
+				}
+			}
+		}
+	}
+	if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+		// shutting down, leave the entry pending
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err == nil {
+		return q.store.Delete(fileMetaQueueKey(e.ID))
+	}
+	e.Attempts++
+	e.Status = FileMetaQueueFailed
+	e.LastError = err.Error()
+	e.UpdatedAt = time.Now()
+	return q.store.Put(fileMetaQueueKey(e.ID), e)
+}
@@ -0,0 +1,130 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	config "github.com/bittorrent/go-btfs-config"
+	"github.com/bittorrent/go-btfs/statestore/mock"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestFileMetaQueue(t *testing.T) {
+	q := NewFileMetaQueue(mock.NewStateStore(), func() (*config.Config, error) {
+		return &config.Config{}, nil
+	})
+	fail := true
+	var submitted []string
+	q.submit = func(ctx context.Context, cfg *config.Config, cid string, data FileMetaData, opts *FileMetaOptions) (common.Hash, error) {
+		if fail {
+			return common.Hash{}, errors.New("rpc unavailable")
+		}
+		submitted = append(submitted, cid)
+		return common.Hash{}, nil
+	}
+
+	for _, cid := range []string{"QmA", "QmB"} {
+		if _, err := q.Enqueue(cid, FileMetaData{FileName: cid}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	q.drain(ctx)
+	entries, err := q.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	for _, e := range entries {
+		if e.Status != FileMetaQueueFailed || e.Attempts != 1 || e.LastError == "" {
+			t.Fatalf("expected failed entry after one attempt, got %+v", e)
+		}
+	}
+
+	// failed entries are not submitted again until retried
+	fail = false
+	q.drain(ctx)
+	if len(submitted) != 0 {
+		t.Fatalf("expected no submission before retry, got %v", submitted)
+	}
+
+	n, err := q.Retry(entries[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 retried entry, got %d", n)
+	}
+	q.drain(ctx)
+	if len(submitted) != 1 || submitted[0] != "QmA" {
+		t.Fatalf("expected QmA to be submitted, got %v", submitted)
+	}
+
+	if n, err = q.Retry(""); err != nil || n != 1 {
+		t.Fatalf("expected 1 retried entry, got %d (%v)", n, err)
+	}
+	q.drain(ctx)
+	entries, err = q.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected empty queue, got %d entries", len(entries))
+	}
+
+	if _, err := q.Retry("unknown"); err == nil {
+		t.Fatal("expected error for unknown id")
+	}
+}
+
+func TestFileMetaQueueOptions(t *testing.T) {
+	q := NewFileMetaQueue(mock.NewStateStore(), func() (*config.Config, error) {
+		return &config.Config{}, nil
+	})
+	got := make(map[string]*FileMetaOptions)
+	q.submit = func(ctx context.Context, cfg *config.Config, cid string, data FileMetaData, opts *FileMetaOptions) (common.Hash, error) {
+		got[cid] = opts
+		return common.Hash{}, nil
+	}
+
+	opts := DefaultFileMetaOptions()
+	opts.MaxAttempts = 7
+	opts.GasPrice = big.NewInt(2000000000)
+	opts.GasTipCap = big.NewInt(1000000000)
+	// enqueued at once, the entries must not overwrite each other
+	ids := make(map[string]bool)
+	for _, cid := range []string{"QmA", "QmB", "QmC"} {
+		o := opts
+		if cid == "QmC" {
+			o = nil
+		}
+		e, err := q.Enqueue(cid, FileMetaData{FileName: cid}, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[e.ID] = true
+	}
+	if len(ids) != 3 {
+		t.Fatalf("expected 3 distinct ids, got %v", ids)
+	}
+
+	q.drain(context.Background())
+	if len(got) != 3 {
+		t.Fatalf("expected 3 submissions, got %d", len(got))
+	}
+	for _, cid := range []string{"QmA", "QmB"} {
+		o := got[cid]
+		if o.MaxAttempts != 7 || o.GasPrice.Cmp(opts.GasPrice) != 0 || o.GasTipCap.Cmp(opts.GasTipCap) != 0 {
+			t.Fatalf("%s: expected the options it was enqueued with, got %+v", cid, o)
+		}
+	}
+	if o := got["QmC"]; o.MaxAttempts != DefaultFileMetaMaxAttempts || o.GasPrice != nil || o.GasTipCap != nil {
+		t.Fatalf("QmC: expected the default options, got %+v", o)
+	}
+}
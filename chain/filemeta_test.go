@@ -0,0 +1,440 @@
+package chain_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	config "github.com/bittorrent/go-btfs-config"
+	"github.com/bittorrent/go-btfs/chain"
+	"github.com/bittorrent/go-btfs/chain/abi"
+	"github.com/bittorrent/go-btfs/transaction/backendmock"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const testFileMetaChainID = 1029
+
+func testFileMetaConfig(t *testing.T) *config.Config {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The identity key is stored with a 4 byte protobuf header.
+	raw := append([]byte{0x08, 0x02, 0x12, 0x20}, crypto.FromECDSA(key)...)
+	cfg := &config.Config{}
+	cfg.Identity.PeerID = "16Uiu2HAmTestPeer"
+	cfg.Identity.PrivKey = base64.StdEncoding.EncodeToString(raw)
+	cfg.Identity.BttcAddr = crypto.PubkeyToAddress(key.PublicKey).Hex()
+	cfg.ChainInfo.ChainId = testFileMetaChainID
+	return cfg
+}
+
+func TestSubmitFileMeta(t *testing.T) {
+	cfg := testFileMetaConfig(t)
+	data := chain.FileMetaData{
+		FileName: "file.txt",
+		FileExt:  ".txt",
+		FileSize: 42,
+	}
+
+	var sent *types.Transaction
+	backend := backendmock.New(
+		backendmock.WithHeaderbyNumberFunc(func(ctx context.Context, number *big.Int) (*types.Header, error) {
+			return &types.Header{}, nil
+		}),
+		backendmock.WithPendingNonceAtFunc(func(ctx context.Context, account common.Address) (uint64, error) {
+			return 7, nil
+		}),
+		backendmock.WithSuggestGasPriceFunc(func(ctx context.Context) (*big.Int, error) {
+			return big.NewInt(1), nil
+		}),
+		backendmock.WithPendingCodeAtFunc(func(ctx context.Context, account common.Address) ([]byte, error) {
+			return []byte{1}, nil
+		}),
+		backendmock.WithEstimateGasFunc(func(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+			return 100000, nil
+		}),
+		backendmock.WithSendTransactionFunc(func(ctx context.Context, tx *types.Transaction) error {
+			sent = tx
+			return nil
+		}),
+	)
+
+	txHash, err := chain.SubmitFileMetaWithBackend(context.Background(), backend, cfg, "QmTest", data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent == nil {
+		t.Fatal("expected a transaction to be sent")
+	}
+	if txHash != sent.Hash() {
+		t.Fatalf("expected tx hash %s, got %s", sent.Hash(), txHash)
+	}
+	if sent.Nonce() != 7 {
+		t.Fatalf("expected nonce 7, got %d", sent.Nonce())
+	}
+
+	parsed, err := abi.FileMetaMetaData.GetAbi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	args, err := parsed.Methods["AddFileMeta"].Inputs.Unpack(sent.Data()[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args[0].(string) != "QmTest" {
+		t.Fatalf("expected cid QmTest, got %v", args[0])
+	}
+}
+
+func TestSubmitFileMetaSendError(t *testing.T) {
+	cfg := testFileMetaConfig(t)
+	sendErr := errors.New("send failed")
+	sends := 0
+	backend := backendmock.New(
+		backendmock.WithHeaderbyNumberFunc(func(ctx context.Context, number *big.Int) (*types.Header, error) {
+			return &types.Header{}, nil
+		}),
+		backendmock.WithPendingNonceAtFunc(func(ctx context.Context, account common.Address) (uint64, error) {
+			return 0, nil
+		}),
+		backendmock.WithSuggestGasPriceFunc(func(ctx context.Context) (*big.Int, error) {
+			return big.NewInt(1), nil
+		}),
+		backendmock.WithPendingCodeAtFunc(func(ctx context.Context, account common.Address) ([]byte, error) {
+			return []byte{1}, nil
+		}),
+		backendmock.WithEstimateGasFunc(func(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+			return 100000, nil
+		}),
+		backendmock.WithSendTransactionFunc(func(ctx context.Context, tx *types.Transaction) error {
+			sends++
+			return sendErr
+		}),
+	)
+
+	opts := &chain.FileMetaOptions{MaxAttempts: 3, RetryInterval: time.Millisecond}
+	_, err := chain.SubmitFileMetaWithBackend(context.Background(), backend, cfg, "QmTest", chain.FileMetaData{}, opts)
+	if !errors.Is(err, sendErr) {
+		t.Fatalf("expected %v, got %v", sendErr, err)
+	}
+	if sends != 3 {
+		t.Fatalf("expected 3 attempts, got %d", sends)
+	}
+}
+
+func TestSubmitFileMetaRetryRefreshesNonce(t *testing.T) {
+	cfg := testFileMetaConfig(t)
+	nonce := uint64(7)
+	var nonces []uint64
+	backend := backendmock.New(
+		backendmock.WithHeaderbyNumberFunc(func(ctx context.Context, number *big.Int) (*types.Header, error) {
+			return &types.Header{}, nil
+		}),
+		backendmock.WithPendingNonceAtFunc(func(ctx context.Context, account common.Address) (uint64, error) {
+			return nonce, nil
+		}),
+		backendmock.WithSuggestGasPriceFunc(func(ctx context.Context) (*big.Int, error) {
+			return big.NewInt(1), nil
+		}),
+		backendmock.WithPendingCodeAtFunc(func(ctx context.Context, account common.Address) ([]byte, error) {
+			return []byte{1}, nil
+		}),
+		backendmock.WithEstimateGasFunc(func(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+			return 100000, nil
+		}),
+		backendmock.WithSendTransactionFunc(func(ctx context.Context, tx *types.Transaction) error {
+			nonces = append(nonces, tx.Nonce())
+			if len(nonces) == 1 {
+				// another transaction took the nonce in the meantime
+				nonce++
+				return errors.New("nonce too low")
+			}
+			return nil
+		}),
+	)
+
+	opts := &chain.FileMetaOptions{MaxAttempts: 3, RetryInterval: time.Millisecond}
+	if _, err := chain.SubmitFileMetaWithBackend(context.Background(), backend, cfg, "QmTest", chain.FileMetaData{}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(nonces) != 2 || nonces[0] != 7 || nonces[1] != 8 {
+		t.Fatalf("expected nonces [7 8], got %v", nonces)
+	}
+}
+
+func TestSubmitFileMetaUnsupportedChain(t *testing.T) {
+	cfg := testFileMetaConfig(t)
+	cfg.ChainInfo.ChainId = 424242
+
+	_, err := chain.SubmitFileMetaWithBackend(context.Background(), backendmock.New(), cfg, "QmTest", chain.FileMetaData{}, nil)
+	if err == nil {
+		t.Fatal("expected error for unsupported chain")
+	}
+}
+
+func TestSubmitFileMetaGas(t *testing.T) {
+	price := big.NewInt(50)
+	tip := big.NewInt(2)
+
+	for _, c := range []struct {
+		name    string
+		baseFee *big.Int
+	}{
+		{"legacy", nil},
+		{"eip1559", big.NewInt(10)},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := testFileMetaConfig(t)
+			var sent *types.Transaction
+			backend := backendmock.New(
+				backendmock.WithHeaderbyNumberFunc(func(ctx context.Context, number *big.Int) (*types.Header, error) {
+					return &types.Header{BaseFee: c.baseFee}, nil
+				}),
+				backendmock.WithPendingNonceAtFunc(func(ctx context.Context, account common.Address) (uint64, error) {
+					return 0, nil
+				}),
+				backendmock.WithPendingCodeAtFunc(func(ctx context.Context, account common.Address) ([]byte, error) {
+					return []byte{1}, nil
+				}),
+				backendmock.WithEstimateGasFunc(func(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+					return 100000, nil
+				}),
+				backendmock.WithSendTransactionFunc(func(ctx context.Context, tx *types.Transaction) error {
+					sent = tx
+					return nil
+				}),
+			)
+
+			opts := chain.DefaultFileMetaOptions()
+			opts.GasPrice = price
+			opts.GasTipCap = tip
+			if _, err := chain.SubmitFileMetaWithBackend(context.Background(), backend, cfg, "QmTest", chain.FileMetaData{}, opts); err != nil {
+				t.Fatal(err)
+			}
+			if c.baseFee == nil {
+				if sent.Type() != types.LegacyTxType || sent.GasPrice().Cmp(price) != 0 {
+					t.Fatalf("expected legacy tx with gas price %s, got type %d price %s", price, sent.Type(), sent.GasPrice())
+				}
+				return
+			}
+			if sent.Type() != types.DynamicFeeTxType {
+				t.Fatalf("expected dynamic fee tx, got type %d", sent.Type())
+			}
+			if sent.GasFeeCap().Cmp(price) != 0 || sent.GasTipCap().Cmp(tip) != 0 {
+				t.Fatalf("expected fee cap %s and tip %s, got %s and %s", price, tip, sent.GasFeeCap(), sent.GasTipCap())
+			}
+		})
+	}
+}
+
+func TestSubmitFileMetaSuggestedGas(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		baseFee   *big.Int
+		txType    uint8
+		gasPrice  int64
+		gasFeeCap int64
+		gasTipCap int64
+	}{
+		{name: "legacy", txType: types.LegacyTxType, gasPrice: 5},
+		{name: "eip1559", baseFee: big.NewInt(10), txType: types.DynamicFeeTxType, gasFeeCap: 23, gasTipCap: 3},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := testFileMetaConfig(t)
+			var sent *types.Transaction
+			backend := backendmock.New(
+				backendmock.WithHeaderbyNumberFunc(func(ctx context.Context, number *big.Int) (*types.Header, error) {
+					return &types.Header{BaseFee: c.baseFee}, nil
+				}),
+				backendmock.WithSuggestGasPriceFunc(func(ctx context.Context) (*big.Int, error) {
+					return big.NewInt(5), nil
+				}),
+				backendmock.WithSuggestGasTipCapFunc(func(ctx context.Context) (*big.Int, error) {
+					if c.baseFee == nil {
+						return nil, errors.New("method not supported")
+					}
+					return big.NewInt(3), nil
+				}),
+				backendmock.WithPendingNonceAtFunc(func(ctx context.Context, account common.Address) (uint64, error) {
+					return 0, nil
+				}),
+				backendmock.WithPendingCodeAtFunc(func(ctx context.Context, account common.Address) ([]byte, error) {
+					return []byte{1}, nil
+				}),
+				backendmock.WithEstimateGasFunc(func(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+					return 100000, nil
+				}),
+				backendmock.WithSendTransactionFunc(func(ctx context.Context, tx *types.Transaction) error {
+					sent = tx
+					return nil
+				}),
+			)
+
+			if _, err := chain.SubmitFileMetaWithBackend(context.Background(), backend, cfg, "QmTest", chain.FileMetaData{}, nil); err != nil {
+				t.Fatal(err)
+			}
+			if sent.Type() != c.txType {
+				t.Fatalf("expected tx type %d, got %d", c.txType, sent.Type())
+			}
+			if c.txType == types.LegacyTxType {
+				if sent.GasPrice().Int64() != c.gasPrice {
+					t.Fatalf("expected gas price %d, got %s", c.gasPrice, sent.GasPrice())
+				}
+				return
+			}
+			if sent.GasFeeCap().Int64() != c.gasFeeCap || sent.GasTipCap().Int64() != c.gasTipCap {
+				t.Fatalf("expected fee cap %d and tip %d, got %s and %s", c.gasFeeCap, c.gasTipCap, sent.GasFeeCap(), sent.GasTipCap())
+			}
+		})
+	}
+}
+
+func TestGweiToWei(t *testing.T) {
+	wei, err := chain.GweiToWei(1.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wei.Cmp(big.NewInt(1500000000)) != 0 {
+		t.Fatalf("expected 1500000000 wei, got %s", wei)
+	}
+	if _, err := chain.GweiToWei(0); err == nil {
+		t.Fatal("expected error for zero gwei")
+	}
+}
+
+func TestWaitFileMeta(t *testing.T) {
+	txHash := common.HexToHash("0x01")
+	receiptPolls := 0
+	head := uint64(10)
+	backend := backendmock.New(
+		backendmock.WithTransactionReceiptFunc(func(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+			receiptPolls++
+			if receiptPolls < 3 {
+				return nil, ethereum.NotFound
+			}
+			return &types.Receipt{Status: types.ReceiptStatusSuccessful, BlockNumber: big.NewInt(10)}, nil
+		}),
+		backendmock.WithBlockNumberFunc(func(ctx context.Context) (uint64, error) {
+			head++
+			return head, nil
+		}),
+	)
+
+	var stages []chain.FileMetaStage
+	opts := &chain.FileMetaOptions{
+		PollInterval: time.Millisecond,
+		Progress: func(p chain.FileMetaProgress) {
+			stages = append(stages, p.Stage)
+			if p.BlockNumber != 10 {
+				t.Errorf("expected block 10, got %d", p.BlockNumber)
+			}
+		},
+	}
+	if _, err := chain.WaitFileMetaWithBackend(context.Background(), backend, txHash, 4, opts); err != nil {
+		t.Fatal(err)
+	}
+	if head != 13 {
+		t.Fatalf("expected to wait for block 13, got to %d", head)
+	}
+	if len(stages) != 1 || stages[0] != chain.FileMetaConfirmed {
+		t.Fatalf("expected a single confirmed stage, got %v", stages)
+	}
+}
+
+func TestWaitFileMetaReverted(t *testing.T) {
+	backend := backendmock.New(
+		backendmock.WithTransactionReceiptFunc(func(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+			return &types.Receipt{Status: types.ReceiptStatusFailed, BlockNumber: big.NewInt(10)}, nil
+		}),
+	)
+
+	var stages []chain.FileMetaStage
+	opts := &chain.FileMetaOptions{
+		Progress: func(p chain.FileMetaProgress) {
+			stages = append(stages, p.Stage)
+		},
+	}
+	_, err := chain.WaitFileMetaWithBackend(context.Background(), backend, common.HexToHash("0x01"), 1, opts)
+	if !errors.Is(err, chain.ErrFileMetaReverted) {
+		t.Fatalf("expected %v, got %v", chain.ErrFileMetaReverted, err)
+	}
+	if len(stages) != 1 || stages[0] != chain.FileMetaReverted {
+		t.Fatalf("expected a single reverted stage, got %v", stages)
+	}
+}
+
+func TestGetFileMeta(t *testing.T) {
+	cfg := testFileMetaConfig(t)
+	parsed, err := abi.FileMetaMetaData.GetAbi()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := abi.FileMetaFileMetaData{
+		OwnerPeerId: cfg.Identity.PeerID,
+		From:        common.HexToAddress(cfg.Identity.BttcAddr),
+		FileName:    "file.txt",
+		FileExt:     ".txt",
+		FileSize:    big.NewInt(42),
+	}
+	stored := map[string]abi.FileMetaFileMetaData{"QmTest": recorded}
+	backend := backendmock.New(
+		backendmock.WithCallContractFunc(func(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+			args, err := parsed.Methods["GetFileMeta"].Inputs.Unpack(call.Data[4:])
+			if err != nil {
+				return nil, err
+			}
+			meta, ok := stored[args[0].(string)]
+			if !ok {
+				meta = abi.FileMetaFileMetaData{FileSize: big.NewInt(0)}
+			}
+			return parsed.Methods["GetFileMeta"].Outputs.Pack(meta)
+		}),
+	)
+
+	meta, err := chain.GetFileMetaWithBackend(context.Background(), backend, cfg, "QmTest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.OwnerPeerId != recorded.OwnerPeerId || meta.FileName != recorded.FileName || meta.FileSize.Cmp(recorded.FileSize) != 0 {
+		t.Fatalf("unexpected file meta %+v", meta)
+	}
+
+	if _, err := chain.GetFileMetaWithBackend(context.Background(), backend, cfg, "QmUnknown"); !errors.Is(err, chain.ErrFileMetaNotFound) {
+		t.Fatalf("expected %v, got %v", chain.ErrFileMetaNotFound, err)
+	}
+}
+
+func TestFileMetaPrivateKey(t *testing.T) {
+	cfg := testFileMetaConfig(t)
+	if _, err := chain.FileMetaPrivateKey(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name string
+		key  string
+		want string
+	}{
+		{"base64", "not base64!", "not valid base64"},
+		{"short", base64.StdEncoding.EncodeToString([]byte{0x08}), "not a valid ECDSA key"},
+		{"ecdsa", base64.StdEncoding.EncodeToString([]byte{0x08, 0x02, 0x12, 0x20, 0x01}), "not a valid ECDSA key"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			cfg.Identity.PrivKey = c.key
+			_, err := chain.FileMetaPrivateKey(cfg)
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Fatalf("expected an error containing %q, got %v", c.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package chain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	config "github.com/bittorrent/go-btfs-config"
+	"github.com/bittorrent/go-btfs/transaction/storage"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// FileMetaWatcherObject is the watcher of the running node, set by the
+// daemon.
+var FileMetaWatcherObject *FileMetaWatcher
+
+const (
+	fileMetaWatchKeyPrefix = "keyFileMetaWatch-" // + tx hash
+
+	// FileMetaTxPending marks transactions not mined or not confirmed yet.
+	FileMetaTxPending = "pending"
+	// FileMetaTxConfirmed marks transactions mined and confirmed by the
+	// blocks they were watched for.
+	FileMetaTxConfirmed = "confirmed"
+	// FileMetaTxReverted marks transactions mined but reverted.
+	FileMetaTxReverted = "reverted"
+
+	fileMetaWatchInterval = 15 * time.Second
+	// fileMetaWatchRetention is how long confirmed and reverted
+	// transactions can still be queried.
+	fileMetaWatchRetention = 30 * 24 * time.Hour
+)
+
+// ErrFileMetaTxNotWatched is returned by FileMetaWatcher.Status for a
+// transaction it doesn't know of.
+var ErrFileMetaTxNotWatched = errors.New("file meta transaction is not watched")
+
+// FileMetaTxStatus is the confirmation status of a FileMeta transaction,
+// persisted in the state store.
+type FileMetaTxStatus struct {
+	TxHash string
+	Cid    string
+	Status string
+	// BlockNumber is the block the transaction was mined in, 0 until it is.
+	BlockNumber uint64
+	// Confirmations is the number of blocks, counting the one it was mined
+	// in, for the transaction to be confirmed.
+	Confirmations uint64
+	// LastError is the last error checking the transaction on chain.
+	LastError   string
+	SubmittedAt time.Time
+	UpdatedAt   time.Time
+}
+
+// FileMetaWatcher tracks the submitted FileMeta transactions until they are
+// confirmed or reverted, so that adds don't have to wait for them. Pending
+// transactions are persisted and watched again after a restart.
+type FileMetaWatcher struct {
+	store     storage.StateStorer
+	getConfig func() (*config.Config, error)
+	dial      func(cfg *config.Config) (FileMetaReceiptBackend, func(), error)
+
+	// mu serializes updates of statuses between the worker and callers.
+	mu   sync.Mutex
+	wake chan struct{}
+}
+
+// NewFileMetaWatcher returns a watcher backed by store. getConfig is called
+// before each check so that config changes are picked up.
+func NewFileMetaWatcher(store storage.StateStorer, getConfig func() (*config.Config, error)) *FileMetaWatcher {
+	return &FileMetaWatcher{
+		store:     store,
+		getConfig: getConfig,
+		dial: func(cfg *config.Config) (FileMetaReceiptBackend, func(), error) {
+			cli, err := ethclient.Dial(cfg.ChainInfo.Endpoint)
+			if err != nil {
+				return nil, nil, err
+			}
+			return cli, cli.Close, nil
+		},
+		wake: make(chan struct{}, 1),
+	}
+}
+
+func fileMetaWatchKey(txHash common.Hash) string {
+	return fileMetaWatchKeyPrefix + txHash.Hex()
+}
+
+// Watch persists txHash, the FileMeta transaction of cid, as pending until
+// it is confirmed by confirmations blocks, at least 1, or reverted.
+func (w *FileMetaWatcher) Watch(txHash common.Hash, cid string, confirmations uint64) (*FileMetaTxStatus, error) {
+	if confirmations == 0 {
+		confirmations = 1
+	}
+	now := time.Now()
+	s := &FileMetaTxStatus{
+		TxHash:        txHash.Hex(),
+		Cid:           cid,
+		Status:        FileMetaTxPending,
+		Confirmations: confirmations,
+		SubmittedAt:   now,
+		UpdatedAt:     now,
+	}
+
+	w.mu.Lock()
+	err := w.store.Put(fileMetaWatchKey(txHash), s)
+	w.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	w.notify()
+	return s, nil
+}
+
+// Status returns the status of the transaction txHash, or
+// ErrFileMetaTxNotWatched.
+func (w *FileMetaWatcher) Status(txHash common.Hash) (*FileMetaTxStatus, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var s FileMetaTxStatus
+	if err := w.store.Get(fileMetaWatchKey(txHash), &s); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrFileMetaTxNotWatched
+		}
+		return nil, err
+	}
+	return &s, nil
+}
+
+// List returns the statuses of all the watched transactions, oldest first.
+func (w *FileMetaWatcher) List() ([]*FileMetaTxStatus, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.list()
+}
+
+func (w *FileMetaWatcher) list() ([]*FileMetaTxStatus, error) {
+	statuses := make([]*FileMetaTxStatus, 0)
+	err := w.store.Iterate(fileMetaWatchKeyPrefix, func(key, val []byte) (stop bool, err error) {
+		var s FileMetaTxStatus
+		if err := json.Unmarshal(val, &s); err != nil {
+			return false, err
+		}
+		statuses = append(statuses, &s)
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].SubmittedAt.Before(statuses[j].SubmittedAt)
+	})
+	return statuses, nil
+}
+
+func (w *FileMetaWatcher) notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run checks the pending transactions until ctx is done, when one is
+// watched and periodically.
+func (w *FileMetaWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(fileMetaWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		w.poll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll checks every pending transaction once, and forgets the settled ones
+// past their retention.
+func (w *FileMetaWatcher) poll(ctx context.Context) {
+	statuses, err := w.List()
+	if err != nil {
+		log.Errorf("list watched file meta transactions: %v", err)
+		return
+	}
+	pending := make([]*FileMetaTxStatus, 0, len(statuses))
+	for _, s := range statuses {
+		if s.Status == FileMetaTxPending {
+			pending = append(pending, s)
+		} else if time.Since(s.UpdatedAt) > fileMetaWatchRetention {
+			w.mu.Lock()
+			err := w.store.Delete(fileMetaWatchKey(common.HexToHash(s.TxHash)))
+			w.mu.Unlock()
+			if err != nil {
+				log.Errorf("forget file meta transaction %s: %v", s.TxHash, err)
+			}
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	cfg, err := w.getConfig()
+	if err != nil {
+		log.Errorf("watch file meta transactions: %v", err)
+		return
+	}
+	backend, closeBackend, err := w.dial(cfg)
+	if err != nil {
+		log.Errorf("watch file meta transactions: %v", err)
+		return
+	}
+	defer closeBackend()
+	for _, s := range pending {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := w.check(ctx, backend, s); err != nil {
+			log.Errorf("update watched file meta transaction %s: %v", s.TxHash, err)
+		}
+	}
+}
+
+// check updates the status of s from the chain, with the same confirmation
+// rule as WaitFileMeta.
+func (w *FileMetaWatcher) check(ctx context.Context, backend FileMetaReceiptBackend, s *FileMetaTxStatus) error {
+	err := func() error {
+		receipt, err := backend.TransactionReceipt(ctx, common.HexToHash(s.TxHash))
+		if errors.Is(err, ethereum.NotFound) || (err == nil && receipt == nil) {
+			return nil // not mined yet
+		}
+		if err != nil {
+			return err
+		}
+		s.BlockNumber = receipt.BlockNumber.Uint64()
+		if receipt.Status != types.ReceiptStatusSuccessful {
+			s.Status = FileMetaTxReverted
+			log.Warnf("file meta transaction %s of %s reverted in block %d", s.TxHash, s.Cid, s.BlockNumber)
+			return nil
+		}
+		head, err := backend.BlockNumber(ctx)
+		if err != nil {
+			return err
+		}
+		if head+1 >= s.BlockNumber+s.Confirmations {
+			s.Status = FileMetaTxConfirmed
+			log.Infof("file meta transaction %s of %s confirmed in block %d", s.TxHash, s.Cid, s.BlockNumber)
+		}
+		return nil
+	}()
+	if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+		// shutting down, checked again after the restart
+		return nil
+	}
+	s.LastError = ""
+	if err != nil {
+		s.LastError = err.Error()
+	}
+	s.UpdatedAt = time.Now()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.store.Put(fileMetaWatchKey(common.HexToHash(s.TxHash)), s)
+}
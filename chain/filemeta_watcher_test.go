@@ -0,0 +1,93 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	config "github.com/bittorrent/go-btfs-config"
+	"github.com/bittorrent/go-btfs/statestore/mock"
+	"github.com/bittorrent/go-btfs/transaction/backendmock"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestFileMetaWatcher(t *testing.T) {
+	store := mock.NewStateStore()
+	getConfig := func() (*config.Config, error) {
+		return &config.Config{}, nil
+	}
+	okTx, revertedTx := common.HexToHash("0x01"), common.HexToHash("0x02")
+	mined := false
+	head := uint64(10)
+	backend := backendmock.New(
+		backendmock.WithTransactionReceiptFunc(func(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+			switch {
+			case hash == revertedTx:
+				return &types.Receipt{Status: types.ReceiptStatusFailed, BlockNumber: big.NewInt(10)}, nil
+			case !mined:
+				return nil, ethereum.NotFound
+			}
+			return &types.Receipt{Status: types.ReceiptStatusSuccessful, BlockNumber: big.NewInt(10)}, nil
+		}),
+		backendmock.WithBlockNumberFunc(func(ctx context.Context) (uint64, error) {
+			return head, nil
+		}),
+	)
+	newWatcher := func() *FileMetaWatcher {
+		w := NewFileMetaWatcher(store, getConfig)
+		w.dial = func(cfg *config.Config) (FileMetaReceiptBackend, func(), error) {
+			return backend, func() {}, nil
+		}
+		return w
+	}
+
+	w := newWatcher()
+	if _, err := w.Status(okTx); !errors.Is(err, ErrFileMetaTxNotWatched) {
+		t.Fatalf("expected %v, got %v", ErrFileMetaTxNotWatched, err)
+	}
+	if _, err := w.Watch(okTx, "QmA", 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Watch(revertedTx, "QmB", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	w.poll(ctx)
+	expectStatus := func(w *FileMetaWatcher, txHash common.Hash, status string, block uint64) {
+		t.Helper()
+		s, err := w.Status(txHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Status != status || s.BlockNumber != block {
+			t.Fatalf("expected %s in block %d, got %s in block %d", status, block, s.Status, s.BlockNumber)
+		}
+	}
+	expectStatus(w, okTx, FileMetaTxPending, 0)
+	expectStatus(w, revertedTx, FileMetaTxReverted, 10)
+
+	// mined in block 10, confirmed by 3 blocks at block 12
+	mined = true
+	head = 11
+	w.poll(ctx)
+	expectStatus(w, okTx, FileMetaTxPending, 10)
+
+	// pending transactions are watched again after a restart
+	w = newWatcher()
+	head = 12
+	w.poll(ctx)
+	expectStatus(w, okTx, FileMetaTxConfirmed, 10)
+
+	statuses, err := w.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 || statuses[0].Cid != "QmA" || statuses[1].Cid != "QmB" {
+		t.Fatalf("expected QmA and QmB, got %v", statuses)
+	}
+}
@@ -484,8 +484,14 @@ If the user need to start multiple nodes on the same machine, the configuration
 			deployGasPrice = chainInfo.Chainconfig.DeploymentGas
 		}
 
+		oracleCacheTTL, err := chain.PriceOracleCacheTTL(repo.GetConfigKey)
+		if err != nil {
+			return err
+		}
+
 		/*settleinfo*/
-		settleInfo, err := chain.InitSettlement(context.Background(), statestore, chainInfo, deployGasPrice, chainInfo.ChainID)
+		settleInfo, err := chain.InitSettlement(context.Background(), statestore, chainInfo, deployGasPrice, chainInfo.ChainID,
+			oracleCacheTTL)
 		if err != nil {
 			fmt.Println("init settlement err: ", err)
 			if strings.Contains(err.Error(), "insufficient funds") {
@@ -760,6 +766,12 @@ If the user need to start multiple nodes on the same machine, the configuration
 		spin.Hosts(node, env)
 		spin.Contracts(node, req, env, nodepb.ContractStat_HOST.String())
 		spin.RestartFixChequeCashOut()
+
+		chain.FileMetaWatcherObject = chain.NewFileMetaWatcher(statestore, node.Repo.Config)
+		go chain.FileMetaWatcherObject.Run(req.Context)
+		chain.FileMetaQueueObject = chain.NewFileMetaQueue(statestore, node.Repo.Config)
+		chain.FileMetaQueueObject.SetWatcher(chain.FileMetaWatcherObject)
+		go chain.FileMetaQueueObject.Run(req.Context)
 	}
 
 	// Give the user some immediate feedback when they hit C-c
@@ -1,38 +1,43 @@
 package commands
 
 import (
-	"encoding/base64"
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"math/big"
 	"os"
 	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/bittorrent/go-btfs/chain/abi"
-	chainconfig "github.com/bittorrent/go-btfs/chain/config"
+	"github.com/bittorrent/go-btfs/chain"
 	oldcmds "github.com/bittorrent/go-btfs/commands"
+	"github.com/bittorrent/go-btfs/core"
 	"github.com/bittorrent/go-btfs/core/commands/cmdenv"
-	"github.com/ethereum/go-ethereum/accounts/abi/bind"
-	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/crypto"
-	ethCrypto "github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/bittorrent/go-btfs/core/corehttp/remote"
+	"github.com/bittorrent/go-btfs/core/coreunix"
 
 	cmds "github.com/bittorrent/go-btfs-cmds"
 	files "github.com/bittorrent/go-btfs-files"
+	"github.com/bittorrent/go-mfs"
 	coreiface "github.com/bittorrent/interface-go-btfs-core"
 	"github.com/bittorrent/interface-go-btfs-core/options"
 	coreifacePath "github.com/bittorrent/interface-go-btfs-core/path"
+	"github.com/ethereum/go-ethereum/common"
+	cid "github.com/ipfs/go-cid"
+	cidenc "github.com/ipfs/go-cidutil/cidenc"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/libp2p/go-libp2p/core/peer"
 	mh "github.com/multiformats/go-multihash"
 	pb "gopkg.in/cheggaaa/pb.v1"
 )
 
 // ErrDepthLimitExceeded indicates that the max depth has been exceeded.
-var ErrDepthLimitExceeded = fmt.Errorf("depth limit exceeded")
+var ErrDepthLimitExceeded = coreunix.ErrDepthLimitExceeded
 
 type TimeParts struct {
 	t *time.Time
@@ -58,6 +63,84 @@ type AddEvent struct {
 	Size  string `json:",omitempty"`
 	Mode  string `json:",omitempty"`
 	Mtime int64  `json:",omitempty"`
+	// MtimeNsec is the sub-second part of Mtime, when known.
+	MtimeNsec uint32 `json:",omitempty"`
+	// Dedup is only set on the final summary event of --dedup-stats.
+	Dedup *AddDedupStats `json:",omitempty"`
+	// DedupAgainst is only set on the final summary event of
+	// --dedup-against.
+	DedupAgainst *AddDedupAgainst `json:",omitempty"`
+	// Car is only set on the final event of --to-car.
+	Car *AddCarOutput `json:",omitempty"`
+	// Chunker is only set by --hash-all-chunkers, with the chunker Hash
+	// was computed with.
+	Chunker string `json:",omitempty"`
+	// HashFunction is only set by --hash-multi, with the hash function Hash
+	// was computed with.
+	HashFunction string `json:",omitempty"`
+	// Target is only set for symlinks, with the path they point to.
+	Target string `json:",omitempty"`
+	// SHA256 is only set by --record-sha256, with the hex encoded SHA-256
+	// of the content of the file.
+	SHA256 string `json:",omitempty"`
+	// Skipped is set by --skip-pinned on files which weren't read because
+	// their CID is already pinned.
+	Skipped bool `json:",omitempty"`
+	// Mfs is only set by --to-mfs, on the event reporting the MFS path
+	// the added root Name was placed at.
+	Mfs string `json:",omitempty"`
+	// Blockchain is only set on the events reporting the stages of the
+	// --to-blockchain submission of the file meta of Name.
+	Blockchain *AddBlockchainProgress `json:",omitempty"`
+	// Warning is only set on an event sent before the add, when an option
+	// changes the CIDs in a way the user may not expect.
+	Warning string `json:",omitempty"`
+}
+
+// AddBlockchainProgress is a stage of a --to-blockchain submission, one of
+// nonce-fetched, submitted, confirmed or reverted, queued with
+// --blockchain-async, or watched once a running daemon keeps track of the
+// confirmation.
+type AddBlockchainProgress struct {
+	Stage string
+	// QueueID is only set on the queued stage, with the ID of the entry in
+	// the --blockchain-async queue.
+	QueueID     string `json:",omitempty"`
+	Attempt     int    `json:",omitempty"`
+	Nonce       uint64 `json:",omitempty"`
+	TxHash      string `json:",omitempty"`
+	BlockNumber uint64 `json:",omitempty"`
+}
+
+// AddCarOutput describes the CAR file written by --to-car.
+type AddCarOutput struct {
+	Roots []string
+	Path  string
+	Size  int64
+}
+
+// AddManifestEntry describes one added path in the --manifest output.
+type AddManifestEntry struct {
+	Cid    string
+	Size   string `json:",omitempty"`
+	Mode   string `json:",omitempty"`
+	Mtime  int64  `json:",omitempty"`
+	SHA256 string `json:",omitempty"`
+}
+
+// AddDedupStats summarizes how much of an add was already stored.
+type AddDedupStats struct {
+	TotalBlocks uint64
+	NewBlocks   uint64
+	DedupBytes  uint64
+}
+
+// AddDedupAgainst summarizes the leaves of an add left to the peer of
+// --dedup-against.
+type AddDedupAgainst struct {
+	Peer          string
+	SkippedBlocks uint64
+	SkippedBytes  uint64
 }
 
 const (
@@ -67,6 +150,7 @@ const (
 	progressOptionName           = "progress"
 	trickleOptionName            = "trickle"
 	wrapOptionName               = "wrap-with-directory"
+	wrapNameOptionName           = "wrap-name"
 	onlyHashOptionName           = "only-hash"
 	chunkerOptionName            = "chunker"
 	pinOptionName                = "pin"
@@ -81,11 +165,39 @@ const (
 	pubkeyName                   = "public-key"
 	peerIdName                   = "peer-id"
 	pinDurationCountOptionName   = "pin-duration-count"
+	pinDurationRootOnlyName      = "pin-duration-root-only"
 	uploadToBlockchainOptionName = "to-blockchain"
+	blockchainAttemptsOptionName = "to-blockchain-attempts"
+	gasPriceOptionName           = "gas-price"
+	gasTipOptionName             = "gas-tip"
+	blockchainAsyncOptionName    = "blockchain-async"
+	waitConfirmOptionName        = "wait-confirm"
+	toMfsOptionName              = "to-mfs"
+	stdinSizeOptionName          = "stdin-size"
+	manifestOptionName           = "manifest"
+	manifestOutOptionName        = "manifest-out"
 	preserveModeOptionName       = "preserve-mode"
 	preserveMtimeOptionName      = "preserve-mtime"
+	preserveOwnerOptionName      = "preserve-owner"
 	modeOptionName               = "mode"
 	mtimeOptionName              = "mtime"
+	mtimeNsecOptionName          = "mtime-nsec"
+	dedupStatsOptionName         = "dedup-stats"
+	maxDepthOptionName           = "max-depth"
+	excludeOptionName            = "exclude"
+	toCarOptionName              = "to-car"
+	hashAllChunkersOptionName    = "hash-all-chunkers"
+	hashMultiOptionName          = "hash-multi"
+	recordSHA256OptionName       = "record-sha256"
+	skipPinnedOptionName         = "skip-pinned"
+	resumeOptionName             = "resume"
+	expectCidOptionName          = "expect-cid"
+	addConcurrencyOptionName     = "add-concurrency"
+	shardThresholdOptionName     = "shard-threshold"
+	reprovideNowOptionName       = "reprovide-now"
+	provideOptionName            = "provide"
+	dedupAgainstOptionName       = "dedup-against"
+	codecOptionName              = "codec"
 )
 
 const adderOutChanSize = 8
@@ -103,7 +215,18 @@ MerkleDAG.
 
 If the daemon is not running, it will just add locally.
 If the daemon is started later, it will be advertised after a few
-seconds when the reprovider runs.
+seconds when the reprovider runs. With --reprovide-now, the added roots
+are announced to the routing system as soon as the add completes instead.
+It does nothing when the node is offline.
+
+The provide option, '--provide', selects what an online add announces
+as it stores the blocks: 'all' of them, the default, only the 'roots',
+or 'none'. The default can be set in the config, e.g. with
+'btfs config AddProvideStrategy roots'. Announcing only the roots takes
+far fewer DHT records for large adds, but peers can then only find the
+node through the root: a block or subdirectory looked up by its own CID
+isn't found until the reprovider announces it, which depends on
+Reprovider.Strategy ('roots' and 'pinned' only announce pinned content).
 
 The wrap option, '-w', wraps the file (or files, if using the
 recursive option) in a directory. This directory contains only
@@ -120,6 +243,140 @@ You can now refer to the added file in a gateway, like so:
 
   /btfs/QmaG4FuMqEBnQNn3C8XJ5bpW8kLs7zq2ZXgHptJHbKDDVx/example.jpg
 
+The wrapping directory itself is unnamed. With --wrap-name, it is output
+under the given name, and the wrapped entries below it. The name can't hold
+path separators. It doesn't change the CID of the directory:
+
+  > btfs add example.jpg -w --wrap-name=photos
+  added QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH photos/example.jpg
+  added QmaG4FuMqEBnQNn3C8XJ5bpW8kLs7zq2ZXgHptJHbKDDVx photos
+
+Symlinks are added as UnixFS symlink nodes holding the path they point to,
+whether it exists or not, and are never followed inside added directories.
+Symlinks given as arguments are followed only with --dereference-args:
+
+  > btfs add -r site
+  added QmNuBw2Q3bmcH53iBsyXdf4uA8tYpZFLiqvY5PbYs2wZtd site/latest -> v2/index.html
+
+Re-adding a tree which mostly didn't change can reuse the manifest of the
+previous add. Files whose CID in it is already pinned are not read again:
+
+  > btfs add -r --manifest-out site.json site
+  > btfs add -r --skip-pinned site.json site
+  skipped QmNuBw2Q3bmcH53iBsyXdf4uA8tYpZFLiqvY5PbYs2wZtd site/index.html, already pinned
+
+This is only a heuristic: the CID of the manifest is trusted, so a file
+changed since then keeps its old CID. Files with no entry in the manifest, or
+whose CID isn't pinned, are added as usual.
+
+With --encrypt, a file is encrypted for the public key given with
+--public-key, or the one of the peer given with --peer-id, by default the one
+of this node. Both can be repeated to encrypt the file for several
+recipients, any of which can then decrypt it with 'btfs get --decrypt'. The
+file is then encrypted once with a random key, using AES-256-GCM, and that
+key is encrypted with ECIES for each recipient. They are recorded in the
+token metadata of the file as:
+
+  "Envelope": {
+    "Cipher": "aes-256-gcm",
+    "Nonce": "<hex nonce>",
+    "Recipients": [
+      {"PublicKey": "<hex>", "Key": "<ECIES ciphertext>", "Metadata": {<ECIES metadata>}}
+    ]
+  }
+
+where each Key is the ECIES encrypted hex encoded AES key, with the same
+Metadata as a file encrypted for a single recipient. Peer IDs which don't
+embed their public key can't be resolved and fail the add.
+
+When Datastore.StorageQuota is set in the config, e.g. with
+'btfs config Datastore.StorageQuota 50GB', an add fails with "storage quota
+exceeded" once the blocks it stores would take the repo above it. Blocks
+already stored don't count, and --only-hash adds are never refused. The quota
+holds for every block stored, by 'btfs block put', 'btfs dag put', pins and
+bitswap as well. It is read again every 30 seconds, so changing it needs no
+restart.
+
+AddPolicy in the config rejects files before they are stored, e.g. with
+'btfs config --json AddPolicy '{"MaxFileSize": "1GB", "BlockedExtensions":
+[".exe"], "BlockedTypes": ["application/x-msdownload"]}''. Types are detected
+from the first 512 bytes of each file. An add with a rejected file fails with
+"rejected by the add policy". Files whose size isn't known ahead fail once
+they are read past MaxFileSize. The policy is read on every add, and never
+applies to --only-hash adds.
+
+With --resume, the leaves of each added file are checkpointed in the
+datastore every 64 leaves, and an add of the same file which failed or was
+interrupted continues from its last checkpoint: the leaves already stored are
+reused and only the rest of the file is read and chunked. The checkpoint of a
+file is removed once it is added. A file is the same when its path, size and
+modification time are, with the same chunker, layout, raw-leaves and hash
+options. Its content is not compared: a file modified in place without a
+change of size or mtime is added with the start of its previous content, and
+gets a CID matching neither version. Leaves removed by a GC since the
+checkpoint are chunked again. Files streamed without their modification
+time, such as stdin, only match on path and size.
+
+With --expect-cid, the add fails once the root it computes isn't the given
+CID, printing both, e.g. to check that a build reproduces a known CID. The
+CID only matches with the chunker, layout, CID version and hash options it was
+computed with. Add with --only-hash to check without storing anything.
+
+With --preserve-owner, the uid and gid of each added file are recorded in its
+token metadata as {"Owner": {"Uid": 1000, "Gid": 1000}}, for backups to be
+restored with 'btfs get --preserve-owner'. The owner is part of the file, so
+it changes its CID. Only the owners of files are recorded, not those of
+directories or symlinks. The owner is read from the file itself, so it is
+only recorded when adding without a running daemon, which only receives the
+content, mode and mtime of the files. Windows files have no uid and gid, and
+are added with no owner.
+
+Large directories are HAMT sharded when Experimental.ShardingEnabled is set
+in the config, all of them or none, so the same directory gets different
+CIDs on nodes with different configs. With --shard-threshold=<bytes>, the
+directories whose links take that many bytes or more are sharded, and only
+those, whatever the config. The size of a directory is estimated as the sum,
+over its entries, of the length of the name and of the CID. Adding with the
+same threshold gives the same CIDs on every node:
+
+  > btfs add -r --shard-threshold=262144 photos
+
+Directories are rebuilt once added, so the mode and mtime of a directory
+which gets sharded are dropped.
+
+With --add-concurrency, several of the files and directories given are added
+at once, which speeds up adding many independent files. Their output is still
+written in the order they were given, once the ones before are added. This
+only applies when btfs adds the files itself, without a running daemon:
+
+  > btfs add --add-concurrency=8 *.mp4
+
+Files sent to a running daemon are read one after the other from the request,
+so they are added one at a time, and a warning says so.
+
+With --to-blockchain, the file meta of each added root is recorded on-chain
+under its CID. With -w, that root is the wrapping directory, the CID the
+files are retrieved with, so the file meta is recorded for it, as a
+directory, and the CIDs of the wrapped files are not recorded. The wrapping
+directory is named by --wrap-name, or else after the file or directory it
+wraps when there is only one, and left unnamed otherwise:
+
+  > btfs add -w --to-blockchain example.jpg
+  added QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH example.jpg
+  added QmaG4FuMqEBnQNn3C8XJ5bpW8kLs7zq2ZXgHptJHbKDDVx
+
+records QmaG4FuMqEBnQNn3C8XJ5bpW8kLs7zq2ZXgHptJHbKDDVx, a directory named
+example.jpg.
+
+The add returns once the file meta transaction is submitted, unless
+--wait-confirm makes it wait for the transaction to be confirmed. Either way,
+a running daemon watches the transaction, submitted by the add or by the
+--blockchain-async queue, until it is confirmed or reverted, also across
+restarts. 'btfs add filemeta status <tx-hash>' tells where it is at:
+
+  > btfs add filemeta status 0x5c50...e3a1
+  0x5c50...e3a1 (QmaG4FuMqEBnQNn3C8XJ5bpW8kLs7zq2ZXgHptJHbKDDVx): confirmed in block 27190344
+
 The chunker option, '-s', specifies the chunking strategy that dictates
 how to break files into blocks. Blocks with same content can
 be deduplicated. Different chunking strategies will produce different
@@ -133,7 +390,10 @@ specifying buzhash or rabin-[min]-[avg]-[max] (where min/avg/max refer
 to the desired chunk sizes in bytes), e.g. 'rabin-262144-524288-1048576'.
 For replicated files intended for host storage, reed-solomon should be
 used with default settings. It is also supported to customize data and
-parity shards using reed-solomon-[#data]-[#parity]-[size].
+parity shards using reed-solomon-[#data]-[#parity]-[size]. Chunkers
+registered by name with coreunix.RegisterChunker are selected the same way,
+--chunker=[name] or [name]-[params]. An unknown name fails the add with the
+list of available chunkers.
 
 The following examples use very small byte sizes to demonstrate the
 properties of the different chunkers on a small file. You'll likely
@@ -154,13 +414,46 @@ You can now check what blocks have been created by:
   QmerURi9k4XzKCaaPbsK6BL5pMEjF7PGphjDvkkjDtsVf3 868
   QmQB28iwSriSUSMqG2nXDTLtdPHgWb4rebBrU7Q1j4vxPv 338
 
+To compare the CIDs of a file with other implementations, --hash-multi hashes
+it with several hash functions, reading it once, and prints one CID each:
+
+  > btfs add --hash-multi=sha2-256,blake3,sha3-256 btfs-logo.svg
+
+When syncing to a known peer, --dedup-against=<peer-id> asks the peer which
+leaf blocks of the add it already has, and doesn't store those locally. The
+DAG is computed in full and the blocks with links are stored, the skipped
+leaves are fetched from the network when the content is read. The add isn't
+pinned, as pinning would fetch them back. Nothing keeps the peer from
+removing the blocks afterwards, leaving the content incomplete until another
+peer provides them. The peer is asked once for every batch of new leaves,
+which slows down the add by a round trip each, and must run a version
+serving 'btfs block has'.
+
+The codec of the root of an added file follows from the other options: raw
+when the file fits in a single raw leaf, dag-pb otherwise. --codec sets it
+instead. --codec=dag-pb wraps a single raw leaf in a dag-pb node, so that
+the root can hold links, and --codec=raw adds the file as a single raw block.
+A raw root implies --raw-leaves and fails if the file doesn't fit in a single
+chunk. It can't hold UnixFS metadata like mode or modification time, nor
+token metadata, so it can't be used with the options storing them, with
+--encrypt nor with the reed-solomon chunker. The root of a directory is always
+dag-pb:
+
+  > btfs add --codec=raw --chunker=size-1048576 config.json
+
 Finally, a note on hash determinism. While not guaranteed, adding the same
 file/directory with the same flags will almost always result in the same output
 hash. However, almost all of the flags provided by this command (other than pin,
 only-hash, and progress/status related flags) will change the final hash.
+Without --cid-version, the options making the CIDs CIDv1, a --hash other than
+sha2-256, --raw-leaves, --nocopy and --inline, print a warning naming them.
 `,
 	},
 
+	Subcommands: map[string]*cmds.Command{
+		"blockchain-queue": addBlockchainQueueCmd,
+		"filemeta":         addFileMetaCmd,
+	},
 	Arguments: []cmds.Argument{
 		cmds.FileArg("path", true, true, "The path to a file to be added to btfs.").EnableRecursive().EnableStdin(),
 	},
@@ -178,27 +471,110 @@ only-hash, and progress/status related flags) will change the final hash.
 		cmds.BoolOption(trickleOptionName, "t", "Use trickle-dag format for dag generation."),
 		cmds.BoolOption(onlyHashOptionName, "n", "Only chunk and hash - do not write to disk."),
 		cmds.BoolOption(wrapOptionName, "w", "Wrap files with a directory object."),
-		cmds.StringOption(chunkerOptionName, "s", "Chunking algorithm, size-[bytes], rabin-[min]-[avg]-[max], buzhash or reed-solomon-[#data]-[#parity]-[size]").WithDefault("size-262144"),
+		cmds.StringOption(wrapNameOptionName, "Name of the wrapping directory in the output. Requires -w."),
+		cmds.StringOption(chunkerOptionName, "s", "Chunking algorithm, size-[bytes], rabin-[min]-[avg]-[max], buzhash, reed-solomon-[#data]-[#parity]-[size] or a registered chunker").WithDefault("size-262144"),
 		cmds.BoolOption(pinOptionName, "Pin this object when adding.").WithDefault(true),
 		cmds.BoolOption(rawLeavesOptionName, "Use raw blocks for leaf nodes. (experimental)"),
 		cmds.BoolOption(noCopyOptionName, "Add the file using filestore. Implies raw-leaves. (experimental)"),
 		cmds.BoolOption(fstoreCacheOptionName, "Check the filestore for pre-existing blocks. (experimental)"),
 		cmds.IntOption(cidVersionOptionName, "CID version. Defaults to 0 unless an option that depends on CIDv1 is passed. (experimental)"),
 		cmds.StringOption(hashOptionName, "Hash function to use. Implies CIDv1 if not sha2-256. (experimental)").WithDefault("sha2-256"),
+		cmds.StringOption(codecOptionName, "Codec of the root of an added file: dag-pb or raw. A raw root implies raw-leaves and needs the file to fit in a single chunk. (experimental)"),
 		cmds.BoolOption(inlineOptionName, "Inline small blocks into CIDs. (experimental)"),
 		cmds.IntOption(inlineLimitOptionName, "Maximum block size to inline. (experimental)").WithDefault(32),
 		cmds.StringOption(tokenMetaOptionName, "m", "Token metadata in JSON string"),
 		cmds.BoolOption(encryptName, "Encrypt the file."),
-		cmds.StringOption(pubkeyName, "The public key to encrypt the file."),
-		cmds.StringOption(peerIdName, "The peer id to encrypt the file."),
-		cmds.IntOption(pinDurationCountOptionName, "d", "Duration for which the object is pinned in days.").WithDefault(0),
+		cmds.StringsOption(pubkeyName, "The public key to encrypt the file. Can be repeated, with --peer-id as well, to encrypt for several recipients."),
+		cmds.StringsOption(peerIdName, "The peer id to encrypt the file. Can be repeated, with --public-key as well, to encrypt for several recipients."),
+		cmds.IntOption(pinDurationCountOptionName, "d", "Duration for which the object is pinned in days. Requires pinning.").WithDefault(0),
+		cmds.BoolOption(pinDurationRootOnlyName, "Pin only the top-level CID for the pin duration, the blocks below it follow the default GC. Requires --pin-duration-count.").WithDefault(false),
 		cmds.BoolOption(uploadToBlockchainOptionName, "add file meta to blockchain").WithDefault(false),
+		cmds.IntOption(blockchainAttemptsOptionName, "Max attempts to submit file meta to blockchain.").WithDefault(chain.DefaultFileMetaMaxAttempts),
+		cmds.BoolOption(blockchainAsyncOptionName, "Queue the file meta and submit it to blockchain in the background instead of waiting for it. See 'btfs add blockchain-queue'.").WithDefault(false),
+		cmds.UintOption(waitConfirmOptionName, "Wait for the file meta transaction to be confirmed by the given number of blocks and report whether it succeeded or reverted. 0 returns once it is submitted.").WithDefault(uint(0)),
+		cmds.FloatOption(gasPriceOptionName, "Gas price in gwei for the file meta transaction. Used as max fee per gas on EIP-1559 chains."),
+		cmds.FloatOption(gasTipOptionName, "Max priority fee per gas in gwei for the file meta transaction on EIP-1559 chains."),
 		cmds.BoolOption(preserveModeOptionName, "Apply existing POSIX permissions to created UnixFS entries. Disables raw-leaves. (experimental)"),
 		cmds.BoolOption(preserveMtimeOptionName, "Apply existing POSIX modification time to created UnixFS entries. Disables raw-leaves. (experimental)"),
+		cmds.BoolOption(preserveOwnerOptionName, "Record the uid and gid of each added file in its token metadata, restored by 'btfs get --preserve-owner'. Disables raw-leaves. (experimental)"),
 		cmds.UintOption(modeOptionName, "Custom POSIX file mode to store in created UnixFS entries. Disables raw-leaves. (experimental)"),
 		cmds.Int64Option(mtimeOptionName, "Custom POSIX modification time to store in created UnixFS entries (seconds before or after the Unix Epoch). Disables raw-leaves. (experimental)"),
+		cmds.UintOption(mtimeNsecOptionName, "Custom POSIX modification time (optional time fraction in nanoseconds). Requires --mtime. (experimental)"),
+		cmds.IntOption(maxDepthOptionName, "Maximum number of directory levels to descend into when adding recursively. -1 means unlimited.").WithDefault(-1),
+		cmds.StringsOption(excludeOptionName, "Glob pattern of paths, relative to the added root, to skip when adding recursively. Patterns without a slash also match base names. Can be repeated."),
+		cmds.Int64Option(stdinSizeOptionName, "Expected size in bytes of data read from stdin, used to show progress percentage."),
+		cmds.BoolOption(manifestOptionName, "Write a JSON manifest mapping each added path to its CID, size, mode and mtime once the add completes. Replaces the per-file output when written to stdout."),
+		cmds.StringOption(manifestOutOptionName, "Write the manifest to the given file instead of stdout. Implies --manifest."),
+		cmds.BoolOption(dedupStatsOptionName, "Report how many blocks were already stored at the end of the add.").WithDefault(false),
+		cmds.StringOption(dedupAgainstOptionName, "Don't store the leaf blocks this peer already has, asking it before writing them. The add isn't pinned."),
+		cmds.StringOption(toCarOptionName, "Write the added DAG as a CARv2 file to the given path, or to stdout with '-', instead of storing the blocks. Implies --only-hash."),
+		cmds.StringOption(skipPinnedOptionName, "Manifest of a previous add, as written by --manifest-out. Files whose CID in it is already pinned are not read again. The CID is trusted, not checked against the file."),
+		cmds.BoolOption(recordSHA256OptionName, "Output the SHA-256 of the content of each added file, computed while it is read. Also recorded in the manifest.").WithDefault(false),
+		cmds.BoolOption(resumeOptionName, "Checkpoint the add of each file, and continue the add of a file from its last checkpoint.").WithDefault(false),
+		cmds.StringOption(toMfsOptionName, "Place each added root at the given MFS path once added, creating the parents. A path ending with '/' is a directory the roots are placed in by name."),
+		cmds.BoolOption(forceOptionName, "With --to-mfs, replace an existing entry at the MFS path.").WithDefault(false),
+		cmds.StringOption(expectCidOptionName, "Fail unless the added root is this CID. Needs a single root."),
+		cmds.IntOption(shardThresholdOptionName, "HAMT shard the added directories whose links take this many bytes or more, and only those, whatever the sharding config of the node. 0 uses the config. (experimental)").WithDefault(0),
+		cmds.BoolOption(reprovideNowOptionName, "Announce the added roots to the routing system once the add completes, instead of waiting for the reprovider. Does nothing offline.").WithDefault(false),
+		cmds.StringOption(provideOptionName, "Which CIDs of the add to announce to the routing system: all, roots or none. Defaults to the AddProvideStrategy config, or all."),
+		cmds.IntOption(addConcurrencyOptionName, "Number of the given files and directories to add at once. The output stays in the order they were given.").WithDefault(1),
+		cmds.StringOption(hashAllChunkersOptionName, "Comma separated chunkers to hash each file with, reading it only once, e.g. 'size-262144,rabin,buzhash'. Outputs the hash of each chunker. Implies --only-hash."),
+		cmds.StringOption(hashMultiOptionName, "Comma separated hash functions to hash each file with, reading it only once, e.g. 'sha2-256,blake3,sha3-256'. Outputs the hash of each function. Implies --only-hash."),
 	},
 	PreRun: func(req *cmds.Request, env cmds.Environment) error {
+		// Reject bad exclude patterns before the client starts sending files.
+		exclude, _ := req.Options[excludeOptionName].([]string)
+		if err := coreunix.ValidateExcludePatterns(exclude); err != nil {
+			return err
+		}
+		// The key signing the file meta is checked again by Run, the
+		// client may not have the config of a remote daemon.
+		if toBlockchain, _ := req.Options[uploadToBlockchainOptionName].(bool); toBlockchain {
+			if cfg, err := cmdenv.GetConfig(env); err == nil {
+				if _, err := chain.FileMetaPrivateKey(cfg); err != nil {
+					return err
+				}
+			}
+		}
+
+		if stdinSize, ok := req.Options[stdinSizeOptionName].(int64); ok && stdinSize <= 0 {
+			return fmt.Errorf("%s must be positive", stdinSizeOptionName)
+		}
+
+		// the manifest is keyed by path, which all the chunkers share
+		if hashAll, _ := req.Options[hashAllChunkersOptionName].(string); hashAll != "" {
+			if manifest, _ := req.Options[manifestOptionName].(bool); manifest || req.Options[manifestOutOptionName] != nil {
+				return fmt.Errorf("%s can't be used with %s", hashAllChunkersOptionName, manifestOptionName)
+			}
+		}
+		// and so do all the hash functions
+		if hashMulti, _ := req.Options[hashMultiOptionName].(string); hashMulti != "" {
+			if manifest, _ := req.Options[manifestOptionName].(bool); manifest || req.Options[manifestOutOptionName] != nil {
+				return fmt.Errorf("%s can't be used with %s", hashMultiOptionName, manifestOptionName)
+			}
+		}
+
+		// The CAR file is written by the node, resolve it against the
+		// client's working directory.
+		toCar, _ := req.Options[toCarOptionName].(string)
+		if manifest, _ := req.Options[manifestOptionName].(bool); toCar == "-" && manifest && req.Options[manifestOutOptionName] == nil {
+			return fmt.Errorf("%s=- needs %s to write the manifest to a file", toCarOptionName, manifestOutOptionName)
+		}
+		if toCar != "" && toCar != "-" {
+			abs, err := filepath.Abs(toCar)
+			if err != nil {
+				return err
+			}
+			req.Options[toCarOptionName] = abs
+		}
+		if skipPinned, _ := req.Options[skipPinnedOptionName].(string); skipPinned != "" {
+			abs, err := filepath.Abs(skipPinned)
+			if err != nil {
+				return err
+			}
+			req.Options[skipPinnedOptionName] = abs
+		}
+
 		quiet, _ := req.Options[quietOptionName].(bool)
 		quieter, _ := req.Options[quieterOptionName].(bool)
 		quiet = quiet || quieter
@@ -226,6 +602,7 @@ only-hash, and progress/status related flags) will change the final hash.
 		progress, _ := req.Options[progressOptionName].(bool)
 		trickle, _ := req.Options[trickleOptionName].(bool)
 		wrap, _ := req.Options[wrapOptionName].(bool)
+		wrapName, wrapNameSet := req.Options[wrapNameOptionName].(string)
 		hash, _ := req.Options[onlyHashOptionName].(bool)
 		silent, _ := req.Options[silentOptionName].(bool)
 		chunker, _ := req.Options[chunkerOptionName].(string)
@@ -239,20 +616,215 @@ only-hash, and progress/status related flags) will change the final hash.
 		inlineLimit, _ := req.Options[inlineLimitOptionName].(int)
 		tokenMetadata, _ := req.Options[tokenMetaOptionName].(string)
 		encrypt, _ := req.Options[encryptName].(bool)
-		pubkey, _ := req.Options[pubkeyName].(string)
-		peerId, _ := req.Options[peerIdName].(string)
+		pubkeys, _ := req.Options[pubkeyName].([]string)
+		peerIds, _ := req.Options[peerIdName].([]string)
 		pinDuration, _ := req.Options[pinDurationCountOptionName].(int)
+		pinRootOnly, _ := req.Options[pinDurationRootOnlyName].(bool)
 		uploadToBlockchain, _ := req.Options[uploadToBlockchainOptionName].(bool)
+		blockchainAttempts, _ := req.Options[blockchainAttemptsOptionName].(int)
+		blockchainAsync, _ := req.Options[blockchainAsyncOptionName].(bool)
+		waitConfirm, _ := req.Options[waitConfirmOptionName].(uint)
 		preserveMode, _ := req.Options[preserveModeOptionName].(bool)
 		preserveMtime, _ := req.Options[preserveMtimeOptionName].(bool)
+		preserveOwner, _ := req.Options[preserveOwnerOptionName].(bool)
 		mode, _ := req.Options[modeOptionName].(uint)
 		mtime, _ := req.Options[mtimeOptionName].(int64)
+		mtimeNsec, _ := req.Options[mtimeNsecOptionName].(uint)
+		dedupStats, _ := req.Options[dedupStatsOptionName].(bool)
+		maxDepth, _ := req.Options[maxDepthOptionName].(int)
+		exclude, _ := req.Options[excludeOptionName].([]string)
+		toCar, _ := req.Options[toCarOptionName].(string)
+		hashAll, _ := req.Options[hashAllChunkersOptionName].(string)
+		hashMulti, _ := req.Options[hashMultiOptionName].(string)
+		recordSHA256, _ := req.Options[recordSHA256OptionName].(bool)
+		skipPinned, _ := req.Options[skipPinnedOptionName].(string)
+		toMfs, _ := req.Options[toMfsOptionName].(string)
+		force, _ := req.Options[forceOptionName].(bool)
+		resume, _ := req.Options[resumeOptionName].(bool)
+		expectCidStr, _ := req.Options[expectCidOptionName].(string)
+		concurrency, _ := req.Options[addConcurrencyOptionName].(int)
+		shardThreshold, _ := req.Options[shardThresholdOptionName].(int)
+		reprovideNow, _ := req.Options[reprovideNowOptionName].(bool)
+		provideStr, provideSet := req.Options[provideOptionName].(string)
+		dedupAgainstStr, _ := req.Options[dedupAgainstOptionName].(string)
+
+		if preserveOwner {
+			// the owner is token metadata of each file, which these
+			// replace or don't support
+			if tokenMetadata != "" || encrypt || resume || strings.HasPrefix(chunker, "reed-solomon") {
+				return fmt.Errorf("%s can't be used with %s, %s, %s or the reed-solomon chunker", preserveOwnerOptionName,
+					tokenMetaOptionName, encryptName, resumeOptionName)
+			}
+			// files sent to a daemon have no stat to read the owner from
+			if files.IsMultiPartDirectory(req.Files) {
+				return fmt.Errorf("%s only works with files added without a running daemon", preserveOwnerOptionName)
+			}
+		}
+
+		if recordSHA256 && encrypt {
+			// the encrypted content is added in place of the file
+			return fmt.Errorf("%s can't be used with %s", recordSHA256OptionName, encryptName)
+		}
+
+		var expectCid cid.Cid
+		if expectCidStr != "" {
+			if hashAll != "" || hashMulti != "" {
+				return fmt.Errorf("%s can't be used with %s or %s", expectCidOptionName, hashAllChunkersOptionName,
+					hashMultiOptionName)
+			}
+			if expectCid, err = cid.Decode(expectCidStr); err != nil {
+				return fmt.Errorf("%s: %w", expectCidOptionName, err)
+			}
+		}
+
+		var hashChunkers []string
+		if hashAll != "" {
+			if toCar != "" || nocopy || uploadToBlockchain || dedupStats {
+				return fmt.Errorf("%s can't be used with %s, %s, %s or %s", hashAllChunkersOptionName,
+					toCarOptionName, noCopyOptionName, uploadToBlockchainOptionName, dedupStatsOptionName)
+			}
+			if hashChunkers, err = parseHashAllChunkers(hashAll); err != nil {
+				return err
+			}
+			hash = true
+		}
+
+		var hashFunctions []string
+		if hashMulti != "" {
+			if hashAll != "" {
+				return fmt.Errorf("%s can't be used with %s", hashMultiOptionName, hashAllChunkersOptionName)
+			}
+			if toCar != "" || nocopy || uploadToBlockchain || dedupStats {
+				return fmt.Errorf("%s can't be used with %s, %s, %s or %s", hashMultiOptionName,
+					toCarOptionName, noCopyOptionName, uploadToBlockchainOptionName, dedupStatsOptionName)
+			}
+			if hashFunctions, err = parseHashMulti(hashMulti); err != nil {
+				return err
+			}
+			hash = true
+		}
+
+		if toCar != "" {
+			if nocopy {
+				return fmt.Errorf("%s can't be used with %s", toCarOptionName, noCopyOptionName)
+			}
+			if toCar == "-" {
+				nd, err := cmdenv.GetNode(env)
+				if err != nil {
+					return err
+				}
+				if nd.IsDaemon {
+					return fmt.Errorf("%s=- is only supported without a running daemon, pass a file path instead", toCarOptionName)
+				}
+			}
+			hash = true
+		}
+
+		var skipManifest map[string]AddManifestEntry
+		if skipPinned != "" {
+			// skipped files are taken from the blockstore, there is none
+			// to take them from when only hashing
+			if hash || encrypt {
+				return fmt.Errorf("%s can't be used with %s or %s", skipPinnedOptionName, onlyHashOptionName, encryptName)
+			}
+			if skipManifest, err = readAddManifest(skipPinned); err != nil {
+				return fmt.Errorf("%s: %w", skipPinnedOptionName, err)
+			}
+		}
+
+		if resume {
+			// resumed leaves are read back from the blockstore, and are
+			// the plaintext of the file
+			if hash || nocopy || encrypt || tokenMetadata != "" {
+				return fmt.Errorf("%s can't be used with %s, %s, %s or %s", resumeOptionName,
+					onlyHashOptionName, noCopyOptionName, encryptName, tokenMetaOptionName)
+			}
+			if strings.HasPrefix(chunker, "reed-solomon") {
+				return fmt.Errorf("%s can't be used with the reed-solomon chunker", resumeOptionName)
+			}
+		}
+
+		if reprovideNow && hash {
+			// nothing is stored to provide
+			return fmt.Errorf("%s can't be used with %s", reprovideNowOptionName, onlyHashOptionName)
+		}
+
+		var provide coreunix.ProvideStrategy
+		if provideSet {
+			if provide, err = coreunix.ParseProvideStrategy(provideStr); err != nil {
+				return fmt.Errorf("%s: %w", provideOptionName, err)
+			}
+			if reprovideNow && provide == coreunix.ProvideNone {
+				return fmt.Errorf("%s can't be used with %s=%s", reprovideNowOptionName, provideOptionName, provide)
+			}
+		}
+
+		var dedupAgainst *coreunix.DedupAgainst
+		if dedupAgainstStr != "" {
+			// nothing is stored when only hashing, and resumed adds read
+			// their leaves back from the blockstore
+			if hash || nocopy || resume {
+				return fmt.Errorf("%s can't be used with %s, %s or %s", dedupAgainstOptionName,
+					onlyHashOptionName, noCopyOptionName, resumeOptionName)
+			}
+			pid, err := peer.Decode(dedupAgainstStr)
+			if err != nil {
+				return fmt.Errorf("%s: %w", dedupAgainstOptionName, err)
+			}
+			nd, err := cmdenv.GetNode(env)
+			if err != nil {
+				return err
+			}
+			if !nd.IsOnline {
+				return fmt.Errorf("%s: %w", dedupAgainstOptionName, coreiface.ErrOffline)
+			}
+			dedupAgainst = dedupAgainstPeer(nd, api, pid)
+		}
+
+		var mfsRoot *mfs.Root
+		if toMfs != "" {
+			if hash {
+				return fmt.Errorf("%s can't be used with %s", toMfsOptionName, onlyHashOptionName)
+			}
+			if toMfs, err = checkPath(toMfs); err != nil {
+				return fmt.Errorf("%s: %w", toMfsOptionName, err)
+			}
+			nd, err := cmdenv.GetNode(env)
+			if err != nil {
+				return err
+			}
+			mfsRoot = nd.FilesRoot
+			// fail before adding anything when the single target is taken
+			if !force && !strings.HasSuffix(toMfs, "/") {
+				if _, err := mfs.Lookup(mfsRoot, toMfs); err == nil {
+					return fmt.Errorf("%s already exists in MFS, use --%s to replace it", toMfs, forceOptionName)
+				}
+			}
+		}
 
 		hashFunCode, ok := mh.Names[strings.ToLower(hashFunStr)]
 		if !ok {
 			return fmt.Errorf("unrecognized hash function: %s", strings.ToLower(hashFunStr))
 		}
 
+		if err := validateChunker(chunker); err != nil {
+			return err
+		}
+		var rootCodec uint64
+		if codec, ok := req.Options[codecOptionName].(string); ok {
+			if rootCodec, err = coreunix.ParseRootCodec(codec); err != nil {
+				return err
+			}
+		}
+		if wrapNameSet {
+			if !wrap {
+				return fmt.Errorf("%s needs --%s", wrapNameOptionName, wrapOptionName)
+			}
+			if err := validateWrapName(wrapName); err != nil {
+				return err
+			}
+		}
+
 		enc, err := cmdenv.GetCidEncoder(req)
 		if err != nil {
 			return err
@@ -261,7 +833,7 @@ only-hash, and progress/status related flags) will change the final hash.
 		toadd := req.Files
 		if wrap {
 			toadd = files.NewSliceDirectory([]files.DirEntry{
-				files.FileEntry("", req.Files),
+				files.FileEntry(wrapName, req.Files),
 			})
 		}
 
@@ -292,13 +864,86 @@ only-hash, and progress/status related flags) will change the final hash.
 			opts = append(opts, options.Unixfs.CidVersion(cidVer))
 		}
 
-		if rbset {
-			opts = append(opts, options.Unixfs.RawLeaves(rawblks))
+		// Mode and mtime are resolved independently: each one is either taken
+		// from the file (preserve) or set to a fixed value, never both.
+		if preserveMode && mode != 0 {
+			return fmt.Errorf("%s and %s can't be used together", preserveModeOptionName, modeOptionName)
+		}
+		if preserveMtime && mtime != 0 {
+			return fmt.Errorf("%s and %s can't be used together", preserveMtimeOptionName, mtimeOptionName)
+		}
+		if mtimeNsec != 0 {
+			if mtime == 0 {
+				return fmt.Errorf("%s requires %s", mtimeNsecOptionName, mtimeOptionName)
+			}
+			if mtimeNsec >= uint(time.Second) {
+				return fmt.Errorf("%s must be less than %d", mtimeNsecOptionName, time.Second)
+			}
+		}
+
+		if err := coreunix.ValidateExcludePatterns(exclude); err != nil {
+			return err
+		}
+		if uploadToBlockchain && blockchainAsync && chain.FileMetaQueueObject == nil {
+			return errNoFileMetaQueue
+		}
+		if uploadToBlockchain && blockchainAttempts < 1 {
+			return fmt.Errorf("%s must be at least 1", blockchainAttemptsOptionName)
+		}
+		if uploadToBlockchain {
+			cfg, err := cmdenv.GetConfig(env)
+			if err != nil {
+				return err
+			}
+			if _, err := chain.FileMetaPrivateKey(cfg); err != nil {
+				return err
+			}
+		}
+		if waitConfirm > 0 && (!uploadToBlockchain || blockchainAsync) {
+			return fmt.Errorf("%s needs %s and can't be used with %s", waitConfirmOptionName,
+				uploadToBlockchainOptionName, blockchainAsyncOptionName)
+		}
+		if pinDuration != 0 && !dopin {
+			return fmt.Errorf("%s can't be used with --%s=false", pinDurationCountOptionName, pinOptionName)
+		}
+		if pinRootOnly && pinDuration <= 0 {
+			return fmt.Errorf("%s requires a positive %s", pinDurationRootOnlyName, pinDurationCountOptionName)
+		}
+		if concurrency < 1 {
+			return fmt.Errorf("%s must be at least 1", addConcurrencyOptionName)
+		}
+		if shardThreshold < 0 {
+			return fmt.Errorf("%s must be >= 0", shardThresholdOptionName)
+		}
+		// the metadata of a directory is one of its links, and reed-solomon
+		// directories are built by their own adder
+		if shardThreshold > 0 && (tokenMetadata != "" || strings.HasPrefix(chunker, "reed-solomon")) {
+			return fmt.Errorf("%s can't be used with %s or the reed-solomon chunker", shardThresholdOptionName, tokenMetaOptionName)
+		}
+		if maxDepth < -1 {
+			return fmt.Errorf("%s must be -1 (unlimited) or a non-negative value", maxDepthOptionName)
+		}
+
+		// A raw root is a single raw leaf, it holds no metadata of any kind.
+		if rootCodec == cid.Raw {
+			if preserveMode || preserveMtime || preserveOwner || mode != 0 || mtime != 0 {
+				return fmt.Errorf("%s=raw can't be used with UnixFS metadata like mode or modification time", codecOptionName)
+			}
+			if wrap || tokenMetadata != "" || encrypt || strings.HasPrefix(chunker, "reed-solomon") {
+				return fmt.Errorf("%s=raw can't be used with %s, %s, %s or the reed-solomon chunker", codecOptionName,
+					wrapOptionName, tokenMetaOptionName, encryptName)
+			}
+			if rbset && !rawblks {
+				return fmt.Errorf("%s=raw can't be used with --%s=false", codecOptionName, rawLeavesOptionName)
+			}
+			rbset = true
+			rawblks = true
 		}
 
 		// Storing optional mode or mtime (UnixFS 1.5) requires root block
 		// to always be 'dag-pb' and not 'raw'. Below adjusts raw-leaves setting, if possible.
-		if preserveMode || preserveMtime || mode != 0 || mtime != 0 {
+		// This has to happen before the raw-leaves option is appended.
+		if preserveMode || preserveMtime || preserveOwner || mode != 0 || mtime != 0 {
 			// Error if --raw-leaves flag was explicitly passed by the user.
 			// (let user make a decision to manually disable it and retry)
 			if rbset && rawblks {
@@ -309,14 +954,22 @@ only-hash, and progress/status related flags) will change the final hash.
 			rawblks = false
 		}
 
+		if rbset {
+			opts = append(opts, options.Unixfs.RawLeaves(rawblks))
+		}
+
 		if trickle {
 			opts = append(opts, options.Unixfs.Layout(options.TrickleLayout))
 		}
 
 		if encrypt {
 			opts = append(opts, options.Unixfs.Encrypt(encrypt))
-			opts = append(opts, options.Unixfs.Pubkey(pubkey))
-			opts = append(opts, options.Unixfs.PeerId(peerId))
+			if len(pubkeys) == 1 {
+				opts = append(opts, options.Unixfs.Pubkey(pubkeys[0]))
+			}
+			if len(peerIds) == 1 {
+				opts = append(opts, options.Unixfs.PeerId(peerIds[0]))
+			}
 		}
 
 		if mode != 0 {
@@ -324,28 +977,153 @@ only-hash, and progress/status related flags) will change the final hash.
 		}
 		if mtime != 0 {
 			opts = append(opts, options.Unixfs.Mtime(mtime))
+			if mtimeNsec != 0 {
+				opts = append(opts, unixfsMtimeNsec(uint32(mtimeNsec)))
+			}
 		}
 
 		opts = append(opts, nil) // events option placeholder
 
-		var added int
-		addit := toadd.Entries()
-		for addit.Next() {
-			_, dir := addit.Node().(files.Directory)
-			errCh := make(chan error, 1)
-			events := make(chan interface{}, adderOutChanSize)
-			opts[len(opts)-1] = options.Unixfs.Events(events)
-			var pr coreifacePath.Resolved
-			go func() {
-				var err error
-				defer close(events)
-				pr, err = api.Unixfs().Add(req.Context, addit.Node(), opts...)
-				errCh <- err
-			}()
+		ctx := req.Context
+		fileMetaOpts := chain.DefaultFileMetaOptions()
+		fileMetaOpts.MaxAttempts = blockchainAttempts
+		if gasPrice, ok := req.Options[gasPriceOptionName].(float64); ok {
+			if fileMetaOpts.GasPrice, err = chain.GweiToWei(gasPrice); err != nil {
+				return fmt.Errorf("%s: %w", gasPriceOptionName, err)
+			}
+		}
+		if gasTip, ok := req.Options[gasTipOptionName].(float64); ok {
+			if fileMetaOpts.GasTipCap, err = chain.GweiToWei(gasTip); err != nil {
+				return fmt.Errorf("%s: %w", gasTipOptionName, err)
+			}
+		}
+		if fileMetaOpts.GasPrice != nil && fileMetaOpts.GasTipCap != nil && fileMetaOpts.GasTipCap.Cmp(fileMetaOpts.GasPrice) > 0 {
+			return fmt.Errorf("%s can't be greater than %s", gasTipOptionName, gasPriceOptionName)
+		}
+		if maxDepth >= 0 {
+			ctx = coreunix.SetMaxDepth(ctx, maxDepth)
+		}
+		if len(exclude) > 0 {
+			ctx = coreunix.SetExcludePatterns(ctx, exclude)
+		}
+		if pinRootOnly {
+			ctx = coreunix.SetPinRootOnly(ctx, true)
+		}
+		if recordSHA256 {
+			ctx = coreunix.SetRecordSHA256(ctx, true)
+		}
+		if resume {
+			ctx = coreunix.SetResume(ctx, true)
+		}
+		if preserveOwner {
+			ctx = coreunix.SetPreserveOwner(ctx, true)
+		}
+		if shardThreshold > 0 {
+			ctx = coreunix.SetShardThreshold(ctx, shardThreshold)
+		}
+		if rootCodec != 0 {
+			ctx = coreunix.SetRootCodec(ctx, rootCodec)
+		}
+		if provide != "" {
+			ctx = coreunix.SetProvideStrategy(ctx, provide)
+		}
+		// several recipients are sealed in an envelope, see the help
+		if encrypt && len(pubkeys)+len(peerIds) > 1 {
+			ctx = coreunix.SetEncryptRecipients(ctx, coreunix.EncryptRecipients{
+				PublicKeys: pubkeys,
+				PeerIds:    peerIds,
+			})
+		}
+		var stats *coreunix.DedupStats
+		if dedupStats {
+			stats = new(coreunix.DedupStats)
+			ctx = coreunix.SetDedupStats(ctx, stats)
+		}
+		if dedupAgainst != nil {
+			ctx = coreunix.SetDedupAgainst(ctx, dedupAgainst)
+		}
+		var car *coreunix.CarBuilder
+		if toCar != "" {
+			if car, err = coreunix.NewCarBuilder(""); err != nil {
+				return err
+			}
+			defer car.Close()
+			ctx = coreunix.SetCarBuilder(ctx, car)
+		}
 
-			for event := range events {
-				output, ok := event.(*coreiface.AddEvent)
+		if !cidVerSet {
+			if warning := cidV1Warning(hashFunStr, inline, rbset && rawblks, nocopy); warning != "" {
+				if err := res.Emit(&AddEvent{Warning: warning}); err != nil {
+					return err
+				}
+			}
+		}
+
+		if len(hashChunkers) > 0 {
+			return emitAllChunkerHashes(ctx, res, api, toadd, hashChunkers, opts[:len(opts)-1], enc)
+		}
+		if len(hashFunctions) > 0 {
+			return emitMultiHashes(ctx, res, api, toadd, hashFunctions, opts[:len(opts)-1], enc)
+		}
+
+		// a multipart request is read as a stream, a file at a time
+		if concurrency > 1 && files.IsMultiPartDirectory(toadd) {
+			if err := res.Emit(&AddEvent{Warning: multipartConcurrencyWarning}); err != nil {
+				return err
+			}
+			concurrency = 1
+		}
+
+		var added int
+		var roots []cid.Cid
+		// stops the adds still running when returning early
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		adds := startAddJobs(ctx, toadd.Entries(), concurrency,
+			func(ctx context.Context, name string, node files.Node, events chan<- interface{}) (coreifacePath.Resolved, error) {
+				addCtx := coreunix.SetAddName(ctx, name)
+				if skipManifest != nil {
+					_, dir := node.(files.Directory)
+					hints, err := skipPinnedHints(skipManifest, name, dir)
+					if err != nil {
+						return nil, fmt.Errorf("%s: %w", skipPinnedOptionName, err)
+					}
+					addCtx = coreunix.SetSkipPinned(addCtx, hints)
+				}
+				// opts is shared by the adds, each one has its own events
+				addOpts := append(opts[:len(opts)-1:len(opts)-1], options.Unixfs.Events(events))
+				return api.Unixfs().Add(addCtx, node, addOpts...)
+			})
+		for job := range adds.jobs {
+			dir := job.dir
+			// the entries the wrapping directory holds, by name
+			wrapped := make(map[string]bool)
+			for {
+				event, ok := job.events.next()
 				if !ok {
+					break
+				}
+				var output *coreiface.AddEvent
+				var target, sum string
+				var nsec uint32
+				var skipped bool
+				switch e := event.(type) {
+				case *coreiface.AddEvent:
+					output = e
+				case *coreunix.AddMtimeEvent:
+					output = &e.AddEvent
+					nsec = e.MtimeNsec
+				case *coreunix.AddSymlinkEvent:
+					output = &e.AddEvent
+					target = e.Target
+				case *coreunix.AddChecksumEvent:
+					output = &e.AddEvent
+					sum = e.SHA256
+					nsec = e.MtimeNsec
+				case *coreunix.AddSkippedEvent:
+					output = &e.AddEvent
+					skipped = true
+				default:
 					return errors.New("unknown event type")
 				}
 
@@ -354,18 +1132,27 @@ only-hash, and progress/status related flags) will change the final hash.
 					h = enc.Encode(output.Path.Cid())
 				}
 
-				if !dir && addit.Name() != "" {
-					output.Name = addit.Name()
+				if !dir && job.name != "" {
+					output.Name = job.name
 				} else {
-					output.Name = path.Join(addit.Name(), output.Name)
+					output.Name = path.Join(job.name, output.Name)
+				}
+				if wrap && output.Path != nil {
+					if name, ok := wrappedEntryName(output.Name, wrapName); ok {
+						wrapped[name] = true
+					}
 				}
 
 				addEvent := AddEvent{
-					Name:  output.Name,
-					Hash:  h,
-					Bytes: output.Bytes,
-					Size:  output.Size,
-					Mtime: output.Mtime,
+					Name:      output.Name,
+					Hash:      h,
+					Bytes:     output.Bytes,
+					Size:      output.Size,
+					Mtime:     output.Mtime,
+					MtimeNsec: nsec,
+					Target:    target,
+					SHA256:    sum,
+					Skipped:   skipped,
 				}
 
 				if output.Mode != 0 {
@@ -377,79 +1164,170 @@ only-hash, and progress/status related flags) will change the final hash.
 				}
 			}
 
-			if err := <-errCh; err != nil {
-				return err
+			<-job.done
+			adds.release()
+			if job.err != nil {
+				return job.err
 			}
+			pr := job.root
 			added++
-			if uploadToBlockchain {
-				cctx := env.(*oldcmds.Context)
-				cfg, err := cctx.GetConfig()
-				if err != nil {
-					return err
+			roots = append(roots, pr.Cid())
+			// checked before the root is used any further
+			if expectCid.Defined() {
+				if added > 1 {
+					return fmt.Errorf("%s needs a single root, add with --%s to wrap the files in a directory",
+						expectCidOptionName, wrapOptionName)
 				}
-				fname := addit.Name()
-				size, _ := addit.Node().Size()
-				cli, err := ethclient.Dial(cfg.ChainInfo.Endpoint)
-				if err != nil {
+				if err := checkExpectedCid(expectCid, pr.Cid(), enc); err != nil {
 					return err
 				}
-				defer cli.Close()
-				currChainCfg, ok := chainconfig.GetChainConfig(cfg.ChainInfo.ChainId)
-				if !ok {
-					return fmt.Errorf("chain %d is not supported yet", cfg.ChainInfo.ChainId)
+			}
+			if mfsRoot != nil {
+				target := toMfs
+				if strings.HasSuffix(target, "/") {
+					name := job.name
+					if name == "" {
+						name = pr.Cid().String()
+					}
+					target += name
 				}
-				contractAddress := currChainCfg.FileMetaAddress
-				contr, err := abi.NewFileMeta(contractAddress, cli)
+				nd, err := api.Dag().Get(req.Context, pr.Cid())
 				if err != nil {
 					return err
 				}
-				pkbytesOri, err := base64.StdEncoding.DecodeString(cfg.Identity.PrivKey)
-				if err != nil {
-					return err
+				if err := putInMfs(req.Context, mfsRoot, target, nd, force); err != nil {
+					return fmt.Errorf("%s: %w", toMfsOptionName, err)
 				}
-				privateKey, err := ethCrypto.ToECDSA(pkbytesOri[4:])
-				if err != nil {
+				if err := res.Emit(&AddEvent{Name: job.name, Hash: enc.Encode(pr.Cid()), Mfs: target}); err != nil {
 					return err
 				}
-				fromAddress := crypto.PubkeyToAddress(privateKey.PublicKey)
-				nonce, err := cli.PendingNonceAt(req.Context, fromAddress)
+			}
+			if uploadToBlockchain {
+				cctx := env.(*oldcmds.Context)
+				cfg, err := cctx.GetConfig()
 				if err != nil {
 					return err
 				}
-				auth, err := bind.NewKeyedTransactorWithChainID(privateKey, big.NewInt(cfg.ChainInfo.ChainId))
-				if err != nil {
-					return err
+				size, _ := job.node.Size()
+				var data chain.FileMetaData
+				if wrap {
+					data = wrapFileMeta(wrapped, size)
+					if wrapName != "" {
+						data.FileName = wrapName
+					}
+				} else {
+					data = chain.FileMetaData{
+						FileName: job.name,
+						FileExt:  path.Ext(job.name),
+						IsDir:    dir,
+						FileSize: size,
+					}
+				}
+				fname := data.FileName
+				if blockchainAsync {
+					entry, err := chain.FileMetaQueueObject.Enqueue(pr.Cid().String(), data, fileMetaOpts)
+					if err != nil {
+						return err
+					}
+					progress := &AddBlockchainProgress{Stage: addBlockchainQueued, QueueID: entry.ID}
+					if err := res.Emit(&AddEvent{Name: fname, Blockchain: progress}); err != nil {
+						return err
+					}
+					continue
+				}
+				fileMetaOpts.Progress = func(p chain.FileMetaProgress) {
+					progress := &AddBlockchainProgress{
+						Stage:       string(p.Stage),
+						Attempt:     p.Attempt,
+						Nonce:       p.Nonce,
+						BlockNumber: p.BlockNumber,
+					}
+					if p.TxHash != (common.Hash{}) {
+						progress.TxHash = p.TxHash.Hex()
+					}
+					if err := res.Emit(&AddEvent{Name: fname, Blockchain: progress}); err != nil {
+						log.Debugf("emit file meta progress: %s", err)
+					}
 				}
-				auth.Nonce = big.NewInt(int64(nonce))
-				auth.Value = big.NewInt(0)
-				data := abi.FileMetaFileMetaData{
-					OwnerPeerId: cfg.Identity.PeerID,
-					From:        common.HexToAddress(cfg.Identity.BttcAddr),
-					FileName:    fname,
-					FileExt:     path.Ext(fname),
-					IsDir:       dir,
-					FileSize:    big.NewInt(size),
-				}
-				tx, err := contr.AddFileMeta(auth, pr.Cid().String(), data)
+				txHash, err := chain.SubmitFileMeta(req.Context, cfg, pr.Cid().String(), data, fileMetaOpts)
 				if err != nil {
 					return err
 				}
-				fmt.Println("Write into file meta contract successfully! Transaction hash is: ", tx.Hash().Hex())
+				log.Infof("wrote the file meta of %s, transaction %s", pr.Cid(), txHash.Hex())
+				// the daemon keeps track of the confirmation, whether the add
+				// waits for it or not
+				if w := chain.FileMetaWatcherObject; w != nil {
+					if _, err := w.Watch(txHash, pr.Cid().String(), uint64(max(waitConfirm, 1))); err != nil {
+						log.Errorf("watch file meta transaction %s: %s", txHash.Hex(), err)
+					} else if waitConfirm == 0 {
+						progress := &AddBlockchainProgress{Stage: addBlockchainWatched, TxHash: txHash.Hex()}
+						if err := res.Emit(&AddEvent{Name: fname, Blockchain: progress}); err != nil {
+							return err
+						}
+					}
+				}
+				if waitConfirm > 0 {
+					if _, err := chain.WaitFileMeta(req.Context, cfg, txHash, uint64(waitConfirm), fileMetaOpts); err != nil {
+						return fmt.Errorf("file meta transaction %s: %w", txHash.Hex(), err)
+					}
+				}
 			}
 		}
 
-		if addit.Err() != nil {
-			return addit.Err()
+		if adds.err != nil {
+			return adds.err
+		}
+		if err := req.Context.Err(); err != nil {
+			return err
 		}
 
 		if added == 0 {
 			return fmt.Errorf("expected a file argument")
 		}
 
+		if stats != nil {
+			err := res.Emit(&AddEvent{
+				Dedup: &AddDedupStats{
+					TotalBlocks: stats.TotalBlocks(),
+					NewBlocks:   stats.NewBlocks(),
+					DedupBytes:  stats.DedupBytes(),
+				},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if dedupAgainst != nil {
+			err := res.Emit(&AddEvent{
+				DedupAgainst: &AddDedupAgainst{
+					Peer:          dedupAgainstStr,
+					SkippedBlocks: dedupAgainst.SkippedBlocks(),
+					SkippedBytes:  dedupAgainst.SkippedBytes(),
+				},
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if reprovideNow {
+			if err := reprovideAdded(req.Context, env, roots); err != nil {
+				return fmt.Errorf("%s: %w", reprovideNowOptionName, err)
+			}
+		}
+
+		if car != nil {
+			out, err := writeAddCar(car, toCar, roots, enc)
+			if err != nil {
+				return err
+			}
+			return res.Emit(&AddEvent{Car: out})
+		}
+
 		return nil
 	},
 	PostRun: cmds.PostRunMap{
-		cmds.CLI: func(res cmds.Response, re cmds.ResponseEmitter) error {
+		cmds.CLI: func(res cmds.Response, re cmds.ResponseEmitter) (err error) {
 			sizeChan := make(chan int64, 1)
 			outChan := make(chan interface{})
 			req := res.Request()
@@ -474,6 +1352,10 @@ only-hash, and progress/status related flags) will change the final hash.
 					}
 				} else {
 					size, err := req.Files.Size()
+					// The size of piped input is unknown, use the hint if given.
+					if stdinSize, ok := req.Options[stdinSizeOptionName].(int64); ok && (err != nil || size == 0) {
+						size, err = stdinSize, nil
+					}
 					if err != nil {
 						log.Warnf("error getting files size: %s", err)
 						// see comment above
@@ -483,6 +1365,17 @@ only-hash, and progress/status related flags) will change the final hash.
 				}
 			}()
 
+			// stdout carries the CAR with --to-car=-, report on stderr
+			stdout := io.Writer(os.Stdout)
+			if toCar, _ := req.Options[toCarOptionName].(string); toCar == "-" {
+				stdout = os.Stderr
+			}
+
+			manifestOut, _ := req.Options[manifestOutOptionName].(string)
+			writeManifest, _ := req.Options[manifestOptionName].(bool)
+			writeManifest = writeManifest || manifestOut != ""
+			manifest := make(map[string]AddManifestEntry)
+
 			progressBar := func(wait chan struct{}) {
 				defer close(wait)
 
@@ -512,15 +1405,65 @@ only-hash, and progress/status related flags) will change the final hash.
 					case out, ok := <-outChan:
 						if !ok {
 							if quieter {
-								fmt.Fprintln(os.Stdout, lastHash)
+								fmt.Fprintln(stdout, lastHash)
 							}
 
 							break LOOP
 						}
 						output := out.(*AddEvent)
+						if output.Warning != "" {
+							if progress {
+								fmt.Fprintf(os.Stderr, "\033[2K\r")
+							}
+							fmt.Fprintf(os.Stderr, "warning: %s\n", output.Warning)
+							continue
+						}
+						if output.Car != nil {
+							if !quiet {
+								fmt.Fprintf(stdout, "wrote CAR of %d bytes to %s, roots: %s\n",
+									output.Car.Size, output.Car.Path, strings.Join(output.Car.Roots, " "))
+							}
+							continue
+						}
+						if output.Mfs != "" {
+							if !quiet {
+								fmt.Fprintf(stdout, "placed %s at %s\n", output.Hash, output.Mfs)
+							}
+							continue
+						}
+						if output.Blockchain != nil {
+							if !quiet {
+								fmt.Fprintln(stdout, blockchainProgressText(output.Name, output.Blockchain))
+							}
+							continue
+						}
+						if output.Dedup != nil {
+							if !quiet {
+								fmt.Fprintf(stdout, "dedup: %d of %d blocks new, %d bytes deduplicated\n",
+									output.Dedup.NewBlocks, output.Dedup.TotalBlocks, output.Dedup.DedupBytes)
+							}
+							continue
+						}
+						if output.DedupAgainst != nil {
+							if !quiet {
+								fmt.Fprintf(stdout, "dedup-against: %d blocks, %d bytes, left to %s\n",
+									output.DedupAgainst.SkippedBlocks, output.DedupAgainst.SkippedBytes,
+									output.DedupAgainst.Peer)
+							}
+							continue
+						}
 						if len(output.Hash) > 0 {
 							lastHash = output.Hash
-							if quieter {
+							if writeManifest {
+								manifest[output.Name] = AddManifestEntry{
+									Cid:    output.Hash,
+									Size:   output.Size,
+									Mode:   output.Mode,
+									Mtime:  output.Mtime,
+									SHA256: output.SHA256,
+								}
+							}
+							if quieter || (writeManifest && manifestOut == "") {
 								continue
 							}
 
@@ -528,10 +1471,21 @@ only-hash, and progress/status related flags) will change the final hash.
 								// clear progress bar line before we print "added x" output
 								fmt.Fprintf(os.Stderr, "\033[2K\r")
 							}
-							if quiet {
-								fmt.Fprintf(os.Stdout, "%s\n", output.Hash)
-							} else {
-								fmt.Fprintf(os.Stdout, "added %s %s\n", output.Hash, output.Name)
+							switch {
+							case quiet:
+								fmt.Fprintf(stdout, "%s\n", output.Hash)
+							case output.Skipped:
+								fmt.Fprintf(stdout, "skipped %s %s, already pinned\n", output.Hash, output.Name)
+							case output.Chunker != "":
+								fmt.Fprintf(stdout, "added %s %s with %s\n", output.Hash, output.Name, output.Chunker)
+							case output.HashFunction != "":
+								fmt.Fprintf(stdout, "added %s %s with %s\n", output.Hash, output.Name, output.HashFunction)
+							case output.Target != "":
+								fmt.Fprintf(stdout, "added %s %s -> %s\n", output.Hash, output.Name, output.Target)
+							case output.SHA256 != "":
+								fmt.Fprintf(stdout, "added %s %s sha256:%s\n", output.Hash, output.Name, output.SHA256)
+							default:
+								fmt.Fprintf(stdout, "added %s %s\n", output.Hash, output.Name)
 							}
 
 						} else {
@@ -549,6 +1503,15 @@ only-hash, and progress/status related flags) will change the final hash.
 							lastBytes = output.Bytes
 							delta := prevFiles + lastBytes - totalProgress
 							totalProgress = bar.Add64(delta)
+
+							// More data than expected (e.g. a wrong --stdin-size),
+							// fall back to an indeterminate bar.
+							if bar.Total > 0 && totalProgress > bar.Total {
+								bar.Total = 0
+								bar.ShowPercent = false
+								bar.ShowBar = false
+								bar.ShowTimeLeft = false
+							}
 						}
 
 						if progress {
@@ -584,7 +1547,12 @@ only-hash, and progress/status related flags) will change the final hash.
 			wait := make(chan struct{})
 			go progressBar(wait)
 
-			defer func() { <-wait }()
+			defer func() {
+				<-wait
+				if err == nil && writeManifest {
+					err = writeAddManifest(manifest, manifestOut)
+				}
+			}()
 			defer close(outChan)
 
 			for {
@@ -607,3 +1575,329 @@ only-hash, and progress/status related flags) will change the final hash.
 	},
 	Type: AddEvent{},
 }
+
+// maxChunkSize is the largest chunk size accepted by --chunker. Blocks
+// bigger than this can't be exchanged reliably with other nodes.
+const maxChunkSize = 1024 * 1024
+
+// validateChunker checks the size-[bytes] and rabin-[min]-[avg]-[max]
+// chunker forms early so that bad values get a clear error. Other forms are
+// left to the chunker itself.
+func validateChunker(chunker string) error {
+	parseSize := func(s string) (int64, error) {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid chunker %q: %q is not a number", chunker, s)
+		}
+		if n <= 0 {
+			return 0, fmt.Errorf("invalid chunker %q: sizes must be positive", chunker)
+		}
+		if n > maxChunkSize {
+			return 0, fmt.Errorf("invalid chunker %q: sizes can't exceed %d bytes", chunker, maxChunkSize)
+		}
+		return n, nil
+	}
+
+	switch {
+	case strings.HasPrefix(chunker, "size-"):
+		_, err := parseSize(strings.TrimPrefix(chunker, "size-"))
+		return err
+	case strings.HasPrefix(chunker, "rabin-"):
+		parts := strings.Split(strings.TrimPrefix(chunker, "rabin-"), "-")
+		if len(parts) != 1 && len(parts) != 3 {
+			return fmt.Errorf("invalid chunker %q: expected rabin-[avg] or rabin-[min]-[avg]-[max]", chunker)
+		}
+		sizes := make([]int64, len(parts))
+		for i, p := range parts {
+			n, err := parseSize(p)
+			if err != nil {
+				return err
+			}
+			sizes[i] = n
+		}
+		if len(sizes) == 3 && !(sizes[0] <= sizes[1] && sizes[1] <= sizes[2]) {
+			return fmt.Errorf("invalid chunker %q: sizes must satisfy min <= avg <= max", chunker)
+		}
+	}
+	return coreunix.CheckChunker(chunker)
+}
+
+// checkExpectedCid fails with both CIDs unless got is expected.
+func checkExpectedCid(expected, got cid.Cid, enc cidenc.Encoder) error {
+	if got.Equals(expected) {
+		return nil
+	}
+	msg := fmt.Sprintf("root CID mismatch:\n  expected: %s\n  got:      %s", enc.Encode(expected), enc.Encode(got))
+	if bytes.Equal(got.Hash(), expected.Hash()) {
+		msg += "\nthe hashes match, only the CID version or codec differs"
+	}
+	return errors.New(msg)
+}
+
+// reprovideAdded provides roots to the routing system right away. It does
+// nothing when the node is offline, the reprovider announces them once it
+// is online.
+func reprovideAdded(ctx context.Context, env cmds.Environment, roots []cid.Cid) error {
+	nd, err := cmdenv.GetNode(env)
+	if err != nil {
+		return err
+	}
+	if !nd.IsOnline {
+		return nil
+	}
+	if len(nd.PeerHost.Network().Conns()) == 0 {
+		log.Debugf("not providing %d added roots, no connected peers", len(roots))
+		return nil
+	}
+	return provideKeys(ctx, nd.Routing, roots)
+}
+
+// dedupAgainstBatch is how many CIDs --dedup-against asks the peer about in
+// one call.
+const dedupAgainstBatch = 128
+
+// dedupAgainstPeer returns a DedupAgainst asking pid which blocks it has,
+// through its 'block has'.
+func dedupAgainstPeer(nd *core.IpfsNode, api coreiface.CoreAPI, pid peer.ID) *coreunix.DedupAgainst {
+	return &coreunix.DedupAgainst{
+		Has: func(ctx context.Context, cids []cid.Cid) ([]bool, error) {
+			has := make([]bool, 0, len(cids))
+			for start := 0; start < len(cids); start += dedupAgainstBatch {
+				batch := cids[start:min(start+dedupAgainstBatch, len(cids))]
+				keys := make([]string, len(batch))
+				for i, c := range batch {
+					keys[i] = c.String()
+				}
+				b, err := remote.P2PCallStrings(ctx, nd, api, pid, "/block/has", keys...)
+				if err != nil {
+					return nil, err
+				}
+				var out BlockHas
+				if err := json.Unmarshal(b, &out); err != nil {
+					return nil, err
+				}
+				if len(out.Has) != len(keys) {
+					return nil, fmt.Errorf("peer %s answered for %d blocks, %d were asked", pid, len(out.Has), len(keys))
+				}
+				has = append(has, out.Has...)
+			}
+			return has, nil
+		},
+	}
+}
+
+// multipartConcurrencyWarning is the warning of an add with --add-concurrency
+// whose files are sent to a running daemon.
+var multipartConcurrencyWarning = fmt.Sprintf("--%s has no effect on files sent to a running daemon, they are added one at a time; "+
+	"stop the daemon to add them at once", addConcurrencyOptionName)
+
+// cidV1Warning returns a warning naming the options which make an add
+// without --cid-version produce CIDv1 CIDs, or "" if none does.
+func cidV1Warning(hashFun string, inline, rawLeaves, nocopy bool) string {
+	var reasons []string
+	if strings.ToLower(hashFun) != "sha2-256" {
+		reasons = append(reasons, fmt.Sprintf("--%s=%s makes all the CIDs CIDv1", hashOptionName, hashFun))
+	}
+	if rawLeaves || nocopy {
+		option := rawLeavesOptionName
+		if !rawLeaves {
+			option = noCopyOptionName
+		}
+		reasons = append(reasons, fmt.Sprintf("--%s makes the leaves, and the root of files of a single block, raw CIDv1 CIDs", option))
+	}
+	if inline {
+		reasons = append(reasons, fmt.Sprintf("--%s makes the inlined blocks identity CIDv1 CIDs", inlineOptionName))
+	}
+	if len(reasons) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s, the CIDs won't match an add without it; pass --%s to silence this warning",
+		strings.Join(reasons, "; "), cidVersionOptionName)
+}
+
+// writeAddManifest writes the manifest as JSON to path, or to stdout if path
+// is empty. Keys are sorted by the JSON encoder, so the output is ordered
+// by path.
+func writeAddManifest(manifest map[string]AddManifestEntry, path string) error {
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if path == "" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// putInMfs places nd at target in the MFS of root, creating the parents.
+// An existing entry at target is only replaced with force.
+func putInMfs(ctx context.Context, root *mfs.Root, target string, nd ipld.Node, force bool) error {
+	_, err := mfs.Lookup(root, target)
+	switch {
+	case err == nil && !force:
+		return fmt.Errorf("%s already exists, use --%s to replace it", target, forceOptionName)
+	case err == nil:
+		dir, name := path.Split(target)
+		pdir, err := getParentDir(root, dir)
+		if err != nil {
+			return err
+		}
+		if err := pdir.Unlink(name); err != nil {
+			return err
+		}
+	case err != os.ErrNotExist:
+		return err
+	}
+
+	if err := ensureContainingDirectoryExists(root, target, nil); err != nil {
+		return err
+	}
+	if err := mfs.PutNode(root, target, nd); err != nil {
+		return err
+	}
+	_, err = mfs.FlushPath(ctx, root, target)
+	return err
+}
+
+// wrapFileMeta returns the file meta of the wrapping directory of an add
+// with -w, which holds the wrapped entries. It is named after the entry it
+// wraps if there is a single one.
+func wrapFileMeta(wrapped map[string]bool, size int64) chain.FileMetaData {
+	data := chain.FileMetaData{IsDir: true, FileSize: size}
+	if len(wrapped) == 1 {
+		for name := range wrapped {
+			data.FileName = name
+		}
+	}
+	return data
+}
+
+// wrappedEntryName returns the name of the entry of the wrapping directory
+// named wrapName that the output named name is for, if it is one rather
+// than the directory itself or something deeper.
+func wrappedEntryName(name string, wrapName string) (string, bool) {
+	if wrapName != "" {
+		var ok bool
+		if name, ok = strings.CutPrefix(name, wrapName+"/"); !ok {
+			return "", false
+		}
+	}
+	return name, name != "" && !strings.Contains(name, "/")
+}
+
+// validateWrapName checks that name can name the wrapping directory, a single
+// path element.
+func validateWrapName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return fmt.Errorf("invalid %s %q: it must be a name without path separators", wrapNameOptionName, name)
+	}
+	return nil
+}
+
+// Stages of a --to-blockchain submission reported by add itself.
+const (
+	// addBlockchainQueued is the stage of a submission left to the
+	// --blockchain-async queue.
+	addBlockchainQueued = "queued"
+	// addBlockchainWatched is the stage of a submitted transaction the
+	// daemon watches the confirmation of.
+	addBlockchainWatched = "watched"
+)
+
+// blockchainProgressText describes a stage of a --to-blockchain submission.
+func blockchainProgressText(name string, p *AddBlockchainProgress) string {
+	switch chain.FileMetaStage(p.Stage) {
+	case addBlockchainQueued:
+		return fmt.Sprintf("file meta of %s: queued for submission as %s", name, p.QueueID)
+	case addBlockchainWatched:
+		return fmt.Sprintf("file meta of %s: check its confirmation with: btfs add filemeta status %s", name, p.TxHash)
+	case chain.FileMetaNonceFetched:
+		return fmt.Sprintf("file meta of %s: attempt %d with nonce %d", name, p.Attempt, p.Nonce)
+	case chain.FileMetaSubmitted:
+		return fmt.Sprintf("file meta of %s: submitted transaction %s", name, p.TxHash)
+	case chain.FileMetaConfirmed:
+		return fmt.Sprintf("file meta of %s: transaction %s confirmed in block %d", name, p.TxHash, p.BlockNumber)
+	case chain.FileMetaReverted:
+		return fmt.Sprintf("file meta of %s: transaction %s reverted in block %d", name, p.TxHash, p.BlockNumber)
+	default:
+		return fmt.Sprintf("file meta of %s: %s", name, p.Stage)
+	}
+}
+
+// readAddManifest reads a manifest written by writeAddManifest.
+func readAddManifest(path string) (map[string]AddManifestEntry, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest map[string]AddManifestEntry
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// skipPinnedHints returns the CIDs of the manifest under the added entry
+// name, keyed by path relative to it as the adder sees them. A file added
+// on its own is keyed by the empty path.
+func skipPinnedHints(manifest map[string]AddManifestEntry, name string, dir bool) (map[string]cid.Cid, error) {
+	hints := make(map[string]cid.Cid)
+	for p, entry := range manifest {
+		var rel string
+		switch {
+		case name == "":
+			rel = p
+		case !dir && p == name:
+			rel = ""
+		case dir && strings.HasPrefix(p, name+"/"):
+			rel = strings.TrimPrefix(p, name+"/")
+		default:
+			continue
+		}
+		c, err := cid.Decode(entry.Cid)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CID for %s: %w", p, err)
+		}
+		hints[rel] = c
+	}
+	return hints, nil
+}
+
+// writeAddCar writes the CAR collected by --to-car to dst, or to stdout if
+// dst is "-".
+func writeAddCar(car *coreunix.CarBuilder, dst string, roots []cid.Cid, enc cidenc.Encoder) (*AddCarOutput, error) {
+	var size int64
+	var err error
+	if dst == "-" {
+		size, err = car.WriteTo(os.Stdout, roots...)
+	} else {
+		var f *os.File
+		if f, err = os.Create(dst); err != nil {
+			return nil, err
+		}
+		size, err = car.WriteTo(f, roots...)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("write CAR: %w", err)
+	}
+
+	out := &AddCarOutput{Path: dst, Size: size}
+	for _, c := range roots {
+		out.Roots = append(out.Roots, enc.Encode(c))
+	}
+	return out, nil
+}
+
+// unixfsMtimeNsec sets the sub-second part of the custom mtime. It must be
+// applied after options.Unixfs.Mtime, which only takes whole seconds.
+func unixfsMtimeNsec(nsec uint32) options.UnixfsAddOption {
+	return func(settings *options.UnixfsAddSettings) error {
+		settings.Mtime = time.Unix(settings.Mtime.Unix(), int64(nsec))
+		return nil
+	}
+}
@@ -0,0 +1,98 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+	"github.com/bittorrent/go-btfs/chain"
+)
+
+var errNoFileMetaQueue = errors.New("file meta queue is not running, it is not available in simple mode")
+
+var addBlockchainQueueCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Inspect file meta queued by 'btfs add --to-blockchain --blockchain-async'.",
+	},
+	Subcommands: map[string]*cmds.Command{
+		"status": addBlockchainQueueStatusCmd,
+		"retry":  addBlockchainQueueRetryCmd,
+	},
+}
+
+type addBlockchainQueueStatus struct {
+	Entries []*chain.FileMetaQueueEntry
+}
+
+var addBlockchainQueueStatusCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline:          "List pending and failed file meta submissions.",
+		ShortDescription: "Entries are removed from the queue once they are submitted.",
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		if err := checkDaemon(env); err != nil {
+			return err
+		}
+		q := chain.FileMetaQueueObject
+		if q == nil {
+			return errNoFileMetaQueue
+		}
+		entries, err := q.List()
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &addBlockchainQueueStatus{Entries: entries})
+	},
+	Type: addBlockchainQueueStatus{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *addBlockchainQueueStatus) error {
+			tw := tabwriter.NewWriter(w, 4, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "ID\tCID\tNAME\tSTATUS\tATTEMPTS\tERROR")
+			for _, e := range out.Entries {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n", e.ID, e.Cid, e.Data.FileName, e.Status, e.Attempts, e.LastError)
+			}
+			return tw.Flush()
+		}),
+	},
+}
+
+type addBlockchainQueueRetryResult struct {
+	Retried int
+}
+
+var addBlockchainQueueRetryCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline:          "Retry failed file meta submissions.",
+		ShortDescription: "Retries the entry with the given id, or all failed entries if no id is given.",
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("id", false, false, "Id of the queue entry to retry."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		if err := checkDaemon(env); err != nil {
+			return err
+		}
+		q := chain.FileMetaQueueObject
+		if q == nil {
+			return errNoFileMetaQueue
+		}
+		id := ""
+		if len(req.Arguments) > 0 {
+			id = req.Arguments[0]
+		}
+		n, err := q.Retry(id)
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &addBlockchainQueueRetryResult{Retried: n})
+	},
+	Type: addBlockchainQueueRetryResult{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *addBlockchainQueueRetryResult) error {
+			_, err := fmt.Fprintf(w, "%d entries queued for retry\n", out.Retried)
+			return err
+		}),
+	},
+}
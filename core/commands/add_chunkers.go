@@ -0,0 +1,187 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+	files "github.com/bittorrent/go-btfs-files"
+	coreiface "github.com/bittorrent/interface-go-btfs-core"
+	"github.com/bittorrent/interface-go-btfs-core/options"
+	cid "github.com/ipfs/go-cid"
+	cidenc "github.com/ipfs/go-cidutil/cidenc"
+	mh "github.com/multiformats/go-multihash"
+	"golang.org/x/sync/errgroup"
+)
+
+// parseHashAllChunkers splits the comma separated chunkers of
+// --hash-all-chunkers and validates each of them.
+func parseHashAllChunkers(s string) ([]string, error) {
+	chunkers := strings.Split(s, ",")
+	seen := make(map[string]bool, len(chunkers))
+	for i, c := range chunkers {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			return nil, fmt.Errorf("%s: empty chunker in %q", hashAllChunkersOptionName, s)
+		}
+		if seen[c] {
+			return nil, fmt.Errorf("%s: chunker %s given twice", hashAllChunkersOptionName, c)
+		}
+		if err := validateChunker(c); err != nil {
+			return nil, err
+		}
+		seen[c] = true
+		chunkers[i] = c
+	}
+	return chunkers, nil
+}
+
+// parseHashMulti splits the comma separated hash functions of --hash-multi
+// and checks that each of them is known.
+func parseHashMulti(s string) ([]string, error) {
+	names := strings.Split(s, ",")
+	seen := make(map[string]bool, len(names))
+	for i, n := range names {
+		n = strings.ToLower(strings.TrimSpace(n))
+		if n == "" {
+			return nil, fmt.Errorf("%s: empty hash function in %q", hashMultiOptionName, s)
+		}
+		if seen[n] {
+			return nil, fmt.Errorf("%s: hash function %s given twice", hashMultiOptionName, n)
+		}
+		if _, ok := mh.Names[n]; !ok {
+			return nil, fmt.Errorf("%s: unrecognized hash function: %s", hashMultiOptionName, n)
+		}
+		seen[n] = true
+		names[i] = n
+	}
+	return names, nil
+}
+
+// hashVariant is one of the ways files are hashed at once by
+// --hash-all-chunkers or --hash-multi.
+type hashVariant struct {
+	name string
+	opt  options.UnixfsAddOption
+}
+
+func chunkerVariants(chunkers []string) []hashVariant {
+	variants := make([]hashVariant, len(chunkers))
+	for i, c := range chunkers {
+		variants[i] = hashVariant{name: c, opt: options.Unixfs.Chunker(c)}
+	}
+	return variants
+}
+
+// hashFunctionVariants returns the variants of names, which parseHashMulti
+// checked.
+func hashFunctionVariants(names []string) []hashVariant {
+	variants := make([]hashVariant, len(names))
+	for i, n := range names {
+		variants[i] = hashVariant{name: n, opt: options.Unixfs.Hash(mh.Names[n])}
+	}
+	return variants
+}
+
+// emitAllChunkerHashes hashes every file of toadd with each of chunkers, and
+// emits one event per file and chunker.
+func emitAllChunkerHashes(ctx context.Context, res cmds.ResponseEmitter, api coreiface.CoreAPI, toadd files.Directory,
+	chunkers []string, opts []options.UnixfsAddOption, enc cidenc.Encoder) error {
+	return emitVariantHashes(ctx, res, api, toadd, hashAllChunkersOptionName, chunkerVariants(chunkers), opts,
+		func(name string, root cid.Cid, v hashVariant) *AddEvent {
+			return &AddEvent{Name: name, Hash: enc.Encode(root), Chunker: v.name}
+		})
+}
+
+// emitMultiHashes hashes every file of toadd with each of the hash functions
+// names, and emits one event per file and hash function.
+func emitMultiHashes(ctx context.Context, res cmds.ResponseEmitter, api coreiface.CoreAPI, toadd files.Directory,
+	names []string, opts []options.UnixfsAddOption, enc cidenc.Encoder) error {
+	return emitVariantHashes(ctx, res, api, toadd, hashMultiOptionName, hashFunctionVariants(names), opts,
+		func(name string, root cid.Cid, v hashVariant) *AddEvent {
+			return &AddEvent{Name: name, Hash: enc.Encode(root), HashFunction: v.name}
+		})
+}
+
+// emitVariantHashes hashes every file of toadd with each of variants, and
+// emits the event made by event for each file and variant.
+func emitVariantHashes(ctx context.Context, res cmds.ResponseEmitter, api coreiface.CoreAPI, toadd files.Directory,
+	option string, variants []hashVariant, opts []options.UnixfsAddOption,
+	event func(name string, root cid.Cid, v hashVariant) *AddEvent) error {
+	var added int
+	it := toadd.Entries()
+	for it.Next() {
+		if _, ok := it.Node().(*files.Symlink); ok {
+			return fmt.Errorf("%s is a symlink, pass --dereference-args to hash the file it points to", it.Name())
+		}
+		f, ok := it.Node().(files.File)
+		if !ok {
+			return fmt.Errorf("%s only supports files, %s is a directory", option, it.Name())
+		}
+		roots, err := hashVariants(ctx, api, f, variants, opts)
+		if err != nil {
+			return err
+		}
+		for i, v := range variants {
+			if err := res.Emit(event(it.Name(), roots[i], v)); err != nil {
+				return err
+			}
+		}
+		added++
+	}
+	if it.Err() != nil {
+		return it.Err()
+	}
+	if added == 0 {
+		return fmt.Errorf("expected a file argument")
+	}
+	return nil
+}
+
+// hashVariants reads f once and tees it to one adder per variant, all
+// running at the same time. It returns the root of each variant, in order.
+func hashVariants(ctx context.Context, api coreiface.CoreAPI, f files.File, variants []hashVariant,
+	opts []options.UnixfsAddOption) ([]cid.Cid, error) {
+	readers := make([]*io.PipeReader, len(variants))
+	writers := make([]*io.PipeWriter, len(variants))
+	tee := make([]io.Writer, len(variants))
+	for i := range variants {
+		readers[i], writers[i] = io.Pipe()
+		tee[i] = writers[i]
+	}
+	go func() {
+		// a failed adder closes its reader, which stops the copy and makes
+		// the other adders fail too
+		_, err := io.Copy(io.MultiWriter(tee...), f)
+		for _, w := range writers {
+			w.CloseWithError(err)
+		}
+	}()
+
+	roots := make([]cid.Cid, len(variants))
+	g, gctx := errgroup.WithContext(ctx)
+	for i, v := range variants {
+		i, v := i, v
+		g.Go(func() error {
+			defer readers[i].Close()
+			vopts := append(append([]options.UnixfsAddOption{}, opts...),
+				v.opt,
+				options.Unixfs.HashOnly(true),
+				options.Unixfs.Progress(false),
+			)
+			p, err := api.Unixfs().Add(gctx, files.NewReaderFile(readers[i]), vopts...)
+			if err != nil {
+				readers[i].CloseWithError(err)
+				return fmt.Errorf("%s: %w", v.name, err)
+			}
+			roots[i] = p.Cid()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return roots, nil
+}
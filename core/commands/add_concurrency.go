@@ -0,0 +1,161 @@
+package commands
+
+import (
+	"context"
+	"sync"
+
+	files "github.com/bittorrent/go-btfs-files"
+	coreiface "github.com/bittorrent/interface-go-btfs-core"
+	coreifacePath "github.com/bittorrent/interface-go-btfs-core/path"
+)
+
+// addEntryFunc adds node, the entry named name of an add, sending the events
+// of the add on events.
+type addEntryFunc func(ctx context.Context, name string, node files.Node, events chan<- interface{}) (coreifacePath.Resolved, error)
+
+// addJob is the add of one entry, run by addJobs.
+type addJob struct {
+	name   string
+	node   files.Node
+	dir    bool
+	events *addEventBuffer
+
+	done chan struct{}
+	// root and err are set once done is closed
+	root coreifacePath.Resolved
+	err  error
+}
+
+// addJobs adds the entries of an add, up to concurrency at once, and hands
+// the adds over on jobs in input order. An add keeps its slot until it is
+// released, once its events were read, so that at most concurrency adds hold
+// events nobody reads yet.
+type addJobs struct {
+	jobs  chan *addJob
+	slots chan struct{}
+	// err is the error of the entries iterator, set once jobs is closed
+	err error
+}
+
+// startAddJobs starts adding the entries of it with add. Canceling ctx
+// stops the adds and closes jobs.
+func startAddJobs(ctx context.Context, it files.DirIterator, concurrency int, add addEntryFunc) *addJobs {
+	a := &addJobs{
+		jobs:  make(chan *addJob, concurrency),
+		slots: make(chan struct{}, concurrency),
+	}
+	go func() {
+		defer close(a.jobs)
+		for {
+			// the next entry is only read once a slot is free, the entries
+			// of a multipart request can't be read ahead
+			select {
+			case a.slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			if !it.Next() {
+				a.err = it.Err()
+				return
+			}
+			_, dir := it.Node().(files.Directory)
+			job := &addJob{
+				name:   it.Name(),
+				node:   it.Node(),
+				dir:    dir,
+				events: newAddEventBuffer(),
+				done:   make(chan struct{}),
+			}
+			events := make(chan interface{}, adderOutChanSize)
+			go func() {
+				for e := range events {
+					job.events.push(e)
+				}
+				job.events.close()
+			}()
+			go func() {
+				defer close(job.done)
+				defer close(events)
+				job.root, job.err = add(ctx, job.name, job.node, events)
+			}()
+			a.jobs <- job
+		}
+	}()
+	return a
+}
+
+// release frees the slot of an add handed over on jobs.
+func (a *addJobs) release() {
+	<-a.slots
+}
+
+// addEventBuffer holds the events of an add until they are read, so that the
+// adds running ahead of the one whose events are emitted don't wait for it.
+type addEventBuffer struct {
+	mu     sync.Mutex
+	events []interface{}
+	closed bool
+	ready  chan struct{}
+}
+
+func newAddEventBuffer() *addEventBuffer {
+	return &addEventBuffer{ready: make(chan struct{}, 1)}
+}
+
+func (b *addEventBuffer) push(e interface{}) {
+	b.mu.Lock()
+	// Only the last progress of a file counts for the progress bar, which
+	// sums the bytes of a file once the next one starts. Progress going
+	// back is the start of another file of the same name.
+	n := len(b.events)
+	if p, ok := addProgress(e); ok && n > 0 {
+		if last, ok := addProgress(b.events[n-1]); ok && last.Name == p.Name && last.Bytes <= p.Bytes {
+			n--
+			b.events = b.events[:n]
+		}
+	}
+	b.events = append(b.events, e)
+	b.mu.Unlock()
+	b.signal()
+}
+
+func (b *addEventBuffer) close() {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	b.signal()
+}
+
+func (b *addEventBuffer) signal() {
+	select {
+	case b.ready <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the next event, waiting for it, or false once the add is done
+// and all its events were read.
+func (b *addEventBuffer) next() (interface{}, bool) {
+	for {
+		b.mu.Lock()
+		if len(b.events) > 0 {
+			e := b.events[0]
+			b.events[0] = nil
+			b.events = b.events[1:]
+			b.mu.Unlock()
+			return e, true
+		}
+		closed := b.closed
+		b.mu.Unlock()
+		if closed {
+			return nil, false
+		}
+		<-b.ready
+	}
+}
+
+// addProgress returns e if it only reports the progress of an add.
+func addProgress(e interface{}) (*coreiface.AddEvent, bool) {
+	p, ok := e.(*coreiface.AddEvent)
+	return p, ok && p.Path == nil
+}
@@ -0,0 +1,146 @@
+package commands
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+	"github.com/bittorrent/go-btfs/chain"
+	"github.com/bittorrent/go-btfs/core/commands/cmdenv"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var addFileMetaCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Read file meta recorded by 'btfs add --to-blockchain'.",
+	},
+	Subcommands: map[string]*cmds.Command{
+		"get":    addFileMetaGetCmd,
+		"status": addFileMetaStatusCmd,
+	},
+}
+
+type addFileMetaGetResult struct {
+	Cid         string
+	OwnerPeerId string
+	From        string
+	FileName    string
+	FileExt     string
+	IsDir       bool
+	FileSize    *big.Int
+}
+
+var addFileMetaGetCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Get the file meta recorded on chain for a CID.",
+		ShortDescription: `
+Reads the file meta recorded for the CID in the FileMeta contract of the
+configured chain, through the chain endpoint of the config, and prints it as
+JSON. A CID with no recorded file meta fails with a client error, distinct
+from errors reaching the chain.`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("cid", true, false, "CID of the added file or directory."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		cfg, err := cmdenv.GetConfig(env)
+		if err != nil {
+			return err
+		}
+		c := req.Arguments[0]
+		meta, err := chain.GetFileMeta(req.Context, cfg, c)
+		if errors.Is(err, chain.ErrFileMetaNotFound) {
+			return cmds.Errorf(cmds.ErrClient, "no file meta recorded for %s", c)
+		}
+		if err != nil {
+			return fmt.Errorf("read file meta from chain: %w", err)
+		}
+		return cmds.EmitOnce(res, &addFileMetaGetResult{
+			Cid:         c,
+			OwnerPeerId: meta.OwnerPeerId,
+			From:        meta.From.Hex(),
+			FileName:    meta.FileName,
+			FileExt:     meta.FileExt,
+			IsDir:       meta.IsDir,
+			FileSize:    meta.FileSize,
+		})
+	},
+	Type: addFileMetaGetResult{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *addFileMetaGetResult) error {
+			b, err := json.MarshalIndent(out, "", "  ")
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(w, "%s\n", b)
+			return err
+		}),
+	},
+}
+
+var addFileMetaStatusCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show whether a file meta transaction is confirmed.",
+		ShortDescription: `
+Prints the status of a file meta transaction submitted by 'btfs add
+--to-blockchain', as tracked by the daemon: pending until it is mined and
+confirmed, then confirmed or reverted, with the block it was mined in.
+Transactions submitted without a running daemon are not tracked.`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("tx-hash", true, false, "Hash of the file meta transaction."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		if err := checkDaemon(env); err != nil {
+			return err
+		}
+		w := chain.FileMetaWatcherObject
+		if w == nil {
+			return errNoFileMetaWatcher
+		}
+		txHash, err := parseTxHash(req.Arguments[0])
+		if err != nil {
+			return cmds.Errorf(cmds.ErrClient, err.Error())
+		}
+		status, err := w.Status(txHash)
+		if errors.Is(err, chain.ErrFileMetaTxNotWatched) {
+			return cmds.Errorf(cmds.ErrClient, "file meta transaction %s is not tracked by this node", txHash.Hex())
+		}
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, status)
+	},
+	Type: chain.FileMetaTxStatus{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *chain.FileMetaTxStatus) error {
+			fmt.Fprintf(w, "%s (%s): %s", out.TxHash, out.Cid, out.Status)
+			if out.BlockNumber != 0 {
+				fmt.Fprintf(w, " in block %d", out.BlockNumber)
+			}
+			if out.LastError != "" {
+				fmt.Fprintf(w, ", last check failed: %s", out.LastError)
+			}
+			_, err := fmt.Fprintln(w)
+			return err
+		}),
+	},
+}
+
+var errNoFileMetaWatcher = errors.New("file meta watcher is not running, it is not available in simple mode")
+
+// parseTxHash parses a 32 bytes hex encoded transaction hash, with or
+// without 0x prefix.
+func parseTxHash(s string) (common.Hash, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil || len(b) != common.HashLength {
+		return common.Hash{}, fmt.Errorf("invalid transaction hash %q", s)
+	}
+	return common.BytesToHash(b), nil
+}
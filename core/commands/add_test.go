@@ -0,0 +1,349 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	coremock "github.com/bittorrent/go-btfs/core/mock"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+	files "github.com/bittorrent/go-btfs-files"
+	coreiface "github.com/bittorrent/interface-go-btfs-core"
+	coreifacePath "github.com/bittorrent/interface-go-btfs-core/path"
+	cid "github.com/ipfs/go-cid"
+	cidenc "github.com/ipfs/go-cidutil/cidenc"
+	mh "github.com/multiformats/go-multihash"
+)
+
+func TestValidateChunker(t *testing.T) {
+	for _, c := range []struct {
+		chunker string
+		valid   bool
+	}{
+		{"", true},
+		{"size-262144", true},
+		{"size-0", false},
+		{"size--1", false},
+		{"size-abc", false},
+		{"size-1073741824", false},
+		{"rabin", true},
+		{"rabin-262144", true},
+		{"rabin-1000-2000-3000", true},
+		{"rabin-1000-1000-1000", true},
+		{"rabin-3000-2000-1000", false},
+		{"rabin-1000-2000", false},
+		{"rabin-0-2000-3000", false},
+		{"reed-solomon", true},
+		{"fastcdc-4096", false},
+	} {
+		err := validateChunker(c.chunker)
+		if c.valid && err != nil {
+			t.Errorf("%q: unexpected error: %s", c.chunker, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%q: expected an error", c.chunker)
+		}
+	}
+}
+
+func TestParseHashAllChunkers(t *testing.T) {
+	chunkers, err := parseHashAllChunkers("size-262144, rabin,buzhash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunkers) != 3 || chunkers[0] != "size-262144" || chunkers[1] != "rabin" || chunkers[2] != "buzhash" {
+		t.Fatalf("unexpected chunkers %q", chunkers)
+	}
+
+	for _, s := range []string{"size-262144,,rabin", "rabin,rabin", "size-0,rabin"} {
+		if _, err := parseHashAllChunkers(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestCheckExpectedCid(t *testing.T) {
+	sum, err := mh.Sum([]byte("data"), mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v0 := cid.NewCidV0(sum)
+	v1 := cid.NewCidV1(cid.DagProtobuf, sum)
+	other := cid.NewCidV1(cid.Raw, sum)
+	enc := cidenc.Default()
+
+	if err := checkExpectedCid(v0, v0, enc); err != nil {
+		t.Fatal(err)
+	}
+	err = checkExpectedCid(v0, v1, enc)
+	if err == nil || !strings.Contains(err.Error(), enc.Encode(v0)) || !strings.Contains(err.Error(), enc.Encode(v1)) {
+		t.Fatalf("expected a mismatch listing both CIDs, got %v", err)
+	}
+	if err := checkExpectedCid(v1, other, enc); err == nil || !strings.Contains(err.Error(), "only the CID version or codec differs") {
+		t.Fatalf("expected a codec mismatch, got %v", err)
+	}
+}
+
+func TestAddJobs(t *testing.T) {
+	const entries, concurrency = 6, 3
+	var dirEntries []files.DirEntry
+	for i := 0; i < entries; i++ {
+		dirEntries = append(dirEntries, files.FileEntry(fmt.Sprintf("file%d", i), files.NewBytesFile([]byte("data"))))
+	}
+
+	var mu sync.Mutex
+	var running, maxRunning int
+	adds := startAddJobs(context.Background(), files.NewSliceDirectory(dirEntries).Entries(), concurrency,
+		func(ctx context.Context, name string, node files.Node, events chan<- interface{}) (coreifacePath.Resolved, error) {
+			mu.Lock()
+			if running++; running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+			defer func() {
+				mu.Lock()
+				running--
+				mu.Unlock()
+			}()
+			// the first entries are the slowest to add
+			var i int
+			fmt.Sscanf(name, "file%d", &i)
+			time.Sleep(time.Duration(entries-i) * 10 * time.Millisecond)
+
+			sum, err := mh.Sum([]byte(name), mh.SHA2_256, -1)
+			if err != nil {
+				return nil, err
+			}
+			root := coreifacePath.IpfsPath(cid.NewCidV1(cid.Raw, sum))
+			for b := int64(1); b <= 3; b++ {
+				events <- &coreiface.AddEvent{Name: name, Bytes: b}
+			}
+			events <- &coreiface.AddEvent{Name: name, Path: root}
+			return root, nil
+		})
+
+	var got int
+	for job := range adds.jobs {
+		if want := fmt.Sprintf("file%d", got); job.name != want {
+			t.Fatalf("expected %s, got %s", want, job.name)
+		}
+		var progress []int64
+		var done bool
+		for {
+			e, ok := job.events.next()
+			if !ok {
+				break
+			}
+			if p, ok := addProgress(e); ok {
+				progress = append(progress, p.Bytes)
+			} else {
+				done = true
+			}
+		}
+		<-job.done
+		adds.release()
+		if job.err != nil {
+			t.Fatal(job.err)
+		}
+		if !done || len(progress) == 0 || progress[len(progress)-1] != 3 {
+			t.Fatalf("expected the progress of %s to end at 3 bytes before it is added, got %v", job.name, progress)
+		}
+		got++
+	}
+	if adds.err != nil {
+		t.Fatal(adds.err)
+	}
+	if got != entries {
+		t.Fatalf("expected %d adds, got %d", entries, got)
+	}
+	if maxRunning > concurrency {
+		t.Fatalf("expected at most %d adds at once, got %d", concurrency, maxRunning)
+	}
+}
+
+func TestAddEventBufferCoalesce(t *testing.T) {
+	b := newAddEventBuffer()
+	for _, e := range []*coreiface.AddEvent{
+		{Name: "a", Bytes: 1},
+		{Name: "a", Bytes: 5},
+		// another file of the same name
+		{Name: "a", Bytes: 2},
+		{Name: "b", Bytes: 4},
+		{Name: "b", Bytes: 7},
+	} {
+		b.push(e)
+	}
+	b.close()
+
+	var got []string
+	for {
+		e, ok := b.next()
+		if !ok {
+			break
+		}
+		p := e.(*coreiface.AddEvent)
+		got = append(got, fmt.Sprintf("%s:%d", p.Name, p.Bytes))
+	}
+	if want := "a:5 a:2 b:7"; strings.Join(got, " ") != want {
+		t.Fatalf("expected %s, got %s", want, strings.Join(got, " "))
+	}
+}
+
+func TestCidV1Warning(t *testing.T) {
+	if w := cidV1Warning("sha2-256", false, false, false); w != "" {
+		t.Fatalf("expected no warning by default, got %q", w)
+	}
+	for _, c := range []struct {
+		hash                      string
+		inline, rawLeaves, nocopy bool
+		option                    string
+	}{
+		{hash: "blake2b-256", option: "--hash=blake2b-256"},
+		{hash: "sha2-256", rawLeaves: true, option: "--raw-leaves"},
+		{hash: "sha2-256", nocopy: true, option: "--nocopy"},
+		{hash: "sha2-256", inline: true, option: "--inline"},
+	} {
+		w := cidV1Warning(c.hash, c.inline, c.rawLeaves, c.nocopy)
+		if !strings.Contains(w, c.option) || !strings.Contains(w, "--cid-version") {
+			t.Fatalf("expected a warning naming %s, got %q", c.option, w)
+		}
+	}
+}
+
+func TestWrapFileMeta(t *testing.T) {
+	data := wrapFileMeta(map[string]bool{"example.jpg": true}, 42)
+	if !data.IsDir || data.FileName != "example.jpg" || data.FileExt != "" || data.FileSize != 42 {
+		t.Fatalf("unexpected file meta of a single wrapped file: %+v", data)
+	}
+	data = wrapFileMeta(map[string]bool{"a.txt": true, "b.txt": true}, 42)
+	if !data.IsDir || data.FileName != "" {
+		t.Fatalf("unexpected file meta of several wrapped files: %+v", data)
+	}
+}
+
+func TestWrapName(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		valid bool
+	}{
+		{"photos", true},
+		{"my photos.2024", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"a/b", false},
+		{"a\\b", false},
+	} {
+		err := validateWrapName(c.name)
+		if c.valid && err != nil {
+			t.Errorf("%q: unexpected error: %s", c.name, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%q: expected an error", c.name)
+		}
+	}
+
+	for _, c := range []struct {
+		name, wrapName string
+		entry          string
+		ok             bool
+	}{
+		{"example.jpg", "", "example.jpg", true},
+		{"", "", "", false},
+		{"dir/a.txt", "", "", false},
+		{"photos/example.jpg", "photos", "example.jpg", true},
+		{"photos", "photos", "", false},
+		{"photos/dir/a.txt", "photos", "", false},
+		{"other/a.txt", "photos", "", false},
+	} {
+		entry, ok := wrappedEntryName(c.name, c.wrapName)
+		if ok != c.ok || ok && entry != c.entry {
+			t.Errorf("%q wrapped in %q: expected (%q, %v), got (%q, %v)", c.name, c.wrapName, c.entry, c.ok, entry, ok)
+		}
+	}
+}
+
+func TestParseHashMulti(t *testing.T) {
+	names, err := parseHashMulti("sha2-256, BLAKE3,sha3-256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 3 || names[0] != "sha2-256" || names[1] != "blake3" || names[2] != "sha3-256" {
+		t.Fatalf("unexpected hash functions %q", names)
+	}
+	for _, v := range hashFunctionVariants(names) {
+		if v.opt == nil {
+			t.Fatalf("%s: missing hash option", v.name)
+		}
+	}
+
+	for _, s := range []string{"sha2-256,,blake3", "blake3,blake3", "sha2-256,md42"} {
+		if _, err := parseHashMulti(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestAddPreserveDisablesRawLeaves(t *testing.T) {
+	env, err := coremock.MockCmdsCtx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	add := func(opts cmds.OptMap) cid.Cid {
+		t.Helper()
+		dir := files.NewMapDirectory(map[string]files.Node{
+			"file": files.NewBytesFile([]byte("preserved")),
+		})
+		req, err := cmds.NewRequest(context.Background(), []string{}, opts, nil, dir, AddCmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := req.FillDefaults(); err != nil {
+			t.Fatal(err)
+		}
+		re, res := cmds.NewChanResponsePair(req)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- cmds.NewExecutor(AddCmd).Execute(req, re, &env)
+		}()
+
+		var root cid.Cid
+		for {
+			v, err := res.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e, ok := v.(*AddEvent); ok && e.Hash != "" {
+				if root, err = cid.Decode(e.Hash); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if err := <-errCh; err != nil {
+			t.Fatal(err)
+		}
+		if !root.Defined() {
+			t.Fatalf("%v: no root added", opts)
+		}
+		return root
+	}
+
+	// CIDv1 implies raw leaves, the file is a single raw block
+	if root := add(cmds.OptMap{cidVersionOptionName: 1}); root.Type() != cid.Raw {
+		t.Fatalf("expected a raw root without UnixFS metadata, got %s", root)
+	}
+	for _, opt := range []string{preserveModeOptionName, preserveMtimeOptionName} {
+		root := add(cmds.OptMap{cidVersionOptionName: 1, opt: true})
+		if root.Type() != cid.DagProtobuf {
+			t.Fatalf("--%s: expected raw leaves off and a dag-pb root, got %s", opt, root)
+		}
+	}
+}
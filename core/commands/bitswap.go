@@ -26,6 +26,7 @@ var BitswapCmd = &cmds.Command{
 		"wantlist":  showWantlistCmd,
 		"ledger":    ledgerCmd,
 		"reprovide": reprovideCmd,
+		"provide":   bitswapProvideCmd,
 	},
 }
 
@@ -241,3 +242,58 @@ Trigger reprovider to announce our data to network.
 		return nil
 	},
 }
+
+type bitswapProvideResult struct {
+	Enabled bool
+}
+
+var bitswapProvideCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show or toggle bitswap announcing of new blocks.",
+		ShortDescription: `
+Without an argument, shows whether bitswap announces new blocks to the
+routing system. With "on" or "off", turns announcing on or off until the
+daemon is restarted, which goes back to the configured value.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("state", false, false, "on or off."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if !nd.IsOnline {
+			return ErrNotOnline
+		}
+		if nd.BitswapProvide == nil {
+			return fmt.Errorf("bitswap provide control is not available")
+		}
+
+		if len(req.Arguments) > 0 {
+			switch req.Arguments[0] {
+			case "on":
+				nd.BitswapProvide.SetEnabled(true)
+			case "off":
+				nd.BitswapProvide.SetEnabled(false)
+			default:
+				return fmt.Errorf("invalid state %q, must be on or off", req.Arguments[0])
+			}
+		}
+
+		return cmds.EmitOnce(res, &bitswapProvideResult{Enabled: nd.BitswapProvide.Enabled()})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *bitswapProvideResult) error {
+			state := "off"
+			if out.Enabled {
+				state = "on"
+			}
+			_, err := fmt.Fprintf(w, "bitswap provide: %s\n", state)
+			return err
+		}),
+	},
+	Type: bitswapProvideResult{},
+}
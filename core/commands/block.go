@@ -13,6 +13,7 @@ import (
 	files "github.com/bittorrent/go-btfs-files"
 	options "github.com/bittorrent/interface-go-btfs-core/options"
 	path "github.com/bittorrent/interface-go-btfs-core/path"
+	cid "github.com/ipfs/go-cid"
 	mh "github.com/multiformats/go-multihash"
 )
 
@@ -40,6 +41,7 @@ multihash.
 		"get":  blockGetCmd,
 		"put":  blockPutCmd,
 		"rm":   blockRmCmd,
+		"has":  blockHasCmd,
 	},
 }
 
@@ -84,6 +86,67 @@ on raw BTFS blocks. It outputs the following to stdout:
 	},
 }
 
+// maxBlockHasKeys bounds the keys of a single 'block has', which remote
+// peers call as well.
+const maxBlockHasKeys = 1024
+
+// BlockHas tells, in the order of Keys, which blocks are stored.
+type BlockHas struct {
+	Keys []string
+	Has  []bool
+}
+
+var blockHasCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Check which blocks are stored locally.",
+		ShortDescription: `
+'btfs block has' is a plumbing command telling, for each <key>, whether
+the block is stored in the local blockstore. It never fetches blocks from
+the network. Peers call it to find out which blocks of an add they can
+leave to this node, see 'btfs add --dedup-against'.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("key", true, true, "The CIDs of the blocks to look for."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		if len(req.Arguments) > maxBlockHasKeys {
+			return fmt.Errorf("at most %d blocks can be checked at once, got %d", maxBlockHasKeys, len(req.Arguments))
+		}
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		out := &BlockHas{
+			Keys: req.Arguments,
+			Has:  make([]bool, len(req.Arguments)),
+		}
+		for i, k := range req.Arguments {
+			c, err := cid.Decode(k)
+			if err != nil {
+				return fmt.Errorf("invalid block key %q: %w", k, err)
+			}
+			if out.Has[i], err = nd.Blockstore.Has(req.Context, c); err != nil {
+				return err
+			}
+		}
+		return cmds.EmitOnce(res, out)
+	},
+	Type: BlockHas{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, bh *BlockHas) error {
+			for i, k := range bh.Keys {
+				if _, err := fmt.Fprintf(w, "%s %t\n", k, bh.Has[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}),
+	},
+}
+
 var blockGetCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Get a raw BTFS block.",
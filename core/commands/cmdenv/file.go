@@ -2,9 +2,12 @@ package cmdenv
 
 import (
 	"bufio"
+	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	gopath "path"
 	"strings"
@@ -26,6 +29,10 @@ import (
 var DefaultBufSize = 1048576
 var ErrInvalidCompressionLevel = errors.New("compression level must be between 1 and 9")
 
+// ErrSHA256Mismatch is returned once a file got with an expected SHA-256 is
+// read to its end, if its content has another one.
+var ErrSHA256Mismatch = errors.New("sha256 mismatch")
+
 // GetFileArg returns the next file from the directory or an error
 func GetFileArg(it files.DirIterator) (files.File, error) {
 	if !it.Next() {
@@ -47,12 +54,16 @@ type PinOutput struct {
 }
 
 func DownloadAndRebuildFile(req *cmds.Request, res cmds.ResponseEmitter, api coreiface.CoreAPI, fileHash string, lostShards string) error {
-	_, err := GetFile(req, res, api, fileHash, false, "", false, lostShards, false, false, false, 0)
+	_, err := GetFile(req, res, api, fileHash, false, "", false, lostShards, false, false, false, 0, nil)
 	return err
 }
 
+// GetFile returns the archive, or the compressed file, of btfsPath. If
+// sha256Sum is set, btfsPath must be a file, and reading the archive to its
+// end fails with ErrSHA256Mismatch unless the content of the file has that
+// SHA-256. In quiet mode, the file is then read here to be checked.
 func GetFile(req *cmds.Request, res cmds.ResponseEmitter, api coreiface.CoreAPI, btfsPath string, decrypt bool,
-	privateKey string, meta bool, repairShards string, quiet bool, archive bool, cmprs bool, cmplvl int) (io.Reader, error) {
+	privateKey string, meta bool, repairShards string, quiet bool, archive bool, cmprs bool, cmplvl int, sha256Sum []byte) (io.Reader, error) {
 
 	var repairs []cid.Cid
 	if repairShards != "" {
@@ -79,6 +90,18 @@ func GetFile(req *cmds.Request, res cmds.ResponseEmitter, api coreiface.CoreAPI,
 		return nil, err
 	}
 
+	if sha256Sum != nil {
+		f, ok := file.(files.File)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a file, only the content of files can be verified", btfsPath)
+		}
+		file = &sha256File{File: f, h: sha256.New(), sum: sha256Sum}
+		if quiet {
+			_, err := io.Copy(io.Discard, file.(files.File))
+			return nil, err
+		}
+	}
+
 	if quiet {
 		return nil, nil
 	}
@@ -193,6 +216,25 @@ func fileArchive(f files.Node, name string, archive bool, compression int) (io.R
 	return piper, nil
 }
 
+// sha256File checks, once it is read to its end, that the content of File
+// has the SHA-256 sum.
+type sha256File struct {
+	files.File
+	h   hash.Hash
+	sum []byte
+}
+
+func (f *sha256File) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.h.Write(p[:n])
+	if err == io.EOF {
+		if got := f.h.Sum(nil); !bytes.Equal(got, f.sum) {
+			return n, fmt.Errorf("%w: expected %x, got %x", ErrSHA256Mismatch, f.sum, got)
+		}
+	}
+	return n, err
+}
+
 func newMaybeGzWriter(w io.Writer, compression int) (io.WriteCloser, error) {
 	if compression != gzip.NoCompression {
 		return gzip.NewWriterLevel(w, compression)
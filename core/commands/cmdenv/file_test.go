@@ -0,0 +1,36 @@
+package cmdenv
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	files "github.com/bittorrent/go-btfs-files"
+)
+
+func TestSHA256File(t *testing.T) {
+	content := []byte(strings.Repeat("verified content ", 1000))
+	sum := sha256.Sum256(content)
+
+	f := &sha256File{File: files.NewBytesFile(content), h: sha256.New(), sum: sum[:]}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != string(content) {
+		t.Fatal("expected the content to be read as is")
+	}
+
+	other := sha256.Sum256([]byte("other content"))
+	f = &sha256File{File: files.NewBytesFile(content), h: sha256.New(), sum: other[:]}
+	_, err = io.ReadAll(f)
+	if !errors.Is(err, ErrSHA256Mismatch) {
+		t.Fatalf("expected a mismatch, got %v", err)
+	}
+	if !strings.Contains(err.Error(), hex.EncodeToString(sum[:])) {
+		t.Fatalf("expected the computed sum in the error, got %v", err)
+	}
+}
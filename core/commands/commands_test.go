@@ -4,6 +4,8 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/upload"
+
 	cmds "github.com/bittorrent/go-btfs-cmds"
 )
 
@@ -67,16 +69,40 @@ func TestROCommands(t *testing.T) {
 		}
 	}
 }
+
+// Hosts only serve RootRemote to renters.
+func TestRemoteStorageUploadChallenge(t *testing.T) {
+	path := strings.Split(strings.TrimPrefix(upload.StorageUploadChallengePath, "/"), "/")
+	sub, err := RootRemote.Get(path)
+	if err != nil {
+		t.Fatalf("error getting subcommand %q: %v", upload.StorageUploadChallengePath, err)
+	}
+	if sub != upload.StorageUploadChallengeCmd {
+		t.Fatalf("expected %q to be the upload challenge command", upload.StorageUploadChallengePath)
+	}
+	if _, err := Root.Get(path); err == nil {
+		t.Fatalf("expected %q not to be a local command", upload.StorageUploadChallengePath)
+	}
+}
+
 func TestCommands(t *testing.T) {
 	list := []string{
 		"/add",
+		"/add/blockchain-queue",
+		"/add/blockchain-queue/retry",
+		"/add/blockchain-queue/status",
+		"/add/filemeta",
+		"/add/filemeta/get",
+		"/add/filemeta/status",
 		"/bitswap",
 		"/bitswap/ledger",
+		"/bitswap/provide",
 		"/bitswap/reprovide",
 		"/bitswap/stat",
 		"/bitswap/wantlist",
 		"/block",
 		"/block/get",
+		"/block/has",
 		"/block/put",
 		"/block/rm",
 		"/block/stat",
@@ -105,6 +131,7 @@ func TestCommands(t *testing.T) {
 		"/dag/export",
 		"/dag/put",
 		"/dag/import",
+		"/dag/import-add",
 		"/dag/resolve",
 		"/dag/stat",
 		"/dht",
@@ -178,6 +205,10 @@ func TestCommands(t *testing.T) {
 		"/p2p/stream/ls",
 		"/pin",
 		"/pin/add",
+		"/pin/expiring",
+		"/pin/export",
+		"/pin/extend",
+		"/pin/import",
 		"/ping",
 		"/pin/ls",
 		"/pin/rm",
@@ -193,6 +224,7 @@ func TestCommands(t *testing.T) {
 		"/repo",
 		"/repo/fsck",
 		"/repo/gc",
+		"/repo/scrub",
 		"/repo/stat",
 		"/repo/verify",
 		"/repo/version",
@@ -203,6 +235,7 @@ func TestCommands(t *testing.T) {
 		"/stats",
 		"/stats/bitswap",
 		"/stats/bw",
+		"/stats/bw/data",
 		"/stats/dht",
 		"/stats/repo",
 		"/swarm",
@@ -239,10 +272,16 @@ func TestCommands(t *testing.T) {
 		"/storage/upload/recvcontract",
 		"/storage/upload/status",
 		"/storage/upload/repair",
+		"/storage/upload/resume",
+		"/storage/upload/renew",
+		"/storage/upload/watch",
+		"/storage/upload/history",
 		"/storage/upload/getcontractbatch",
 		"/storage/upload/signcontractbatch",
 		"/storage/upload/getunsigned",
 		"/storage/upload/sign",
+		"/storage/upload/preparecontracts",
+		"/storage/upload/completecontracts",
 		"/storage/announce",
 		"/storage/info",
 		"/storage/hosts",
@@ -254,6 +293,8 @@ func TestCommands(t *testing.T) {
 		"/storage/dcrepair",
 		"/storage/dcrepair/request",
 		"/storage/dcrepair/response",
+		"/storage/reconstruct",
+		"/storage/check",
 		"/storage/stats",
 		"/storage/stats/info",
 		"/storage/stats/sync",
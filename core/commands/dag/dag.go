@@ -52,12 +52,13 @@ to deprecate and replace the existing 'btfs object' command moving forward.
 		`,
 	},
 	Subcommands: map[string]*cmds.Command{
-		"put":     DagPutCmd,
-		"get":     DagGetCmd,
-		"resolve": DagResolveCmd,
-		"import":  DagImportCmd,
-		"export":  DagExportCmd,
-		"stat":    DagStatCmd,
+		"put":        DagPutCmd,
+		"get":        DagGetCmd,
+		"resolve":    DagResolveCmd,
+		"import":     DagImportCmd,
+		"import-add": DagImportAddCmd,
+		"export":     DagExportCmd,
+		"stat":       DagStatCmd,
 	},
 }
 
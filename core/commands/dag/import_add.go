@@ -0,0 +1,221 @@
+package dagcmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/bittorrent/go-btfs/core"
+	"github.com/bittorrent/go-btfs/core/commands/cmdenv"
+	"github.com/bittorrent/go-btfs/core/coreunix"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+	files "github.com/bittorrent/go-btfs-files"
+	blocks "github.com/ipfs/go-block-format"
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipld "github.com/ipfs/go-ipld-format"
+	mdag "github.com/ipfs/go-merkledag"
+	gocarv2 "github.com/ipld/go-car/v2"
+)
+
+const (
+	pinOptionName                 = "pin"
+	pinDurationCountOptionName    = "pin-duration-count"
+	pinDurationRootOnlyOptionName = "pin-duration-root-only"
+
+	importAddBatchSize = 1024
+)
+
+// ImportAddEvent is the output of 'dag import-add', one per root. It has the
+// fields of the 'btfs add' output so that the same consumers can read it.
+type ImportAddEvent struct {
+	Name string
+	Hash string `json:",omitempty"`
+	Size string `json:",omitempty"`
+}
+
+var DagImportAddCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Add the contents of .car files like 'btfs add' would.",
+		ShortDescription: `
+'btfs dag import-add' imports all blocks of the given .car files (CARv1 or
+CARv2), checks that the complete DAG of every root listed in the headers is
+available, then pins the roots with the pin options of 'btfs add'.
+
+Unlike 'btfs dag import', the command fails without pinning anything if a
+root or any block below it is missing from both the .car files and the
+local blockstore. Imported blocks are not removed in that case, but they
+are not pinned and may be garbage collected later.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.FileArg("path", true, true, "The path of a .car file.").EnableStdin(),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(silentOptionName, "No output."),
+		cmds.BoolOption(pinOptionName, "Pin the roots of the .car files.").WithDefault(true),
+		cmds.IntOption(pinDurationCountOptionName, "d", "Duration for which the roots are pinned in days. Requires pinning.").WithDefault(0),
+		cmds.BoolOption(pinDurationRootOnlyOptionName, "Pin only the roots for the pin duration, the blocks below them follow the default GC. Requires --pin-duration-count.").WithDefault(false),
+	},
+	Type: ImportAddEvent{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		node, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		enc, err := cmdenv.GetLowLevelCidEncoder(req)
+		if err != nil {
+			return err
+		}
+
+		dopin, _ := req.Options[pinOptionName].(bool)
+		pinDuration, _ := req.Options[pinDurationCountOptionName].(int)
+		pinRootOnly, _ := req.Options[pinDurationRootOnlyOptionName].(bool)
+		if pinDuration != 0 && !dopin {
+			return fmt.Errorf("%s can't be used with --%s=false", pinDurationCountOptionName, pinOptionName)
+		}
+		if pinRootOnly && pinDuration <= 0 {
+			return fmt.Errorf("%s requires a positive %s", pinDurationRootOnlyOptionName, pinDurationCountOptionName)
+		}
+
+		// hold the pin lock so that nothing imported is collected before
+		// the roots are pinned
+		ctx := req.Context
+		defer node.Blockstore.PinLock(ctx).Unlock(ctx)
+
+		roots, err := importCarBlocks(req, node)
+		if err != nil {
+			return err
+		}
+
+		// never reach out to the network, the DAG must be complete locally
+		dserv := mdag.NewDAGService(bserv.New(node.Blockstore, offline.Exchange(node.Blockstore)))
+		nodes := make([]ipld.Node, 0, len(roots))
+		for _, c := range roots {
+			nd, err := dserv.Get(ctx, c)
+			if ipld.IsNotFound(err) {
+				return fmt.Errorf("root %s is missing from the .car files and the blockstore", c)
+			} else if err != nil {
+				return err
+			}
+			err = mdag.Walk(ctx, mdag.GetLinksDirect(dserv), c, cid.NewSet().Visit)
+			if err != nil {
+				return fmt.Errorf("DAG of root %s is incomplete: %w", c, err)
+			}
+			nodes = append(nodes, nd)
+		}
+
+		for _, nd := range nodes {
+			if dopin {
+				// the roots are pinned like 'btfs add' pins them
+				if err := node.Pinning.Pin(ctx, nd, !pinRootOnly); err != nil {
+					return fmt.Errorf("pin %s: %w", nd.Cid(), err)
+				}
+				if pinDuration > 0 {
+					err := coreunix.SetPinDuration(ctx, node.Repo.Datastore(), nd.Cid(), int64(pinDuration))
+					if err != nil {
+						return err
+					}
+				}
+			}
+
+			event := &ImportAddEvent{
+				Name: enc.Encode(nd.Cid()),
+				Hash: enc.Encode(nd.Cid()),
+			}
+			if size, err := nd.Size(); err == nil {
+				event.Size = fmt.Sprint(size)
+			}
+			if err := res.Emit(event); err != nil {
+				return err
+			}
+		}
+		if dopin {
+			return node.Pinning.Flush(ctx)
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, event *ImportAddEvent) error {
+			if silent, _ := req.Options[silentOptionName].(bool); silent {
+				return nil
+			}
+			_, err := fmt.Fprintf(w, "added %s %s\n", event.Hash, event.Name)
+			return err
+		}),
+	},
+}
+
+// importCarBlocks stores the blocks of all .car files of req and returns
+// the roots listed in their headers, sorted.
+func importCarBlocks(req *cmds.Request, node *core.IpfsNode) ([]cid.Cid, error) {
+	ctx := req.Context
+	roots := make(map[cid.Cid]struct{})
+	batch := make([]blocks.Block, 0, importAddBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := node.Blockstore.PutMany(ctx, batch)
+		batch = batch[:0]
+		return err
+	}
+
+	it := req.Files.Entries()
+	for it.Next() {
+		file := files.FileFromEntry(it)
+		if file == nil {
+			return nil, errors.New("expected a file handle")
+		}
+
+		err := func() error {
+			defer file.Close()
+
+			car, err := gocarv2.NewBlockReader(file)
+			if err != nil {
+				return err
+			}
+			for _, c := range car.Roots {
+				roots[c] = struct{}{}
+			}
+			for {
+				block, err := car.Next()
+				if err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				batch = append(batch, block)
+				if len(batch) == importAddBatchSize {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+			}
+		}()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", it.Name(), err)
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	if len(roots) == 0 {
+		return nil, errors.New("no roots in the .car headers")
+	}
+
+	sorted := make([]cid.Cid, 0, len(roots))
+	for c := range roots {
+		sorted = append(sorted, c)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].KeyString() < sorted[j].KeyString()
+	})
+	return sorted, nil
+}
@@ -439,6 +439,7 @@ $ btfs cp /btfs/<CID> /your/desired/mfs/path
 			return fmt.Errorf("cp: cannot get node from path %s: %s", src, err)
 		}
 
+		holdFilesRoot(nd, flush)
 		err = mfs.PutNode(nd.FilesRoot, dst, node)
 		if err != nil {
 			return fmt.Errorf("cp: cannot put node in path %s: %s", dst, err)
@@ -740,6 +741,7 @@ Example:
 			return err
 		}
 
+		holdFilesRoot(nd, flush)
 		err = mfs.Mv(nd.FilesRoot, src, dst)
 		if err == nil && flush {
 			_, err = mfs.FlushPath(req.Context, nd.FilesRoot, "/")
@@ -837,6 +839,7 @@ stat' on the file or any of its ancestors.
 			return fmt.Errorf("cannot have negative write offset")
 		}
 
+		holdFilesRoot(nd, flush)
 		if mkParents {
 			err := ensureContainingDirectoryExists(nd.FilesRoot, path, prefix)
 			if err != nil {
@@ -943,6 +946,7 @@ Examples:
 		if err != nil {
 			return err
 		}
+		holdFilesRoot(n, flush)
 		root := n.FilesRoot
 
 		err = mfs.Mkdir(root, dirtomake, mfs.MkdirOpts{
@@ -959,12 +963,24 @@ type flushRes struct {
 	Cid string
 }
 
+// holdFilesRoot opens a batch of MFS writes when flush is false, so that
+// the root is only written once 'btfs files flush' ends it.
+func holdFilesRoot(nd *core.IpfsNode, flush bool) {
+	if !flush && nd.FilesBatch != nil {
+		nd.FilesBatch.Start()
+	}
+}
+
 var filesFlushCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Flush a given path's data to disk.",
 		ShortDescription: `
 Flush a given path to the disk. This is only useful when other commands
 are run with the '--flush=false'.
+
+Commands run with '--flush=false' also stop the MFS root from being written to
+the datastore, so that a series of them syncs it once. Flush writes the root
+before returning, whatever the path flushed, and resumes the regular writes.
 `,
 	},
 	Arguments: []cmds.Argument{
@@ -990,6 +1006,12 @@ are run with the '--flush=false'.
 		if err != nil {
 			return err
 		}
+		// the root is written before returning, batched or not
+		if nd.FilesBatch != nil {
+			if err := nd.FilesBatch.End(req.Context); err != nil {
+				return err
+			}
+		}
 
 		return cmds.EmitOnce(res, &flushRes{enc.Encode(n.Cid())})
 	},
@@ -1028,6 +1050,7 @@ Change the cid version or hash function of the root node of a given path.
 			return err
 		}
 
+		holdFilesRoot(nd, flush)
 		err = updatePath(nd.FilesRoot, path, prefix)
 		if err == nil && flush {
 			_, err = mfs.FlushPath(req.Context, nd.FilesRoot, path)
@@ -2,10 +2,13 @@ package commands
 
 import (
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"time"
 
@@ -26,6 +29,8 @@ const (
 	decryptName                = "decrypt"
 	privateKeyName             = "private-key"
 	repairShardsName           = "repair-shards"
+	getPreserveOwnerOptionName = "preserve-owner"
+	verifySHA256OptionName     = "verify-sha256"
 )
 
 var GetCmd = &cmds.Command{
@@ -46,6 +51,28 @@ To output just the metadata of this BTFS node, use '--meta' or '-m'.
 
 To repair missing shards of a Reed-Solomon encoded file, use '--repair-shards' or '-rs'.
 If '--meta' or '-m' is enabled, this option is ignored.
+
+To decrypt a file added with 'btfs add --encrypt', use '--decrypt' or '-d'.
+The file is decrypted with the private key of this node, or the one given
+with '--private-key'. Files encrypted for several recipients can be
+decrypted by any of them. Getting a file which is not encrypted, or not
+encrypted for the key, fails. The whole file is decrypted before any of it
+is output, and directories can't be decrypted.
+
+To restore the owners recorded with 'btfs add --preserve-owner', use
+'--preserve-owner'. The extracted files are given their recorded uid and gid,
+which usually needs to run as root. With '--archive', the owners are set in
+the headers of the TAR archive instead. Files added without an owner keep
+the one of the user running the command. Owners can't be restored on
+Windows, which has no uid and gid, but can still be kept in an archive.
+
+To check the content of a file against the SHA-256 output by 'btfs add
+--record-sha256', use '--verify-sha256=<hex>'. The content is hashed as it
+is streamed, and the get fails with the computed SHA-256 once the file was
+read if it doesn't match. This checks the content itself, whatever the
+chunking or encoding of the DAG, unlike the CID. The output may then already
+be written, and must be discarded. With '-q', the file is read and checked
+without writing it.
 `,
 	},
 
@@ -62,8 +89,33 @@ If '--meta' or '-m' is enabled, this option is ignored.
 		cmds.StringOption(privateKeyName, "pk", "The private key to decrypt file."),
 		cmds.StringOption(repairShardsName, "rs", "Repair the list of shards. Multihashes separated by ','."),
 		cmds.BoolOption(quietOptionName, "q", "Quiet mode: perform get operation without writing to anywhere. Same as using -o /dev/null."),
+		cmds.BoolOption(getPreserveOwnerOptionName, "Restore the owners recorded with 'btfs add --preserve-owner'."),
+		cmds.StringOption(verifySHA256OptionName, "Fail unless the content of the file has this hex encoded SHA-256, as output by 'btfs add --record-sha256'."),
 	},
 	PreRun: func(req *cmds.Request, env cmds.Environment) error {
+		decrypt, _ := req.Options[decryptName].(bool)
+		if meta, _ := req.Options[getMetaDisplayOptionName].(bool); decrypt && meta {
+			return fmt.Errorf("%s can't be used with %s", decryptName, getMetaDisplayOptionName)
+		}
+		if _, ok := req.Options[privateKeyName]; ok && !decrypt {
+			return fmt.Errorf("%s needs %s", privateKeyName, decryptName)
+		}
+		if _, err := verifySHA256Option(req); err != nil {
+			return err
+		}
+		if preserveOwner, _ := req.Options[getPreserveOwnerOptionName].(bool); preserveOwner {
+			meta, _ := req.Options[getMetaDisplayOptionName].(bool)
+			cmprs, _ := getCompressOptions(req)
+			if meta || cmprs {
+				return fmt.Errorf("%s can't be used with %s or %s", getPreserveOwnerOptionName,
+					getMetaDisplayOptionName, compressOptionName)
+			}
+			archive, _ := req.Options[archiveOptionName].(bool)
+			if runtime.GOOS == "windows" && !archive {
+				return fmt.Errorf("%s can't restore owners on Windows, use it with %s to keep them in the archive",
+					getPreserveOwnerOptionName, archiveOptionName)
+			}
+		}
 		_, err := cmdenv.GetCompressLevel(getCompressOptions(req))
 		return err
 	},
@@ -82,11 +134,18 @@ If '--meta' or '-m' is enabled, this option is ignored.
 		quiet, _ := req.Options[quietOptionName].(bool)
 		archive, _ := req.Options[archiveOptionName].(bool)
 		cmprs, cmplvl := getCompressOptions(req)
+		sum, err := verifySHA256Option(req)
+		if err != nil {
+			return err
+		}
 
-		reader, err := cmdenv.GetFile(req, res, api, btfsPath, decrypt, privateKey, meta, repairShards, quiet, archive, cmprs, cmplvl)
+		reader, err := cmdenv.GetFile(req, res, api, btfsPath, decrypt, privateKey, meta, repairShards, quiet, archive, cmprs, cmplvl, sum)
 		if err != nil {
 			return err
 		}
+		if preserveOwner, _ := req.Options[getPreserveOwnerOptionName].(bool); preserveOwner && reader != nil {
+			reader = withTarOwners(req.Context, api, btfsPath, reader)
+		}
 
 		return res.Emit(reader)
 	},
@@ -117,12 +176,14 @@ If '--meta' or '-m' is enabled, this option is ignored.
 			}
 
 			archive, _ := req.Options[archiveOptionName].(bool)
+			preserveOwner, _ := req.Options[getPreserveOwnerOptionName].(bool)
 			gw := getWriter{
-				Out:         os.Stdout,
-				Err:         os.Stderr,
-				Archive:     archive,
-				Compression: cmplvl,
-				Size:        int64(res.Length()),
+				Out:           os.Stdout,
+				Err:           os.Stderr,
+				Archive:       archive,
+				Compression:   cmplvl,
+				Size:          int64(res.Length()),
+				PreserveOwner: preserveOwner,
 			}
 
 			return gw.Write(outReader, outPath)
@@ -183,6 +244,9 @@ type getWriter struct {
 	Archive     bool
 	Compression int
 	Size        int64
+	// PreserveOwner gives the extracted files the owners set in the tar
+	// by withTarOwners.
+	PreserveOwner bool
 }
 
 func (gw *getWriter) Write(r io.Reader, fpath string) error {
@@ -231,9 +295,28 @@ func (gw *getWriter) writeExtracted(r io.Reader, fpath string) error {
 	defer bar.Set64(gw.Size)
 
 	extractor := &tar.Extractor{Path: fpath, Progress: bar.Add64}
+	if gw.PreserveOwner {
+		return extractWithOwners(extractor.Extract, r, fpath)
+	}
 	return extractor.Extract(r)
 }
 
+// verifySHA256Option returns the SHA-256 given with --verify-sha256, or nil.
+func verifySHA256Option(req *cmds.Request) ([]byte, error) {
+	s, _ := req.Options[verifySHA256OptionName].(string)
+	if s == "" {
+		return nil, nil
+	}
+	if meta, _ := req.Options[getMetaDisplayOptionName].(bool); meta {
+		return nil, fmt.Errorf("%s can't be used with %s", verifySHA256OptionName, getMetaDisplayOptionName)
+	}
+	sum, err := hex.DecodeString(strings.TrimPrefix(s, "sha256:"))
+	if err != nil || len(sum) != sha256.Size {
+		return nil, fmt.Errorf("invalid %s %q, must be %d hex characters", verifySHA256OptionName, s, 2*sha256.Size)
+	}
+	return sum, nil
+}
+
 func getCompressOptions(req *cmds.Request) (bool, int) {
 	cmprs, _ := req.Options[compressOptionName].(bool)
 	cmplvl, _ := req.Options[compressionLevelOptionName].(int)
@@ -0,0 +1,131 @@
+package commands
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	gopath "path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bittorrent/go-btfs/core/coreunix"
+
+	coreiface "github.com/bittorrent/interface-go-btfs-core"
+	"github.com/bittorrent/interface-go-btfs-core/path"
+)
+
+// tarOwnerRecord is the PAX record marking the tar headers whose uid and gid
+// are the owner recorded with 'btfs add --preserve-owner', as opposed to
+// the zero they default to.
+const tarOwnerRecord = "BTFS.owner"
+
+// withTarOwners rewrites r, the tar of btfsPath written by cmdenv.GetFile,
+// setting the owners recorded in the token metadata of the files in their
+// headers.
+func withTarOwners(ctx context.Context, api coreiface.CoreAPI, btfsPath string, r io.Reader) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		err := func() error {
+			tr := tar.NewReader(r)
+			tw := tar.NewWriter(pw)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					return tw.Close()
+				}
+				if err != nil {
+					return err
+				}
+				if hdr.Typeflag == tar.TypeReg {
+					if owner := recordedOwner(ctx, api, gopath.Join(btfsPath, tarRelPath(hdr.Name))); owner != nil {
+						hdr.Uid, hdr.Gid = int(owner.Uid), int(owner.Gid)
+						hdr.PAXRecords = map[string]string{tarOwnerRecord: fmt.Sprintf("%d:%d", owner.Uid, owner.Gid)}
+						hdr.Format = tar.FormatPAX
+					}
+				}
+				if err := tw.WriteHeader(hdr); err != nil {
+					return err
+				}
+				if _, err := io.Copy(tw, tr); err != nil {
+					return err
+				}
+			}
+		}()
+		if err != nil {
+			// stop the writer of r too
+			if c, ok := r.(*io.PipeReader); ok {
+				c.CloseWithError(err)
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// recordedOwner returns the owner recorded for the file at p, or nil.
+func recordedOwner(ctx context.Context, api coreiface.CoreAPI, p string) *coreunix.FileOwner {
+	meta, err := coreunix.GetMetaData(ctx, api, path.New(p))
+	if err != nil || len(meta) == 0 {
+		return nil
+	}
+	owner, err := coreunix.ReadFileOwner(meta)
+	if err != nil {
+		log.Debugf("no owner for %s: %s", p, err)
+		return nil
+	}
+	return owner
+}
+
+// tarRelPath returns the path of a tar header name relative to the got
+// root, which names the first element.
+func tarRelPath(name string) string {
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		return name[i+1:]
+	}
+	return ""
+}
+
+type extractedOwner struct {
+	path     string
+	uid, gid int
+}
+
+// extractWithOwners extracts the tar r to fpath with extract, then gives the
+// extracted files the owners set by withTarOwners.
+func extractWithOwners(extract func(io.Reader) error, r io.Reader, fpath string) error {
+	pr, pw := io.Pipe()
+	var owners []extractedOwner
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tr := tar.NewReader(pr)
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				break
+			}
+			if _, ok := hdr.PAXRecords[tarOwnerRecord]; ok && hdr.Typeflag == tar.TypeReg {
+				// where the extractor writes it, in place of the root
+				p := filepath.Join(fpath, filepath.FromSlash(tarRelPath(hdr.Name)))
+				owners = append(owners, extractedOwner{path: p, uid: hdr.Uid, gid: hdr.Gid})
+			}
+		}
+		// keep draining, the extractor must not block on a malformed tar
+		_, _ = io.Copy(io.Discard, pr)
+	}()
+
+	err := extract(io.TeeReader(r, pw))
+	pw.Close()
+	<-done
+	if err != nil {
+		return err
+	}
+	for _, o := range owners {
+		if err := os.Lchown(o.path, o.uid, o.gid); err != nil {
+			return fmt.Errorf("restoring the owner %d:%d of %s: %w", o.uid, o.gid, o.path, err)
+		}
+	}
+	return nil
+}
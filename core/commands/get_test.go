@@ -1,11 +1,17 @@
 package commands
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 
 	cmds "github.com/bittorrent/go-btfs-cmds"
+	tarutils "github.com/whyrusleeping/tar-utils"
 )
 
 func TestGetOutputPath(t *testing.T) {
@@ -66,3 +72,56 @@ func TestGetOutputPath(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractWithOwners(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no file owners on windows")
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	write := func(hdr *tar.Header, content string) {
+		hdr.Size = int64(len(content))
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(&tar.Header{Name: "root", Typeflag: tar.TypeDir, Mode: 0755}, "")
+	write(&tar.Header{
+		Name:       "root/owned",
+		Typeflag:   tar.TypeReg,
+		Mode:       0644,
+		Uid:        os.Getuid(),
+		Gid:        os.Getgid(),
+		PAXRecords: map[string]string{tarOwnerRecord: fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())},
+	}, "owned")
+	write(&tar.Header{Name: "root/plain", Typeflag: tar.TypeReg, Mode: 0644}, "plain")
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(t.TempDir(), "out")
+	extractor := &tarutils.Extractor{Path: out}
+	// fails if the owned file isn't found where it was extracted
+	if err := extractWithOwners(extractor.Extract, &buf, out); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"owned", "plain"} {
+		b, err := os.ReadFile(filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != name {
+			t.Fatalf("expected %s to be extracted, got %q", name, b)
+		}
+	}
+
+	if got := tarRelPath("root/sub/file"); got != "sub/file" {
+		t.Fatalf("expected sub/file, got %s", got)
+	}
+	if got := tarRelPath("root"); got != "" {
+		t.Fatalf("expected the root to be relative to itself, got %s", got)
+	}
+}
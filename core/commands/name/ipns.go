@@ -1,10 +1,12 @@
 package name
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 	"time"
 
 	cmdenv "github.com/bittorrent/go-btfs/core/commands/cmdenv"
@@ -15,12 +17,37 @@ import (
 	nsopts "github.com/bittorrent/interface-go-btfs-core/options/namesys"
 	logging "github.com/ipfs/go-log"
 	path "github.com/ipfs/go-path"
+	isd "github.com/jbenet/go-is-domain"
 )
 
 var log = logging.Logger("core/commands/btns")
 
+// ErrRecursionLimitExceeded is returned when a recursive resolution takes
+// more hops than allowed by --max-recursion.
+var ErrRecursionLimitExceeded = errors.New("recursion limit exceeded")
+
 type ResolvedPath struct {
 	Path path.Path
+	// Name and Error are only set when several names are resolved at once.
+	Name  string `json:",omitempty"`
+	Error string `json:",omitempty"`
+	// Explain is only set with --explain.
+	Explain *ResolveExplanation `json:",omitempty"`
+	// Sequence is the sequence number of the IPNS record the path was read
+	// from, only set with --verbose when the record came from the DHT.
+	Sequence *uint64 `json:",omitempty"`
+	// Seq numbers the entries of --stream from 1, each superseding the
+	// previous ones. Once there are no more, the last one is emitted again
+	// with Final set.
+	Seq   uint64 `json:",omitempty"`
+	Final bool   `json:",omitempty"`
+}
+
+// ResolveExplanation tells which resolver produced a path, and how many DHT
+// records were received to get it.
+type ResolveExplanation struct {
+	Resolver   string
+	DhtRecords int
 }
 
 const (
@@ -29,8 +56,19 @@ const (
 	dhtRecordCountOptionName = "dht-record-count"
 	dhtTimeoutOptionName     = "dht-timeout"
 	streamOptionName         = "stream"
+	dnslinkOnlyOptionName    = "dnslink-only"
+	explainOptionName        = "explain"
+	verboseOptionName        = "verbose"
+	maxRecursionOptionName   = "max-recursion"
+	cacheTTLOptionName       = "cache-ttl"
+	offlineOptionName        = "offline"
+	routingOptionName        = "routing"
 )
 
+// resolveParallelism is the max number of names resolved at the same time
+// when several are given.
+const resolveParallelism = 16
+
 var IpnsCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Resolve BTNS names.",
@@ -66,18 +104,68 @@ Resolve the value of a dnslink:
   > btfs name resolve btfs.io
   /btfs/QmaBvfZooxWkrv7D3r8LS9moNjzD2o525XMZze69hhoxf5
 
+Resolve several names at once, each result is prefixed with its name:
+
+  > btfs name resolve QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ btfs.io
+  QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ: /btfs/QmSiTko9JZyabH56y2fussEt1A5oDqsFXB3CkvAqraFryz
+  btfs.io: /btfs/QmaBvfZooxWkrv7D3r8LS9moNjzD2o525XMZze69hhoxf5
+
+A name which can't be resolved doesn't stop the others, its error is
+reported in place of its path.
+
+Resolve a dnslink without falling back to the DHT:
+
+  > btfs name resolve --dnslink-only btfs.io
+  /btfs/QmaBvfZooxWkrv7D3r8LS9moNjzD2o525XMZze69hhoxf5
+
+With --dnslink-only, recursion follows dnslinks to other domains and stops
+at the first name which isn't a domain.
+
+Recursive resolution gives up with a "recursion limit exceeded" error after
+--max-recursion names, which guards against cyclic or overly long chains.
+
+Resolve without the network, from the cache and the records stored locally,
+i.e. the ones this node published and the ones it keeps for the DHT:
+
+  > btfs name resolve --offline QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ
+  /btfs/QmSiTko9JZyabH56y2fussEt1A5oDqsFXB3CkvAqraFryz
+
+Names without such a record, and domain names which aren't cached, fail with
+a "not found offline" error. --offline can't be used with --nocache.
+
+Resolve through one of the routers of the node rather than all of them, e.g.
+a private DHT or a delegated router configured in Routing.Routers, or "dht"
+for the DHT of a node with the default routing:
+
+  > btfs name resolve --routing=private-dht QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ
+  /btfs/QmSiTko9JZyabH56y2fussEt1A5oDqsFXB3CkvAqraFryz
+
+Names resolved with --routing don't use nor fill the cache.
+
+Tell which resolver produced the path:
+
+  > btfs name resolve --explain QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ
+  /btfs/QmSiTko9JZyabH56y2fussEt1A5oDqsFXB3CkvAqraFryz (resolver: dht, dht records: 16)
+
 `,
 	},
 
 	Arguments: []cmds.Argument{
-		cmds.StringArg("name", false, false, "The BTNS name to resolve. Defaults to your node's peerID."),
+		cmds.StringArg("name", false, true, "The BTNS names to resolve. Defaults to your node's peerID.").EnableStdin(),
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption(recursiveOptionName, "r", "Resolve until the result is not an BTNS name.").WithDefault(true),
+		cmds.UintOption(maxRecursionOptionName, "Max number of names resolved in a row when resolving recursively.").WithDefault(uint(nsopts.DefaultDepthLimit)),
 		cmds.BoolOption(nocacheOptionName, "n", "Do not use cached entries."),
+		cmds.StringOption(cacheTTLOptionName, "Max age of a cached entry to use, and max time the result is cached for, whatever the TTL of its record, eg \"30s\"."),
 		cmds.UintOption(dhtRecordCountOptionName, "dhtrc", "Number of records to request for DHT resolution."),
 		cmds.StringOption(dhtTimeoutOptionName, "dhtt", "Max time to collect values during DHT resolution eg \"30s\". Pass 0 for no timeout."),
 		cmds.BoolOption(streamOptionName, "s", "Stream entries as they are found."),
+		cmds.BoolOption(dnslinkOnlyOptionName, "Only resolve the dnslink of a domain name, never look up the DHT."),
+		cmds.BoolOption(offlineOptionName, "Only resolve from the cache and the records stored locally, never use the network."),
+		cmds.StringOption(routingOptionName, "Name of the router to resolve through, among the routers of Routing.Routers in the config."),
+		cmds.BoolOption(verboseOptionName, "v", "Also output the sequence number of the IPNS record the path was read from."),
+		cmds.BoolOption(explainOptionName, "Also output which resolver (dht, dnslink, cache or proquint) produced the path, and the number of DHT records received."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		api, err := cmdenv.GetApi(env, req)
@@ -87,31 +175,53 @@ Resolve the value of a dnslink:
 
 		nocache, _ := req.Options["nocache"].(bool)
 
-		var name string
-		if len(req.Arguments) == 0 {
+		names, err := nameArgs(req, func() (string, error) {
 			self, err := api.Key().Self(req.Context)
 			if err != nil {
-				return err
+				return "", err
 			}
-			name = self.ID().String()
-		} else {
-			name = req.Arguments[0]
+			return self.ID().String(), nil
+		})
+		if err != nil {
+			return err
 		}
+		name := names[0]
+		multiple := len(names) > 1
 
 		recursive, _ := req.Options[recursiveOptionName].(bool)
 		rc, rcok := req.Options[dhtRecordCountOptionName].(uint)
 		dhtt, dhttok := req.Options[dhtTimeoutOptionName].(string)
 		stream, _ := req.Options[streamOptionName].(bool)
+		dnslinkOnly, _ := req.Options[dnslinkOnlyOptionName].(bool)
+		explain, _ := req.Options[explainOptionName].(bool)
+		verbose, _ := req.Options[verboseOptionName].(bool)
+		routingName, _ := req.Options[routingOptionName].(string)
+		useNamesys := explain || verbose || routingName != ""
 
-		opts := []options.NameResolveOption{
-			options.Name.Cache(!nocache),
+		maxRecursion, _ := req.Options[maxRecursionOptionName].(uint)
+		if recursive && maxRecursion == 0 {
+			return fmt.Errorf("--%s must be > 0", maxRecursionOptionName)
+		}
+		// recursionErr reports the depth limit being hit as a failure only
+		// when resolving recursively
+		recursionErr := func(err error) error {
+			if err != namesys.ErrResolveRecursion {
+				return err
+			}
+			if !recursive {
+				return nil
+			}
+			return fmt.Errorf("%w: more than %d names to resolve", ErrRecursionLimitExceeded, maxRecursion)
 		}
 
+		var ropts []nsopts.ResolveOpt
 		if !recursive {
-			opts = append(opts, options.Name.ResolveOption(nsopts.Depth(1)))
+			ropts = append(ropts, nsopts.Depth(1))
+		} else {
+			ropts = append(ropts, nsopts.Depth(maxRecursion))
 		}
 		if rcok {
-			opts = append(opts, options.Name.ResolveOption(nsopts.DhtRecordCount(rc)))
+			ropts = append(ropts, nsopts.DhtRecordCount(rc))
 		}
 		if dhttok {
 			d, err := time.ParseDuration(dhtt)
@@ -121,44 +231,312 @@ Resolve the value of a dnslink:
 			if d < 0 {
 				return errors.New("DHT timeout value must be >= 0")
 			}
-			opts = append(opts, options.Name.ResolveOption(nsopts.DhtTimeout(d)))
+			ropts = append(ropts, nsopts.DhtTimeout(d))
+		}
+		ctx := req.Context
+		if offline, _ := req.Options[offlineOptionName].(bool); offline {
+			if nocache {
+				return fmt.Errorf("--%s can't be used with --%s", offlineOptionName, nocacheOptionName)
+			}
+			if dnslinkOnly {
+				return fmt.Errorf("--%s can't be used with --%s", offlineOptionName, dnslinkOnlyOptionName)
+			}
+			if routingName != "" {
+				return fmt.Errorf("--%s can't be used with --%s", offlineOptionName, routingOptionName)
+			}
+			ctx = namesys.ContextWithOffline(ctx)
+		}
+		if cacheTTL, ok := req.Options[cacheTTLOptionName].(string); ok {
+			if nocache {
+				return fmt.Errorf("--%s can't be used with --%s", cacheTTLOptionName, nocacheOptionName)
+			}
+			d, err := time.ParseDuration(cacheTTL)
+			if err != nil {
+				return err
+			}
+			if d <= 0 {
+				return errors.New("cache TTL value must be > 0")
+			}
+			ctx = namesys.ContextWithCacheTTL(ctx, d)
+		}
+		opts := []options.NameResolveOption{
+			options.Name.Cache(!nocache),
+		}
+		for _, o := range ropts {
+			opts = append(opts, options.Name.ResolveOption(o))
 		}
 
-		if !strings.HasPrefix(name, "/btns/") {
-			name = "/btns/" + name
+		resolve := func(ctx context.Context, name string) (*ResolvedPath, error) {
+			output, err := api.Name().Resolve(ctx, btnsName(name), opts...)
+			if err := recursionErr(err); err != nil {
+				return nil, err
+			}
+			return &ResolvedPath{Path: path.FromString(output.String())}, nil
+		}
+
+		// the name API doesn't tell how a name was resolved nor from which
+		// record, so --explain and --verbose need the name system of the node
+		var ns namesys.Resolver
+		if useNamesys || dnslinkOnly {
+			node, err := cmdenv.GetNode(env)
+			if err != nil {
+				return err
+			}
+			if useNamesys {
+				ns = node.Namesys
+				if routingName != "" {
+					if dnslinkOnly {
+						return fmt.Errorf("--%s can't be used with --%s", routingOptionName, dnslinkOnlyOptionName)
+					}
+					if !node.IsOnline {
+						return fmt.Errorf("--%s needs the node to be online", routingOptionName)
+					}
+					router, err := node.Routers.Get(routingName)
+					if err != nil {
+						return err
+					}
+					ns, err = namesys.NewNameSystem(router,
+						namesys.WithDatastore(node.Repo.Datastore()),
+						namesys.WithDNSResolver(node.DNSResolver))
+					if err != nil {
+						return err
+					}
+				} else if nocache {
+					ns, err = namesys.NewNameSystem(node.Routing, namesys.WithDatastore(node.Repo.Datastore()))
+					if err != nil {
+						return err
+					}
+				}
+				resolve = func(ctx context.Context, name string) (*ResolvedPath, error) {
+					ctx, cancel := context.WithCancel(ctx)
+					defer cancel()
+
+					r := namesys.Result{Err: namesys.ErrResolveFailed}
+					for r = range ns.ResolveAsync(ctx, btnsName(name), ropts...) {
+						if r.Err != nil {
+							break
+						}
+					}
+					if err := recursionErr(r.Err); err != nil {
+						return nil, err
+					}
+					return namesysPath(r, explain, verbose), nil
+				}
+			}
+			if dnslinkOnly {
+				if stream {
+					return fmt.Errorf("--stream can't be used with --%s", dnslinkOnlyOptionName)
+				}
+				resolver := namesys.NewDNSResolver(node.DNSResolver.LookupTXT)
+				resolve = func(ctx context.Context, name string) (*ResolvedPath, error) {
+					p, err := resolveDNSLink(ctx, resolver, name, recursive, maxRecursion)
+					if err := recursionErr(err); err != nil {
+						return nil, err
+					}
+					return namesysPath(namesys.Result{Path: p, Source: namesys.SourceDNSLink}, explain, verbose), nil
+				}
+			}
+		}
+
+		if multiple {
+			if stream {
+				return errors.New("--stream can only be used with a single name")
+			}
+			for _, rp := range resolveNames(ctx, names, resolve) {
+				if err := res.Emit(rp); err != nil {
+					return err
+				}
+			}
+			return nil
 		}
 
 		if !stream {
-			output, err := api.Name().Resolve(req.Context, name, opts...)
-			if err != nil && (recursive || err != namesys.ErrResolveRecursion) {
+			rp, err := resolve(ctx, name)
+			if err != nil {
 				return err
 			}
 
-			return cmds.EmitOnce(res, &ResolvedPath{path.FromString(output.String())})
+			return cmds.EmitOnce(res, rp)
 		}
 
-		output, err := api.Name().Search(req.Context, name, opts...)
+		entries := &resolvedStream{res: res}
+		if useNamesys {
+			for v := range ns.ResolveAsync(ctx, btnsName(name), ropts...) {
+				if err := recursionErr(v.Err); err != nil {
+					return err
+				}
+				if err := entries.add(namesysPath(v, explain, verbose)); err != nil {
+					return err
+				}
+			}
+			return entries.close()
+		}
+
+		output, err := api.Name().Search(ctx, btnsName(name), opts...)
 		if err != nil {
 			return err
 		}
 
 		for v := range output {
-			if v.Err != nil && (recursive || v.Err != namesys.ErrResolveRecursion) {
-				return v.Err
+			if err := recursionErr(v.Err); err != nil {
+				return err
 			}
-			if err := res.Emit(&ResolvedPath{path.FromString(v.Path.String())}); err != nil {
+			if err := entries.add(&ResolvedPath{Path: path.FromString(v.Path.String())}); err != nil {
 				return err
 			}
 
 		}
 
-		return nil
+		return entries.close()
 	},
 	Encoders: cmds.EncoderMap{
 		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, rp *ResolvedPath) error {
-			_, err := fmt.Fprintln(w, rp.Path)
+			if rp.Final {
+				// the path was already written when it arrived
+				return nil
+			}
+			var err error
+			line := rp.Path.String()
+			var notes []string
+			if rp.Explain != nil {
+				notes = append(notes, fmt.Sprintf("resolver: %s, dht records: %d", rp.Explain.Resolver, rp.Explain.DhtRecords))
+			}
+			if rp.Sequence != nil {
+				notes = append(notes, fmt.Sprintf("sequence: %d", *rp.Sequence))
+			}
+			if len(notes) > 0 {
+				line = fmt.Sprintf("%s (%s)", line, strings.Join(notes, ", "))
+			}
+			switch {
+			case rp.Name == "":
+				_, err = fmt.Fprintln(w, line)
+			case rp.Error != "":
+				_, err = fmt.Fprintf(w, "%s: error: %s\n", rp.Name, rp.Error)
+			default:
+				_, err = fmt.Fprintf(w, "%s: %s\n", rp.Name, line)
+			}
 			return err
 		}),
 	},
 	Type: ResolvedPath{},
 }
+
+// resolvedStream emits the entries of --stream as they arrive, and the last
+// one again as final once there are no more.
+type resolvedStream struct {
+	res  cmds.ResponseEmitter
+	seq  uint64
+	last *ResolvedPath
+}
+
+func (s *resolvedStream) add(rp *ResolvedPath) error {
+	s.seq++
+	rp.Seq = s.seq
+	s.last = rp
+	return s.res.Emit(rp)
+}
+
+// close emits a final copy of the last entry.
+func (s *resolvedStream) close() error {
+	if s.last == nil {
+		return nil
+	}
+	final := *s.last
+	final.Final = true
+	return s.res.Emit(&final)
+}
+
+// namesysPath is the output for r, with the details asked by --explain and
+// --verbose.
+func namesysPath(r namesys.Result, explain bool, verbose bool) *ResolvedPath {
+	rp := &ResolvedPath{Path: r.Path}
+	if explain {
+		rp.Explain = &ResolveExplanation{
+			Resolver:   string(r.Source),
+			DhtRecords: r.Records,
+		}
+	}
+	if verbose && r.Source == namesys.SourceDHT {
+		seq := r.Sequence
+		rp.Sequence = &seq
+	}
+	return rp
+}
+
+// nameArgs returns the names to resolve, given as arguments or on stdin, or
+// the one self returns when there are none.
+func nameArgs(req *cmds.Request, self func() (string, error)) ([]string, error) {
+	if err := req.ParseBodyArgs(); err != nil {
+		return nil, err
+	}
+	if len(req.Arguments) > 0 {
+		return req.Arguments, nil
+	}
+	name, err := self()
+	if err != nil {
+		return nil, err
+	}
+	return []string{name}, nil
+}
+
+func btnsName(name string) string {
+	if !strings.HasPrefix(name, "/btns/") {
+		return "/btns/" + name
+	}
+	return name
+}
+
+// resolveNames resolves names concurrently and returns their results in the
+// same order. A name which fails to resolve gets its error in the result.
+func resolveNames(ctx context.Context, names []string,
+	resolve func(ctx context.Context, name string) (*ResolvedPath, error)) []*ResolvedPath {
+	results := make([]*ResolvedPath, len(names))
+	sem := make(chan struct{}, resolveParallelism)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			rp, err := resolve(ctx, name)
+			if err != nil {
+				rp = &ResolvedPath{Error: err.Error()}
+			}
+			rp.Name = name
+			results[i] = rp
+		}(i, name)
+	}
+	wg.Wait()
+	return results
+}
+
+// resolveDNSLink resolves name through dnslinks only. With recursive, a
+// dnslink to another domain is followed, and resolution stops at the first
+// name which isn't a domain since it could only be resolved with the DHT.
+func resolveDNSLink(ctx context.Context, resolver *namesys.DNSResolver, name string, recursive bool,
+	maxRecursion uint) (path.Path, error) {
+	name = strings.TrimPrefix(name, "/btns/")
+	if !isDomain(name) {
+		return "", fmt.Errorf("%s is not a domain name, --%s can only resolve dnslinks", name, dnslinkOnlyOptionName)
+	}
+	for depth := uint(1); ; depth++ {
+		p, err := resolver.Resolve(ctx, name, nsopts.Depth(1))
+		if err != nil && err != namesys.ErrResolveRecursion {
+			return "", fmt.Errorf("no dnslink found for %s: %w", name, err)
+		}
+		next := strings.TrimPrefix(p.String(), "/btns/")
+		if !recursive || next == p.String() || !isDomain(next) {
+			return p, nil
+		}
+		if depth >= maxRecursion {
+			return "", namesys.ErrResolveRecursion
+		}
+		name = next
+	}
+}
+
+func isDomain(name string) bool {
+	return isd.IsDomain(strings.SplitN(name, "/", 2)[0])
+}
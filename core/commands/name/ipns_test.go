@@ -0,0 +1,86 @@
+package name
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+	files "github.com/bittorrent/go-btfs-files"
+	path "github.com/ipfs/go-path"
+)
+
+func TestNameArgs(t *testing.T) {
+	self := func() (string, error) { return "self", nil }
+	stdin := func(s string) files.Directory {
+		return files.NewSliceDirectory([]files.DirEntry{files.FileEntry("", files.NewBytesFile([]byte(s)))})
+	}
+	cases := []struct {
+		args  []string
+		stdin files.Directory
+		names []string
+	}{
+		{names: []string{"self"}},
+		{args: []string{"a", "b"}, names: []string{"a", "b"}},
+		{stdin: stdin("a\nb\n"), names: []string{"a", "b"}},
+		// arguments take precedence over stdin
+		{args: []string{"c"}, stdin: stdin("a\n"), names: []string{"c"}},
+	}
+	for i, c := range cases {
+		req, err := cmds.NewRequest(context.Background(), []string{"name", "resolve"}, nil, c.args, c.stdin, IpnsCmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names, err := nameArgs(req, self)
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		if len(names) != len(c.names) {
+			t.Fatalf("case %d: expected %v, got %v", i, c.names, names)
+		}
+		for j := range names {
+			if names[j] != c.names[j] {
+				t.Fatalf("case %d: expected %v, got %v", i, c.names, names)
+			}
+		}
+	}
+}
+
+func TestResolvedStream(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := cmds.NewRequest(ctx, []string{"name", "resolve"}, nil, nil, nil, IpnsCmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	re, res := cmds.NewChanResponsePair(req)
+	next := make(chan struct{})
+	go func() {
+		s := &resolvedStream{res: re}
+		_ = s.add(&ResolvedPath{Path: path.FromString("/btfs/a")})
+		// the second entry only comes once the first one was read
+		<-next
+		_ = s.add(&ResolvedPath{Path: path.FromString("/btfs/b")})
+		_ = s.close()
+		re.Close()
+	}()
+
+	want := []ResolvedPath{
+		{Path: path.FromString("/btfs/a"), Seq: 1},
+		{Path: path.FromString("/btfs/b"), Seq: 2},
+		{Path: path.FromString("/btfs/b"), Seq: 2, Final: true},
+	}
+	for i, w := range want {
+		v, err := res.Next()
+		if err != nil {
+			t.Fatalf("entry %d: %v", i, err)
+		}
+		rp := v.(*ResolvedPath)
+		if rp.Path != w.Path || rp.Seq != w.Seq || rp.Final != w.Final {
+			t.Fatalf("entry %d: expected %+v, got %+v", i, w, *rp)
+		}
+		if i == 0 {
+			close(next)
+		}
+	}
+}
@@ -32,11 +32,15 @@ var PinCmd = &cmds.Command{
 	},
 
 	Subcommands: map[string]*cmds.Command{
-		"add":    addPinCmd,
-		"rm":     rmPinCmd,
-		"ls":     listPinCmd,
-		"verify": verifyPinCmd,
-		"update": updatePinCmd,
+		"add":      addPinCmd,
+		"rm":       rmPinCmd,
+		"ls":       listPinCmd,
+		"verify":   verifyPinCmd,
+		"update":   updatePinCmd,
+		"export":   exportPinCmd,
+		"import":   importPinCmd,
+		"extend":   extendPinCmd,
+		"expiring": expiringPinCmd,
 	},
 }
 
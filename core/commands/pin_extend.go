@@ -0,0 +1,197 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+	cmdenv "github.com/bittorrent/go-btfs/core/commands/cmdenv"
+	"github.com/bittorrent/go-btfs/core/coreunix"
+	"github.com/bittorrent/interface-go-btfs-core/path"
+)
+
+const pinExtendDaysOptionName = "days"
+
+type PinExtendOutput struct {
+	Cid     string
+	Expires time.Time `json:",omitempty"`
+	Error   string    `json:",omitempty"`
+}
+
+var extendPinCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Extend the duration of pins made with a duration.",
+		ShortDescription: `
+Pushes back by --days the expiry of the pins made for a duration, e.g. with
+'btfs add --pin-duration-count'. Pins which already expired are extended from
+now. CIDs which aren't pinned, or were pinned without a duration, are reported
+and left as is, the others are still extended.
+
+CIDs can be read from stdin, one per line, to extend many pins at once:
+
+  > btfs pin ls --type=recursive --quiet | btfs pin extend --days=30
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("cid", true, true, "CIDs of the pins to extend.").EnableStdin(),
+	},
+	Options: []cmds.Option{
+		cmds.IntOption(pinExtendDaysOptionName, "Number of days to extend the pins by."),
+	},
+	Type: PinExtendOutput{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+		days, ok := req.Options[pinExtendDaysOptionName].(int)
+		if !ok || days <= 0 {
+			return fmt.Errorf("--%s must be a positive number of days", pinExtendDaysOptionName)
+		}
+		if err := req.ParseBodyArgs(); err != nil {
+			return err
+		}
+		enc, err := cmdenv.GetCidEncoder(req)
+		if err != nil {
+			return err
+		}
+
+		d := n.Repo.Datastore()
+		for _, arg := range req.Arguments {
+			out := &PinExtendOutput{Cid: arg}
+			rp, err := api.ResolvePath(req.Context, path.New(arg))
+			if err == nil {
+				out.Cid = enc.Encode(rp.Cid())
+				var pinned bool
+				if _, pinned, err = n.Pinning.IsPinned(req.Context, rp.Cid()); err == nil && !pinned {
+					err = errors.New("not pinned")
+				}
+				if err == nil {
+					out.Expires, err = coreunix.ExtendPinDuration(req.Context, d, rp.Cid(), int64(days))
+				}
+			}
+			if err != nil {
+				out.Error = err.Error()
+			}
+			if err := res.Emit(out); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *PinExtendOutput) error {
+			if out.Error != "" {
+				_, err := fmt.Fprintf(w, "%s not extended: %s\n", out.Cid, out.Error)
+				return err
+			}
+			_, err := fmt.Fprintf(w, "%s expires %s\n", out.Cid, out.Expires.Local().Format(time.RFC3339))
+			return err
+		}),
+	},
+}
+
+const pinExpiringWithinOptionName = "within"
+
+type PinExpiringOutput struct {
+	Cid     string
+	Expires time.Time
+	// Remaining is negative for pins which already expired.
+	Remaining time.Duration
+	// Days is the duration the pin was made for, ExtendedDays the sum of
+	// its extensions.
+	Days         int64
+	ExtendedDays int64 `json:",omitempty"`
+}
+
+var expiringPinCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the pins made with a duration which expire soon.",
+		ShortDescription: `
+Lists the pins made for a duration, e.g. with 'btfs add --pin-duration-count',
+which expire within --within from now, the soonest first. Pins which already
+expired are listed first. Extend them with 'btfs pin extend'. The output
+includes the time left and the number of days the pins were made for.
+
+  > btfs pin expiring --within=72h
+`,
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(pinExpiringWithinOptionName, "List the pins expiring within this duration from now, e.g. \"72h\".").WithDefault("168h"),
+	},
+	Type: PinExpiringOutput{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		within, err := time.ParseDuration(req.Options[pinExpiringWithinOptionName].(string))
+		if err != nil {
+			return fmt.Errorf("invalid --%s: %w", pinExpiringWithinOptionName, err)
+		}
+		if within < 0 {
+			return fmt.Errorf("--%s must be >= 0", pinExpiringWithinOptionName)
+		}
+		enc, err := cmdenv.GetCidEncoder(req)
+		if err != nil {
+			return err
+		}
+
+		pds, err := coreunix.ListPinDurations(req.Context, n.Repo.Datastore())
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		deadline := now.Add(within)
+		expiring := pds[:0]
+		for _, pd := range pds {
+			if pd.Expires.After(deadline) {
+				continue
+			}
+			// the pin may have been removed since
+			if _, pinned, err := n.Pinning.IsPinned(req.Context, pd.Cid); err != nil {
+				return err
+			} else if pinned {
+				expiring = append(expiring, pd)
+			}
+		}
+		sort.Slice(expiring, func(i, j int) bool {
+			return expiring[i].Expires.Before(expiring[j].Expires)
+		})
+
+		for _, pd := range expiring {
+			if err := res.Emit(&PinExpiringOutput{
+				Cid:          enc.Encode(pd.Cid),
+				Expires:      pd.Expires,
+				Remaining:    pd.Expires.Sub(now).Round(time.Second),
+				Days:         pd.Days,
+				ExtendedDays: pd.ExtendedDays,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *PinExpiringOutput) error {
+			remaining := out.Remaining.String() + " left"
+			if out.Remaining <= 0 {
+				remaining = "expired"
+			}
+			duration := fmt.Sprintf("pinned for %d days", out.Days)
+			if out.ExtendedDays > 0 {
+				duration += fmt.Sprintf(", extended by %d", out.ExtendedDays)
+			}
+			_, err := fmt.Fprintf(w, "%s expires %s (%s, %s)\n", out.Cid,
+				out.Expires.Local().Format(time.RFC3339), remaining, duration)
+			return err
+		}),
+	},
+}
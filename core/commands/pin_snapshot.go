@@ -0,0 +1,80 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+	cmdenv "github.com/bittorrent/go-btfs/core/commands/cmdenv"
+	"github.com/bittorrent/go-btfs/core/corerepo"
+)
+
+var exportPinCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Export the pin set for backup.",
+		ShortDescription: `
+Writes the recursive and direct pins of the node as JSON to stdout. Pins are
+flushed to disk before the snapshot is taken, and the pin set can't change
+while it is being exported. Indirect pins are not listed, they are restored
+with their recursive roots.
+
+Restore a snapshot with 'btfs pin import'.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		if _, err := corerepo.ExportPins(req.Context, n, &buf); err != nil {
+			return err
+		}
+		return res.Emit(&buf)
+	},
+}
+
+type PinImportOutput struct {
+	Pinned int
+}
+
+var importPinCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Restore pins from a snapshot written by 'btfs pin export'.",
+		ShortDescription: `
+Pins every object listed in the snapshot. Objects that are not available
+locally are fetched from the network when the daemon is running. Objects
+that are already pinned are skipped.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.FileArg("file", true, false, "The pin snapshot to import.").EnableStdin(),
+	},
+	Type: PinImportOutput{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		file, err := cmdenv.GetFileArg(req.Files.Entries())
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		pinned, err := corerepo.ImportPins(req.Context, n, file)
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &PinImportOutput{Pinned: pinned})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *PinImportOutput) error {
+			_, err := fmt.Fprintf(w, "imported %d pins\n", out.Pinned)
+			return err
+		}),
+	},
+}
@@ -39,6 +39,7 @@ var RepoCmd = &cmds.Command{
 		"fsck":    repoFsckCmd,
 		"version": repoVersionCmd,
 		"verify":  repoVerifyCmd,
+		"scrub":   repoScrubCmd,
 	},
 }
 
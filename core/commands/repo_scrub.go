@@ -0,0 +1,134 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	cmdenv "github.com/bittorrent/go-btfs/core/commands/cmdenv"
+	corerepo "github.com/bittorrent/go-btfs/core/corerepo"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+	cid "github.com/ipfs/go-cid"
+)
+
+const (
+	repoScrubSampleOptionName  = "sample"
+	repoScrubRemoveOptionName  = "remove"
+	repoScrubRefetchOptionName = "refetch"
+)
+
+// ScrubResult is a corrupt block found by "repo scrub", or the summary of
+// the scrub when Key is unset.
+type ScrubResult struct {
+	Key    string `json:",omitempty"`
+	Error  string `json:",omitempty"`
+	Action string `json:",omitempty"`
+
+	Checked    uint64 `json:",omitempty"`
+	Corrupt    uint64 `json:",omitempty"`
+	Unreadable uint64 `json:",omitempty"`
+	Skipped    uint64 `json:",omitempty"`
+}
+
+var repoScrubCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Check that the blocks in the repo match their CID.",
+		ShortDescription: `
+'btfs repo scrub' reads the blocks of the blockstore and hashes them again,
+reporting the blocks whose content doesn't match their CID, or which can't be
+read.
+
+With --remove, corrupt blocks are deleted from the blockstore. With --refetch,
+they are deleted and fetched again from the network, which needs the daemon to
+be running. Blocks which can't be read are only reported, as they may well be
+intact.
+
+Blocks are checked in the order of the datastore, and the position of the
+scrub is saved in the datastore as it goes. A scrub which is interrupted
+continues from that position the next time it runs, and starts over once it
+has gone through all the blocks. --sample checks only that percentage of the blocks, picked at
+random, for periodic spot checks.
+`,
+	},
+	Options: []cmds.Option{
+		cmds.FloatOption(repoScrubSampleOptionName, "Percentage of the blocks to check, picked at random.").WithDefault(100.0),
+		cmds.BoolOption(repoScrubRemoveOptionName, "Remove the corrupt blocks from the blockstore.").WithDefault(false),
+		cmds.BoolOption(repoScrubRefetchOptionName, "Remove the corrupt blocks and fetch them again from the network.").WithDefault(false),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		sample, _ := req.Options[repoScrubSampleOptionName].(float64)
+		remove, _ := req.Options[repoScrubRemoveOptionName].(bool)
+		refetch, _ := req.Options[repoScrubRefetchOptionName].(bool)
+		if sample <= 0 || sample > 100 {
+			return fmt.Errorf("%s must be more than 0 and at most 100", repoScrubSampleOptionName)
+		}
+		if refetch && !nd.IsOnline {
+			return errors.New("--refetch needs the daemon to be running")
+		}
+
+		corrupt := func(ctx context.Context, c cid.Cid, cerr error) error {
+			out := &ScrubResult{Key: c.String(), Error: cerr.Error()}
+			if remove || refetch {
+				if err := nd.Blockstore.DeleteBlock(ctx, c); err != nil {
+					return fmt.Errorf("can't remove block %s: %w", c, err)
+				}
+				out.Action = "removed"
+			}
+			if refetch {
+				if _, err := nd.Blocks.GetBlock(ctx, c); err != nil {
+					out.Action = fmt.Sprintf("removed, refetch failed: %s", err)
+				} else {
+					out.Action = "refetched"
+				}
+			}
+			return res.Emit(out)
+		}
+
+		unreadable := func(ctx context.Context, c cid.Cid, err error) error {
+			return res.Emit(&ScrubResult{Key: c.String(), Error: err.Error()})
+		}
+
+		stats, err := corerepo.Scrub(req.Context, nd.Blockstore, nd.Repo.Datastore(), corerepo.ScrubOptions{
+			Sample:     sample,
+			Corrupt:    corrupt,
+			Unreadable: unreadable,
+		})
+		if err != nil {
+			return err
+		}
+		return res.Emit(&ScrubResult{Checked: stats.Checked, Corrupt: stats.Corrupt, Unreadable: stats.Unreadable,
+			Skipped: stats.Skipped})
+	},
+	Type: ScrubResult{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *ScrubResult) error {
+			if out.Key == "" {
+				fmt.Fprintf(w, "scrub complete, %d blocks checked, %d corrupt", out.Checked, out.Corrupt)
+				if out.Unreadable > 0 {
+					fmt.Fprintf(w, ", %d unreadable", out.Unreadable)
+				}
+				if out.Skipped > 0 {
+					fmt.Fprintf(w, ", %d checked by the previous scrub", out.Skipped)
+				}
+				fmt.Fprintln(w)
+				return nil
+			}
+			if out.Error != corerepo.ErrBlockMismatch.Error() {
+				_, err := fmt.Fprintf(w, "block %s can't be read (%s)\n", out.Key, out.Error)
+				return err
+			}
+			fmt.Fprintf(w, "block %s is corrupt (%s)", out.Key, out.Error)
+			if out.Action != "" {
+				fmt.Fprintf(w, ": %s", out.Action)
+			}
+			fmt.Fprintln(w)
+			return nil
+		}),
+	},
+}
@@ -237,6 +237,11 @@ var rootROSubcommands = map[string]*cmds.Command{
 var RootRemote = &cmds.Command{}
 
 var rootRemoteSubcommands = map[string]*cmds.Command{
+	"block": {
+		Subcommands: map[string]*cmds.Command{
+			"has": blockHasCmd,
+		},
+	},
 	"storage": {
 		Subcommands: map[string]*cmds.Command{
 			"challenge": {
@@ -249,7 +254,9 @@ var rootRemoteSubcommands = map[string]*cmds.Command{
 					"init":          upload.StorageUploadInitCmd,
 					"supporttokens": upload.StorageUploadSupportTokensCmd,
 					"recvcontract":  upload.StorageUploadRecvContractCmd,
+					"renew":         upload.StorageUploadRenewShardCmd,
 					"cheque":        upload.StorageUploadChequeCmd,
+					"challenge":     upload.StorageUploadChallengeCmd,
 				},
 			},
 			"dcrepair": {
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	cmdenv "github.com/bittorrent/go-btfs/core/commands/cmdenv"
+	"github.com/bittorrent/go-btfs/core/node"
 
 	cmds "github.com/bittorrent/go-btfs-cmds"
 	humanize "github.com/dustin/go-humanize"
@@ -75,8 +76,14 @@ Example:
     TotalOut: 12MB
     RateIn: 0B/s
     RateOut: 0B/s
+
+To see how much file data moved through adds and the bitswap exchange,
+rather than over the network as a whole, use 'btfs stats bw data'.
 `,
 	},
+	Subcommands: map[string]*cmds.Command{
+		"data": statBwDataCmd,
+	},
 	Options: []cmds.Option{
 		cmds.StringOption(statPeerOptionName, "p", "Specify a peer to print bandwidth for."),
 		cmds.StringOption(statProtoOptionName, "t", "Specify a protocol to print bandwidth for."),
@@ -185,6 +192,48 @@ Example:
 	},
 }
 
+var statBwDataCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Print the bytes of data moved by adds and bitswap.",
+		ShortDescription: `
+'btfs stats bw data' prints the bytes of file data moved since the daemon
+started, by path and direction:
+
+  - AddIn: bytes of the files read by adds. Encrypted files are counted
+    before they are encrypted.
+  - AddOut: bytes of the blocks adds wrote to the blockstore, which include
+    the DAG overhead and, for encrypted files, the ciphertext. Blocks left to
+    the filestore with --nocopy are not counted.
+  - BitswapIn: bytes of the blocks received from other peers, e.g. by get.
+  - BitswapOut: bytes of the blocks sent to other peers.
+
+The same counters are exported as the btfs_data_bandwidth_* metrics.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if nd.DataBandwidth == nil {
+			return fmt.Errorf("data bandwidth not counted by this node")
+		}
+		stats := nd.DataBandwidth.Stats()
+		return cmds.EmitOnce(res, &stats)
+	},
+	Type: node.DataBandwidthStats{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, s *node.DataBandwidthStats) error {
+			fmt.Fprintln(w, "Data bandwidth")
+			fmt.Fprintf(w, "AddIn: %s\n", humanize.Bytes(s.AddIn))
+			fmt.Fprintf(w, "AddOut: %s\n", humanize.Bytes(s.AddOut))
+			fmt.Fprintf(w, "BitswapIn: %s\n", humanize.Bytes(s.BitswapIn))
+			fmt.Fprintf(w, "BitswapOut: %s\n", humanize.Bytes(s.BitswapOut))
+			return nil
+		}),
+	},
+}
+
 func printStats(out io.Writer, bs *metrics.Stats) {
 	fmt.Fprintln(out, "Bandwidth")
 	fmt.Fprintf(out, "TotalIn: %s\n", humanize.Bytes(uint64(bs.TotalIn)))
@@ -21,14 +21,16 @@ Storage services include client upload operations, host storage operations,
 host information sync/display operations, and BTT payment-related routines.`,
 	},
 	Subcommands: map[string]*cmds.Command{
-		"upload":    upload.StorageUploadCmd,
-		"hosts":     hosts.StorageHostsCmd,
-		"info":      info.StorageInfoCmd,
-		"announce":  announce.StorageAnnounceCmd,
-		"challenge": challenge.StorageChallengeCmd,
-		"stats":     stats.StorageStatsCmd,
-		"contracts": contracts.StorageContractsCmd,
-		"path":      path.PathCmd,
-		"dcrepair":  upload.StorageDcRepairRouterCmd,
+		"upload":      upload.StorageUploadCmd,
+		"hosts":       hosts.StorageHostsCmd,
+		"info":        info.StorageInfoCmd,
+		"announce":    announce.StorageAnnounceCmd,
+		"challenge":   challenge.StorageChallengeCmd,
+		"stats":       stats.StorageStatsCmd,
+		"contracts":   contracts.StorageContractsCmd,
+		"path":        path.PathCmd,
+		"dcrepair":    upload.StorageDcRepairRouterCmd,
+		"reconstruct": upload.StorageReconstructCmd,
+		"check":       upload.StorageCheckCmd,
 	},
 }
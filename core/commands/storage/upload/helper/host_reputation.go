@@ -0,0 +1,117 @@
+package helper
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+
+	hubpb "github.com/bittorrent/go-btfs-common/protos/hub"
+
+	"github.com/ipfs/go-datastore"
+)
+
+// HostRanking is the order in which HostsProvider offers the candidate hosts.
+type HostRanking string
+
+const (
+	// HostRankingReputation offers the hosts with the best record of past
+	// uploads and challenges first.
+	HostRankingReputation HostRanking = "reputation"
+	// HostRankingRandom offers the hosts in random order.
+	HostRankingRandom HostRanking = "random"
+	// HostRankingPrice offers the cheapest hosts first.
+	HostRankingPrice HostRanking = "price"
+)
+
+// ParseHostRanking checks s is one of the host rankings.
+func ParseHostRanking(s string) (HostRanking, error) {
+	switch r := HostRanking(s); r {
+	case HostRankingReputation, HostRankingRandom, HostRankingPrice:
+		return r, nil
+	default:
+		return "", fmt.Errorf("unknown host ranking %q, must be %s, %s or %s", s,
+			HostRankingReputation, HostRankingRandom, HostRankingPrice)
+	}
+}
+
+const hostReputationKey = "/btfs/%s/renter/host-reputation/%s"
+
+// HostReputation counts how many uploads and challenges a host succeeded and
+// failed with this renter.
+type HostReputation struct {
+	Successes uint64
+	Failures  uint64
+}
+
+// Score is the share of successes, counting one success and one failure
+// more than recorded so that a host with no history scores 0.5: below hosts
+// which did well, above hosts which failed.
+func (r HostReputation) Score() float64 {
+	return float64(r.Successes+1) / float64(r.Successes+r.Failures+2)
+}
+
+// hostReputationMu serializes updates of the records.
+var hostReputationMu sync.Mutex
+
+// GetHostReputation returns the record of host, empty if it has none.
+func GetHostReputation(cp *ContextParams, host string) (HostReputation, error) {
+	var r HostReputation
+	b, err := cp.N.Repo.Datastore().Get(cp.Ctx, datastore.NewKey(fmt.Sprintf(hostReputationKey, cp.N.Identity, host)))
+	if err == datastore.ErrNotFound {
+		return r, nil
+	}
+	if err != nil {
+		return r, err
+	}
+	err = json.Unmarshal(b, &r)
+	return r, err
+}
+
+// RecordHostResult adds a success or a failure to the record of host.
+func RecordHostResult(cp *ContextParams, host string, success bool) error {
+	hostReputationMu.Lock()
+	defer hostReputationMu.Unlock()
+	r, err := GetHostReputation(cp, host)
+	if err != nil {
+		return err
+	}
+	if success {
+		r.Successes++
+	} else {
+		r.Failures++
+	}
+	b, err := json.Marshal(&r)
+	if err != nil {
+		return err
+	}
+	return cp.N.Repo.Datastore().Put(cp.Ctx, datastore.NewKey(fmt.Sprintf(hostReputationKey, cp.N.Identity, host)), b)
+}
+
+// rankHosts orders hosts by ranking. Hosts which rank the same keep their
+// order.
+func rankHosts(cp *ContextParams, hosts []*hubpb.Host, ranking HostRanking) {
+	switch ranking {
+	case HostRankingRandom:
+		rand.Shuffle(len(hosts), func(i, j int) {
+			hosts[i], hosts[j] = hosts[j], hosts[i]
+		})
+	case HostRankingPrice:
+		sort.SliceStable(hosts, func(i, j int) bool {
+			return hosts[i].StoragePriceAsk < hosts[j].StoragePriceAsk
+		})
+	case HostRankingReputation:
+		scores := make(map[string]float64, len(hosts))
+		for _, h := range hosts {
+			r, err := GetHostReputation(cp, h.NodeId)
+			if err != nil {
+				log.Debugf("host %s reputation: %s", h.NodeId, err)
+			}
+			scores[h.NodeId] = r.Score()
+		}
+		sort.SliceStable(hosts, func(i, j int) bool {
+			return scores[hosts[i].NodeId] > scores[hosts[j].NodeId]
+		})
+	}
+}
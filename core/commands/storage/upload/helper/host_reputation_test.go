@@ -0,0 +1,51 @@
+package helper
+
+import (
+	"context"
+	"testing"
+
+	coremock "github.com/bittorrent/go-btfs/core/mock"
+
+	hubpb "github.com/bittorrent/go-btfs-common/protos/hub"
+)
+
+func TestRankHostsByReputation(t *testing.T) {
+	node, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := &ContextParams{Ctx: context.Background(), N: node}
+
+	for _, r := range []struct {
+		host    string
+		success bool
+	}{
+		{"good", true}, {"good", true}, {"bad", false}, {"mixed", true}, {"mixed", false}, {"mixed", false},
+	} {
+		if err := RecordHostResult(cp, r.host, r.success); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hosts := []*hubpb.Host{{NodeId: "bad"}, {NodeId: "new-a"}, {NodeId: "mixed"}, {NodeId: "good"}, {NodeId: "new-b"}}
+	rankHosts(cp, hosts, HostRankingReputation)
+	// hosts without history rank below good ones and above bad ones, in
+	// their original order
+	want := []string{"good", "new-a", "new-b", "mixed", "bad"}
+	for i, h := range hosts {
+		if h.NodeId != want[i] {
+			t.Fatalf("expected host %d to be %s, got %s", i, want[i], h.NodeId)
+		}
+	}
+}
+
+func TestParseHostRanking(t *testing.T) {
+	for _, s := range []string{"reputation", "random", "price"} {
+		if _, err := ParseHostRanking(s); err != nil {
+			t.Fatalf("expected %s to parse, got %v", s, err)
+		}
+	}
+	if _, err := ParseHostRanking("score"); err == nil {
+		t.Fatal("expected an error for an unknown ranking")
+	}
+}
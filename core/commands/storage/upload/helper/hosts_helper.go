@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -20,10 +22,45 @@ import (
 )
 
 const (
-	minimumHosts = 30
-	failMsg      = "failed to find more valid hosts, please try again later"
+	minimumHosts   = 30
+	failMsg        = "failed to find more valid hosts, please try again later"
+	allExcludedMsg = "all candidate hosts are excluded by the host blacklist"
 )
 
+// HostBlacklist is a set of hosts that must never be selected for an upload.
+type HostBlacklist map[peer.ID]struct{}
+
+// NewHostBlacklist decodes the given peer IDs into a HostBlacklist.
+// Empty entries are ignored so that a plain strings.Split result can be passed in.
+func NewHostBlacklist(ids []string) (HostBlacklist, error) {
+	bl := make(HostBlacklist)
+	for _, s := range ids {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		id, err := peer.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host peer id %q in blacklist: %w", s, err)
+		}
+		bl[id] = struct{}{}
+	}
+	return bl, nil
+}
+
+// Contains reports whether the given host is blacklisted.
+func (bl HostBlacklist) Contains(host string) bool {
+	if len(bl) == 0 {
+		return false
+	}
+	id, err := peer.Decode(host)
+	if err != nil {
+		return false
+	}
+	_, ok := bl[id]
+	return ok
+}
+
 type IHostsProvider interface {
 	NextValidHost() (string, error)
 }
@@ -88,22 +125,33 @@ type HostsProvider struct {
 	mode            string
 	current         int
 	hosts           []*hubpb.Host
-	blacklist       []string
+	blacklist       HostBlacklist
+	allExcluded     bool
 	backupList      []string
 	backupListLock  sync.Mutex
 	ctx             context.Context
 	cancel          context.CancelFunc
 	times           int
 	needHigherPrice bool
+	ranking         HostRanking
+}
+
+// GetHostsProvider returns a HostsProvider offering the hosts with the best
+// reputation first.
+func GetHostsProvider(cp *ContextParams, blacklist HostBlacklist) IHostsProvider {
+	return GetRankedHostsProvider(cp, blacklist, HostRankingReputation)
 }
 
-func GetHostsProvider(cp *ContextParams, blacklist []string) IHostsProvider {
+// GetRankedHostsProvider returns a HostsProvider offering the hosts in the
+// order of ranking.
+func GetRankedHostsProvider(cp *ContextParams, blacklist HostBlacklist, ranking HostRanking) IHostsProvider {
 	ctx, cancel := context.WithTimeout(cp.Ctx, 10*time.Minute)
 	p := &HostsProvider{
 		cp:              cp,
 		mode:            cp.Cfg.Experimental.HostsSyncMode,
 		current:         -1,
 		blacklist:       blacklist,
+		ranking:         ranking,
 		ctx:             ctx,
 		cancel:          cancel,
 		needHigherPrice: false,
@@ -113,13 +161,21 @@ func GetHostsProvider(cp *ContextParams, blacklist []string) IHostsProvider {
 }
 
 func (p *HostsProvider) init() (err error) {
-	p.hosts, err = helper.GetHostsFromDatastore(p.cp.Ctx, p.cp.N, p.mode, minimumHosts)
+	hosts, err := helper.GetHostsFromDatastore(p.cp.Ctx, p.cp.N, p.mode, minimumHosts)
 	if err != nil {
 		return err
 	}
+	p.hosts = make([]*hubpb.Host, 0, len(hosts))
+	for _, h := range hosts {
+		if !p.blacklist.Contains(h.NodeId) {
+			p.hosts = append(p.hosts, h)
+		}
+	}
+	rankHosts(p.cp, p.hosts, p.ranking)
 	peers, err := p.cp.Api.Swarm().Peers(p.cp.Ctx)
 	if err != nil {
 		log.Debug(err)
+		p.allExcluded = len(hosts) > 0 && len(p.hosts) == 0
 		return nil
 	}
 	var prs Peers = peers
@@ -131,8 +187,12 @@ func (p *HostsProvider) init() (err error) {
 				continue
 			}
 		}
+		if _, ok := p.blacklist[h.ID()]; ok {
+			continue
+		}
 		p.backupList = append(p.backupList, h.ID().String())
 	}
+	p.allExcluded = len(hosts)+len(prs) > 0 && len(p.hosts)+len(p.backupList) == 0
 	return nil
 }
 
@@ -246,10 +306,8 @@ LOOP:
 		p.Unlock()
 		if index, err := p.AddIndex(); times < 2000 && err == nil {
 			host := p.hosts[index]
-			for _, h := range p.blacklist {
-				if h == host.NodeId {
-					continue LOOP
-				}
+			if p.blacklist.Contains(host.NodeId) {
+				continue LOOP
 			}
 			id, err := peer.Decode(host.NodeId)
 			//if err != nil || int64(host.StoragePriceAsk) > price {
@@ -289,6 +347,9 @@ LOOP:
 }
 
 func (p *HostsProvider) getMsg() string {
+	if p.allExcluded {
+		return allExcludedMsg
+	}
 	msg := failMsg
 	if p.needHigherPrice {
 		msg += " or raise price"
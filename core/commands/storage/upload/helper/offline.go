@@ -1,6 +1,8 @@
 package helper
 
 import (
+	"strings"
+
 	cmap "github.com/orcaman/concurrent-map"
 )
 
@@ -17,3 +19,16 @@ var (
 	QuestionsChanMaps           = cmap.New()
 	WaitUploadChanMap           = cmap.New()
 )
+
+// PendingGuardContracts returns the unsigned guard contracts of session ssId
+// waiting for an offline signature, by shard id.
+func PendingGuardContracts(ssId string) map[string][]byte {
+	pending := make(map[string][]byte)
+	for item := range GuardContractMaps.IterBuffered() {
+		// shard ids are <session id>:<shard hash>:<shard index>
+		if strings.HasPrefix(item.Key, ssId+":") {
+			pending[item.Key] = item.Val.([]byte)
+		}
+	}
+	return pending
+}
@@ -2,6 +2,7 @@ package helper
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
@@ -13,6 +14,7 @@ import (
 	"github.com/bittorrent/go-btfs/core/commands/cmdenv"
 	"github.com/bittorrent/go-btfs/core/commands/storage/helper"
 
+	chunker "github.com/bittorrent/go-btfs-chunker"
 	cmds "github.com/bittorrent/go-btfs-cmds"
 	config "github.com/bittorrent/go-btfs-config"
 	iface "github.com/bittorrent/interface-go-btfs-core"
@@ -28,8 +30,25 @@ import (
 const (
 	uploadPriceOptionName   = "price"
 	storageLengthOptionName = "storage-length"
+
+	DefaultHandleShardMaxElapsed  = 300 * time.Second
+	DefaultHandleShardMaxInterval = 1 * time.Second
 )
 
+// NewHandleShardBo returns the backoff used to set up a shard with a host,
+// giving up after maxElapsed and waiting at most maxInterval between tries.
+func NewHandleShardBo(maxElapsed, maxInterval time.Duration) *backoff.ExponentialBackOff {
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = 1 * time.Second
+	if maxInterval < bo.InitialInterval {
+		bo.InitialInterval = maxInterval
+	}
+	bo.MaxElapsedTime = maxElapsed
+	bo.Multiplier = 1.5
+	bo.MaxInterval = maxInterval
+	return bo
+}
+
 var (
 	log = logging.Logger("upload")
 
@@ -41,14 +60,9 @@ var (
 		bo.MaxInterval = 10 * time.Minute
 		return bo
 	}
-	HandleShardBo = func() *backoff.ExponentialBackOff {
-		bo := backoff.NewExponentialBackOff()
-		bo.InitialInterval = 1 * time.Second
-		bo.MaxElapsedTime = 300 * time.Second
-		bo.Multiplier = 1
-		bo.MaxInterval = 1 * time.Second
-		return bo
-	}()
+	// HandleShardBo is shared, backoff state included. Retries running
+	// concurrently should each use their own NewHandleShardBo instead.
+	HandleShardBo  = NewHandleShardBo(DefaultHandleShardMaxElapsed, DefaultHandleShardMaxInterval)
 	CheckPaymentBo = func() *backoff.ExponentialBackOff {
 		bo := backoff.NewExponentialBackOff()
 		bo.InitialInterval = 10 * time.Second
@@ -160,6 +174,38 @@ func GetShardHashesCopy(params *ContextParams, fileHash string, copyNum int) (sh
 	return
 }
 
+// GetShardLayout returns the size of each of shardHashes, the way
+// GetShardHashes measures it, and how many of them are data shards. Files
+// which aren't reed-solomon encoded are uploaded as whole copies, their first
+// shard is then the only data shard.
+func GetShardLayout(params *ContextParams, fileHash string, shardHashes []string) (sizes []int64, dataShards int,
+	err error) {
+	fileCid, err := cidlib.Parse(fileHash)
+	if err != nil {
+		return nil, 0, err
+	}
+	dataShards = 1
+	if mbytes, err := params.Api.Unixfs().GetMetadata(params.Ctx, path.IpfsPath(fileCid)); err == nil {
+		var rsMeta chunker.RsMetaMap
+		if err := json.Unmarshal(mbytes, &rsMeta); err == nil && rsMeta.NumData > 0 {
+			dataShards = int(rsMeta.NumData)
+		}
+	}
+	sizes = make([]int64, 0, len(shardHashes))
+	for _, h := range shardHashes {
+		c, err := cidlib.Parse(h)
+		if err != nil {
+			return nil, 0, err
+		}
+		sz, err := getNodeSizeFromCid(params.Ctx, c, params.Api)
+		if err != nil {
+			return nil, 0, err
+		}
+		sizes = append(sizes, int64(sz))
+	}
+	return sizes, dataShards, nil
+}
+
 func GetPriceAndMinStorageLength(params *ContextParams) (price int64, storageLength int, err error) {
 	ns, err := helper.GetHostStorageConfig(params.Ctx, params.N)
 	if err != nil {
@@ -0,0 +1,106 @@
+package offline
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/bittorrent/go-btfs/core/commands/storage/helper"
+	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+	"github.com/bittorrent/go-btfs/utils"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+	"github.com/bittorrent/go-btfs-common/crypto"
+	guardpb "github.com/bittorrent/go-btfs-common/protos/guard"
+	"github.com/bittorrent/protobuf/proto"
+)
+
+var StorageUploadCompleteContractsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Give the signed shard contracts back to an offline signed upload.",
+		ShortDescription: `
+This command takes the signatures of the contracts returned by
+'btfs storage upload preparecontracts' and resumes the upload. All the
+contracts must be signed, and every signature is checked against the
+renter key before any is used.`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("session-id", true, false, "ID for the entire storage upload session."),
+		cmds.StringArg("signed-contracts", true, false, "JSON list of {\"key\", \"contract\"} items holding the base64 signatures.").EnableStdin(),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		err := utils.CheckSimpleMode(env)
+		if err != nil {
+			return err
+		}
+
+		ssId := req.Arguments[0]
+		ctxParams, err := uh.ExtractContextParams(req, env)
+		if err != nil {
+			return err
+		}
+		if !ctxParams.Cfg.Experimental.StorageClientEnabled {
+			return fmt.Errorf("storage client api not enabled")
+		}
+		rss, err := sessions.GetRenterSession(ctxParams, ssId, "", make([]string, 0))
+		if err != nil {
+			return err
+		}
+		status, err := rss.Status()
+		if err != nil {
+			return err
+		}
+		if status.Status != sessions.RssAwaitingSignaturesStatus {
+			return fmt.Errorf("session is not awaiting signatures, current status: %s", status.Status)
+		}
+		meta, err := rss.OfflineMeta()
+		if err != nil {
+			return err
+		}
+		renterPubKey, err := crypto.GetPubKeyFromPeerId(meta.OfflinePeerId)
+		if err != nil {
+			return err
+		}
+
+		var signed []contract
+		if err := json.Unmarshal([]byte(req.Arguments[1]), &signed); err != nil {
+			return err
+		}
+		pending := uh.PendingGuardContracts(ssId)
+		if len(signed) != len(pending) {
+			return fmt.Errorf("number of signed contracts %d does not match number of unsigned contracts %d",
+				len(signed), len(pending))
+		}
+		sigs := make(map[string][]byte, len(signed))
+		for _, c := range signed {
+			unsigned, ok := pending[c.Key]
+			if !ok {
+				return fmt.Errorf("no unsigned contract for key %s", c.Key)
+			}
+			sig, err := helper.StringToBytes(c.ContractData, helper.Base64)
+			if err != nil {
+				return err
+			}
+			gm := &guardpb.ContractMeta{}
+			if err := proto.Unmarshal(unsigned, gm); err != nil {
+				return err
+			}
+			if ok, err := crypto.Verify(renterPubKey, gm, sig); err != nil || !ok {
+				return fmt.Errorf("invalid signature for contract %s", c.Key)
+			}
+			sigs[c.Key] = sig
+		}
+
+		if err := rss.To(sessions.RssToSignaturesReceivedEvent); err != nil {
+			return err
+		}
+		for k, sig := range sigs {
+			ch, found := uh.GuardChanMaps.Get(k)
+			if !found {
+				return fmt.Errorf("can not find an entry for key %s", k)
+			}
+			ch.(chan []byte) <- sig
+		}
+		return nil
+	},
+}
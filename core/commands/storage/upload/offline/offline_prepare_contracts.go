@@ -0,0 +1,75 @@
+package offline
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/bittorrent/go-btfs/core/commands/storage/helper"
+	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+	"github.com/bittorrent/go-btfs/utils"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+)
+
+var StorageUploadPrepareContractsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Get the unsigned shard contracts of an offline signed upload.",
+		ShortDescription: `
+This command returns the unsigned guard contracts of all the shards of an
+upload started with offline signing, once the session is in the
+'init:awaiting-signatures' state. Each contract is a base64 encoded
+ContractMeta to be signed with the key of the renter, then given back with:
+
+    $ btfs storage upload completecontracts <session-id> <signed-contracts>
+
+where <signed-contracts> is the JSON list of the returned items, with each
+"contract" replaced by its base64 encoded signature.`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("session-id", true, false, "ID for the entire storage upload session."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		err := utils.CheckSimpleMode(env)
+		if err != nil {
+			return err
+		}
+
+		ssId := req.Arguments[0]
+		ctxParams, err := uh.ExtractContextParams(req, env)
+		if err != nil {
+			return err
+		}
+		if !ctxParams.Cfg.Experimental.StorageClientEnabled {
+			return fmt.Errorf("storage client api not enabled")
+		}
+		rss, err := sessions.GetRenterSession(ctxParams, ssId, "", make([]string, 0))
+		if err != nil {
+			return err
+		}
+		status, err := rss.Status()
+		if err != nil {
+			return err
+		}
+		if status.Status != sessions.RssAwaitingSignaturesStatus {
+			return fmt.Errorf("session is not awaiting signatures, current status: %s", status.Status)
+		}
+
+		pending := uh.PendingGuardContracts(ssId)
+		contracts := make([]*contract, 0, len(pending))
+		for k, bytes := range pending {
+			data, err := helper.BytesToString(bytes, helper.Base64)
+			if err != nil {
+				return err
+			}
+			contracts = append(contracts, &contract{Key: k, ContractData: data})
+		}
+		sort.Slice(contracts, func(i, j int) bool {
+			return contracts[i].Key < contracts[j].Key
+		})
+		return res.Emit(&getContractBatchRes{
+			Contracts: contracts,
+		})
+	},
+	Type: getContractBatchRes{},
+}
@@ -0,0 +1,59 @@
+package sessions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ipfs/go-datastore"
+)
+
+const RenterSessionShardEncryptionKey = RenterSessionKey + "shard-encryption"
+
+// ShardEncryption records how the shards of a session were encrypted before
+// being sent to their hosts. The session then uploads a root linking the
+// encrypted shards, this is what it takes to get the file back from them.
+type ShardEncryption struct {
+	Cipher string
+	// Salt is mixed with the private key of the renter into the key of the
+	// shards, hex encoded.
+	Salt string
+	// FileHash is the reed-solomon encoded file the shards were encrypted
+	// from, and ShardHashes its shards in order.
+	FileHash    string
+	ShardHashes []string
+	// Nonces are the hex encoded nonces of the shards, in order.
+	Nonces    []string
+	NumData   int
+	NumParity int
+	FileSize  int64
+}
+
+// SaveShardEncryption records that the shards of the session are encrypted
+// as e describes.
+func (rs *RenterSession) SaveShardEncryption(e *ShardEncryption) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	k := datastore.NewKey(fmt.Sprintf(RenterSessionShardEncryptionKey, rs.PeerId, rs.SsId))
+	return rs.CtxParams.N.Repo.Datastore().Put(context.TODO(), k, b)
+}
+
+// ShardEncryption returns how the shards of the session are encrypted, or
+// nil if they aren't.
+func (rs *RenterSession) ShardEncryption() (*ShardEncryption, error) {
+	k := datastore.NewKey(fmt.Sprintf(RenterSessionShardEncryptionKey, rs.PeerId, rs.SsId))
+	b, err := rs.CtxParams.N.Repo.Datastore().Get(context.TODO(), k)
+	if err == datastore.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	e := new(ShardEncryption)
+	if err := json.Unmarshal(b, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
@@ -0,0 +1,55 @@
+package sessions
+
+import "errors"
+
+// ErrorCategory tells what kind of failure moved a session to the error
+// status, so that clients can tell the user what to do about it.
+type ErrorCategory string
+
+const (
+	// ErrCategoryBalanceInsufficient means the vault can't pay for the
+	// upload, it has to be deposited to before trying again.
+	ErrCategoryBalanceInsufficient ErrorCategory = "balance-insufficient"
+	// ErrCategoryHostTimeout means hosts didn't answer in time.
+	ErrCategoryHostTimeout ErrorCategory = "host-timeout"
+	// ErrCategoryTokenUnsupported means hosts don't accept the token of the
+	// upload.
+	ErrCategoryTokenUnsupported ErrorCategory = "token-unsupported"
+	// ErrCategoryContractSignFailed means the renter couldn't sign a shard
+	// contract.
+	ErrCategoryContractSignFailed ErrorCategory = "contract-sign-failed"
+)
+
+// CategorizedError is an error with the category of the failure. It reads
+// as the error it wraps.
+type CategorizedError struct {
+	Category ErrorCategory
+	Err      error
+}
+
+func (e *CategorizedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CategorizedError) Unwrap() error {
+	return e.Err
+}
+
+// WithErrorCategory wraps err in a CategorizedError of category c. A nil err
+// stays nil.
+func WithErrorCategory(c ErrorCategory, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &CategorizedError{Category: c, Err: err}
+}
+
+// ErrorCategoryOf returns the category of the first CategorizedError in the
+// chain of err, or "" if there's none.
+func ErrorCategoryOf(err error) ErrorCategory {
+	var ce *CategorizedError
+	if errors.As(err, &ce) {
+		return ce.Category
+	}
+	return ""
+}
@@ -0,0 +1,97 @@
+package sessions
+
+import (
+	"sync"
+	"time"
+)
+
+// sessionEventBufferSize is how many events a subscriber may fall behind
+// before it misses some.
+const sessionEventBufferSize = 32
+
+// SessionEvent is a state transition of a renter session.
+type SessionEvent struct {
+	SessionId string
+	Event     string
+	From      string
+	To        string
+	Message   string `json:",omitempty"`
+	Time      time.Time
+	// Category is the kind of failure of a session entering the error
+	// status, if known.
+	Category ErrorCategory `json:",omitempty"`
+	// Shard is only set on the transitions of a single shard, which leave
+	// the status of the session as it is.
+	Shard *ShardEvent `json:",omitempty"`
+}
+
+// ShardEventName is the Event of the SessionEvent of a shard transition.
+const ShardEventName = "shard"
+
+// ShardEvent is a step in setting up the contract of a single shard of a
+// session.
+type ShardEvent struct {
+	ShardIndex int
+	State      string
+	HostID     string `json:",omitempty"`
+	Err        string `json:",omitempty"`
+}
+
+// sessionEventBus sends the events of a session to its subscribers. Sending
+// never blocks: a subscriber whose buffer is full misses the event, so that
+// a subscriber which stopped reading can't hold up the state machine.
+type sessionEventBus struct {
+	mu   sync.Mutex
+	subs map[chan *SessionEvent]struct{}
+}
+
+func (b *sessionEventBus) subscribe() (<-chan *SessionEvent, func()) {
+	ch := make(chan *SessionEvent, sessionEventBufferSize)
+	b.mu.Lock()
+	if b.subs == nil {
+		b.subs = make(map[chan *SessionEvent]struct{})
+	}
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func (b *sessionEventBus) publish(ev *SessionEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			log.Debugf("session %s subscriber is behind, dropping event %s", ev.SessionId, ev.Event)
+		}
+	}
+}
+
+// Subscribe returns the transitions of the session from now on. The
+// returned function ends the subscription and closes the channel, it must
+// be called once the subscriber is done. Events are dropped for a
+// subscriber which doesn't keep up.
+func (rs *RenterSession) Subscribe() (<-chan *SessionEvent, func()) {
+	return rs.events.subscribe()
+}
+
+// PublishShardEvent sends a shard transition to the subscribers of the
+// session, see Subscribe.
+func (rs *RenterSession) PublishShardEvent(ev *ShardEvent) {
+	rs.events.publish(&SessionEvent{
+		SessionId: rs.SsId,
+		Event:     ShardEventName,
+		Time:      time.Now(),
+		Shard:     ev,
+	})
+}
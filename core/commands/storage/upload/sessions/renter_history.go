@@ -0,0 +1,134 @@
+package sessions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ipfs/go-datastore"
+)
+
+const (
+	RenterSessionHistoryPrefix = "/btfs/%s/renter/session-history/"
+	RenterSessionHistoryKey    = RenterSessionHistoryPrefix + "%s"
+)
+
+// SessionRecord summarizes an upload session. It is kept after the session
+// completes or fails, so that the uploads of the node can be listed later.
+type SessionRecord struct {
+	SessionId  string
+	FileHash   string
+	ShardCount int
+	// Hosts are the hosts storing the shards, in the order of the shards.
+	Hosts []string `json:",omitempty"`
+	// TotalPay is the sum of the amounts of the shard contracts, before
+	// the token rate conversion.
+	TotalPay int64
+	Token    string `json:",omitempty"`
+	Status   string
+	Message  string `json:",omitempty"`
+	// Category is the kind of failure of a failed session, if known.
+	Category ErrorCategory `json:",omitempty"`
+	Started  time.Time
+	Updated  time.Time
+	// Finished is zero while the session runs.
+	Finished time.Time
+}
+
+// saveHistory updates the record of the session as it enters status. The
+// hosts and pay are filled in once the session completes or fails.
+func (rs *RenterSession) saveHistory(status string, msg string, category ErrorCategory) error {
+	d := rs.CtxParams.N.Repo.Datastore()
+	k := datastore.NewKey(fmt.Sprintf(RenterSessionHistoryKey, rs.PeerId, rs.SsId))
+	now := time.Now().UTC()
+	r := &SessionRecord{SessionId: rs.SsId, Started: now}
+	b, err := d.Get(context.TODO(), k)
+	if err == nil {
+		if err := json.Unmarshal(b, r); err != nil {
+			return err
+		}
+	} else if err != datastore.ErrNotFound {
+		return err
+	}
+
+	r.FileHash = rs.Hash
+	r.ShardCount = len(rs.ShardHashes)
+	if rs.Token != (common.Address{}) {
+		r.Token = rs.Token.Hex()
+	}
+	r.Status = status
+	r.Message = msg
+	r.Category = category
+	r.Updated = now
+	if status == RssCompleteStatus || status == RssErrorStatus {
+		r.Finished = now
+		if r.Hosts, r.TotalPay, err = rs.contractsSummary(); err != nil {
+			return err
+		}
+	} else {
+		// a resumed session runs again
+		r.Finished = time.Time{}
+	}
+
+	b, err = json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return d.Put(context.TODO(), k, b)
+}
+
+// contractsSummary returns the hosts of the shards and the sum of the
+// amounts of their contracts. Shards without a contract are left out.
+func (rs *RenterSession) contractsSummary() ([]string, int64, error) {
+	shardHosts, err := rs.ShardHosts()
+	if err != nil {
+		return nil, 0, err
+	}
+	var hosts []string
+	var totalPay int64
+	for i, hash := range rs.ShardHashes {
+		shard, err := GetRenterShard(rs.CtxParams, rs.SsId, hash, i)
+		if err != nil {
+			return nil, 0, err
+		}
+		c, err := shard.Contracts()
+		if err != nil {
+			return nil, 0, err
+		}
+		if c.SignedGuardContract == nil {
+			continue
+		}
+		totalPay += c.SignedGuardContract.Amount
+		host := c.SignedGuardContract.HostPid
+		if h, ok := shardHosts[i]; ok {
+			host = h
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, totalPay, nil
+}
+
+// ListSessionHistory returns the records of the upload sessions of the node,
+// the most recently started first.
+func ListSessionHistory(d datastore.Datastore, peerId string) ([]*SessionRecord, error) {
+	vs, err := List(d, fmt.Sprintf(RenterSessionHistoryPrefix, peerId))
+	if err != nil {
+		return nil, err
+	}
+	records := make([]*SessionRecord, 0, len(vs))
+	for _, v := range vs {
+		r := new(SessionRecord)
+		if err := json.Unmarshal(v, r); err != nil {
+			log.Error(err)
+			continue
+		}
+		records = append(records, r)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Started.After(records[j].Started)
+	})
+	return records, nil
+}
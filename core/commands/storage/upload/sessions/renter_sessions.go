@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,12 +19,14 @@ import (
 	"github.com/bittorrent/protobuf/proto"
 
 	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
 	"github.com/looplab/fsm"
 	cmap "github.com/orcaman/concurrent-map"
 )
 
 const (
 	RssInitStatus                 = "init"
+	RssAwaitingSignaturesStatus   = "init:awaiting-signatures"
 	RssSubmitStatus               = "submit"
 	RssGuardStatus                = "guard"
 	RssGuardFileMetaSignedStatus  = "guard:file-meta-signed"
@@ -34,6 +37,8 @@ const (
 	RssCompleteStatus             = "complete"
 	RssErrorStatus                = "error"
 
+	RssToAwaitingSignaturesEvent   = "to-init:awaiting-signatures-event"
+	RssToSignaturesReceivedEvent   = "to-init-event"
 	RssToSubmitEvent               = "to-submit-event"
 	RssToGuardEvent                = "to-guard-event"
 	RssToGuardFileMetaSignedEvent  = "to-guard:file-meta-signed-event"
@@ -43,6 +48,7 @@ const (
 	RssToPayEvent                  = "to-pay-event"
 	RssToCompleteEvent             = "to-complete-event"
 	RssToErrorEvent                = "to-error-event"
+	RssToResumeEvent               = "to-resume-event"
 
 	RenterSessionPrefix            = "/btfs/%s/renter/sessions/"
 	RenterSessionKey               = RenterSessionPrefix + "%s/"
@@ -51,11 +57,15 @@ const (
 	RenterSessionAdditionalInfoKey = RenterSessionKey + "additional-info"
 	RenterSessionOfflineMetaKey    = RenterSessionKey + "offline-meta"
 	RenterSessionOfflineSigningKey = RenterSessionKey + "offline-signing"
+	RenterSessionShardHostsPrefix  = RenterSessionKey + "shard-hosts/"
+	RenterSessionShardHostKey      = RenterSessionShardHostsPrefix + "%d"
 )
 
 var (
 	renterSessionsInMem = cmap.New()
 	rssFsmEvents        = fsm.Events{
+		{Name: RssToAwaitingSignaturesEvent, Src: []string{RssInitStatus}, Dst: RssAwaitingSignaturesStatus},
+		{Name: RssToSignaturesReceivedEvent, Src: []string{RssAwaitingSignaturesStatus}, Dst: RssInitStatus},
 		{Name: RssToSubmitEvent, Src: []string{RssInitStatus}, Dst: RssSubmitStatus},
 		{Name: RssToGuardEvent, Src: []string{RssSubmitStatus}, Dst: RssGuardStatus},
 		{Name: RssToGuardFileMetaSignedEvent, Src: []string{RssGuardStatus}, Dst: RssGuardFileMetaSignedStatus},
@@ -64,6 +74,7 @@ var (
 		{Name: RssToWaitUploadReqSignedEvent, Src: []string{RssWaitUploadStatus}, Dst: RssWaitUploadReqSignedStatus},
 		{Name: RssToPayEvent, Src: []string{RssWaitUploadReqSignedStatus}, Dst: RssPayStatus},
 		{Name: RssToCompleteEvent, Src: []string{RssPayStatus}, Dst: RssCompleteStatus},
+		{Name: RssToResumeEvent, Src: []string{RssErrorStatus}, Dst: RssInitStatus},
 	}
 )
 
@@ -72,6 +83,9 @@ func init() {
 	for _, s := range rssFsmEvents {
 		src = append(src, s.Src...)
 	}
+	// a complete session still fails if its hosts can't prove possession of
+	// their shards afterwards
+	src = append(src, RssCompleteStatus)
 	rssFsmEvents = append(rssFsmEvents, fsm.EventDesc{
 		Name: RssToErrorEvent, Src: src, Dst: RssErrorStatus,
 	})
@@ -87,6 +101,7 @@ type RenterSession struct {
 	Ctx         context.Context
 	Cancel      context.CancelFunc
 	Token       common.Address
+	events      sessionEventBus
 }
 
 func GetRenterSession(ctxParams *uh.ContextParams, ssId string, hash string, shardHashes []string) (*RenterSession,
@@ -169,24 +184,29 @@ func GetRenterSessionWithToken(ctxParams *uh.ContextParams, ssId string, hash st
 }
 
 var helperText = map[string]string{
-	RssInitStatus:       "Searching for recommended hosts…",
-	RssSubmitStatus:     "Hosts found! Checking chequebook balance, and visiting guard.",
-	RssGuardStatus:      "Preparing meta-data and challenge questions.",
-	RssWaitUploadStatus: "Confirming file shard storage by hosts.",
-	RssPayStatus:        "uploaded, doing the cheque payment.",
-	RssCompleteStatus:   "Payment successful! File storage successful!",
+	RssInitStatus:               "Searching for recommended hosts…",
+	RssAwaitingSignaturesStatus: "Waiting for the offline signatures of the shard contracts.",
+	RssSubmitStatus:             "Hosts found! Checking chequebook balance, and visiting guard.",
+	RssGuardStatus:              "Preparing meta-data and challenge questions.",
+	RssWaitUploadStatus:         "Confirming file shard storage by hosts.",
+	RssPayStatus:                "uploaded, doing the cheque payment.",
+	RssCompleteStatus:           "Payment successful! File storage successful!",
 }
 
 func (rs *RenterSession) enterState(e *fsm.Event) {
 	var msg string
-	if text, ok := helperText[strings.Split(e.Dst, ":")[0]]; ok {
+	if text, ok := helperText[e.Dst]; ok {
+		msg = text
+	} else if text, ok := helperText[strings.Split(e.Dst, ":")[0]]; ok {
 		msg = text
 	} else {
 		msg = ""
 	}
+	var category ErrorCategory
 	switch e.Dst {
 	case RssErrorStatus:
-		msg = e.Args[0].(error).Error()
+		err := e.Args[0].(error)
+		msg, category = err.Error(), ErrorCategoryOf(err)
 		rs.Cancel()
 	case RssCompleteStatus:
 		rs.Cancel()
@@ -206,6 +226,18 @@ func (rs *RenterSession) enterState(e *fsm.Event) {
 				Info:        "",
 				LastUpdated: time.Now(),
 			}})
+	if herr := rs.saveHistory(e.Dst, msg, category); herr != nil {
+		log.Errorf("save history of session %s: %s", rs.SsId, herr)
+	}
+	rs.events.publish(&SessionEvent{
+		SessionId: rs.SsId,
+		Event:     e.Event,
+		From:      e.Src,
+		To:        e.Dst,
+		Message:   msg,
+		Category:  category,
+		Time:      time.Now(),
+	})
 	go func() {
 		_ = rs.To(RssErrorStatus, err)
 	}()
@@ -268,6 +300,22 @@ func (rs *RenterSession) To(event string, args ...interface{}) error {
 	return rs.fsm.Event(event, args...)
 }
 
+// Resume moves a failed session back to init so that its shards without a
+// contract can be uploaded again. The session then runs under ctxParams, the
+// ones of the resuming request.
+func (rs *RenterSession) Resume(ctxParams *uh.ContextParams) error {
+	status, err := rs.Status()
+	if err != nil {
+		return err
+	}
+	if status.Status != RssErrorStatus || rs.fsm == nil {
+		return fmt.Errorf("only failed sessions can be resumed, current status: %s", status.Status)
+	}
+	rs.CtxParams = ctxParams
+	rs.Ctx, rs.Cancel = helper.NewGoContext(ctxParams.Ctx)
+	return rs.To(RssToResumeEvent)
+}
+
 func (rs *RenterSession) SaveOfflineMeta(meta *renterpb.OfflineMeta) error {
 	return Save(rs.CtxParams.N.Repo.Datastore(), fmt.Sprintf(RenterSessionOfflineMetaKey, rs.PeerId, rs.SsId), meta)
 }
@@ -295,6 +343,37 @@ func (rs *RenterSession) OfflineSigning() (*renterpb.OfflineSigning, error) {
 	return signingData, nil
 }
 
+// SaveShardHost records host as the host storing the shard at index. It is
+// overwritten when the shard is moved to another host.
+func (rs *RenterSession) SaveShardHost(index int, host string) error {
+	k := datastore.NewKey(fmt.Sprintf(RenterSessionShardHostKey, rs.PeerId, rs.SsId, index))
+	return rs.CtxParams.N.Repo.Datastore().Put(context.TODO(), k, []byte(host))
+}
+
+// ShardHosts returns the host recorded for each shard index of the session.
+// Shards not set up with a host yet are missing.
+func (rs *RenterSession) ShardHosts() (map[int]string, error) {
+	results, err := rs.CtxParams.N.Repo.Datastore().Query(context.TODO(), query.Query{
+		Prefix: fmt.Sprintf(RenterSessionShardHostsPrefix, rs.PeerId, rs.SsId),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	hosts := make(map[int]string)
+	for entry := range results.Next() {
+		if entry.Error != nil {
+			return nil, entry.Error
+		}
+		index, err := strconv.Atoi(datastore.RawKey(entry.Key).BaseNamespace())
+		if err != nil {
+			return nil, fmt.Errorf("invalid shard host key %s: %w", entry.Key, err)
+		}
+		hosts[index] = string(entry.Value)
+	}
+	return hosts, nil
+}
+
 type RenterSessionsCursor struct {
 	ctxParam *uh.ContextParams
 	keys     []string
@@ -1,8 +1,16 @@
 package sessions
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"testing"
 
+	guardpb "github.com/bittorrent/go-btfs-common/protos/guard"
+	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	coremock "github.com/bittorrent/go-btfs/core/mock"
+
+	"github.com/looplab/fsm"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -11,3 +19,212 @@ func TestGetractSessionId(t *testing.T) {
 	id := getSessionId(key)
 	assert.Equal(t, "0fb2f98b-3ff2-42ca-b297-7e5e13d0fe5a", id)
 }
+
+func TestShardHosts(t *testing.T) {
+	node, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := &RenterSession{
+		PeerId:    node.Identity.String(),
+		SsId:      "0fb2f98b-3ff2-42ca-b297-7e5e13d0fe5a",
+		CtxParams: &uh.ContextParams{N: node},
+	}
+	hosts, err := rs.ShardHosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, hosts)
+
+	assert.NoError(t, rs.SaveShardHost(0, "host-a"))
+	assert.NoError(t, rs.SaveShardHost(12, "host-b"))
+	// a shard moved to another host only keeps the last one
+	assert.NoError(t, rs.SaveShardHost(0, "host-c"))
+	hosts, err = rs.ShardHosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, map[int]string{0: "host-c", 12: "host-b"}, hosts)
+}
+
+func TestShardEncryption(t *testing.T) {
+	node, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := &RenterSession{
+		PeerId:    node.Identity.String(),
+		SsId:      "0fb2f98b-3ff2-42ca-b297-7e5e13d0fe5a",
+		CtxParams: &uh.ContextParams{N: node},
+	}
+	e, err := rs.ShardEncryption()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, e)
+
+	saved := &ShardEncryption{
+		Cipher:      "aes-256-gcm",
+		Salt:        "00ff",
+		FileHash:    "QmFile",
+		ShardHashes: []string{"Qm1", "Qm2"},
+		Nonces:      []string{"01", "02"},
+		NumData:     1,
+		NumParity:   1,
+		FileSize:    10,
+	}
+	assert.NoError(t, rs.SaveShardEncryption(saved))
+	e, err = rs.ShardEncryption()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, saved, e)
+}
+
+func TestRenterShardReset(t *testing.T) {
+	node, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctxParams := &uh.ContextParams{N: node, Ctx: context.Background()}
+	shard, err := GetRenterShard(ctxParams, "0fb2f98b-3ff2-42ca-b297-7e5e13d0fe5a", "Qm1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract := &guardpb.Contract{ContractMeta: guardpb.ContractMeta{ShardHash: "Qm1"}}
+	assert.NoError(t, shard.Contract(nil, contract))
+	status, err := shard.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, rshContractStatus, status.Status)
+
+	assert.NoError(t, shard.Reset())
+	status, err = shard.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, rshInitStatus, status.Status)
+	contracts, err := shard.Contracts()
+	assert.NoError(t, err)
+	assert.Nil(t, contracts.SignedGuardContract)
+
+	// the shard can get a new contract
+	assert.NoError(t, shard.Contract(nil, contract))
+}
+
+func TestRenterSessionSubscribe(t *testing.T) {
+	node, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rs := &RenterSession{
+		PeerId:    node.Identity.String(),
+		SsId:      "0fb2f98b-3ff2-42ca-b297-7e5e13d0fe5a",
+		CtxParams: &uh.ContextParams{N: node},
+		Ctx:       ctx,
+		Cancel:    cancel,
+	}
+	rs.fsm = fsm.NewFSM(RssInitStatus, rssFsmEvents, fsm.Callbacks{
+		"enter_state": rs.enterState,
+	})
+
+	events, unsubscribe := rs.Subscribe()
+	// a subscriber which never reads doesn't hold up the transitions
+	_, stalled := rs.Subscribe()
+	defer stalled()
+	for i := 0; i < sessionEventBufferSize; i++ {
+		rs.events.publish(&SessionEvent{SessionId: rs.SsId, Event: "filler"})
+	}
+	for i := 0; i < sessionEventBufferSize; i++ {
+		<-events
+	}
+
+	assert.NoError(t, rs.To(RssToSubmitEvent))
+	ev := <-events
+	assert.Equal(t, RssToSubmitEvent, ev.Event)
+	assert.Equal(t, RssInitStatus, ev.From)
+	assert.Equal(t, RssSubmitStatus, ev.To)
+	assert.Empty(t, ev.Category)
+
+	cause := errors.New("no answer")
+	assert.NoError(t, rs.To(RssToErrorEvent, WithErrorCategory(ErrCategoryHostTimeout, cause)))
+	ev = <-events
+	assert.Equal(t, RssErrorStatus, ev.To)
+	assert.Equal(t, cause.Error(), ev.Message)
+	assert.Equal(t, ErrCategoryHostTimeout, ev.Category)
+
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Fatal("expected the channel to be closed")
+	}
+	unsubscribe() // a second call is a no-op
+}
+
+func TestSessionHistory(t *testing.T) {
+	node, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctxParams := &uh.ContextParams{N: node, Ctx: context.Background()}
+	rs := &RenterSession{
+		PeerId:      node.Identity.String(),
+		SsId:        "0fb2f98b-3ff2-42ca-b297-7e5e13d0fe5a",
+		Hash:        "QmFile",
+		ShardHashes: []string{"Qm1", "Qm2"},
+		CtxParams:   ctxParams,
+	}
+	assert.NoError(t, rs.saveHistory(RssSubmitStatus, "", ""))
+	records, err := ListSessionHistory(node.Repo.Datastore(), rs.PeerId)
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	started := records[0].Started
+	assert.True(t, records[0].Finished.IsZero())
+
+	// only the first shard got a contract
+	shard, err := GetRenterShard(ctxParams, rs.SsId, "Qm1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, shard.Contract(nil, &guardpb.Contract{
+		ContractMeta: guardpb.ContractMeta{ShardHash: "Qm1", HostPid: "host-a", Amount: 5},
+	}))
+	assert.NoError(t, rs.saveHistory(RssErrorStatus, "failed", ErrCategoryHostTimeout))
+	records, err = ListSessionHistory(node.Repo.Datastore(), rs.PeerId)
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+	r := records[0]
+	assert.Equal(t, "QmFile", r.FileHash)
+	assert.Equal(t, 2, r.ShardCount)
+	assert.Equal(t, []string{"host-a"}, r.Hosts)
+	assert.Equal(t, int64(5), r.TotalPay)
+	assert.Equal(t, RssErrorStatus, r.Status)
+	assert.Equal(t, "failed", r.Message)
+	assert.Equal(t, ErrCategoryHostTimeout, r.Category)
+	assert.True(t, r.Started.Equal(started))
+	assert.False(t, r.Finished.IsZero())
+}
+
+func TestErrorCategoryOf(t *testing.T) {
+	cause := errors.New("insufficient funds")
+	err := fmt.Errorf("submit: %w", WithErrorCategory(ErrCategoryBalanceInsufficient, cause))
+	assert.Equal(t, ErrCategoryBalanceInsufficient, ErrorCategoryOf(err))
+	assert.ErrorIs(t, err, cause)
+	assert.Equal(t, "submit: insufficient funds", err.Error())
+
+	assert.Empty(t, ErrorCategoryOf(cause))
+	assert.NoError(t, WithErrorCategory(ErrCategoryHostTimeout, nil))
+}
+
+func TestRenterSessionShardEvents(t *testing.T) {
+	rs := &RenterSession{SsId: "0fb2f98b-3ff2-42ca-b297-7e5e13d0fe5a"}
+	// nobody listening
+	rs.PublishShardEvent(&ShardEvent{ShardIndex: 0, State: "contract-signing"})
+
+	events, unsubscribe := rs.Subscribe()
+	defer unsubscribe()
+	rs.PublishShardEvent(&ShardEvent{ShardIndex: 3, State: "init-sent", HostID: "host"})
+	ev := <-events
+	assert.Equal(t, ShardEventName, ev.Event)
+	assert.Equal(t, rs.SsId, ev.SessionId)
+	assert.Empty(t, ev.To)
+	assert.Equal(t, &ShardEvent{ShardIndex: 3, State: "init-sent", HostID: "host"}, ev.Shard)
+}
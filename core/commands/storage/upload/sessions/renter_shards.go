@@ -145,6 +145,25 @@ func (rs *RenterShard) doContract(signedEscrowContract []byte, signedGuardContra
 	})
 }
 
+// Reset drops the contract of the shard, which goes back to init to be
+// uploaded again.
+func (rs *RenterShard) Reset() error {
+	shardId := GetShardId(rs.ssId, rs.hash, rs.index)
+	err := Batch(rs.ds, []string{
+		fmt.Sprintf(renterShardStatusKey, rs.peerId, shardId),
+		fmt.Sprintf(renterShardContractsKey, rs.peerId, shardId),
+	}, []proto.Message{
+		&shardpb.Status{Status: rshInitStatus}, nil,
+	})
+	if err != nil {
+		return err
+	}
+	rs.fsm = fsm.NewFSM(rshInitStatus, renterShardFsmEvents, fsm.Callbacks{
+		"enter_state": rs.enterState,
+	})
+	return nil
+}
+
 func (rs *RenterShard) Contract(signedEscrowContract []byte, signedGuardContract *guardpb.Contract) error {
 	return rs.fsm.Event(rshToContractEvent, signedEscrowContract, signedGuardContract)
 }
@@ -0,0 +1,143 @@
+package upload_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	oldcmds "github.com/bittorrent/go-btfs/commands"
+	"github.com/bittorrent/go-btfs/core"
+	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/upload"
+	"github.com/bittorrent/go-btfs/core/coreapi"
+	"github.com/bittorrent/go-btfs/core/corehttp"
+	"github.com/bittorrent/go-btfs/core/corehttp/remote"
+	coremock "github.com/bittorrent/go-btfs/core/mock"
+
+	config "github.com/bittorrent/go-btfs-config"
+	files "github.com/bittorrent/go-btfs-files"
+	cidlib "github.com/ipfs/go-cid"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// challengeNetwork is a renter along with a host serving the remote commands
+// over P2P the way the daemon does, and a file stored by both.
+type challengeNetwork struct {
+	renter *uh.ContextParams
+	host   *core.IpfsNode
+	other  *core.IpfsNode
+	root   cidlib.Cid
+}
+
+func newChallengeNetwork(t *testing.T) *challengeNetwork {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	mn := mocknet.New()
+	var nodes []*core.IpfsNode
+	for i := 0; i < 3; i++ {
+		n, err := coremock.MockPublicNode(ctx, mn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { n.Close() })
+		nodes = append(nodes, n)
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+	renter, host, other := nodes[0], nodes[1], nodes[2]
+	for _, n := range []*core.IpfsNode{host, other} {
+		serveRemoteApi(t, ctx, n)
+	}
+
+	renterApi, err := coreapi.NewCoreAPI(renter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var root cidlib.Cid
+	data := bytes.Repeat([]byte("shard"), 1000)
+	for _, n := range []*core.IpfsNode{renter, host} {
+		api, err := coreapi.NewCoreAPI(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := api.Unixfs().Add(ctx, files.NewBytesFile(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		root = p.Cid()
+	}
+	return &challengeNetwork{
+		renter: &uh.ContextParams{Ctx: ctx, N: renter, Api: renterApi},
+		host:   host,
+		other:  other,
+		root:   root,
+	}
+}
+
+// serveRemoteApi serves the remote commands of n to its peers.
+func serveRemoteApi(t *testing.T, ctx context.Context, n *core.IpfsNode) {
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Experimental.StorageHostEnabled = true
+
+	lis, err := manet.Listen(ma.StringCast("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	cctx := oldcmds.Context{
+		ConfigRoot: t.TempDir(),
+		LoadConfig: func(string) (*config.Config, error) {
+			return n.Repo.Config()
+		},
+		ConstructNode: func() (*core.IpfsNode, error) {
+			return n, nil
+		},
+	}
+	go corehttp.Serve(n, manet.NetListener(lis), corehttp.CommandsRemoteOption(cctx))
+	if _, err := n.P2P.ForwardRemote(ctx, remote.P2PRemoteCallProto, lis.Multiaddr(), false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProveShardP2P(t *testing.T) {
+	nw := newChallengeNetwork(t)
+	ctx := nw.renter.Ctx
+
+	s := upload.NewShardHost(0, nw.root.String(), nw.host.Identity)
+	if err := upload.ProveShard(ctx, nw.renter, nw.root, s, time.Minute); err != nil {
+		t.Fatalf("expected the host to prove it stores the shard, got %v", err)
+	}
+	s = upload.NewShardHost(0, nw.root.String(), nw.other.Identity)
+	if err := upload.ProveShard(ctx, nw.renter, nw.root, s, time.Minute); err == nil {
+		t.Fatal("expected a host without the shard to fail the challenge")
+	}
+}
+
+func TestCheckShardsP2P(t *testing.T) {
+	nw := newChallengeNetwork(t)
+	ctx := nw.renter.Ctx
+
+	// the way 'btfs storage check' challenges the hosts
+	hosts := map[int]string{0: nw.host.Identity.String(), 1: nw.other.Identity.String()}
+	checks := upload.CheckShards(ctx, []string{nw.root.String(), nw.root.String()}, hosts, 2,
+		func(ctx context.Context, s upload.ShardHost) error {
+			return upload.ProveShard(ctx, nw.renter, nw.root, s, time.Minute)
+		})
+	if !checks[0].Available {
+		t.Fatalf("expected the shard of the host storing it to be available, got %+v", checks[0])
+	}
+	if checks[1].Available || checks[1].Error == "" {
+		t.Fatalf("expected the shard of a host without it to be unavailable, got %+v", checks[1])
+	}
+}
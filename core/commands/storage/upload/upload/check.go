@@ -0,0 +1,239 @@
+package upload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	storagehelper "github.com/bittorrent/go-btfs/core/commands/storage/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+
+	cidlib "github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	checkSessionOptionName = "session-id"
+	checkTimeoutOptionName = "timeout"
+	checkJsonOptionName    = "json"
+)
+
+var StorageCheckCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Check that a stored file can still be retrieved from its hosts.",
+		ShortDescription: `
+This command challenges the host of every shard of a stored file to prove it
+still stores the shard, the same way uploads are verified, and reports which
+shards are available. The hosts are taken from the upload session given with
+--session-id, or else from the last upload session of the file.
+
+The file is retrievable as long as at least as many shards are available as
+it has data shards, or any of them for a file uploaded as copies. Use --json
+for a report to feed monitoring with.
+
+    $ btfs storage check <file-hash>`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("file-hash", true, false, "Hash of the stored file to check."),
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(checkSessionOptionName, "Upload session to take the hosts of the shards from. Default: the last upload session of the file."),
+		cmds.StringOption(checkTimeoutOptionName, "How long to wait for each host to answer its challenge, e.g. '1m'.").WithDefault("1m"),
+		cmds.IntOption(shardParallelismOptionName, "Max number of hosts being challenged at the same time.").WithDefault(DefaultShardParallelism),
+		cmds.BoolOption(checkJsonOptionName, "Print the report as JSON.").WithDefault(false),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		ctxParams, err := helper.ExtractContextParams(req, env)
+		if err != nil {
+			return err
+		}
+		if !ctxParams.Cfg.Experimental.StorageClientEnabled {
+			return fmt.Errorf("storage client api not enabled")
+		}
+		fileHash, err := cidlib.Parse(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		timeout, err := time.ParseDuration(req.Options[checkTimeoutOptionName].(string))
+		if err != nil {
+			return fmt.Errorf("invalid --%s: %w", checkTimeoutOptionName, err)
+		}
+		if timeout <= 0 {
+			return fmt.Errorf("--%s must be greater than zero, got %s", checkTimeoutOptionName, timeout)
+		}
+		parallelism := req.Options[shardParallelismOptionName].(int)
+		if parallelism <= 0 {
+			return fmt.Errorf("--%s must be greater than zero, got %d", shardParallelismOptionName, parallelism)
+		}
+
+		ssId, _ := req.Options[checkSessionOptionName].(string)
+		rss, err := fileSession(ctxParams, fileHash.String(), ssId)
+		if err != nil {
+			return err
+		}
+		if rss == nil {
+			return fmt.Errorf("no upload session of %s is known, give one with --%s", fileHash, checkSessionOptionName)
+		}
+		dataShards, err := checkDataShards(req.Context, ctxParams, rss)
+		if err != nil {
+			return err
+		}
+		hosts, err := sessionShardHosts(ctxParams, rss)
+		if err != nil {
+			return err
+		}
+
+		checks := checkShards(req.Context, rss.ShardHashes, hosts, parallelism,
+			func(ctx context.Context, s shardHost) error {
+				err := proveShard(ctx, ctxParams, fileHash, s, timeout)
+				recordHostResult(ctx, rss, s.host.String(), err)
+				return err
+			})
+		if err := req.Context.Err(); err != nil {
+			return err
+		}
+		available, retrievable := checkRetrievable(checks, dataShards)
+		return cmds.EmitOnce(res, &StorageCheckRes{
+			FileHash:     fileHash.String(),
+			SessionId:    rss.SsId,
+			DataShards:   dataShards,
+			ParityShards: len(checks) - dataShards,
+			Available:    available,
+			Retrievable:  retrievable,
+			Shards:       checks,
+		})
+	},
+	Type: StorageCheckRes{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *StorageCheckRes) error {
+			if asJson, _ := req.Options[checkJsonOptionName].(bool); asJson {
+				return json.NewEncoder(w).Encode(out)
+			}
+			for _, s := range out.Shards {
+				host := s.Host
+				if host == "" {
+					host = "unknown host"
+				}
+				if s.Available {
+					fmt.Fprintf(w, "shard %d %s on %s: available\n", s.Index, s.Hash, host)
+				} else {
+					fmt.Fprintf(w, "shard %d %s on %s: unavailable (%s)\n", s.Index, s.Hash, host, s.Error)
+				}
+			}
+			state := "retrievable"
+			if !out.Retrievable {
+				state = "NOT retrievable"
+			}
+			fmt.Fprintf(w, "%s: %d of %d shards available, %d needed, %s\n", out.FileHash, out.Available,
+				len(out.Shards), out.DataShards, state)
+			return nil
+		}),
+	},
+}
+
+// StorageCheckRes is the availability of the shards of a stored file.
+type StorageCheckRes struct {
+	FileHash     string
+	SessionId    string
+	DataShards   int
+	ParityShards int
+	Available    int
+	Retrievable  bool
+	Shards       []*ShardCheck
+}
+
+// ShardCheck tells whether the host of a shard proved it stores it.
+type ShardCheck struct {
+	Index     int
+	Hash      string
+	Host      string
+	Available bool
+	Error     string `json:",omitempty"`
+}
+
+// checkDataShards returns how many of the shards of rss the file needs to be
+// retrieved: one for a file uploaded as copies, its data shards otherwise.
+func checkDataShards(ctx context.Context, ctxParams *helper.ContextParams, rss *sessions.RenterSession) (int, error) {
+	encryption, err := rss.ShardEncryption()
+	if err != nil {
+		return 0, err
+	}
+	if encryption != nil {
+		return encryption.NumData, nil
+	}
+	copies := true
+	for _, h := range rss.ShardHashes {
+		if h != rss.Hash {
+			copies = false
+			break
+		}
+	}
+	if copies {
+		return 1, nil
+	}
+	root, err := cidlib.Parse(rss.Hash)
+	if err != nil {
+		return 0, err
+	}
+	meta, _, err := storagehelper.GetReedSolomonShards(ctx, ctxParams.Api, root)
+	if err != nil {
+		return 0, err
+	}
+	if int(meta.NumData+meta.NumParity) != len(rss.ShardHashes) {
+		return 0, fmt.Errorf("session %s has %d shards, %s is encoded in %d", rss.SsId, len(rss.ShardHashes),
+			rss.Hash, meta.NumData+meta.NumParity)
+	}
+	return int(meta.NumData), nil
+}
+
+// checkShards runs prove for every shard with a host, at most parallelism
+// at once, and returns the result of each. Unlike verifyShards it doesn't
+// stop at the first failure.
+func checkShards(ctx context.Context, shardHashes []string, hosts map[int]string, parallelism int,
+	prove func(ctx context.Context, s shardHost) error) []*ShardCheck {
+	checks := make([]*ShardCheck, len(shardHashes))
+	g := new(errgroup.Group)
+	g.SetLimit(parallelism)
+	for i, h := range shardHashes {
+		c := &ShardCheck{Index: i, Hash: h, Host: hosts[i]}
+		checks[i] = c
+		if c.Host == "" {
+			c.Error = "no known host"
+			continue
+		}
+		host, err := peer.Decode(c.Host)
+		if err != nil {
+			c.Error = fmt.Sprintf("invalid host: %s", err)
+			continue
+		}
+		s := shardHost{index: i, hash: h, host: host}
+		g.Go(func() error {
+			if err := prove(ctx, s); err != nil {
+				c.Error = err.Error()
+			} else {
+				c.Available = true
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return checks
+}
+
+// checkRetrievable counts the available shards of checks, and tells whether
+// they are enough for the dataShards needed.
+func checkRetrievable(checks []*ShardCheck, dataShards int) (int, bool) {
+	available := 0
+	for _, c := range checks {
+		if c.Available {
+			available++
+		}
+	}
+	return available, dataShards > 0 && available >= dataShards
+}
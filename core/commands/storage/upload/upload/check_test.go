@@ -0,0 +1,59 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/test"
+)
+
+func TestCheckShards(t *testing.T) {
+	p1 := test.RandPeerIDFatal(t)
+	p2 := test.RandPeerIDFatal(t)
+	hosts := map[int]string{0: p1.String(), 1: p2.String(), 3: "not-a-peer"}
+
+	checks := checkShards(context.Background(), []string{"a", "b", "c", "d"}, hosts, 2,
+		func(ctx context.Context, s shardHost) error {
+			if s.host == p2 {
+				return errors.New("wrong challenge answer")
+			}
+			return nil
+		})
+	if len(checks) != 4 {
+		t.Fatalf("expected 4 shards checked, got %d", len(checks))
+	}
+	if !checks[0].Available || checks[0].Error != "" {
+		t.Fatalf("expected shard 0 available, got %+v", checks[0])
+	}
+	if checks[1].Available || checks[1].Error != "wrong challenge answer" {
+		t.Fatalf("expected shard 1 unavailable, got %+v", checks[1])
+	}
+	if checks[2].Available || checks[2].Error != "no known host" {
+		t.Fatalf("expected shard 2 without a host, got %+v", checks[2])
+	}
+	if checks[3].Available || checks[3].Error == "" {
+		t.Fatalf("expected shard 3 with an invalid host, got %+v", checks[3])
+	}
+}
+
+func TestCheckRetrievable(t *testing.T) {
+	checks := []*ShardCheck{{Available: true}, {}, {Available: true}, {}}
+	for _, tc := range []struct {
+		dataShards  int
+		retrievable bool
+	}{
+		{1, true},
+		{2, true},
+		{3, false},
+		{0, false},
+	} {
+		available, retrievable := checkRetrievable(checks, tc.dataShards)
+		if available != 2 {
+			t.Fatalf("expected 2 shards available, got %d", available)
+		}
+		if retrievable != tc.retrievable {
+			t.Fatalf("%d data shards: expected retrievable %v, got %v", tc.dataShards, tc.retrievable, retrievable)
+		}
+	}
+}
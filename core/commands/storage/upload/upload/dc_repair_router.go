@@ -408,7 +408,7 @@ FOR:
 	if err != nil {
 		return nil, err
 	}
-	hp := uh.GetHostsProvider(ctxParams, make([]string, 0))
+	hp := uh.GetHostsProvider(ctxParams, nil)
 	shardMap := make(map[int]string)
 	ctx, _ := context.WithTimeout(rss.Ctx, 10*time.Minute)
 	for _, contract := range contracts {
@@ -438,6 +438,7 @@ FOR:
 
 func downloadAndSignContracts(contract *guardpb.Contract, rss *sessions.RenterSession, ctx context.Context, hp uh.IHostsProvider) {
 	go func() {
+		bo := uh.NewHandleShardBo(uh.DefaultHandleShardMaxElapsed, uh.DefaultHandleShardMaxInterval)
 		err := backoff.Retry(func() error {
 			select {
 			case <-ctx.Done():
@@ -507,11 +508,11 @@ func downloadAndSignContracts(contract *guardpb.Contract, rss *sessions.RenterSe
 			case <-tick:
 				return errors.New("host timeout")
 			}
-		}, uh.HandleShardBo)
+		}, bo)
 		if err != nil {
 			logger.Errorf("fail to setup contract for shard [%s]: [%v]", contract.ShardHash, err)
 			_ = rss.To(sessions.RssToErrorEvent,
-				errors.New("timeout: fail to setup contract in "+uh.HandleShardBo.MaxElapsedTime.String()))
+				errors.New("timeout: fail to setup contract in "+bo.MaxElapsedTime.String()))
 		}
 	}()
 }
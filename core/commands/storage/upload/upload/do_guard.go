@@ -20,7 +20,8 @@ import (
 	cidlib "github.com/ipfs/go-cid"
 )
 
-func doGuardAndPay(rss *sessions.RenterSession, res *escrowpb.SignedPayinResult, fileSize int64, offlineSigning bool) error {
+func doGuardAndPay(rss *sessions.RenterSession, res *escrowpb.SignedPayinResult, fileSize int64, offlineSigning bool,
+	confirm ConfirmLevel) error {
 	if err := rss.To(sessions.RssToGuardEvent); err != nil {
 		return err
 	}
@@ -38,7 +39,9 @@ func doGuardAndPay(rss *sessions.RenterSession, res *escrowpb.SignedPayinResult,
 		//contracts.SignedGuardContract.EscrowSignature = res.EscrowSignature
 		//contracts.SignedGuardContract.EscrowSignedTime = res.Result.EscrowSignedTime
 		contracts.SignedGuardContract.LastModifyTime = time.Now()
-		contracts.SignedGuardContract.Token = rss.Token.String()
+		if contracts.SignedGuardContract.Token == "" {
+			contracts.SignedGuardContract.Token = rss.Token.String()
+		}
 		cts = append(cts, contracts.SignedGuardContract)
 		selectedHosts = append(selectedHosts, contracts.SignedGuardContract.HostPid)
 	}
@@ -102,7 +105,7 @@ func doGuardAndPay(rss *sessions.RenterSession, res *escrowpb.SignedPayinResult,
 	if err != nil {
 		return fmt.Errorf("failed to send challenge questions to guard: [%v]", err)
 	}
-	return waitUpload(rss, offlineSigning, fsStatus, false)
+	return waitUpload(rss, offlineSigning, fsStatus, false, confirm)
 }
 
 func NewFileStatus(contracts []*guardpb.Contract, configuration *config.Config,
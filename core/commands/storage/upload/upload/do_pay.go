@@ -8,9 +8,12 @@ import (
 
 	"github.com/bittorrent/go-btfs/chain"
 	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+
+	guardpb "github.com/bittorrent/go-btfs-common/protos/guard"
 )
 
 func payInCheque(rss *sessions.RenterSession) error {
+	refreshed := make(map[common.Address]bool)
 	for i, hash := range rss.ShardHashes {
 		shard, err := sessions.GetRenterShard(rss.CtxParams, rss.SsId, hash, i)
 		if err != nil {
@@ -23,16 +26,22 @@ func payInCheque(rss *sessions.RenterSession) error {
 
 		// token: get real amount
 		//realAmount, err := getRealAmount(c.SignedGuardContract.Amount)
-		realAmount, err := getRealAmount(c.SignedGuardContract.Amount, rss.Token)
+		token := contractToken(rss, c.SignedGuardContract)
+		// pay with the latest rate rather than a cached one
+		if !refreshed[token] {
+			chain.SettleObject.OracleService.Refresh(token)
+			refreshed[token] = true
+		}
+		realAmount, err := getRealAmount(c.SignedGuardContract.Amount, token)
 		if err != nil {
 			return err
 		}
 
 		host := c.SignedGuardContract.HostPid
 		contractId := c.SignedGuardContract.ContractId
-		fmt.Printf("send cheque: paying...  host:%v, amount:%v, contractId:%v, token:%v. \n", host, realAmount.String(), contractId, rss.Token.String())
+		fmt.Printf("send cheque: paying...  host:%v, amount:%v, contractId:%v, token:%v. \n", host, realAmount.String(), contractId, token.String())
 
-		err = chain.SettleObject.SwapService.Settle(host, realAmount, contractId, rss.Token)
+		err = chain.SettleObject.SwapService.Settle(host, realAmount, contractId, token)
 		if err != nil {
 			return err
 		}
@@ -42,6 +51,15 @@ func payInCheque(rss *sessions.RenterSession) error {
 	return nil
 }
 
+// contractToken returns the token the shard contract was signed with. Contracts
+// without one are paid in the session token.
+func contractToken(rss *sessions.RenterSession, c *guardpb.Contract) common.Address {
+	if c != nil && c.Token != "" {
+		return common.HexToAddress(c.Token)
+	}
+	return rss.Token
+}
+
 func getRealAmount(amount int64, token common.Address) (*big.Int, error) {
 	//this is price's rate [Compatible with older versions]
 	rateObj, err := chain.SettleObject.OracleService.CurrentRate(token)
@@ -76,11 +76,14 @@ func RenterSignGuardContract(rss *sessions.RenterSession, params *ContractParams
 		return nil, err
 	}
 	uh.GuardContractMaps.Set(shardId, bytes)
-	if !offlineSigning {
+	if offlineSigning {
+		awaitOfflineSignatures(rss)
+	} else {
 		go func() {
 			sign, err := crypto.Sign(rss.CtxParams.N.PrivateKey, gm)
 			if err != nil {
-				_ = rss.To(sessions.RssToErrorEvent, err)
+				_ = rss.To(sessions.RssToErrorEvent,
+					sessions.WithErrorCategory(sessions.ErrCategoryContractSignFailed, err))
 				return
 			}
 			bc <- sign
@@ -94,6 +97,24 @@ func RenterSignGuardContract(rss *sessions.RenterSession, params *ContractParams
 	return proto.Marshal(cont)
 }
 
+// awaitOfflineSignatures moves the session to the awaiting signatures state
+// once every shard either has its contract or waits for its signature, so that
+// they can all be signed at once.
+func awaitOfflineSignatures(rss *sessions.RenterSession) {
+	completeNum, _, err := rss.GetCompleteShardsNum()
+	if err != nil {
+		log.Debugf("session %s counts complete shards error: %s", rss.SsId, err.Error())
+		return
+	}
+	if completeNum+len(uh.PendingGuardContracts(rss.SsId)) < len(rss.ShardHashes) {
+		return
+	}
+	if err := rss.To(sessions.RssToAwaitingSignaturesEvent); err != nil {
+		// another shard got there first
+		log.Debugf("session %s awaits signatures, transition err: %s", rss.SsId, err.Error())
+	}
+}
+
 func getGuardAndEscrowPid(configuration *config.Config) (peer.ID, peer.ID, error) {
 	escrowPubKeys := configuration.Services.EscrowPubKeys
 	if len(escrowPubKeys) == 0 {
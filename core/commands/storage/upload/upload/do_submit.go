@@ -10,7 +10,7 @@ import (
 	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
 )
 
-func Submit(rss *sessions.RenterSession, fileSize int64, offlineSigning bool) error {
+func Submit(rss *sessions.RenterSession, fileSize int64, offlineSigning bool, confirm ConfirmLevel) error {
 	if err := rss.To(sessions.RssToSubmitEvent); err != nil {
 		return err
 	}
@@ -19,39 +19,46 @@ func Submit(rss *sessions.RenterSession, fileSize int64, offlineSigning bool) er
 	if err != nil {
 		return err
 	}
-	return doGuardAndPay(rss, nil, fileSize, offlineSigning)
+	return doGuardAndPay(rss, nil, fileSize, offlineSigning, confirm)
 }
 
-func prepareAmount(rss *sessions.RenterSession, shardHashes []string) (int64, error) {
-	var totalPrice int64
+// prepareAmount sums the contract amounts of the given shards per payment token.
+func prepareAmount(rss *sessions.RenterSession, shardHashes []string) (map[common.Address]int64, error) {
+	totalPrice := make(map[common.Address]int64)
 	for i, hash := range shardHashes {
 		shard, err := sessions.GetRenterShard(rss.CtxParams, rss.SsId, hash, i)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
 		c, err := shard.Contracts()
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
-		totalPrice += c.SignedGuardContract.Amount
+		totalPrice[contractToken(rss, c.SignedGuardContract)] += c.SignedGuardContract.Amount
 	}
 	return totalPrice, nil
 }
 
 func doSubmit(rss *sessions.RenterSession) error {
-	amount, err := prepareAmount(rss, rss.ShardHashes)
+	amounts, err := prepareAmount(rss, rss.ShardHashes)
 	if err != nil {
 		return err
 	}
 
-	err = checkAvailableBalance(rss.Ctx, amount, rss.Token)
-	if err != nil {
-		return err
+	for token, amount := range amounts {
+		err = checkAvailableBalance(rss.Ctx, amount, token)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// checkAvailableBalance checks that the vault can cover amount in token. The
+// amount is converted with the oracle rate of the token, the same way the
+// cheques are settled in payInCheque and checked by the hosts, so it already is
+// in the smallest unit of the token whatever its decimals.
 func checkAvailableBalance(ctx context.Context, amount int64, token common.Address) error {
 	realAmount, err := getRealAmount(amount, token)
 	if err != nil {
@@ -0,0 +1,78 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/bittorrent/go-btfs/chain"
+	"github.com/bittorrent/go-btfs/settlement/swap/priceoracle"
+	"github.com/bittorrent/go-btfs/settlement/swap/vault"
+	vaultmock "github.com/bittorrent/go-btfs/settlement/swap/vault/mock"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// fixedRateOracle is a price oracle with a fixed rate for every token.
+type fixedRateOracle struct {
+	priceoracle.Service
+	rate *big.Int
+}
+
+func (o fixedRateOracle) CurrentRate(token common.Address) (*big.Int, error) {
+	return o.rate, nil
+}
+
+func TestCheckAvailableBalanceSixDecimals(t *testing.T) {
+	saved := chain.SettleObject
+	defer func() { chain.SettleObject = saved }()
+
+	// a 6 decimals token: the rate converts amounts to its smallest unit
+	token := common.HexToAddress("0x06")
+	const amount = 3
+	rate := big.NewInt(1000000)
+	cheque := new(big.Int).Mul(big.NewInt(amount), rate)
+
+	cases := []struct {
+		name    string
+		balance *big.Int
+		err     error
+	}{
+		{"covers the cheque", cheque, nil},
+		{"short of the cheque", new(big.Int).Sub(cheque, big.NewInt(1)), vault.ErrInsufficientFunds},
+	}
+	for _, c := range cases {
+		balance := c.balance
+		chain.SettleObject.OracleService = fixedRateOracle{rate: rate}
+		chain.SettleObject.VaultService = vaultmock.NewVault(
+			vaultmock.WithTokenDecimalsFunc(func(ctx context.Context, token common.Address) (uint8, error) {
+				return 6, nil
+			}),
+			vaultmock.WithVaultAvailableBalanceFunc(func(ctx context.Context, token common.Address) (*big.Int, error) {
+				return balance, nil
+			}),
+		)
+		if err := checkAvailableBalance(context.Background(), amount, token); !errors.Is(err, c.err) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.err, err)
+		}
+	}
+}
+
+func TestConfirmThreshold(t *testing.T) {
+	cases := []struct {
+		shards   int
+		confirm  ConfirmLevel
+		expected int
+	}{
+		{10, ConfirmAck, 10},
+		{30, ConfirmAck, thresholdContractsNums},
+		{10, ConfirmSettled, 10},
+		{30, ConfirmSettled, 30},
+	}
+	for _, c := range cases {
+		if got := confirmThreshold(c.shards, c.confirm); got != c.expected {
+			t.Errorf("%d shards at %s: expected %d, got %d", c.shards, c.confirm, c.expected, got)
+		}
+	}
+}
@@ -29,17 +29,27 @@ func getSuccessThreshold(totalShards int) int {
 	return int(math.Min(float64(totalShards), thresholdContractsNums))
 }
 
+// confirmThreshold is how many shards the guard must confirm before the
+// hosts are paid.
+func confirmThreshold(totalShards int, confirm ConfirmLevel) int {
+	if confirm == ConfirmSettled {
+		return totalShards
+	}
+	return getSuccessThreshold(totalShards)
+}
+
 func ResumeWaitUploadOnSigning(rss *sessions.RenterSession) error {
 	return waitUpload(rss, false, &guardpb.FileStoreStatus{
 		FileStoreMeta: guardpb.FileStoreMeta{
 			RenterPid: rss.CtxParams.N.Identity.String(),
 			FileSize:  math.MaxInt64,
 		},
-	}, true)
+	}, true, ConfirmAck)
 }
 
-func waitUpload(rss *sessions.RenterSession, offlineSigning bool, fsStatus *guardpb.FileStoreStatus, resume bool) error {
-	threshold := getSuccessThreshold(len(rss.ShardHashes))
+func waitUpload(rss *sessions.RenterSession, offlineSigning bool, fsStatus *guardpb.FileStoreStatus, resume bool,
+	confirm ConfirmLevel) error {
+	threshold := confirmThreshold(len(rss.ShardHashes), confirm)
 	if !resume {
 		if err := rss.To(sessions.RssToWaitUploadEvent); err != nil {
 			return err
@@ -94,6 +104,8 @@ func waitUpload(rss *sessions.RenterSession, offlineSigning bool, fsStatus *guar
 	} else if scaledRetry > highRetry {
 		scaledRetry = highRetry
 	}
+	bo := helper.WaitUploadBo(highRetry)
+	confirmed := 0
 	err = backoff.Retry(func() error {
 		err = grpc.GuardClient(rss.CtxParams.Cfg.Services.GuardDomain).WithContext(rss.Ctx,
 			func(ctx context.Context, client guardpb.GuardServiceClient) error {
@@ -126,10 +138,16 @@ func waitUpload(rss *sessions.RenterSession, offlineSigning bool, fsStatus *guar
 				if num >= threshold {
 					return nil
 				}
+				// waiting for every shard takes as long as the slowest
+				// host, only give up on hosts which stopped progressing
+				if confirm == ConfirmSettled && num > confirmed {
+					bo.Reset()
+				}
+				confirmed = num
 				return errors.New("uploading")
 			})
 		return err
-	}, helper.WaitUploadBo(highRetry))
+	}, bo)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,14 @@
+package upload
+
+import "github.com/libp2p/go-libp2p/core/peer"
+
+type ShardHost = shardHost
+
+var (
+	ProveShard  = proveShard
+	CheckShards = checkShards
+)
+
+func NewShardHost(index int, hash string, host peer.ID) ShardHost {
+	return shardHost{index: index, hash: hash, host: host}
+}
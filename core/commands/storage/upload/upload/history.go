@@ -0,0 +1,124 @@
+package upload
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+	"github.com/bittorrent/go-btfs/utils"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+)
+
+const (
+	historyStatusOptionName   = "status"
+	historyFileHashOptionName = "file-hash"
+)
+
+type historyListRet struct {
+	Total   int
+	Records []*sessions.SessionRecord
+}
+
+var StorageUploadHistoryCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the upload sessions of this node.",
+		ShortDescription: `
+This command lists a summary of the upload sessions run by this node, the most
+recently started first: the file, its shard count and hosts, the total pay of
+the shard contracts, and the state of the session. Sessions are kept after they
+complete or fail.
+
+Use --status and --file-hash to list only some of the sessions. "from" and
+"limit" page through the sessions which match, Total counts all of them.`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("from", true, false, "page offset"),
+		cmds.StringArg("limit", true, false, "page limit."),
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(historyStatusOptionName, "List only the sessions in this state, e.g. complete or error."),
+		cmds.StringOption(historyFileHashOptionName, "List only the sessions uploading this file."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		err := utils.CheckSimpleMode(env)
+		if err != nil {
+			return err
+		}
+		from, err := strconv.Atoi(req.Arguments[0])
+		if err != nil {
+			return fmt.Errorf("parse from:%v failed", req.Arguments[0])
+		}
+		limit, err := strconv.Atoi(req.Arguments[1])
+		if err != nil {
+			return fmt.Errorf("parse limit:%v failed", req.Arguments[1])
+		}
+		if from < 0 {
+			return fmt.Errorf("invalid from: %d", from)
+		}
+		if limit < 0 {
+			return fmt.Errorf("invalid limit: %d", limit)
+		}
+		ctxParams, err := helper.ExtractContextParams(req, env)
+		if err != nil {
+			return err
+		}
+		if !ctxParams.Cfg.Experimental.StorageClientEnabled {
+			return fmt.Errorf("storage client api not enabled")
+		}
+
+		records, err := sessions.ListSessionHistory(ctxParams.N.Repo.Datastore(), ctxParams.N.Identity.String())
+		if err != nil {
+			return err
+		}
+		status, _ := req.Options[historyStatusOptionName].(string)
+		fileHash, _ := req.Options[historyFileHashOptionName].(string)
+		records = filterHistory(records, status, fileHash)
+
+		ret := &historyListRet{Total: len(records), Records: []*sessions.SessionRecord{}}
+		if from < len(records) {
+			records = records[from:]
+			if limit < len(records) {
+				records = records[:limit]
+			}
+			ret.Records = records
+		}
+		return cmds.EmitOnce(res, ret)
+	},
+	Type: historyListRet{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *historyListRet) error {
+			tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "SESSION\tFILE\tSHARDS\tHOSTS\tTOTAL PAY\tSTATUS\tSTARTED")
+			for _, r := range out.Records {
+				fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%s\t%s\n", r.SessionId, r.FileHash, r.ShardCount,
+					len(r.Hosts), r.TotalPay, r.Status, r.Started.Format(time.RFC3339))
+			}
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%d of %d sessions\n", len(out.Records), out.Total)
+			return nil
+		}),
+	},
+}
+
+// filterHistory keeps the records in status and uploading fileHash. Empty
+// filters match all the records.
+func filterHistory(records []*sessions.SessionRecord, status string, fileHash string) []*sessions.SessionRecord {
+	filtered := make([]*sessions.SessionRecord, 0, len(records))
+	for _, r := range records {
+		if status != "" && r.Status != status {
+			continue
+		}
+		if fileHash != "" && r.FileHash != fileHash {
+			continue
+		}
+		filtered = append(filtered, r)
+	}
+	return filtered
+}
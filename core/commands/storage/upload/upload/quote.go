@@ -0,0 +1,79 @@
+package upload
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bittorrent/go-btfs/chain"
+	storagehelper "github.com/bittorrent/go-btfs/core/commands/storage/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/settlement/swap/vault"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// UploadQuote is what an upload would cost, as computed by a dry run.
+type UploadQuote struct {
+	Token         string
+	Price         int64
+	StorageLength int
+	ShardCount    int
+	// ShardPay and TotalPay are in the smallest unit of the token, after
+	// oracle rate conversion.
+	ShardPay          int64
+	TotalPay          int64
+	SufficientBalance bool
+	HostsAvailable    int
+}
+
+// shardPay is what a host is paid in token for storing a shard of shardSize
+// bytes for storageLength days at price.
+func shardPay(token common.Address, price int64, shardSize int64, storageLength int) (int64, error) {
+	rate, err := chain.SettleObject.OracleService.CurrentRate(token)
+	if err != nil {
+		return 0, err
+	}
+	return helper.TotalPay(shardSize, price, storageLength, rate)
+}
+
+// quoteUpload prices an upload of shardCount shards the way UploadShard
+// does, and checks the balance, without contracting with any host.
+func quoteUpload(ctx context.Context, token common.Address, tokenName string, price int64, shardSize int64,
+	storageLength int, shardCount int) (*UploadQuote, error) {
+	onePay, err := shardPay(token, price, shardSize, storageLength)
+	if err != nil {
+		return nil, err
+	}
+	q := &UploadQuote{
+		Token:         tokenName,
+		Price:         price,
+		StorageLength: storageLength,
+		ShardCount:    shardCount,
+		ShardPay:      onePay,
+		TotalPay:      onePay * int64(shardCount),
+	}
+	switch err := checkAvailableBalance(ctx, q.TotalPay, token); {
+	case err == nil:
+		q.SufficientBalance = true
+	case errors.Is(err, vault.ErrInsufficientFunds):
+	default:
+		return nil, err
+	}
+	return q, nil
+}
+
+// countAvailableHosts returns the number of locally known hosts an upload
+// would pick from, the excluded ones left out.
+func countAvailableHosts(ctxParams *helper.ContextParams, blacklist helper.HostBlacklist) (int, error) {
+	hosts, err := storagehelper.GetHostsFromDatastore(ctxParams.Ctx, ctxParams.N, ctxParams.Cfg.Experimental.HostsSyncMode, 0)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, h := range hosts {
+		if !blacklist.Contains(h.NodeId) {
+			n++
+		}
+	}
+	return n, nil
+}
@@ -129,182 +129,226 @@ the shard and replies back to client for the next challenge step.`,
 			shardSize, requestPid, shardIndex)
 
 		//halfSignedEscrowContString := req.Arguments[4]
-		halfSignedGuardContString := req.Arguments[5]
-
-		var halfSignedGuardContBytes []byte
-		halfSignedGuardContBytes = []byte(halfSignedGuardContString)
-		halfSignedGuardContract := &guardpb.Contract{}
-		err = proto.Unmarshal(halfSignedGuardContBytes, halfSignedGuardContract)
+		var renterPid string
+		if len(req.Arguments) >= 10 {
+			renterPid = req.Arguments[9]
+		}
+		accepted, err := acceptGuardContract(req, ctxParams, req.Arguments[5], renterPid, storeLen)
 		if err != nil {
 			return err
 		}
-		guardContractMeta := halfSignedGuardContract.ContractMeta
-		// get renter's public key
-		pid, ok := remote.GetStreamRequestRemotePeerID(req, ctxParams.N)
-		if !ok {
-			return fmt.Errorf("fail to get peer ID from request")
-		}
-		var peerId string
-		if peerId = pid.String(); len(req.Arguments) >= 10 {
-			peerId = req.Arguments[9]
+
+		go func() {
+			err := storeShard(ctxParams, req, env, requestPid, ssId, req.Arguments[1], shardHash, shardIndex, accepted, false)
+			if err != nil {
+				log.Debug(err)
+			}
+		}()
+		return nil
+	},
+}
+
+// acceptedGuardContract is a guard contract of a renter the host signed,
+// with the terms it was checked against.
+type acceptedGuardContract struct {
+	halfSigned  *guardpb.Contract
+	signed      *guardpb.Contract
+	signedBytes []byte
+	price       int64
+	amount      int64
+	rate        *big.Int
+}
+
+// acceptGuardContract verifies the renter signature of the half signed guard
+// contract, checks that its token is supported and that its price and amount
+// are enough for storeLen days, and signs it. The contract is signed by
+// renterPid, or by the requesting peer if it's empty.
+func acceptGuardContract(req *cmds.Request, ctxParams *uh.ContextParams, halfSignedGuardContString string,
+	renterPid string, storeLen int) (*acceptedGuardContract, error) {
+	var halfSignedGuardContBytes []byte
+	halfSignedGuardContBytes = []byte(halfSignedGuardContString)
+	halfSignedGuardContract := &guardpb.Contract{}
+	err := proto.Unmarshal(halfSignedGuardContBytes, halfSignedGuardContract)
+	if err != nil {
+		return nil, err
+	}
+	guardContractMeta := halfSignedGuardContract.ContractMeta
+	// get renter's public key
+	pid, ok := remote.GetStreamRequestRemotePeerID(req, ctxParams.N)
+	if !ok {
+		return nil, fmt.Errorf("fail to get peer ID from request")
+	}
+	peerId := pid.String()
+	if renterPid != "" {
+		peerId = renterPid
+	}
+	payerPubKey, err := crypto.GetPubKeyFromPeerId(peerId)
+	if err != nil {
+		return nil, err
+	}
+	s := halfSignedGuardContract.GetRenterSignature()
+	if s == nil {
+		s = halfSignedGuardContract.GetPreparerSignature()
+	}
+	ok, err = crypto.Verify(payerPubKey, &guardContractMeta, s)
+	if !ok || err != nil {
+		return nil, fmt.Errorf("can't verify guard contract: %v", err)
+	}
+
+	signedGuardContract, err := signGuardContract(&guardContractMeta, halfSignedGuardContract, ctxParams.N.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	signedGuardContractBytes, err := proto.Marshal(signedGuardContract)
+	if err != nil {
+		return nil, err
+	}
+
+	var price int64
+	var amount int64
+	var rate *big.Int
+	{
+		// check renter-token
+		token := common.HexToAddress(halfSignedGuardContract.Token)
+		_, bl := tokencfg.MpTokenStr[token]
+		if !bl {
+			err = errors.New("receive upload init, your input token is not supported. " + token.String())
+			return nil, err
 		}
-		payerPubKey, err := crypto.GetPubKeyFromPeerId(peerId)
+
+		// check renter-price, against the latest price of the oracle
+		price = guardContractMeta.Price
+		chain.SettleObject.OracleService.Refresh(token)
+		priceOnline, err := chain.SettleObject.OracleService.CurrentPrice(token)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		s := halfSignedGuardContract.GetRenterSignature()
-		if s == nil {
-			s = halfSignedGuardContract.GetPreparerSignature()
-		}
-		ok, err = crypto.Verify(payerPubKey, &guardContractMeta, s)
-		if !ok || err != nil {
-			return fmt.Errorf("can't verify guard contract: %v", err)
+		fmt.Printf("receive init, token[%s] renter-price[%v], online-price[%v],  \n", token.String(), price, priceOnline)
+
+		if price < priceOnline.Int64() {
+			return nil, errors.New(
+				fmt.Sprintf("receive init, your renter-price[%v] is less than online-price[%v]. ",
+					price, priceOnline),
+			)
 		}
 
-		signedGuardContract, err := signGuardContract(&guardContractMeta, halfSignedGuardContract, ctxParams.N.PrivateKey)
+		// check renter-amount
+		rate, err = chain.SettleObject.OracleService.CurrentRate(token)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		signedGuardContractBytes, err := proto.Marshal(signedGuardContract)
+		amount = guardContractMeta.Amount
+		amountCal, err := uh.TotalPay(guardContractMeta.ShardFileSize, price, storeLen, rate)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		//fmt.Printf("receive init, renter-amount[%v], cal-amount[%v] \n", amount, amountCal)
+		if amount < amountCal {
+			return nil, errors.New(
+				fmt.Sprintf("receive init, your renter-amount[%v] is less than cal-amount[%v]. ",
+					amount, amountCal),
+			)
+		}
+	}
 
-		var price int64
-		var amount int64
-		var rate *big.Int
-		{
-			// check renter-token
-			token := common.HexToAddress(halfSignedGuardContract.Token)
-			_, bl := tokencfg.MpTokenStr[token]
-			if !bl {
-				err = errors.New("receive upload init, your input token is not supported. " + token.String())
-				return err
-			}
-
-			// check renter-price
-			price = guardContractMeta.Price
-			priceOnline, err := chain.SettleObject.OracleService.CurrentPrice(token)
-			if err != nil {
-				return err
-			}
-			fmt.Printf("receive init, token[%s] renter-price[%v], online-price[%v],  \n", token.String(), price, priceOnline)
-
-			if price < priceOnline.Int64() {
-				return errors.New(
-					fmt.Sprintf("receive init, your renter-price[%v] is less than online-price[%v]. ",
-						price, priceOnline),
-				)
-			}
+	return &acceptedGuardContract{
+		halfSigned:  halfSignedGuardContract,
+		signed:      signedGuardContract,
+		signedBytes: signedGuardContractBytes,
+		price:       price,
+		amount:      amount,
+		rate:        rate,
+	}, nil
+}
 
-			// check renter-amount
-			rate, err = chain.SettleObject.OracleService.CurrentRate(token)
-			if err != nil {
-				return err
-			}
-			amount = guardContractMeta.Amount
-			amountCal, err := uh.TotalPay(guardContractMeta.ShardFileSize, price, storeLen, rate)
-			if err != nil {
-				return err
-			}
-			//fmt.Printf("receive init, renter-amount[%v], cal-amount[%v] \n", amount, amountCal)
-			if amount < amountCal {
-				return errors.New(
-					fmt.Sprintf("receive init, your renter-amount[%v] is less than cal-amount[%v]. ",
-						amount, amountCal),
-				)
-			}
-		}
+// storeShard sends the signed contract back to the renter, gets the shard,
+// answers the challenge of the guard for it and pins it once the renter paid,
+// or removes it if the renter didn't pay in time. The shard of a renewal is
+// kept when unpaid, it is still pinned for its previous contract.
+func storeShard(ctxParams *uh.ContextParams, req *cmds.Request, env cmds.Environment, requestPid peer.ID, ssId string,
+	fileHash string, shardHash string, shardIndex int, accepted *acceptedGuardContract, renewal bool) error {
+	halfSignedGuardContract := accepted.halfSigned
+	signedGuardContract := accepted.signed
+	guardContractMeta := halfSignedGuardContract.ContractMeta
+	shard, err := sessions.GetHostShard(ctxParams, signedGuardContract.ContractId, accepted.price, accepted.amount, accepted.rate)
+	if err != nil {
+		return err
+	}
 
-		go func() {
-			tmp := func() error {
-				shard, err := sessions.GetHostShard(ctxParams, signedGuardContract.ContractId, price, amount, rate)
-				if err != nil {
-					return err
-				}
+	_, err = remote.P2PCall(ctxParams.Ctx, ctxParams.N, ctxParams.Api, requestPid, "/storage/upload/recvcontract",
+		ssId,
+		shardHash,
+		shardIndex,
+		nil,
+		accepted.signedBytes,
+	)
+	if err != nil {
+		return err
+	}
 
-				_, err = remote.P2PCall(ctxParams.Ctx, ctxParams.N, ctxParams.Api, requestPid, "/storage/upload/recvcontract",
-					ssId,
-					shardHash,
-					shardIndex,
-					nil,
-					signedGuardContractBytes,
-				)
-				if err != nil {
-					return err
-				}
+	if err := shard.Contract(nil, signedGuardContract); err != nil {
+		return err
+	}
 
-				if err := shard.Contract(nil, signedGuardContract); err != nil {
-					return err
-				}
+	err = downloadShardFromClient(ctxParams, halfSignedGuardContract, fileHash, shardHash, false)
+	if err != nil {
+		return err
+	}
 
-				fileHash := req.Arguments[1]
-				err = downloadShardFromClient(ctxParams, halfSignedGuardContract, fileHash, shardHash, false)
-				if err != nil {
-					return err
-				}
+	err = challengeShard(ctxParams, fileHash, false, &guardContractMeta)
+	if err != nil {
+		return err
+	}
 
-				err = challengeShard(ctxParams, fileHash, false, &guardContractMeta)
-				if err != nil {
-					return err
+	fmt.Printf("upload init: send /storage/upload/recvcontract ok, wait for pay status, requestPid:%v, shardIndex:%v. \n",
+		requestPid, shardIndex)
+
+	blPay := false
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		// every 30s check pay status
+		tick := time.Tick(30 * time.Second)
+
+		// total timeout for checking pay status
+		timeoutPay := time.NewTimer(10 * time.Minute)
+		for true {
+			select {
+			case <-tick:
+				if bl := shard.IsPayStatus(); bl {
+					blPay = true
+					wg.Done()
+					return
 				}
+			case <-timeoutPay.C:
+				return
+			}
+		}
+	}()
+	wg.Wait()
 
-				fmt.Printf("upload init: send /storage/upload/recvcontract ok, wait for pay status, requestPid:%v, shardIndex:%v. \n",
-					requestPid, shardIndex)
-
-				blPay := false
-				var wg sync.WaitGroup
-				wg.Add(1)
-				go func() {
-					// every 30s check pay status
-					tick := time.Tick(30 * time.Second)
-
-					// total timeout for checking pay status
-					timeoutPay := time.NewTimer(10 * time.Minute)
-					for true {
-						select {
-						case <-tick:
-							if bl := shard.IsPayStatus(); bl {
-								blPay = true
-								wg.Done()
-								return
-							}
-						case <-timeoutPay.C:
-							return
-						}
-					}
-				}()
-				wg.Wait()
-
-				if blPay == true {
-					// pin shardHash
-					err = pinShard(ctxParams, halfSignedGuardContract, fileHash, shardHash)
-					if err != nil {
-						return err
-					}
-					fmt.Printf("upload init: pin shard ok, requestPid:%v, shardIndex:%v. \n", requestPid, shardIndex)
-				} else {
-					// rm shardHash
-					err = rmShard(ctxParams, req, env, shardHash)
-					if err != nil {
-						return err
-					}
-					fmt.Printf("upload init: timeout, remove Shard, requestPid:%v, shardIndex:%v. \n", requestPid, shardIndex)
-				}
+	if blPay == true {
+		// pin shardHash
+		err = pinShard(ctxParams, halfSignedGuardContract, fileHash, shardHash)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("upload init: pin shard ok, requestPid:%v, shardIndex:%v. \n", requestPid, shardIndex)
+	} else if !renewal {
+		// rm shardHash
+		err = rmShard(ctxParams, req, env, shardHash)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("upload init: timeout, remove Shard, requestPid:%v, shardIndex:%v. \n", requestPid, shardIndex)
+	}
 
-				fmt.Printf("upload init: Complete! requestPid:%v, shardIndex:%v. \n", requestPid, shardIndex)
-				if err := shard.Complete(); err != nil {
-					return err
-				}
+	fmt.Printf("upload init: Complete! requestPid:%v, shardIndex:%v. \n", requestPid, shardIndex)
+	if err := shard.Complete(); err != nil {
+		return err
+	}
 
-				return nil
-			}()
-			if tmp != nil {
-				log.Debug(tmp)
-			}
-		}()
-		return nil
-	},
+	return nil
 }
 
 func challengeShard(ctxParams *uh.ContextParams, fileHash string, isRepair bool, guardContractMeta *guardpb.ContractMeta) error {
@@ -0,0 +1,169 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bittorrent/go-btfs/chain"
+	"github.com/bittorrent/go-btfs/core/commands/storage/helper"
+	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/corehttp/remote"
+	"github.com/bittorrent/go-btfs/utils"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cidlib "github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	ipld "github.com/ipfs/go-ipld-format"
+	mdag "github.com/ipfs/go-merkledag"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// errRenewNoShard is returned to renters renewing the contract of a shard the
+// host no longer holds, they have to upload it again.
+var errRenewNoShard = errors.New("host no longer has the shard")
+
+var StorageUploadRenewShardCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Renew the storage contract of a shard the host still holds.",
+		ShortDescription: `
+Storage host opens this endpoint to accept new contracts for shards it already
+stores, so that renters can extend their storage without sending the shards
+again. The host signs the contract if it still has the shard and replies back
+to the client as it does for a new upload, then pins the shard until the end
+of the new contract once it is paid.`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("session-id", true, false, "ID for the renewal session."),
+		cmds.StringArg("file-hash", true, false, "Root file the shard belongs to."),
+		cmds.StringArg("shard-hash", true, false, "Shard the contract is renewed for."),
+		cmds.StringArg("price", true, false, "Per GiB per day in µBTT (=0.000001BTT) for storing this shard offered by client."),
+		cmds.StringArg("guard-contract-meta", true, false, "Client's new guard contract meta."),
+		cmds.StringArg("storage-length", true, false, "Store file for certain length in days."),
+		cmds.StringArg("shard-size", true, false, "Size of the shard in bytes."),
+		cmds.StringArg("shard-index", true, false, "Index of shard within the encoding scheme."),
+		cmds.StringArg("upload-peer-id", false, false, "Peer id when upload sign is used."),
+	},
+	RunTimeout: 5 * time.Minute,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		err := utils.CheckSimpleMode(env)
+		if err != nil {
+			return err
+		}
+
+		ctxParams, err := uh.ExtractContextParams(req, env)
+		if err != nil {
+			return err
+		}
+		if !ctxParams.Cfg.Experimental.StorageHostEnabled {
+			return fmt.Errorf("storage host api not enabled")
+		}
+		requestPid, ok := remote.GetStreamRequestRemotePeerID(req, ctxParams.N)
+		if !ok {
+			return fmt.Errorf("fail to get peer ID from request")
+		}
+
+		// if my vault is not compatible with the peer's one, reject renewing
+		isVaultCompatible, err := chain.SettleObject.Factory.IsVaultCompatibleBetween(ctxParams.Ctx, ctxParams.N.Identity, requestPid)
+		if err != nil {
+			return err
+		}
+		if !isVaultCompatible {
+			return fmt.Errorf("vault factory not compatible, please upgrade your node if possible")
+		}
+
+		ssId := req.Arguments[0]
+		fileHash := req.Arguments[1]
+		shardHash := req.Arguments[2]
+		if _, err := strconv.ParseInt(req.Arguments[3], 10, 64); err != nil {
+			return err
+		}
+		storeLen, err := strconv.Atoi(req.Arguments[5])
+		if err != nil {
+			return err
+		}
+		shardSize, err := strconv.ParseInt(req.Arguments[6], 10, 64)
+		if err != nil {
+			return err
+		}
+		shardIndex, err := strconv.Atoi(req.Arguments[7])
+		if err != nil {
+			return err
+		}
+		settings, err := helper.GetHostStorageConfig(ctxParams.Ctx, ctxParams.N)
+		if err != nil {
+			return err
+		}
+		if uint64(storeLen) < settings.StorageTimeMin {
+			return fmt.Errorf("storage length invalid: want: >=%d, got: %d", settings.StorageTimeMin, storeLen)
+		}
+
+		// the shard is renewed as stored, nothing is downloaded again
+		shardCid, err := cidlib.Parse(shardHash)
+		if err != nil {
+			return err
+		}
+		has, err := shardStored(req.Context, ctxParams.N.Blockstore, shardCid)
+		if err != nil {
+			return err
+		}
+		if !has {
+			return fmt.Errorf("%w: %s", errRenewNoShard, shardHash)
+		}
+
+		var renterPid string
+		if len(req.Arguments) >= 9 {
+			renterPid = req.Arguments[8]
+		}
+		accepted, err := acceptGuardContract(req, ctxParams, req.Arguments[4], renterPid, storeLen)
+		if err != nil {
+			return err
+		}
+		if err := checkRenewedContract(accepted, ctxParams.N.Identity, fileHash, shardHash, shardSize, shardIndex); err != nil {
+			return err
+		}
+
+		fmt.Printf("--- upload renew: start, shardSize:%v, requestPid:%v, shardIndex:%v . \n",
+			shardSize, requestPid, shardIndex)
+
+		go func() {
+			err := storeShard(ctxParams, req, env, requestPid, ssId, fileHash, shardHash, shardIndex, accepted, true)
+			if err != nil {
+				log.Debug(err)
+			}
+		}()
+		return nil
+	},
+}
+
+// shardStored tells whether every block of the shard DAG is still in bs, as
+// the leaves may have been garbage collected while the root is left. Nothing
+// is fetched from the network.
+func shardStored(ctx context.Context, bs blockstore.Blockstore, shard cidlib.Cid) (bool, error) {
+	dserv := mdag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	err := mdag.Walk(ctx, mdag.GetLinksDirect(dserv), shard, cidlib.NewSet().Visit)
+	if ipld.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// checkRenewedContract checks that the accepted contract is for the shard
+// being renewed, with this host.
+func checkRenewedContract(accepted *acceptedGuardContract, host peer.ID, fileHash string, shardHash string,
+	shardSize int64, shardIndex int) error {
+	meta := accepted.halfSigned.ContractMeta
+	if meta.HostPid != host.String() {
+		return fmt.Errorf("contract %s is for host %s", meta.ContractId, meta.HostPid)
+	}
+	if meta.FileHash != fileHash || meta.ShardHash != shardHash || int(meta.ShardIndex) != shardIndex ||
+		meta.ShardFileSize != shardSize {
+		return fmt.Errorf("contract %s is not for shard %d (%s)", meta.ContractId, shardIndex, shardHash)
+	}
+	return nil
+}
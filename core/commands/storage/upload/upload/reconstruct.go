@@ -0,0 +1,323 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	storagehelper "github.com/bittorrent/go-btfs/core/commands/storage/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+	uio "github.com/bittorrent/go-unixfs/io"
+	"github.com/bittorrent/interface-go-btfs-core/path"
+
+	cidlib "github.com/ipfs/go-cid"
+	"github.com/klauspost/reedsolomon"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	reconstructSessionOptionName      = "session-id"
+	reconstructShardTimeoutOptionName = "shard-timeout"
+)
+
+// errTooManyShardsMissing is returned when fewer shards than the data shards
+// of a file are available, it can't be rebuilt.
+var errTooManyShardsMissing = errors.New("too many shards are missing to reconstruct the file")
+
+var StorageReconstructCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Rebuild a reed-solomon encoded file from its surviving shards.",
+		ShortDescription: `
+This command fetches the shards of a reed-solomon encoded file, from the local
+blockstore or from the hosts storing them, rebuilds the file from the ones
+available and writes it to stdout. The hosts are taken from the upload session
+given with --session-id, or else from the last upload session of the file.
+Shards without a known host are looked up on the network. The shards of an
+upload made with --encrypt-shards are fetched from its hosts and decrypted
+with the key of this node, give the session with --session-id to rebuild the
+file from its original hash.
+
+The file can be rebuilt as long as no more shards are missing than it has
+parity shards. Otherwise the command fails with the shards it couldn't get,
+and why.
+
+    $ btfs storage reconstruct <file-hash> > <file>`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("file-hash", true, false, "Hash of the reed-solomon encoded file to rebuild."),
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(reconstructSessionOptionName, "Upload session to take the hosts of the shards from. Default: the last upload session of the file."),
+		cmds.StringOption(reconstructShardTimeoutOptionName, "How long to wait for each shard, e.g. '1m'.").WithDefault("1m"),
+		cmds.IntOption(shardParallelismOptionName, "Max number of shards being fetched at the same time.").WithDefault(DefaultShardParallelism),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		ctxParams, err := helper.ExtractContextParams(req, env)
+		if err != nil {
+			return err
+		}
+		fileHash, err := cidlib.Parse(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		timeout, err := time.ParseDuration(req.Options[reconstructShardTimeoutOptionName].(string))
+		if err != nil {
+			return fmt.Errorf("invalid --%s: %w", reconstructShardTimeoutOptionName, err)
+		}
+		parallelism := req.Options[shardParallelismOptionName].(int)
+		if parallelism <= 0 {
+			return fmt.Errorf("--%s must be greater than zero, got %d", shardParallelismOptionName, parallelism)
+		}
+
+		ssId, _ := req.Options[reconstructSessionOptionName].(string)
+		rss, err := fileSession(ctxParams, fileHash.String(), ssId)
+		if err != nil {
+			return err
+		}
+		hosts := make(map[int]string)
+		var encryption *sessions.ShardEncryption
+		if rss != nil {
+			if hosts, err = sessionShardHosts(ctxParams, rss); err != nil {
+				return err
+			}
+			if encryption, err = rss.ShardEncryption(); err != nil {
+				return err
+			}
+		}
+		layout, shardHashes, err := reconstructLayout(req.Context, ctxParams, fileHash, rss, encryption)
+		if err != nil {
+			return err
+		}
+
+		shards := make([][]byte, len(shardHashes))
+		failures := make(map[int]error)
+		var mu sync.Mutex
+		g := new(errgroup.Group)
+		g.SetLimit(parallelism)
+		for i, h := range shardHashes {
+			i, h := i, h
+			g.Go(func() error {
+				b, err := fetchShard(req.Context, ctxParams, h, hosts[i], timeout)
+				if err == nil && encryption != nil {
+					b, err = openShard(ctxParams.N.PrivateKey, encryption, i, b)
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					failures[i] = err
+				} else {
+					shards[i] = b
+				}
+				return nil
+			})
+		}
+		_ = g.Wait()
+		if err := req.Context.Err(); err != nil {
+			return err
+		}
+		for i, err := range failures {
+			log.Infof("shard %d of %s is missing: %s", i, fileHash, err)
+		}
+
+		enc, err := reconstructShards(shards, layout.numData, layout.numParity)
+		if err != nil {
+			return fmt.Errorf("%w, missing shards: %s", err, describeMissingShards(failures))
+		}
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(enc.Join(pw, shards, int(layout.fileSize)))
+		}()
+		return res.Emit(pr)
+	},
+}
+
+// reconstructEncoding is how a file is split in shards.
+type reconstructEncoding struct {
+	numData   int
+	numParity int
+	fileSize  int64
+}
+
+// reconstructLayout returns the encoding of fileHash and the shards to
+// fetch. The shards of an upload encrypted as encryption are the ones of the
+// session rss, they are decrypted once fetched.
+func reconstructLayout(ctx context.Context, ctxParams *helper.ContextParams, fileHash cidlib.Cid,
+	rss *sessions.RenterSession, encryption *sessions.ShardEncryption) (*reconstructEncoding, []cidlib.Cid, error) {
+	if encryption != nil {
+		if len(rss.ShardHashes) != encryption.NumData+encryption.NumParity {
+			return nil, nil, fmt.Errorf("session %s has %d shards, %s is encoded in %d", rss.SsId,
+				len(rss.ShardHashes), encryption.FileHash, encryption.NumData+encryption.NumParity)
+		}
+		shardHashes := make([]cidlib.Cid, len(rss.ShardHashes))
+		for i, h := range rss.ShardHashes {
+			c, err := cidlib.Parse(h)
+			if err != nil {
+				return nil, nil, err
+			}
+			shardHashes[i] = c
+		}
+		return &reconstructEncoding{
+			numData:   encryption.NumData,
+			numParity: encryption.NumParity,
+			fileSize:  encryption.FileSize,
+		}, shardHashes, nil
+	}
+	meta, shardHashes, err := storagehelper.GetReedSolomonShards(ctx, ctxParams.Api, fileHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &reconstructEncoding{
+		numData:   int(meta.NumData),
+		numParity: int(meta.NumParity),
+		fileSize:  int64(meta.FileSize),
+	}, shardHashes, nil
+}
+
+// fileSession returns the upload session ssId of fileHash, or the last
+// upload session of the file if ssId is empty. It is nil when no session of
+// the file is known.
+func fileSession(ctxParams *helper.ContextParams, fileHash string, ssId string) (*sessions.RenterSession, error) {
+	if ssId == "" {
+		records, err := sessions.ListSessionHistory(ctxParams.N.Repo.Datastore(), ctxParams.N.Identity.String())
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			if r.FileHash == fileHash {
+				ssId = r.SessionId
+				break
+			}
+		}
+		if ssId == "" {
+			return nil, nil
+		}
+	}
+	rss, err := sessions.GetRenterSession(ctxParams, ssId, "", make([]string, 0))
+	if err != nil {
+		return nil, err
+	}
+	if rss.Hash == fileHash {
+		return rss, nil
+	}
+	// an encrypted upload is also an upload of the file it was encrypted from
+	encryption, err := rss.ShardEncryption()
+	if err != nil {
+		return nil, err
+	}
+	if encryption == nil || encryption.FileHash != fileHash {
+		return nil, fmt.Errorf("session %s is not an upload of %s", ssId, fileHash)
+	}
+	return rss, nil
+}
+
+// sessionShardHosts returns the host of each shard of rss which has one.
+func sessionShardHosts(ctxParams *helper.ContextParams, rss *sessions.RenterSession) (map[int]string, error) {
+	hosts, err := rss.ShardHosts()
+	if err != nil {
+		return nil, err
+	}
+	// sessions from before the hosts were recorded only have them in the
+	// contracts
+	for i, h := range rss.ShardHashes {
+		if _, ok := hosts[i]; ok {
+			continue
+		}
+		shard, err := sessions.GetRenterShard(ctxParams, rss.SsId, h, i)
+		if err != nil {
+			return nil, err
+		}
+		contracts, err := shard.Contracts()
+		if err != nil {
+			return nil, err
+		}
+		if contracts.SignedGuardContract != nil {
+			hosts[i] = contracts.SignedGuardContract.HostPid
+		}
+	}
+	return hosts, nil
+}
+
+// fetchShard reads the content of the shard h, connecting to host first
+// unless the shard is stored locally.
+func fetchShard(ctx context.Context, ctxParams *helper.ContextParams, h cidlib.Cid, host string,
+	timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	has, err := ctxParams.N.Blockstore.Has(ctx, h)
+	if err != nil {
+		return nil, err
+	}
+	if !has && host != "" {
+		pid, err := peer.Decode(host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host %s: %w", host, err)
+		}
+		if err := ctxParams.Api.Swarm().Connect(ctx, peer.AddrInfo{ID: pid}); err != nil {
+			return nil, fmt.Errorf("connect to host %s: %w", host, err)
+		}
+	}
+	return readShard(ctx, ctxParams, h)
+}
+
+// readShard reads the content of the shard h.
+func readShard(ctx context.Context, ctxParams *helper.ContextParams, h cidlib.Cid) ([]byte, error) {
+	nd, err := ctxParams.Api.ResolveNode(ctx, path.IpfsPath(h))
+	if err != nil {
+		return nil, err
+	}
+	r, err := uio.NewDagReader(ctx, nd, ctxParams.Api.Dag())
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// reconstructShards rebuilds the missing data shards of shards, nil when
+// missing, and returns the encoder to join them with.
+func reconstructShards(shards [][]byte, numData int, numParity int) (reedsolomon.Encoder, error) {
+	if len(shards) != numData+numParity {
+		return nil, fmt.Errorf("expected %d shards, got %d", numData+numParity, len(shards))
+	}
+	missing := 0
+	for _, s := range shards {
+		if s == nil {
+			missing++
+		}
+	}
+	if missing > numParity {
+		return nil, fmt.Errorf("%w: %d of the %d shards are available, %d are needed", errTooManyShardsMissing,
+			len(shards)-missing, len(shards), numData)
+	}
+	enc, err := reedsolomon.New(numData, numParity)
+	if err != nil {
+		return nil, err
+	}
+	if err := enc.ReconstructData(shards); err != nil {
+		return nil, err
+	}
+	return enc, nil
+}
+
+// describeMissingShards lists the missing shards by index, with the error
+// each was lost to.
+func describeMissingShards(failures map[int]error) string {
+	indexes := make([]int, 0, len(failures))
+	for i := range failures {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	parts := make([]string, len(indexes))
+	for j, i := range indexes {
+		parts[j] = fmt.Sprintf("%d (%s)", i, failures[i])
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,66 @@
+package upload
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+func TestReconstructShards(t *testing.T) {
+	const numData, numParity = 10, 20
+	data := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(data) // Rand.Read never returns an error
+	encode := func() [][]byte {
+		enc, err := reedsolomon.New(numData, numParity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards, err := enc.Split(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Encode(shards); err != nil {
+			t.Fatal(err)
+		}
+		return shards
+	}
+
+	// as many shards as there are parity ones can be lost, data ones included
+	shards := encode()
+	for i := 5; i < 5+numParity; i++ {
+		shards[i] = nil
+	}
+	enc, err := reconstructShards(shards, numData, numParity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := enc.Join(&out, shards, len(data)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatal("reconstructed file doesn't match the original")
+	}
+
+	shards = encode()
+	for i := 0; i <= numParity; i++ {
+		shards[i] = nil
+	}
+	if _, err := reconstructShards(shards, numData, numParity); !errors.Is(err, errTooManyShardsMissing) {
+		t.Fatalf("expected %v, got %v", errTooManyShardsMissing, err)
+	}
+}
+
+func TestDescribeMissingShards(t *testing.T) {
+	got := describeMissingShards(map[int]error{
+		7: errors.New("connect to host a: timeout"),
+		2: errors.New("context deadline exceeded"),
+	})
+	want := "2 (context deadline exceeded), 7 (connect to host a: timeout)"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
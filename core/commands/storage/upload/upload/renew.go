@@ -0,0 +1,179 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bittorrent/go-btfs/chain"
+	storagehelper "github.com/bittorrent/go-btfs/core/commands/storage/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+	"github.com/bittorrent/go-btfs/utils"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+	guardpb "github.com/bittorrent/go-btfs-common/protos/guard"
+	coreiface "github.com/bittorrent/interface-go-btfs-core"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/google/uuid"
+	"github.com/ipfs/go-datastore"
+)
+
+const renewDaysOptionName = "days"
+
+var StorageUploadRenewCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Renew the storage contracts of a completed upload session.",
+		ShortDescription: `
+This command signs new contracts for the shards of a completed session with the
+hosts which store them, for --days more days, without uploading the shards
+again. A renewal starts when the current contract ends, or right away if it
+already expired. The renewal is a new session, its ID is returned and can be
+followed with 'btfs storage upload status'.
+
+Shards whose host no longer has them, refuses the renewal or can't be reached
+are uploaded again to other hosts, as in a new upload. Each contract is
+renewed in the token it was paid with. Sessions signed offline can't be
+renewed.`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("session-id", true, false, "ID for the completed storage upload session."),
+	},
+	Options: []cmds.Option{
+		cmds.IntOption(renewDaysOptionName, "File storage period to renew the contracts for, in days.").WithDefault(defaultStorageLength),
+		cmds.IntOption(shardParallelismOptionName, "Max number of shards being renewed at the same time.").WithDefault(DefaultShardParallelism),
+		cmds.IntOption(maxShardsPerHostOptionName, "Max number of shards of the file a single host may store.").WithDefault(DefaultMaxShardsPerHost),
+	},
+	RunTimeout: 15 * time.Minute,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		err := utils.CheckSimpleMode(env)
+		if err != nil {
+			return err
+		}
+
+		ctxParams, err := helper.ExtractContextParams(req, env)
+		if err != nil {
+			return err
+		}
+		if !ctxParams.Cfg.Experimental.StorageClientEnabled {
+			return fmt.Errorf("storage client api not enabled")
+		}
+		if !ctxParams.N.IsOnline {
+			return coreiface.ErrOffline
+		}
+		days := req.Options[renewDaysOptionName].(int)
+		ns, err := storagehelper.GetHostStorageConfig(ctxParams.Ctx, ctxParams.N)
+		if err != nil {
+			return err
+		}
+		if days <= 0 || uint64(days) < ns.StorageTimeMin {
+			return fmt.Errorf("invalid storage len. want: >= %d, got: %d", ns.StorageTimeMin, days)
+		}
+
+		ssId := req.Arguments[0]
+		rss, err := sessions.GetRenterSession(ctxParams, ssId, "", make([]string, 0))
+		if err != nil {
+			return err
+		}
+		status, err := rss.Status()
+		if err != nil {
+			return err
+		}
+		if status.Status != sessions.RssCompleteStatus {
+			return fmt.Errorf("only completed sessions can be renewed, current status: %s", status.Status)
+		}
+		if _, err := rss.OfflineMeta(); err == nil {
+			return errors.New("sessions signed offline can't be renewed")
+		} else if err != datastore.ErrNotFound {
+			return err
+		}
+
+		renew := make(map[int]*guardpb.Contract)
+		shardIndexes := make([]int, 0, len(rss.ShardHashes))
+		for i, h := range rss.ShardHashes {
+			shardIndexes = append(shardIndexes, i)
+			shard, err := sessions.GetRenterShard(ctxParams, ssId, h, i)
+			if err != nil {
+				return err
+			}
+			contracts, err := shard.Contracts()
+			if err != nil {
+				return err
+			}
+			// shards without a contract are uploaded again
+			if contracts.SignedGuardContract != nil {
+				renew[i] = contracts.SignedGuardContract
+			}
+		}
+		token, fallbacks, err := renewTokens(renew)
+		if err != nil {
+			return err
+		}
+		priceObj, err := chain.SettleObject.OracleService.CurrentPrice(token)
+		if err != nil {
+			return err
+		}
+		fileSize, shardSize, err := sessionShardSizes(ctxParams, rss)
+		if err != nil {
+			return err
+		}
+
+		renewalId := uuid.New().String()
+		renewal, err := sessions.GetRenterSessionWithToken(ctxParams, renewalId, rss.Hash, rss.ShardHashes, token)
+		if err != nil {
+			return err
+		}
+		// the renewal keeps the encrypted shards, and what it takes to
+		// decrypt them
+		if encryption, err := rss.ShardEncryption(); err != nil {
+			return err
+		} else if encryption != nil {
+			if err := renewal.SaveShardEncryption(encryption); err != nil {
+				return err
+			}
+		}
+		hp := helper.GetHostsProvider(ctxParams, nil)
+		uploadOpts := DefaultUploadShardOptions()
+		uploadOpts.Parallelism = req.Options[shardParallelismOptionName].(int)
+		uploadOpts.MaxShardsPerHost = req.Options[maxShardsPerHostOptionName].(int)
+		uploadOpts.Renew = renew
+		uploadOpts.FallbackTokens = fallbacks
+		err = UploadShard(renewal, hp, priceObj.Int64(), token, shardSize, days, false, ctxParams.N.Identity,
+			fileSize, shardIndexes, nil, uploadOpts)
+		if err != nil {
+			return err
+		}
+		return res.Emit(&Res{
+			ID: renewalId,
+		})
+	},
+	Type: Res{},
+}
+
+// renewTokens returns the tokens the contracts were paid with, which their
+// renewals are paid with as well: the one most contracts use, the token of
+// the renewal session, and the others, as its fallback tokens.
+func renewTokens(contracts map[int]*guardpb.Contract) (common.Address, []common.Address, error) {
+	counts := make(map[common.Address]int)
+	for _, c := range contracts {
+		if c.Token != "" {
+			counts[common.HexToAddress(c.Token)]++
+		}
+	}
+	if len(counts) == 0 {
+		return common.Address{}, nil, errors.New("no shard of the session has a contract to renew")
+	}
+	tokens := make([]common.Address, 0, len(counts))
+	for token := range counts {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if counts[tokens[i]] != counts[tokens[j]] {
+			return counts[tokens[i]] > counts[tokens[j]]
+		}
+		return tokens[i].Hex() < tokens[j].Hex()
+	})
+	return tokens[0], tokens[1:], nil
+}
@@ -32,6 +32,9 @@ This command repairs the given shards of a file.`,
 		cmds.StringArg("renter-pid", true, false, "Original renter peer ID."),
 		cmds.StringArg("blacklist", true, false, "Blacklist of hosts during upload."),
 	},
+	Options: []cmds.Option{
+		cmds.BoolOption(verifyAfterUploadOptionName, "Challenge every host for its repaired shard once the upload is submitted, and fail the session if any can't prove it.").WithDefault(false),
+	},
 	RunTimeout: 5 * time.Minute,
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		err := utils.CheckSimpleMode(env)
@@ -86,19 +89,29 @@ This command repairs the given shards of a file.`,
 		if err != nil {
 			return err
 		}
-		hp := uh.GetHostsProvider(ctxParams, strings.Split(req.Arguments[3], ","))
+		blacklist, err := uh.NewHostBlacklist(strings.Split(req.Arguments[3], ","))
+		if err != nil {
+			return err
+		}
+		hp := uh.GetHostsProvider(ctxParams, blacklist)
 		m := contracts[0].ContractMeta
 		renterPid, err := peer.Decode(req.Arguments[2])
 		if err != nil {
 			return err
 		}
 
+		uploadOpts := DefaultUploadShardOptions()
+		uploadOpts.VerifyAfterUpload = req.Options[verifyAfterUploadOptionName].(bool)
+
 		// token: notice repair is dropped. This is just a compatible function of 'UploadShard'.
-		UploadShard(rss, hp, m.Price, tokencfg.GetWbttToken(), m.ShardFileSize, -1, false, renterPid, -1,
+		err = UploadShard(rss, hp, m.Price, tokencfg.GetWbttToken(), m.ShardFileSize, -1, false, renterPid, -1,
 			shardIndexes, &RepairParams{
 				RenterStart: m.RentStart,
 				RenterEnd:   m.RentEnd,
-			})
+			}, uploadOpts)
+		if err != nil {
+			return err
+		}
 		seRes := &Res{
 			ID: ssId,
 		}
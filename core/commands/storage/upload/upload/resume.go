@@ -0,0 +1,191 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bittorrent/go-btfs/chain"
+	"github.com/bittorrent/go-btfs/chain/tokencfg"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+	"github.com/bittorrent/go-btfs/utils"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+	"github.com/bittorrent/go-btfs-common/crypto"
+	guardpb "github.com/bittorrent/go-btfs-common/protos/guard"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// shardHostCheckTimeout bounds connecting to the host of a shard when
+// checking its contract before resuming a session.
+const shardHostCheckTimeout = 10 * time.Second
+
+var StorageUploadResumeCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Resume a failed upload session.",
+		ShortDescription: `
+This command uploads again the shards of a failed session which have no
+contract, keeping the contracts of the other shards. Those contracts are
+checked first, shards whose contract is no longer valid or whose host can't be
+reached are uploaded again as well.
+
+The token and storage length of the existing contracts are reused, the options
+only apply when no shard has a contract. Sessions signed offline can't be
+resumed, nor sessions which failed after all shards got a contract.`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("session-id", true, false, "ID for the failed storage upload session."),
+	},
+	Options: []cmds.Option{
+		cmds.IntOption(storageLengthOptionName, "len", "File storage period on hosts in days.").WithDefault(defaultStorageLength),
+		cmds.StringOption(tokencfg.TokenTypeName, "tk", "file storage with token type,default WBTT, other TRX/USDD/USDT.").WithDefault("WBTT"),
+		cmds.IntOption(shardParallelismOptionName, "Max number of shards being uploaded at the same time.").WithDefault(DefaultShardParallelism),
+		cmds.IntOption(maxShardsPerHostOptionName, "Max number of shards of the file a single host may store.").WithDefault(DefaultMaxShardsPerHost),
+	},
+	RunTimeout: 15 * time.Minute,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		err := utils.CheckSimpleMode(env)
+		if err != nil {
+			return err
+		}
+
+		ctxParams, err := helper.ExtractContextParams(req, env)
+		if err != nil {
+			return err
+		}
+		if !ctxParams.Cfg.Experimental.StorageClientEnabled {
+			return fmt.Errorf("storage client api not enabled")
+		}
+		ssId := req.Arguments[0]
+		rss, err := sessions.GetRenterSession(ctxParams, ssId, "", make([]string, 0))
+		if err != nil {
+			return err
+		}
+		status, err := rss.Status()
+		if err != nil {
+			return err
+		}
+		if status.Status != sessions.RssErrorStatus {
+			return fmt.Errorf("only failed sessions can be resumed, current status: %s", status.Status)
+		}
+		if _, err := rss.OfflineMeta(); err == nil {
+			return errors.New("sessions signed offline can't be resumed")
+		} else if err != datastore.ErrNotFound {
+			return err
+		}
+
+		// keep the shards with a valid contract, the others are uploaded again
+		skip := make(map[int]bool)
+		var kept *guardpb.Contract
+		shardIndexes := make([]int, 0, len(rss.ShardHashes))
+		for i, h := range rss.ShardHashes {
+			shardIndexes = append(shardIndexes, i)
+			shard, err := sessions.GetRenterShard(ctxParams, ssId, h, i)
+			if err != nil {
+				return err
+			}
+			contracts, err := shard.Contracts()
+			if err != nil {
+				return err
+			}
+			if contracts.SignedGuardContract == nil {
+				continue
+			}
+			if err := checkShardContract(req.Context, ctxParams, rss.Hash, h, i, contracts.SignedGuardContract); err != nil {
+				log.Infof("session %s uploads shard %d again: %s", ssId, i, err.Error())
+				if err := shard.Reset(); err != nil {
+					return err
+				}
+				continue
+			}
+			skip[i] = true
+			kept = contracts.SignedGuardContract
+		}
+		if len(skip) == len(rss.ShardHashes) {
+			return errors.New("all shards have a contract, the session failed after submission and can't be resumed")
+		}
+
+		token, storageLength, err := resumeTerms(req, ctxParams, kept)
+		if err != nil {
+			return err
+		}
+		priceObj, err := chain.SettleObject.OracleService.CurrentPrice(token)
+		if err != nil {
+			return err
+		}
+		fileSize, shardSize, err := sessionShardSizes(ctxParams, rss)
+		if err != nil {
+			return err
+		}
+
+		if err := rss.Resume(ctxParams); err != nil {
+			return err
+		}
+		rss.Token = token
+		hp := helper.GetHostsProvider(ctxParams, nil)
+		uploadOpts := DefaultUploadShardOptions()
+		uploadOpts.Parallelism = req.Options[shardParallelismOptionName].(int)
+		uploadOpts.MaxShardsPerHost = req.Options[maxShardsPerHostOptionName].(int)
+		uploadOpts.SkipShards = skip
+		err = UploadShard(rss, hp, priceObj.Int64(), token, shardSize, storageLength, false, ctxParams.N.Identity,
+			fileSize, shardIndexes, nil, uploadOpts)
+		if err != nil {
+			return err
+		}
+		return res.Emit(&Res{
+			ID: ssId,
+		})
+	},
+	Type: Res{},
+}
+
+// checkShardContract checks that c is a contract signed by its renter for the
+// shard at index of the file, with a host which can still be reached.
+func checkShardContract(ctx context.Context, ctxParams *helper.ContextParams, fileHash string, shardHash string,
+	index int, c *guardpb.Contract) error {
+	meta := c.ContractMeta
+	if meta.FileHash != fileHash || meta.ShardHash != shardHash || int(meta.ShardIndex) != index {
+		return fmt.Errorf("contract %s is not for shard %d (%s)", meta.ContractId, index, shardHash)
+	}
+	renterPubKey, err := crypto.GetPubKeyFromPeerId(meta.RenterPid)
+	if err != nil {
+		return err
+	}
+	if ok, err := crypto.Verify(renterPubKey, &meta, c.RenterSignature); err != nil || !ok {
+		return fmt.Errorf("contract %s has an invalid renter signature", meta.ContractId)
+	}
+	hostPid, err := peer.Decode(meta.HostPid)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, shardHostCheckTimeout)
+	defer cancel()
+	if err := ctxParams.Api.Swarm().Connect(ctx, peer.AddrInfo{ID: hostPid}); err != nil {
+		return fmt.Errorf("host %s can't be reached: %w", meta.HostPid, err)
+	}
+	return nil
+}
+
+// resumeTerms returns the token and storage length of the kept contract, or
+// the ones of the options when no contract is kept.
+func resumeTerms(req *cmds.Request, ctxParams *helper.ContextParams, kept *guardpb.Contract) (common.Address, int, error) {
+	if kept != nil && kept.Token != "" {
+		days := int(kept.ContractMeta.RentEnd.Sub(kept.ContractMeta.RentStart) / (24 * time.Hour))
+		return common.HexToAddress(kept.Token), days, nil
+	}
+	tokenStr := req.Options[tokencfg.TokenTypeName].(string)
+	token, ok := tokencfg.MpTokenAddr[tokenStr]
+	if !ok {
+		return common.Address{}, 0, fmt.Errorf("unknown token %q", tokenStr)
+	}
+	_, storageLength, err := helper.GetPriceAndMinStorageLength(ctxParams)
+	if err != nil {
+		return common.Address{}, 0, err
+	}
+	return token, storageLength, nil
+}
@@ -0,0 +1,212 @@
+package upload
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	storagehelper "github.com/bittorrent/go-btfs/core/commands/storage/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+
+	files "github.com/bittorrent/go-btfs-files"
+	"github.com/bittorrent/interface-go-btfs-core/options"
+	"github.com/bittorrent/interface-go-btfs-core/path"
+
+	cidlib "github.com/ipfs/go-cid"
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+)
+
+const (
+	// shardCipher is the only cipher of encrypted shards so far.
+	shardCipher = "aes-256-gcm"
+	// shardSealOverhead is what encrypting a shard adds to its size, the
+	// GCM tag.
+	shardSealOverhead = 16
+	// shardKeyInfo separates the keys of shards from any other use of the
+	// private key.
+	shardKeyInfo = "btfs shard encryption"
+)
+
+// encryptedUpload is a reed-solomon encoded file with its shards encrypted,
+// ready to be uploaded instead of the file.
+type encryptedUpload struct {
+	// root links the encrypted shards, in order. It is what hosts and the
+	// guard see as the file.
+	root        string
+	shardHashes []string
+	// shardSize is the size of the encrypted shards, which hosts are paid for.
+	shardSize  int64
+	encryption *sessions.ShardEncryption
+}
+
+// shardKey derives the key of the shards encrypted with salt from the
+// private key of the renter, so that only the renter can decrypt them.
+func shardKey(priv ic.PrivKey, salt []byte) ([]byte, error) {
+	raw, err := priv.Raw()
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, raw)
+	mac.Write([]byte(shardKeyInfo))
+	mac.Write(salt)
+	return mac.Sum(nil), nil
+}
+
+func shardGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealedShardSize is about the size of a shard of shardSize once encrypted,
+// to price an upload before its shards are.
+func sealedShardSize(shardSize int64) int64 {
+	return shardSize + shardSealOverhead
+}
+
+// encryptShards encrypts every shard of the reed-solomon encoded file
+// fileHash with a key of the renter, adds them to the blockstore under a new
+// root and pins it.
+func encryptShards(ctx context.Context, ctxParams *helper.ContextParams, fileHash string) (*encryptedUpload, error) {
+	fileCid, err := cidlib.Parse(fileHash)
+	if err != nil {
+		return nil, err
+	}
+	meta, shardCids, err := storagehelper.GetReedSolomonShards(ctx, ctxParams.Api, fileCid)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, err := shardKey(ctxParams.N.PrivateKey, salt)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := shardGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	enc := &sessions.ShardEncryption{
+		Cipher:      shardCipher,
+		Salt:        hex.EncodeToString(salt),
+		FileHash:    fileHash,
+		ShardHashes: make([]string, 0, len(shardCids)),
+		Nonces:      make([]string, 0, len(shardCids)),
+		NumData:     int(meta.NumData),
+		NumParity:   int(meta.NumParity),
+		FileSize:    int64(meta.FileSize),
+	}
+
+	dir, err := ctxParams.Api.Object().New(ctx, options.Object.Type("unixfs-dir"))
+	if err != nil {
+		return nil, err
+	}
+	rootPath := path.IpfsPath(dir.Cid())
+	shardHashes := make([]string, 0, len(shardCids))
+	for i, h := range shardCids {
+		plaintext, err := readShard(ctx, ctxParams, h)
+		if err != nil {
+			return nil, fmt.Errorf("read shard %d: %w", i, err)
+		}
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		p, err := ctxParams.Api.Unixfs().Add(ctx, files.NewBytesFile(gcm.Seal(nil, nonce, plaintext, nil)))
+		if err != nil {
+			return nil, err
+		}
+		rootPath, err = ctxParams.Api.Object().AddLink(ctx, rootPath, strconv.Itoa(i), p)
+		if err != nil {
+			return nil, err
+		}
+		enc.ShardHashes = append(enc.ShardHashes, h.String())
+		enc.Nonces = append(enc.Nonces, hex.EncodeToString(nonce))
+		shardHashes = append(shardHashes, p.Cid().String())
+	}
+	if err := ctxParams.Api.Pin().Add(ctx, rootPath); err != nil {
+		return nil, err
+	}
+
+	sizes, _, err := helper.GetShardLayout(ctxParams, rootPath.Cid().String(), shardHashes)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedUpload{
+		root:        rootPath.Cid().String(),
+		shardHashes: shardHashes,
+		shardSize:   slices.Max(sizes),
+		encryption:  enc,
+	}, nil
+}
+
+// openShard decrypts the shard at index, encrypted as enc describes, with
+// the private key of the renter.
+func openShard(priv ic.PrivKey, enc *sessions.ShardEncryption, index int, ciphertext []byte) ([]byte, error) {
+	if enc.Cipher != shardCipher {
+		return nil, fmt.Errorf("unsupported shard cipher %q", enc.Cipher)
+	}
+	if index < 0 || index >= len(enc.Nonces) {
+		return nil, fmt.Errorf("no nonce for shard %d", index)
+	}
+	salt, err := hex.DecodeString(enc.Salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hex.DecodeString(enc.Nonces[index])
+	if err != nil {
+		return nil, err
+	}
+	key, err := shardKey(priv, salt)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := shardGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce length %d for shard %d", len(nonce), index)
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't decrypt shard %d, it wasn't encrypted by this node: %w", index, err)
+	}
+	return plaintext, nil
+}
+
+// sessionShardSizes returns the size of the file uploaded by rss and the
+// size of its shards, the ones its contracts are priced with.
+func sessionShardSizes(ctxParams *helper.ContextParams, rss *sessions.RenterSession) (fileSize int64,
+	shardSize int64, err error) {
+	enc, err := rss.ShardEncryption()
+	if err != nil {
+		return -1, -1, err
+	}
+	if enc != nil {
+		sizes, _, err := helper.GetShardLayout(ctxParams, rss.Hash, rss.ShardHashes)
+		if err != nil {
+			return -1, -1, err
+		}
+		return enc.FileSize, slices.Max(sizes), nil
+	}
+	shardHashes, fileSize, shardSize, err := helper.GetShardHashes(ctxParams, rss.Hash)
+	if err != nil && len(shardHashes) == 0 && fileSize == -1 && shardSize == -1 &&
+		strings.HasPrefix(err.Error(), "invalid hash: file must be reed-solomon encoded") {
+		_, fileSize, shardSize, err = helper.GetShardHashesCopy(ctxParams, rss.Hash, len(rss.ShardHashes)-1)
+	}
+	return fileSize, shardSize, err
+}
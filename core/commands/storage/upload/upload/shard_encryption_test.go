@@ -0,0 +1,70 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+)
+
+func TestOpenShard(t *testing.T) {
+	priv, _, err := ic.GenerateKeyPair(ic.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		t.Fatal(err)
+	}
+	key, err := shardKey(priv, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := shardGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards := [][]byte{[]byte("data shard"), []byte("parity shard")}
+	enc := &sessions.ShardEncryption{Cipher: shardCipher, Salt: hex.EncodeToString(salt)}
+	sealed := make([][]byte, len(shards))
+	for i, s := range shards {
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			t.Fatal(err)
+		}
+		enc.Nonces = append(enc.Nonces, hex.EncodeToString(nonce))
+		sealed[i] = gcm.Seal(nil, nonce, s, nil)
+		if len(sealed[i]) != len(s)+shardSealOverhead {
+			t.Fatalf("expected %d bytes of ciphertext, got %d", len(s)+shardSealOverhead, len(sealed[i]))
+		}
+	}
+
+	for i, s := range shards {
+		plaintext, err := openShard(priv, enc, i, sealed[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(plaintext, s) {
+			t.Fatalf("shard %d: expected %q, got %q", i, s, plaintext)
+		}
+	}
+	// every shard has its own nonce
+	if _, err := openShard(priv, enc, 1, sealed[0]); err == nil {
+		t.Fatal("expected shard 0 not to decrypt as shard 1")
+	}
+	if _, err := openShard(priv, enc, 2, sealed[0]); err == nil {
+		t.Fatal("expected an error for a shard without nonce")
+	}
+	// only the renter can decrypt them
+	other, _, err := ic.GenerateKeyPair(ic.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openShard(other, enc, 0, sealed[0]); err == nil {
+		t.Fatal("expected another key not to decrypt the shard")
+	}
+}
@@ -0,0 +1,29 @@
+package upload
+
+import (
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+)
+
+// ShardState is a step in setting up the contract of a single shard.
+type ShardState string
+
+const (
+	ShardContractSigning ShardState = "contract-signing"
+	ShardInitSent        ShardState = "init-sent"
+	ShardConfirmed       ShardState = "confirmed"
+	ShardErrored         ShardState = "errored"
+)
+
+// emitShardEvent publishes a shard state transition to the subscribers of
+// the session, which 'btfs storage upload watch' streams.
+func emitShardEvent(rss *sessions.RenterSession, index int, state ShardState, host string, err error) {
+	ev := &sessions.ShardEvent{
+		ShardIndex: index,
+		State:      string(state),
+		HostID:     host,
+	}
+	if err != nil {
+		ev.Err = err.Error()
+	}
+	rss.PublishShardEvent(ev)
+}
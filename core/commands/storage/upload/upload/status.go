@@ -132,6 +132,9 @@ This command print upload and payment status by the time queried.`,
 			}
 		}
 		status.Shards = shards
+		if status.ShardHosts, err = session.ShardHosts(); err != nil {
+			return err
+		}
 		if len(status.Shards) == 0 && status.Status == sessions.RssInitStatus {
 			status.Message = "session not found"
 		}
@@ -146,6 +149,8 @@ type StatusRes struct {
 	AdditionalInfo string
 	FileHash       string
 	Shards         map[string]*ShardStatus
+	// ShardHosts maps each shard index to the host which accepted it.
+	ShardHosts map[int]string
 }
 
 type ShardStatus struct {
@@ -35,10 +35,23 @@ const (
 	replicationFactorOptionName      = "replication-factor"
 	hostSelectModeOptionName         = "host-select-mode"
 	hostSelectionOptionName          = "host-selection"
+	hostRankingOptionName            = "host-ranking"
 	testOnlyOptionName               = "host-search-local"
 	customizedPayoutOptionName       = "customize-payout"
 	customizedPayoutPeriodOptionName = "customize-payout-period"
 	copyName                         = "copy"
+	excludeHostsOptionName           = "exclude-hosts"
+	shardParallelismOptionName       = "shard-parallelism"
+	fallbackTokensOptionName         = "fallback-tokens"
+	verifyAfterUploadOptionName      = "verify-after-upload"
+	maxPayOptionName                 = "max-pay"
+	confirmLevelOptionName           = "confirm-level"
+	dryRunOptionName                 = "dry-run"
+	maxShardsPerHostOptionName       = "max-shards-per-host"
+	minHostsOptionName               = "min-hosts"
+	retryMaxElapsedOptionName        = "upload-retry-max-elapsed"
+	retryMaxIntervalOptionName       = "upload-retry-max-interval"
+	encryptShardsOptionName          = "encrypt-shards"
 
 	defaultRepFactor     = 3
 	defaultStorageLength = 30
@@ -57,6 +70,9 @@ var StorageUploadCmd = &cmds.Command{
 		Tagline: "Store files on BTFS network nodes through BTT payment.",
 		ShortDescription: `
 By default, BTFS selects hosts based on overall score according to the current client's environment.
+Among them, the hosts which stored and proved shards for this node before are tried first, and the
+ones which failed to last. Hosts without history rank in between. Use --host-ranking to try them in
+random order or cheapest first instead.
 To upload a file, <file-hash> must refer to a reed-solomon encoded file.
 
 To create a reed-solomon encoded file from a normal file:
@@ -79,6 +95,15 @@ To custom upload and storage a file on specific hosts:
     # Total # of hosts (N) must match # of shards given
     $ btfs storage upload <shard-hash1> <shard-hash2> ... <shard-hashN> -l -m=custom -s=<host1-peer-id>,<host2-peer-id>,...,<hostN-peer-id>
 
+The upload fails right away if fewer distinct hosts are known than its shards need,
+rather than stalling once the hosts run out. Use --min-hosts to change that number.
+
+To keep hosts from reading the file, use --encrypt-shards. Every shard is then encrypted
+with a key derived from the private key of this node before it leaves it, and hosts are
+paid for the size of the encrypted shards. The session records what it takes to decrypt
+them, rebuild the file with:
+    $ btfs storage reconstruct <file-hash> --session-id=<session-id>
+
 Use status command to check for completion:
     $ btfs storage upload status <session-id> | jq`,
 	},
@@ -89,10 +114,16 @@ Use status command to check for completion:
 		"recvcontract":      StorageUploadRecvContractCmd,
 		"status":            StorageUploadStatusCmd,
 		"repair":            StorageUploadRepairCmd,
+		"resume":            StorageUploadResumeCmd,
+		"renew":             StorageUploadRenewCmd,
+		"watch":             StorageUploadWatchCmd,
+		"history":           StorageUploadHistoryCmd,
 		"getcontractbatch":  offline.StorageUploadGetContractBatchCmd,
 		"signcontractbatch": offline.StorageUploadSignContractBatchCmd,
 		"getunsigned":       offline.StorageUploadGetUnsignedCmd,
 		"sign":              offline.StorageUploadSignCmd,
+		"preparecontracts":  offline.StorageUploadPrepareContractsCmd,
+		"completecontracts": offline.StorageUploadCompleteContractsCmd,
 	},
 	Arguments: []cmds.Argument{
 		cmds.StringArg("file-hash", true, false, "Hash of file to upload."),
@@ -105,12 +136,25 @@ Use status command to check for completion:
 		cmds.IntOption(replicationFactorOptionName, "r", "Replication factor for the file with erasure coding built-in.").WithDefault(defaultRepFactor),
 		cmds.StringOption(hostSelectModeOptionName, "m", "Based on this mode to select hosts and upload automatically. Default: mode set in config option Experimental.HostsSyncMode."),
 		cmds.StringOption(hostSelectionOptionName, "s", "Use only these selected hosts in order on 'custom' mode. Use ',' as delimiter."),
+		cmds.StringOption(hostRankingOptionName, "Order in which candidate hosts are tried: 'reputation' (best record of past uploads and challenges first, hosts without history in between), 'random' or 'price' (cheapest first).").WithDefault(string(helper.HostRankingReputation)),
 		cmds.BoolOption(testOnlyOptionName, "t", "Enable host search under all domains 0.0.0.0 (useful for local test)."),
 		cmds.IntOption(storageLengthOptionName, "len", "File storage period on hosts in days.").WithDefault(defaultStorageLength),
 		cmds.BoolOption(customizedPayoutOptionName, "Enable file storage customized payout schedule.").WithDefault(false),
 		cmds.IntOption(customizedPayoutPeriodOptionName, "Period of customized payout schedule.").WithDefault(1),
 		cmds.IntOption(copyName, "copy num of file hash.").WithDefault(0),
 		cmds.StringOption(tokencfg.TokenTypeName, "tk", "file storage with token type,default WBTT, other TRX/USDD/USDT.").WithDefault("WBTT"),
+		cmds.StringOption(excludeHostsOptionName, "Never select these hosts for the upload. Use ',' as delimiter."),
+		cmds.StringOption(fallbackTokensOptionName, "Tokens to pay with, in order, when a host doesn't support the chosen token. Use ',' as delimiter."),
+		cmds.IntOption(shardParallelismOptionName, "Max number of shards being uploaded at the same time.").WithDefault(DefaultShardParallelism),
+		cmds.Int64Option(maxPayOptionName, "Fail the upload if its total cost in the chosen token, after oracle rate conversion, is above this amount. 0 means no limit.").WithDefault(int64(0)),
+		cmds.StringOption(retryMaxElapsedOptionName, "How long to keep retrying a shard with new hosts before failing the upload, e.g. '10m'.").WithDefault(helper.DefaultHandleShardMaxElapsed.String()),
+		cmds.StringOption(retryMaxIntervalOptionName, "Longest wait between two tries of a shard, e.g. '5s'.").WithDefault(helper.DefaultHandleShardMaxInterval.String()),
+		cmds.IntOption(maxShardsPerHostOptionName, "Max number of shards of the file a single host may store.").WithDefault(DefaultMaxShardsPerHost),
+		cmds.IntOption(minHostsOptionName, "Fail before contracting if fewer distinct hosts are available. Default: enough hosts for every shard with --max-shards-per-host, 0 skips the check."),
+		cmds.BoolOption(dryRunOptionName, "Only quote the cost of the upload and the number of available hosts, without contracting.").WithDefault(false),
+		cmds.StringOption(confirmLevelOptionName, "How far shard contracts go before the hosts are paid: 'ack' pays once the guard confirms enough shards, 'settled' waits for the guard to confirm every shard.").WithDefault(string(ConfirmAck)),
+		cmds.BoolOption(verifyAfterUploadOptionName, "Challenge every host for its shard once the upload is submitted, and fail the session if any can't prove it.").WithDefault(false),
+		cmds.BoolOption(encryptShardsOptionName, "Encrypt every shard with a key of this node before sending it, so that hosts only store ciphertext. Needs a reed-solomon encoded file.").WithDefault(false),
 	},
 	RunTimeout: 15 * time.Minute,
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
@@ -182,6 +226,15 @@ Use status command to check for completion:
 		if err != nil {
 			return err
 		}
+		encrypt := req.Options[encryptShardsOptionName].(bool)
+		if encrypt {
+			if len(shardHashes) > 0 && shardHashes[0] == fileHash {
+				return fmt.Errorf("--%s needs a reed-solomon encoded file", encryptShardsOptionName)
+			}
+			// the shards are only encrypted once the session exists, until
+			// then they are priced at the size they will have
+			shardSize = sealedShardSize(shardSize)
+		}
 		_, storageLength, err := helper.GetPriceAndMinStorageLength(ctxParams)
 		if err != nil {
 			return err
@@ -198,7 +251,7 @@ Use status command to check for completion:
 		if err != nil {
 			return err
 		}
-		_, err = helper.TotalPay(shardSize, price, storageLength, rate)
+		onePay, err := helper.TotalPay(shardSize, price, storageLength, rate)
 		if err != nil {
 			fmt.Println(err.Error())
 			return err
@@ -208,7 +261,19 @@ Use status command to check for completion:
 		if !ctxParams.Cfg.Experimental.HostsSyncEnabled {
 			_ = SyncHosts(ctxParams)
 		}
-		hp := helper.GetHostsProvider(ctxParams, make([]string, 0))
+		var blacklist helper.HostBlacklist
+		if excluded, ok := req.Options[excludeHostsOptionName].(string); ok {
+			blacklist, err = helper.NewHostBlacklist(strings.Split(excluded, ","))
+			if err != nil {
+				return err
+			}
+		}
+		ranking, err := helper.ParseHostRanking(req.Options[hostRankingOptionName].(string))
+		if err != nil {
+			return err
+		}
+		hp := helper.GetRankedHostsProvider(ctxParams, blacklist, ranking)
+		hostsAvailable := -1
 		if mode, ok := req.Options[hostSelectModeOptionName].(string); ok {
 			var hostIDs []string
 			if mode == "custom" {
@@ -218,13 +283,103 @@ Use status command to check for completion:
 				if len(hostIDs) != len(shardHashes) {
 					return fmt.Errorf("custom mode hosts length must match shard hashes length")
 				}
+				distinct := make(map[string]bool)
+				for _, h := range hostIDs {
+					if blacklist.Contains(h) {
+						return fmt.Errorf("custom mode host %s is also listed in --%s", h, excludeHostsOptionName)
+					}
+					distinct[h] = true
+				}
+				if maxPerHost := req.Options[maxShardsPerHostOptionName].(int); len(distinct)*maxPerHost < len(shardHashes) {
+					return fmt.Errorf("custom mode hosts can store at most %d of the %d shards with --%s=%d",
+						len(distinct)*maxPerHost, len(shardHashes), maxShardsPerHostOptionName, maxPerHost)
+				}
 				hp = helper.GetCustomizedHostsProvider(ctxParams, hostIDs)
+				hostsAvailable = len(distinct)
 			}
 		}
+		if req.Options[dryRunOptionName].(bool) {
+			if hostsAvailable < 0 {
+				if hostsAvailable, err = countAvailableHosts(ctxParams, blacklist); err != nil {
+					return err
+				}
+			}
+			quote, err := quoteUpload(req.Context, token, tokenStr, price, shardSize, storageLength, len(shardHashes))
+			if err != nil {
+				return err
+			}
+			quote.HostsAvailable = hostsAvailable
+			return res.Emit(&Res{
+				Quote: quote,
+			})
+		}
+		maxPerHost := req.Options[maxShardsPerHostOptionName].(int)
+		minHosts, ok := req.Options[minHostsOptionName].(int)
+		if !ok {
+			minHosts = defaultMinHosts(len(shardHashes), maxPerHost)
+		} else if minHosts < 0 {
+			return fmt.Errorf("--%s must not be negative, got %d", minHostsOptionName, minHosts)
+		}
+		if minHosts > 0 {
+			if hostsAvailable < 0 {
+				if hostsAvailable, err = countAvailableHosts(ctxParams, blacklist); err != nil {
+					return err
+				}
+			}
+			if err := checkMinHosts(hostsAvailable, minHosts); err != nil {
+				return fmt.Errorf("%w, lower --%s or try again once more hosts are synced", err, minHostsOptionName)
+			}
+		}
+		// check all the options before the session is created, so that none
+		// is left behind when one of them is invalid
+		uploadOpts := DefaultUploadShardOptions()
+		uploadOpts.Parallelism = req.Options[shardParallelismOptionName].(int)
+		uploadOpts.VerifyAfterUpload = req.Options[verifyAfterUploadOptionName].(bool)
+		uploadOpts.MaxTotalPay = req.Options[maxPayOptionName].(int64)
+		uploadOpts.MaxShardsPerHost = maxPerHost
+		if uploadOpts.ConfirmLevel, err = ParseConfirmLevel(req.Options[confirmLevelOptionName].(string)); err != nil {
+			return err
+		}
+		if uploadOpts.Retry.MaxElapsed, err = time.ParseDuration(req.Options[retryMaxElapsedOptionName].(string)); err != nil {
+			return fmt.Errorf("invalid --%s: %w", retryMaxElapsedOptionName, err)
+		}
+		if uploadOpts.Retry.MaxInterval, err = time.ParseDuration(req.Options[retryMaxIntervalOptionName].(string)); err != nil {
+			return fmt.Errorf("invalid --%s: %w", retryMaxIntervalOptionName, err)
+		}
+		if fallbacks, ok := req.Options[fallbackTokensOptionName].(string); ok {
+			for _, name := range strings.Split(fallbacks, ",") {
+				fb, ok := tokencfg.MpTokenAddr[strings.TrimSpace(name)]
+				if !ok {
+					return fmt.Errorf("unknown fallback token %q", name)
+				}
+				uploadOpts.FallbackTokens = append(uploadOpts.FallbackTokens, fb)
+			}
+		}
+		if err := uploadOpts.validate(); err != nil {
+			return err
+		}
+		if err := checkMaxTotalPay(onePay*int64(len(shardHashes)), uploadOpts.MaxTotalPay); err != nil {
+			return err
+		}
 		rss, err := sessions.GetRenterSessionWithToken(ctxParams, ssId, fileHash, shardHashes, token)
 		if err != nil {
 			return err
 		}
+		if encrypt {
+			// hosts get the encrypted shards under their own root, and are
+			// paid for their size
+			enc, err := encryptShards(req.Context, ctxParams, fileHash)
+			if err != nil {
+				err = fmt.Errorf("encrypt shards: %w", err)
+				_ = rss.To(sessions.RssToErrorEvent, err)
+				return err
+			}
+			rss.Hash, rss.ShardHashes, shardSize = enc.root, enc.shardHashes, enc.shardSize
+			if err := rss.SaveShardEncryption(enc.encryption); err != nil {
+				_ = rss.To(sessions.RssToErrorEvent, err)
+				return err
+			}
+		}
 		if offlineSigning {
 			offNonceTimestamp, err := strconv.ParseUint(req.Arguments[2], 10, 64)
 			if err != nil {
@@ -243,7 +398,10 @@ Use status command to check for completion:
 		for i, _ := range rss.ShardHashes {
 			shardIndexes = append(shardIndexes, i)
 		}
-		UploadShard(rss, hp, price, token, shardSize, storageLength, offlineSigning, renterId, fileSize, shardIndexes, nil)
+		err = UploadShard(rss, hp, price, token, shardSize, storageLength, offlineSigning, renterId, fileSize, shardIndexes, nil, uploadOpts)
+		if err != nil {
+			return err
+		}
 		seRes := &Res{
 			ID: ssId,
 		}
@@ -267,4 +425,6 @@ func SyncHosts(ctxParams *helper.ContextParams) error {
 
 type Res struct {
 	ID string
+	// Quote is only set on dry runs, where no session is created.
+	Quote *UploadQuote `json:",omitempty"`
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/bittorrent/go-btfs/chain"
@@ -13,162 +14,443 @@ import (
 	"github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
 	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
 	"github.com/bittorrent/go-btfs/core/corehttp/remote"
+	"github.com/bittorrent/go-btfs/settlement/swap/vault"
+
+	guardpb "github.com/bittorrent/go-btfs-common/protos/guard"
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/libp2p/go-libp2p/core/peer"
 )
 
+// ShardTimeouts bounds the P2P exchanges with a host while setting up a shard contract.
+type ShardTimeouts struct {
+	// SupportTokens bounds the /storage/upload/supporttokens call.
+	SupportTokens time.Duration
+	// InitCall bounds the /storage/upload/init call itself.
+	InitCall time.Duration
+	// Init is how long the host has to answer the init request before the
+	// contract is considered invalid and another host is tried.
+	Init time.Duration
+	// Verify bounds each /storage/upload/challenge call made when verifying
+	// the upload.
+	Verify time.Duration
+}
+
+// DefaultShardTimeouts are the timeouts used when none are given to UploadShard.
+var DefaultShardTimeouts = ShardTimeouts{
+	SupportTokens: 60 * time.Second,
+	InitCall:      10 * time.Second,
+	Init:          30 * time.Second,
+	Verify:        60 * time.Second,
+}
+
+func (t ShardTimeouts) validate() error {
+	if t.Init <= 0 {
+		return fmt.Errorf("shard init timeout must be greater than zero, got %s", t.Init)
+	}
+	if t.InitCall <= 0 || t.SupportTokens <= 0 {
+		return fmt.Errorf("shard p2p call timeouts must be greater than zero, got %s and %s",
+			t.InitCall, t.SupportTokens)
+	}
+	return nil
+}
+
+// ShardRetry bounds the retries of setting up a shard, each with a new host.
+type ShardRetry struct {
+	// MaxElapsed is how long a shard is retried before the upload fails.
+	MaxElapsed time.Duration
+	// MaxInterval is the longest wait between two tries.
+	MaxInterval time.Duration
+}
+
+// DefaultShardRetry is the retry policy used when none is given to UploadShard.
+var DefaultShardRetry = ShardRetry{
+	MaxElapsed:  helper.DefaultHandleShardMaxElapsed,
+	MaxInterval: helper.DefaultHandleShardMaxInterval,
+}
+
+func (r ShardRetry) validate() error {
+	if r.MaxElapsed <= 0 || r.MaxInterval <= 0 {
+		return fmt.Errorf("shard retry max elapsed time and max interval must be greater than zero, got %s and %s",
+			r.MaxElapsed, r.MaxInterval)
+	}
+	return nil
+}
+
+// DefaultShardParallelism is the number of shards uploaded concurrently by default.
+const DefaultShardParallelism = 20
+
+// DefaultMaxShardsPerHost keeps every shard of a file on a different host,
+// so that losing a host loses a single shard.
+const DefaultMaxShardsPerHost = 1
+
+// ConfirmLevel is how far a shard contract must go before the shard counts
+// as stored.
+type ConfirmLevel string
+
+const (
+	// ConfirmAck counts a shard once its host signed the contract, and pays
+	// as soon as the guard confirms enough of the shards of the file.
+	ConfirmAck ConfirmLevel = "ack"
+	// ConfirmSettled waits for the guard to confirm the contract of every
+	// shard before paying.
+	ConfirmSettled ConfirmLevel = "settled"
+)
+
+// ParseConfirmLevel checks s is one of the confirm levels.
+func ParseConfirmLevel(s string) (ConfirmLevel, error) {
+	switch l := ConfirmLevel(s); l {
+	case ConfirmAck, ConfirmSettled:
+		return l, nil
+	default:
+		return "", fmt.Errorf("unknown confirm level %q, must be %s or %s", s, ConfirmAck, ConfirmSettled)
+	}
+}
+
+// UploadShardOptions tunes the behavior of UploadShard. A nil value means defaults.
+type UploadShardOptions struct {
+	Timeouts ShardTimeouts
+	Retry    ShardRetry
+	// Parallelism is the maximum number of shards being set up with hosts at once.
+	Parallelism int
+	// FallbackTokens are tried in order when a host doesn't support the
+	// primary token of the upload.
+	FallbackTokens []common.Address
+	// VerifyAfterUpload challenges every host for its shard once the session
+	// is submitted, and fails the session if any of them can't answer.
+	VerifyAfterUpload bool
+	// MaxShardsPerHost is the most shards of the file a single host may
+	// store. Hosts at the limit are skipped.
+	MaxShardsPerHost int
+	// SkipShards lists the indexes of shards which already have a contract,
+	// they are not uploaded again.
+	SkipShards map[int]bool
+	// MaxTotalPay caps what the whole upload may cost, in the smallest unit
+	// of the upload token, once converted with the oracle rate. 0 means no cap.
+	MaxTotalPay int64
+	// ConfirmLevel is how far the shard contracts must go before the
+	// hosts are paid.
+	ConfirmLevel ConfirmLevel
+	// Renew maps the indexes of shards to their current contract. Their
+	// host is first asked to renew it without the shard being sent again,
+	// the shard is uploaded to a new host if the renewal fails.
+	Renew map[int]*guardpb.Contract
+}
+
+// DefaultUploadShardOptions returns the options UploadShard uses when given nil.
+func DefaultUploadShardOptions() *UploadShardOptions {
+	return &UploadShardOptions{
+		Timeouts:         DefaultShardTimeouts,
+		Retry:            DefaultShardRetry,
+		Parallelism:      DefaultShardParallelism,
+		MaxShardsPerHost: DefaultMaxShardsPerHost,
+		ConfirmLevel:     ConfirmAck,
+	}
+}
+
+func (o *UploadShardOptions) validate() error {
+	if o.Parallelism <= 0 {
+		return fmt.Errorf("shard upload parallelism must be greater than zero, got %d", o.Parallelism)
+	}
+	if o.VerifyAfterUpload && o.Timeouts.Verify <= 0 {
+		return fmt.Errorf("shard verify timeout must be greater than zero, got %s", o.Timeouts.Verify)
+	}
+	if o.MaxShardsPerHost <= 0 {
+		return fmt.Errorf("max shards per host must be greater than zero, got %d", o.MaxShardsPerHost)
+	}
+	if o.MaxTotalPay < 0 {
+		return fmt.Errorf("max total pay must not be negative, got %d", o.MaxTotalPay)
+	}
+	if _, err := ParseConfirmLevel(string(o.ConfirmLevel)); err != nil {
+		return err
+	}
+	if err := o.Retry.validate(); err != nil {
+		return err
+	}
+	return o.Timeouts.validate()
+}
+
 func UploadShard(rss *sessions.RenterSession, hp helper.IHostsProvider, price int64, token common.Address, shardSize int64,
 	storageLength int,
-	offlineSigning bool, renterId peer.ID, fileSize int64, shardIndexes []int, rp *RepairParams) error {
+	offlineSigning bool, renterId peer.ID, fileSize int64, shardIndexes []int, rp *RepairParams, opts *UploadShardOptions) error {
+	if opts == nil {
+		opts = DefaultUploadShardOptions()
+	}
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	// repairs upload some of the shards only, without the file size
+	if fileSize >= 0 {
+		sizes, dataShards, err := helper.GetShardLayout(rss.CtxParams, rss.Hash, rss.ShardHashes)
+		if err != nil {
+			return err
+		}
+		encryption, err := rss.ShardEncryption()
+		if err != nil {
+			return err
+		}
+		dataSize := fileSize
+		if encryption != nil {
+			// the root of encrypted shards has no metadata, and each of them
+			// is a bit larger than the shard it was encrypted from
+			dataShards = encryption.NumData
+			dataSize += int64(encryption.NumData) * shardSealOverhead
+		}
+		if err := validateShardSizes(shardSize, dataSize, sizes, dataShards); err != nil {
+			return err
+		}
+	}
 
 	// token: get new rate
-	rate, err := chain.SettleObject.OracleService.CurrentRate(token)
+	expectOnePay, err := shardPay(token, price, shardSize, storageLength)
 	if err != nil {
 		return err
 	}
-	expectOnePay, err := helper.TotalPay(shardSize, price, storageLength, rate)
-	if err != nil {
+	expectTotalPay := expectOnePay * int64(len(rss.ShardHashes))
+	if err := checkMaxTotalPay(expectTotalPay, opts.MaxTotalPay); err != nil {
 		return err
 	}
-	expectTotalPay := expectOnePay * int64(len(rss.ShardHashes))
 	err = checkAvailableBalance(rss.Ctx, expectTotalPay, token)
 	if err != nil {
 		return err
 	}
 
-	for index, shardHash := range rss.ShardHashes {
-		go func(i int, h string) {
-			err := backoff.Retry(func() error {
-				select {
-				case <-rss.Ctx.Done():
-					return nil
-				default:
-					break
+	// token: acceptable tokens in order of preference, the primary one first.
+	var fallbacks []shardTokenTerms
+	for _, fb := range opts.FallbackTokens {
+		if fb == token {
+			continue
+		}
+		terms, err := getShardTokenTerms(fb, shardSize, storageLength)
+		if err != nil {
+			return err
+		}
+		fallbacks = append(fallbacks, terms)
+	}
+	acceptable := []shardTokenTerms{{token: token, price: price, onePay: expectOnePay}}
+	acceptable = append(acceptable, payableShardTokens(fallbacks, len(rss.ShardHashes), opts.MaxTotalPay,
+		func(amount int64, token common.Address) error {
+			return checkAvailableBalance(rss.Ctx, amount, token)
+		})...)
+
+	quota := newShardHostQuota(opts.MaxShardsPerHost)
+	for index, h := range rss.ShardHashes {
+		if !opts.SkipShards[shardIndexes[index]] {
+			continue
+		}
+		// skipped shards keep their host, which counts towards its limit
+		shard, err := sessions.GetRenterShard(rss.CtxParams, rss.SsId, h, shardIndexes[index])
+		if err != nil {
+			return err
+		}
+		contracts, err := shard.Contracts()
+		if err != nil {
+			return err
+		}
+		if contracts.SignedGuardContract != nil {
+			quota.reserve(contracts.SignedGuardContract.HostPid)
+		}
+	}
+	uploadOne := func(i int, h string) {
+		// every shard gets its own backoff, they are retried concurrently
+		bo := helper.NewHandleShardBo(opts.Retry.MaxElapsed, opts.Retry.MaxInterval)
+		renew := opts.Renew[i]
+		err := backoff.Retry(func() error {
+			select {
+			case <-rss.Ctx.Done():
+				return nil
+			default:
+				break
+			}
+			var host string
+			var hostPid peer.ID
+			var chosen shardTokenTerms
+			var err error
+			if renew != nil {
+				// the host of a renewal is paid in the token of its contract
+				if terms, ok := renewalTerms(acceptable, renew); ok {
+					host, chosen = renew.HostPid, terms
+					hostPid, err = peer.Decode(host)
+				} else {
+					log.Infof("shard %d can't be renewed in token %s, uploading it again", i, renew.Token)
+					renew = nil
 				}
-				host, err := hp.NextValidHost()
-				if err != nil {
-					terr := rss.To(sessions.RssToErrorEvent, err)
-					if terr != nil {
-						// Ignore err, just print error log
-						log.Debugf("original err: %s, transition err: %s", err.Error(), terr.Error())
-					}
-					return nil
+			}
+			if renew == nil {
+				host, hostPid, chosen, err = nextShardHost(rss.Ctx, hp, acceptable, opts.Timeouts.SupportTokens,
+					func(ctx context.Context, hostPid peer.ID) ([]byte, error) {
+						return remote.P2PCall(ctx, rss.CtxParams.N, rss.CtxParams.Api, hostPid, "/storage/upload/supporttokens")
+					})
+			}
+			if errors.Is(err, errNoShardHost) {
+				emitShardEvent(rss, i, ShardErrored, "", err)
+				terr := rss.To(sessions.RssToErrorEvent, categorizeShardError(err))
+				if terr != nil {
+					// Ignore err, just print error log
+					log.Debugf("original err: %s, transition err: %s", err.Error(), terr.Error())
 				}
-
-				hostPid, err := peer.Decode(host)
-				if err != nil {
-					log.Errorf("shard %s decodes host_pid error: %s", h, err.Error())
-					return err
+				return nil
+			}
+			if err != nil {
+				log.Infof("shard %s skips host %s: %s", h, host, err.Error())
+				renew = nil
+				return err
+			}
+			if !quota.reserve(host) {
+				log.Infof("shard %s skips host %s: %s", h, host, errHostShardLimit.Error())
+				renew = nil
+				return errHostShardLimit
+			}
+			confirmed := false
+			defer func() {
+				if !confirmed {
+					quota.release(host)
 				}
+			}()
+			log.Infof("shard %d uses token %s with host %s", i, chosen.token.String(), host)
 
-				//token: check host tokens
-				{
-					ctx, _ := context.WithTimeout(rss.Ctx, 60*time.Second)
-					output, err := remote.P2PCall(ctx, rss.CtxParams.N, rss.CtxParams.Api, hostPid, "/storage/upload/supporttokens")
-					if err != nil {
-						fmt.Printf("uploadShard, remote.P2PCall(supporttokens) timeout, hostPid = %v, will try again. \n", hostPid)
-						return err
-					}
-
-					var mpToken map[string]common.Address
-					err = json.Unmarshal(output, &mpToken)
-					if err != nil {
-						return err
-					}
-
-					ok := false
-					for _, v := range mpToken {
-						if token == v {
-							ok = true
-						}
-					}
-					if !ok {
-						return nil
-					}
+			// TotalPay
+			contractId := helper.NewContractID(rss.SsId)
+			startTime := time.Now()
+			shardRp := rp
+			if renew != nil {
+				startTime = renewalStart(renew, startTime)
+				shardRp = &RepairParams{
+					RenterStart: startTime,
+					RenterEnd:   startTime.Add(time.Duration(storageLength*24) * time.Hour),
 				}
+			}
 
-				// TotalPay
-				contractId := helper.NewContractID(rss.SsId)
-				cb := make(chan error)
-				ShardErrChanMap.Set(contractId, cb)
-
-				errChan := make(chan error, 2)
-				var guardContractBytes []byte
-				go func() {
-					tmp := func() error {
-						guardContractBytes, err = RenterSignGuardContract(rss, &ContractParams{
-							ContractId:    contractId,
-							RenterPid:     renterId.String(),
-							HostPid:       host,
-							ShardIndex:    int32(i),
-							ShardHash:     h,
-							ShardSize:     shardSize,
-							FileHash:      rss.Hash,
-							StartTime:     time.Now(),
-							StorageLength: int64(storageLength),
-							Price:         price,
-							TotalPay:      expectOnePay,
-						}, offlineSigning, rp, token.String())
-						if err != nil {
-							log.Errorf("shard %s signs guard_contract error: %s", h, err.Error())
-							return err
-						}
-						return nil
-					}()
-					errChan <- tmp
-				}()
-				c := 0
-				for err := range errChan {
-					c++
+			emitShardEvent(rss, i, ShardContractSigning, host, nil)
+			errChan := make(chan error, 2)
+			var guardContractBytes []byte
+			go func() {
+				tmp := func() error {
+					guardContractBytes, err = RenterSignGuardContract(rss, &ContractParams{
+						ContractId:    contractId,
+						RenterPid:     renterId.String(),
+						HostPid:       host,
+						ShardIndex:    int32(i),
+						ShardHash:     h,
+						ShardSize:     shardSize,
+						FileHash:      rss.Hash,
+						StartTime:     startTime,
+						StorageLength: int64(storageLength),
+						Price:         chosen.price,
+						TotalPay:      chosen.onePay,
+					}, offlineSigning, shardRp, chosen.token.String())
 					if err != nil {
-						return err
-					}
-					if c >= 1 {
-						break
+						log.Errorf("shard %s signs guard_contract error: %s", h, err.Error())
+						return sessions.WithErrorCategory(sessions.ErrCategoryContractSignFailed, err)
 					}
+					return nil
+				}()
+				errChan <- tmp
+			}()
+			c := 0
+			for err := range errChan {
+				c++
+				if err != nil {
+					emitShardEvent(rss, i, ShardErrored, host, err)
+					return err
 				}
+				if c >= 1 {
+					break
+				}
+			}
 
-				go func() {
-					ctx, _ := context.WithTimeout(rss.Ctx, 10*time.Second)
-					_, err := remote.P2PCall(ctx, rss.CtxParams.N, rss.CtxParams.Api, hostPid, "/storage/upload/init",
+			emitShardEvent(rss, i, ShardInitSent, host, nil)
+			// the call may outlive this try, which can reset renew
+			renewing := renew != nil
+			err = initShard(rss.Ctx, opts.Timeouts, contractId, func(ctx context.Context) error {
+				if renewing {
+					_, err := remote.P2PCall(ctx, rss.CtxParams.N, rss.CtxParams.Api, hostPid, "/storage/upload/renew",
 						rss.SsId,
 						rss.Hash,
 						h,
-						price,
-						nil,
+						chosen.price,
 						guardContractBytes,
 						storageLength,
 						shardSize,
 						i,
 						renterId,
 					)
-					if err != nil {
-						cb <- err
-					}
-				}()
-				// host needs to send recv in 30 seconds, or the contract will be invalid.
-				tick := time.Tick(30 * time.Second)
-				select {
-				case err = <-cb:
-					ShardErrChanMap.Remove(contractId)
 					return err
-				case <-tick:
-					return errors.New("host timeout")
 				}
-			}, helper.HandleShardBo)
+				_, err := remote.P2PCall(ctx, rss.CtxParams.N, rss.CtxParams.Api, hostPid, "/storage/upload/init",
+					rss.SsId,
+					rss.Hash,
+					h,
+					chosen.price,
+					nil,
+					guardContractBytes,
+					storageLength,
+					shardSize,
+					i,
+					renterId,
+				)
+				return err
+			})
+			recordHostResult(rss.Ctx, rss, host, err)
 			if err != nil {
-				_ = rss.To(sessions.RssToErrorEvent,
-					errors.New("timeout: failed to setup contract in "+helper.HandleShardBo.MaxElapsedTime.String()))
+				emitShardEvent(rss, i, ShardErrored, host, err)
+				if renewing {
+					// the shard is uploaded again, to the next host
+					log.Infof("shard %d can't be renewed with host %s, uploading it again: %s", i, host, err.Error())
+					renew = nil
+				}
+				return err
+			}
+			if err := rss.SaveShardHost(i, host); err != nil {
+				// the contract holds the host as well, only the audit trail is lost
+				log.Errorf("shard %d saves host %s error: %s", i, host, err.Error())
 			}
-		}(shardIndexes[index], shardHash)
+			confirmed = true
+			emitShardEvent(rss, i, ShardConfirmed, host, nil)
+			return nil
+		}, bo)
+		if err != nil {
+			// the last error of the shard tells why no host took it
+			_ = rss.To(sessions.RssToErrorEvent, categorizeShardError(
+				fmt.Errorf("timeout: failed to setup contract in %s: %w", bo.MaxElapsedTime, err)))
+		}
+	}
+
+	// only opts.Parallelism shards are in-flight at once, the rest wait in the queue.
+	type shardJob struct {
+		index int
+		hash  string
+	}
+	jobs := make(chan shardJob, len(rss.ShardHashes))
+	for index, shardHash := range rss.ShardHashes {
+		if opts.SkipShards[shardIndexes[index]] {
+			continue
+		}
+		jobs <- shardJob{index: shardIndexes[index], hash: shardHash}
+	}
+	close(jobs)
+	workers := opts.Parallelism
+	if offlineSigning {
+		// offline signatures are collected for all shards at once
+		workers = len(rss.ShardHashes)
+	}
+	if workers > len(rss.ShardHashes) {
+		workers = len(rss.ShardHashes)
+	}
+	for w := 0; w < workers; w++ {
+		go func() {
+			for job := range jobs {
+				uploadOne(job.index, job.hash)
+			}
+		}()
 	}
 	// waiting for contracts of 30(n) shards
 	go func(rss *sessions.RenterSession, numShards int) {
-		tick := time.Tick(5 * time.Second)
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
 		for true {
 			select {
-			case <-tick:
+			case <-ticker.C:
 				completeNum, errorNum, err := rss.GetCompleteShardsNum()
 				if err != nil {
 					continue
@@ -176,9 +458,12 @@ func UploadShard(rss *sessions.RenterSession, hp helper.IHostsProvider, price in
 				log.Info("session", rss.SsId, "contractNum", completeNum, "errorNum", errorNum)
 				if completeNum == numShards {
 					// while all shards upload completely, submit its.
-					err := Submit(rss, fileSize, offlineSigning)
+					err := Submit(rss, fileSize, offlineSigning, opts.ConfirmLevel)
+					if err == nil && opts.VerifyAfterUpload {
+						err = verifyAfterUpload(rss, opts)
+					}
 					if err != nil {
-						_ = rss.To(sessions.RssToErrorEvent, err)
+						_ = rss.To(sessions.RssToErrorEvent, categorizeShardError(err))
 					}
 					return
 				} else if errorNum > 0 {
@@ -195,3 +480,283 @@ func UploadShard(rss *sessions.RenterSession, hp helper.IHostsProvider, price in
 
 	return nil
 }
+
+// defaultMinHosts is the number of distinct hosts needed to store shards
+// shards with at most maxPerHost shards on each host.
+func defaultMinHosts(shards int, maxPerHost int) int {
+	if maxPerHost <= 0 {
+		return shards
+	}
+	return (shards + maxPerHost - 1) / maxPerHost
+}
+
+// checkMinHosts fails if fewer than minHosts hosts are available, so that an
+// upload doesn't start contracting shards it can't place all of.
+func checkMinHosts(available int, minHosts int) error {
+	if available < minHosts {
+		return fmt.Errorf("not enough hosts: %d distinct hosts available, at least %d needed", available, minHosts)
+	}
+	return nil
+}
+
+// checkMaxTotalPay fails if totalPay is above maxTotalPay, unless it's 0.
+func checkMaxTotalPay(totalPay, maxTotalPay int64) error {
+	if maxTotalPay > 0 && totalPay > maxTotalPay {
+		return fmt.Errorf("price exceeds cap: total pay %d is more than the maximum of %d", totalPay, maxTotalPay)
+	}
+	return nil
+}
+
+var (
+	// errNoShardHost is returned by nextShardHost when the hosts provider
+	// has no host left, the shard can't be uploaded.
+	errNoShardHost = errors.New("no host available for shard")
+	// errHostTokenUnsupported is returned by nextShardHost when the host
+	// supports none of the acceptable tokens, the shard is retried with
+	// the next host.
+	errHostTokenUnsupported = errors.New("host supports none of the acceptable tokens")
+	// errHostShardLimit is returned when the host already stores as many
+	// shards of the file as allowed, the shard is retried with the next host.
+	errHostShardLimit = errors.New("host already stores the maximum number of shards of the file")
+	// errHostTimeout is returned by initShard when the host doesn't confirm
+	// the contract in time.
+	errHostTimeout = errors.New("host timeout")
+)
+
+// categorizeShardError gives err the category of its failure, when it's
+// one of the known ones, so that the session error tells what went wrong.
+// err itself is kept as is for the logs.
+func categorizeShardError(err error) error {
+	switch {
+	case sessions.ErrorCategoryOf(err) != "":
+		return err
+	case errors.Is(err, vault.ErrInsufficientFunds):
+		return sessions.WithErrorCategory(sessions.ErrCategoryBalanceInsufficient, err)
+	case errors.Is(err, errHostTokenUnsupported):
+		return sessions.WithErrorCategory(sessions.ErrCategoryTokenUnsupported, err)
+	case errors.Is(err, errHostTimeout), errors.Is(err, context.DeadlineExceeded):
+		return sessions.WithErrorCategory(sessions.ErrCategoryHostTimeout, err)
+	default:
+		return err
+	}
+}
+
+// shardHostQuota counts the shards of a file given to each host.
+type shardHostQuota struct {
+	max    int
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newShardHostQuota(max int) *shardHostQuota {
+	return &shardHostQuota{max: max, counts: make(map[string]int)}
+}
+
+// reserve takes a shard slot of host, it returns false if there's none left.
+func (q *shardHostQuota) reserve(host string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.counts[host] >= q.max {
+		return false
+	}
+	q.counts[host]++
+	return true
+}
+
+// release gives back a slot taken by reserve, when the host didn't get the shard.
+func (q *shardHostQuota) release(host string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.counts[host]--; q.counts[host] <= 0 {
+		delete(q.counts, host)
+	}
+}
+
+// nextShardHost takes the next host from hp and picks the token to pay it
+// with, asking the host for its tokens through supportTokens. All errors
+// but errNoShardHost mean the host can't be used and the next one should be
+// tried.
+func nextShardHost(ctx context.Context, hp helper.IHostsProvider, acceptable []shardTokenTerms, timeout time.Duration,
+	supportTokens func(ctx context.Context, hostPid peer.ID) ([]byte, error)) (string, peer.ID, shardTokenTerms, error) {
+	host, err := hp.NextValidHost()
+	if err != nil {
+		return "", "", shardTokenTerms{}, fmt.Errorf("%w: %v", errNoShardHost, err)
+	}
+	hostPid, err := peer.Decode(host)
+	if err != nil {
+		return host, "", shardTokenTerms{}, fmt.Errorf("decode host pid: %w", err)
+	}
+
+	//token: check host tokens
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	output, err := supportTokens(ctx, hostPid)
+	cancel()
+	if err != nil {
+		return host, hostPid, shardTokenTerms{}, fmt.Errorf("query supported tokens: %w", err)
+	}
+	var mpToken map[string]common.Address
+	if err := json.Unmarshal(output, &mpToken); err != nil {
+		return host, hostPid, shardTokenTerms{}, err
+	}
+	terms, ok := pickShardToken(acceptable, mpToken)
+	if !ok {
+		return host, hostPid, shardTokenTerms{}, errHostTokenUnsupported
+	}
+	return host, hostPid, terms, nil
+}
+
+// initShard sends the init request to the host through call and waits for the
+// host to confirm the contract on its error channel. The call context, the
+// wait timer and the channel registration are all released before returning.
+func initShard(ctx context.Context, timeouts ShardTimeouts, contractId string, call func(ctx context.Context) error) error {
+	// buffered so that a late answer never blocks its sender
+	cb := make(chan error, 1)
+	ShardErrChanMap.Set(contractId, cb)
+	defer ShardErrChanMap.Remove(contractId)
+
+	go func() {
+		callCtx, cancel := context.WithTimeout(ctx, timeouts.InitCall)
+		defer cancel()
+		if err := call(callCtx); err != nil {
+			select {
+			case cb <- err:
+			default:
+			}
+		}
+	}()
+
+	// host needs to send recv in time, or the contract will be invalid.
+	timer := time.NewTimer(timeouts.Init)
+	defer timer.Stop()
+	select {
+	case err := <-cb:
+		return err
+	case <-timer.C:
+		return errHostTimeout
+	}
+}
+
+// renewalTerms returns the terms of acceptable in the token c was paid with,
+// the primary token for contracts from before tokens were recorded. It
+// returns false when that token is not acceptable, e.g. when the vault can't
+// cover it anymore.
+func renewalTerms(acceptable []shardTokenTerms, c *guardpb.Contract) (shardTokenTerms, bool) {
+	if c.Token == "" {
+		return acceptable[0], true
+	}
+	token := common.HexToAddress(c.Token)
+	for _, terms := range acceptable {
+		if terms.token == token {
+			return terms, true
+		}
+	}
+	return shardTokenTerms{}, false
+}
+
+// renewalStart returns when the renewal of c starts: at the end of c, or now
+// if c already expired.
+func renewalStart(c *guardpb.Contract, now time.Time) time.Time {
+	if c.RentEnd.After(now) {
+		return c.RentEnd
+	}
+	return now
+}
+
+// recordHostResult adds the outcome of a call to host to its reputation.
+// Calls cut short by ctx say nothing about the host.
+func recordHostResult(ctx context.Context, rss *sessions.RenterSession, host string, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	if rerr := helper.RecordHostResult(rss.CtxParams, host, err == nil); rerr != nil {
+		log.Debugf("host %s reputation not recorded: %s", host, rerr)
+	}
+}
+
+// shardSizeOverhead is the part of the size of the data shards which may be
+// taken by their DAG rather than by the file.
+const shardSizeOverhead = 0.01
+
+// validateShardSizes checks that shardSize, which every shard is priced at,
+// is the size of the shards. All of them must have that size but the last
+// data shard, which may be smaller. The dataShards first shards must hold the
+// fileSize bytes of the file, with at most the padding of the split and the
+// overhead of their DAG on top.
+func validateShardSizes(shardSize int64, fileSize int64, sizes []int64, dataShards int) error {
+	if dataShards <= 0 || dataShards > len(sizes) {
+		return fmt.Errorf("invalid number of data shards %d for %d shards", dataShards, len(sizes))
+	}
+	var dataSize int64
+	for i, sz := range sizes {
+		if i < dataShards {
+			dataSize += sz
+		}
+		if sz == shardSize || (i == dataShards-1 && sz < shardSize) {
+			continue
+		}
+		return fmt.Errorf("shard %d is %d bytes, it doesn't match the shard size %d the upload is priced with",
+			i, sz, shardSize)
+	}
+	tolerance := int64(dataShards) + int64(float64(dataSize)*shardSizeOverhead)
+	if dataSize < fileSize || dataSize-fileSize > tolerance {
+		return fmt.Errorf("the %d data shards hold %d bytes, they don't match the file size %d", dataShards,
+			dataSize, fileSize)
+	}
+	return nil
+}
+
+// shardTokenTerms is what a single shard costs when paid in a given token.
+type shardTokenTerms struct {
+	token  common.Address
+	price  int64
+	onePay int64
+}
+
+func getShardTokenTerms(token common.Address, shardSize int64, storageLength int) (shardTokenTerms, error) {
+	priceObj, err := chain.SettleObject.OracleService.CurrentPrice(token)
+	if err != nil {
+		return shardTokenTerms{}, err
+	}
+	onePay, err := shardPay(token, priceObj.Int64(), shardSize, storageLength)
+	if err != nil {
+		return shardTokenTerms{}, err
+	}
+	return shardTokenTerms{token: token, price: priceObj.Int64(), onePay: onePay}, nil
+}
+
+// payableShardTokens returns the fallback tokens the whole upload of shards
+// shards can be paid with, within maxTotalPay and the balance checkBalance
+// checks. Any shard may fall back to them, so each must cover all of them
+// like the primary token does. The others are dropped rather than failing
+// after contracting with a host.
+func payableShardTokens(fallbacks []shardTokenTerms, shards int, maxTotalPay int64,
+	checkBalance func(amount int64, token common.Address) error) []shardTokenTerms {
+	payable := make([]shardTokenTerms, 0, len(fallbacks))
+	for _, terms := range fallbacks {
+		totalPay := terms.onePay * int64(shards)
+		err := checkMaxTotalPay(totalPay, maxTotalPay)
+		if err == nil {
+			err = checkBalance(totalPay, terms.token)
+		}
+		if err != nil {
+			log.Infof("fallback token %s dropped: %s", terms.token.String(), err.Error())
+			continue
+		}
+		payable = append(payable, terms)
+	}
+	return payable
+}
+
+// pickShardToken returns the first acceptable token the host supports.
+func pickShardToken(acceptable []shardTokenTerms, hostTokens map[string]common.Address) (shardTokenTerms, bool) {
+	supported := make(map[common.Address]bool, len(hostTokens))
+	for _, v := range hostTokens {
+		supported[v] = true
+	}
+	for _, terms := range acceptable {
+		if supported[terms.token] {
+			return terms, true
+		}
+	}
+	return shardTokenTerms{}, false
+}
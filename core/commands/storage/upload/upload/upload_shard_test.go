@@ -0,0 +1,413 @@
+package upload
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+	"github.com/bittorrent/go-btfs/settlement/swap/vault"
+
+	guardpb "github.com/bittorrent/go-btfs-common/protos/guard"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	mdag "github.com/ipfs/go-merkledag"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+)
+
+func TestInitShardReleasesResources(t *testing.T) {
+	timeouts := ShardTimeouts{
+		SupportTokens: time.Second,
+		InitCall:      50 * time.Millisecond,
+		Init:          100 * time.Millisecond,
+	}
+	before := runtime.NumGoroutine()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 400; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			contractId := fmt.Sprintf("leak-test-%d", i)
+			var call func(ctx context.Context) error
+			switch i % 4 {
+			case 0:
+				// host rejects the init request
+				call = func(ctx context.Context) error {
+					return errors.New("rejected")
+				}
+			case 1:
+				// host never answers the p2p call
+				call = func(ctx context.Context) error {
+					<-ctx.Done()
+					return ctx.Err()
+				}
+			case 2:
+				// host accepts and confirms the contract
+				call = func(ctx context.Context) error {
+					go func() {
+						if ch, ok := ShardErrChanMap.Get(contractId); ok {
+							ch.(chan error) <- nil
+						}
+					}()
+					return nil
+				}
+			case 3:
+				// host accepts but never confirms
+				call = func(ctx context.Context) error {
+					return nil
+				}
+			}
+			_ = initShard(context.Background(), timeouts, contractId, call)
+		}(i)
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("goroutines leaked: %d before, %d after", before, n)
+	}
+	if n := ShardErrChanMap.Count(); n != 0 {
+		t.Fatalf("expected all contract channels to be released, %d left", n)
+	}
+}
+
+func TestCheckMaxTotalPay(t *testing.T) {
+	if err := checkMaxTotalPay(100, 0); err != nil {
+		t.Fatalf("expected no cap, got %v", err)
+	}
+	if err := checkMaxTotalPay(100, 100); err != nil {
+		t.Fatalf("expected pay at the cap to pass, got %v", err)
+	}
+	err := checkMaxTotalPay(101, 100)
+	if err == nil {
+		t.Fatal("expected pay above the cap to fail")
+	}
+	if !strings.Contains(err.Error(), "101") || !strings.Contains(err.Error(), "100") {
+		t.Fatalf("expected both amounts in the error, got %v", err)
+	}
+}
+
+func TestPayableShardTokens(t *testing.T) {
+	trx := common.HexToAddress("0x2")
+	usdd := common.HexToAddress("0x3")
+	usdt := common.HexToAddress("0x4")
+	fallbacks := []shardTokenTerms{
+		{token: trx, price: 1, onePay: 10},
+		{token: usdd, price: 1, onePay: 30},
+		{token: usdt, price: 1, onePay: 10},
+	}
+	// the vault can't cover the shards in usdt
+	checkBalance := func(amount int64, token common.Address) error {
+		if token == usdt {
+			return vault.ErrInsufficientFunds
+		}
+		return nil
+	}
+
+	// 3 shards cost 90 in usdd, above the cap
+	payable := payableShardTokens(fallbacks, 3, 50, checkBalance)
+	if len(payable) != 1 || payable[0].token != trx {
+		t.Fatalf("expected only trx to be payable, got %v", payable)
+	}
+	payable = payableShardTokens(fallbacks, 3, 0, checkBalance)
+	if len(payable) != 2 || payable[0].token != trx || payable[1].token != usdd {
+		t.Fatalf("expected trx and usdd to be payable without cap, got %v", payable)
+	}
+}
+
+func TestMinHosts(t *testing.T) {
+	for _, c := range []struct {
+		shards, maxPerHost, want int
+	}{
+		{30, 1, 30},
+		{30, 2, 15},
+		{30, 4, 8},
+		{3, 5, 1},
+	} {
+		if got := defaultMinHosts(c.shards, c.maxPerHost); got != c.want {
+			t.Errorf("%d shards, %d per host: expected %d hosts, got %d", c.shards, c.maxPerHost, c.want, got)
+		}
+	}
+
+	if err := checkMinHosts(30, 30); err != nil {
+		t.Fatalf("expected enough hosts, got %v", err)
+	}
+	err := checkMinHosts(12, 30)
+	if err == nil {
+		t.Fatal("expected too few hosts to fail")
+	}
+	if !strings.Contains(err.Error(), "12") || !strings.Contains(err.Error(), "30") {
+		t.Fatalf("expected both counts in the error, got %v", err)
+	}
+}
+
+func TestValidateShardSizes(t *testing.T) {
+	// 1000 bytes don't split evenly in 3 data shards of 334 bytes
+	const fileSize = 1000
+	for _, c := range []struct {
+		name      string
+		shardSize int64
+		sizes     []int64
+		ok        bool
+	}{
+		{"padded", 334, []int64{334, 334, 334, 334, 334}, true},
+		{"short last data shard", 334, []int64{334, 334, 332, 334, 334}, true},
+		{"uniform size too small", 300, []int64{334, 334, 334, 334, 334}, false},
+		{"uniform size too large", 400, []int64{334, 334, 334, 334, 334}, false},
+		{"short parity shard", 334, []int64{334, 334, 334, 334, 300}, false},
+		{"data shards missing part of the file", 334, []int64{334, 334, 100, 334, 334}, false},
+		{"data shards larger than the file", 400, []int64{400, 400, 400, 400, 400}, false},
+	} {
+		err := validateShardSizes(c.shardSize, fileSize, c.sizes, 3)
+		if c.ok && err != nil {
+			t.Errorf("%s: expected no error, got %v", c.name, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+	}
+
+	// copies of a file are shards of the whole file
+	if err := validateShardSizes(fileSize, fileSize, []int64{fileSize, fileSize}, 1); err != nil {
+		t.Fatalf("expected copies to pass, got %v", err)
+	}
+}
+
+func TestUploadShardOptionsRetry(t *testing.T) {
+	opts := DefaultUploadShardOptions()
+	if err := opts.validate(); err != nil {
+		t.Fatal(err)
+	}
+	opts.Retry.MaxInterval = 0
+	if err := opts.validate(); err == nil {
+		t.Fatal("expected zero retry interval to be rejected")
+	}
+
+	// backoffs are per shard, advancing one leaves the others untouched
+	a := helper.NewHandleShardBo(time.Minute, 10*time.Second)
+	b := helper.NewHandleShardBo(time.Minute, 10*time.Second)
+	for i := 0; i < 10; i++ {
+		a.NextBackOff()
+	}
+	if b.GetElapsedTime() > time.Second || b.NextBackOff() > 2*time.Second {
+		t.Fatal("expected an independent backoff")
+	}
+}
+
+type sliceHostsProvider struct {
+	hosts []string
+	calls int
+}
+
+func (p *sliceHostsProvider) NextValidHost() (string, error) {
+	if p.calls >= len(p.hosts) {
+		return "", errors.New("no more hosts")
+	}
+	p.calls++
+	return p.hosts[p.calls-1], nil
+}
+
+func TestShardReassignedWhenHostRejectsToken(t *testing.T) {
+	wbtt := common.HexToAddress("0x1")
+	trx := common.HexToAddress("0x2")
+	rejecting := test.RandPeerIDFatal(t)
+	accepting := test.RandPeerIDFatal(t)
+	hp := &sliceHostsProvider{hosts: []string{rejecting.String(), accepting.String()}}
+	supportTokens := func(ctx context.Context, hostPid peer.ID) ([]byte, error) {
+		if hostPid == rejecting {
+			return json.Marshal(map[string]common.Address{"TRX": trx})
+		}
+		return json.Marshal(map[string]common.Address{"WBTT": wbtt})
+	}
+	acceptable := []shardTokenTerms{{token: wbtt, price: 1, onePay: 1}}
+
+	var assigned string
+	bo := helper.NewHandleShardBo(time.Second, time.Millisecond)
+	err := backoff.Retry(func() error {
+		host, _, terms, err := nextShardHost(context.Background(), hp, acceptable, time.Second, supportTokens)
+		if errors.Is(err, errNoShardHost) {
+			return backoff.Permanent(err)
+		}
+		if err != nil {
+			return err
+		}
+		if terms.token != wbtt {
+			t.Fatalf("expected WBTT to be chosen, got %s", terms.token)
+		}
+		assigned = host
+		return nil
+	}, bo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assigned != accepting.String() {
+		t.Fatalf("expected shard to be assigned to %s, got %q", accepting, assigned)
+	}
+	if hp.calls != 2 {
+		t.Fatalf("expected 2 hosts to be tried, got %d", hp.calls)
+	}
+
+	// the first host alone never gets the shard
+	hp = &sliceHostsProvider{hosts: []string{rejecting.String()}}
+	if _, _, _, err := nextShardHost(context.Background(), hp, acceptable, time.Second, supportTokens); !errors.Is(err, errHostTokenUnsupported) {
+		t.Fatalf("expected errHostTokenUnsupported, got %v", err)
+	}
+	if _, _, _, err := nextShardHost(context.Background(), hp, acceptable, time.Second, supportTokens); !errors.Is(err, errNoShardHost) {
+		t.Fatalf("expected errNoShardHost, got %v", err)
+	}
+}
+
+func TestShardHostQuota(t *testing.T) {
+	q := newShardHostQuota(2)
+	if !q.reserve("a") || !q.reserve("a") {
+		t.Fatal("expected two shards to fit on host a")
+	}
+	if q.reserve("a") {
+		t.Fatal("expected a third shard on host a to be rejected")
+	}
+	if !q.reserve("b") {
+		t.Fatal("expected host b to be independent of host a")
+	}
+	// a failed setup gives the slot back
+	q.release("a")
+	if !q.reserve("a") {
+		t.Fatal("expected a released slot to be reusable")
+	}
+}
+
+func TestRenewalStart(t *testing.T) {
+	now := time.Now()
+	later := now.Add(24 * time.Hour)
+	c := &guardpb.Contract{ContractMeta: guardpb.ContractMeta{RentEnd: later}}
+	if start := renewalStart(c, now); !start.Equal(later) {
+		t.Fatalf("renewal of a running contract starts at %s, expected its end %s", start, later)
+	}
+	c.RentEnd = now.Add(-time.Hour)
+	if start := renewalStart(c, now); !start.Equal(now) {
+		t.Fatalf("renewal of an expired contract starts at %s, expected now %s", start, now)
+	}
+}
+
+func TestRenewalTerms(t *testing.T) {
+	trx := common.HexToAddress("0x2")
+	usdd := common.HexToAddress("0x3")
+	acceptable := []shardTokenTerms{{token: trx, onePay: 10}, {token: usdd, onePay: 20}}
+	if terms, ok := renewalTerms(acceptable, &guardpb.Contract{Token: usdd.Hex()}); !ok || terms.token != usdd {
+		t.Fatalf("expected a usdd contract to be renewed in usdd, got %v", terms)
+	}
+	if terms, ok := renewalTerms(acceptable, &guardpb.Contract{}); !ok || terms.token != trx {
+		t.Fatalf("expected a contract without token to be renewed in the primary token, got %v", terms)
+	}
+	if _, ok := renewalTerms(acceptable, &guardpb.Contract{Token: common.HexToAddress("0x4").Hex()}); ok {
+		t.Fatal("expected a contract in an unacceptable token not to be renewed")
+	}
+}
+
+func TestRenewTokens(t *testing.T) {
+	trx := common.HexToAddress("0x2")
+	usdd := common.HexToAddress("0x3")
+	usdt := common.HexToAddress("0x4")
+	token, fallbacks, err := renewTokens(map[int]*guardpb.Contract{
+		0: {Token: usdt.Hex()},
+		1: {Token: usdd.Hex()},
+		2: {Token: usdd.Hex()},
+		3: {Token: trx.Hex()},
+		4: {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != usdd || len(fallbacks) != 2 || fallbacks[0] != trx || fallbacks[1] != usdt {
+		t.Fatalf("expected usdd then trx and usdt, got %s then %v", token, fallbacks)
+	}
+	if _, _, err := renewTokens(map[int]*guardpb.Contract{0: {}}); err == nil {
+		t.Fatal("expected an error without any contract token")
+	}
+}
+
+func TestCheckRenewedContract(t *testing.T) {
+	host := test.RandPeerIDFatal(t)
+	accepted := &acceptedGuardContract{halfSigned: &guardpb.Contract{ContractMeta: guardpb.ContractMeta{
+		ContractId:    "c",
+		HostPid:       host.String(),
+		FileHash:      "file",
+		ShardHash:     "shard",
+		ShardIndex:    2,
+		ShardFileSize: 100,
+	}}}
+	if err := checkRenewedContract(accepted, host, "file", "shard", 100, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkRenewedContract(accepted, host, "file", "shard", 100, 3); err == nil {
+		t.Fatal("expected a contract for another shard to be rejected")
+	}
+	if err := checkRenewedContract(accepted, test.RandPeerIDFatal(t), "file", "shard", 100, 2); err == nil {
+		t.Fatal("expected a contract for another host to be rejected")
+	}
+}
+
+func TestShardStored(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	leaf := mdag.NodeWithData([]byte("leaf"))
+	root := mdag.NodeWithData([]byte("root"))
+	if err := root.AddNodeLink("leaf", leaf); err != nil {
+		t.Fatal(err)
+	}
+	for _, nd := range []*mdag.ProtoNode{leaf, root} {
+		if err := bs.Put(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stored, err := shardStored(ctx, bs, root.Cid()); err != nil || !stored {
+		t.Fatalf("expected the whole shard to be stored, got %v (%v)", stored, err)
+	}
+
+	// the root is left after its leaves are collected
+	if err := bs.DeleteBlock(ctx, leaf.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := shardStored(ctx, bs, root.Cid()); err != nil || stored {
+		t.Fatalf("expected a shard missing a leaf not to be stored, got %v (%v)", stored, err)
+	}
+}
+
+func TestCategorizeShardError(t *testing.T) {
+	signErr := sessions.WithErrorCategory(sessions.ErrCategoryContractSignFailed, errors.New("no key"))
+	for _, c := range []struct {
+		err  error
+		want sessions.ErrorCategory
+	}{
+		{fmt.Errorf("submit: %w", vault.ErrInsufficientFunds), sessions.ErrCategoryBalanceInsufficient},
+		{errHostTokenUnsupported, sessions.ErrCategoryTokenUnsupported},
+		{fmt.Errorf("timeout: failed to setup contract in 1m0s: %w", errHostTimeout), sessions.ErrCategoryHostTimeout},
+		{fmt.Errorf("query supported tokens: %w", context.DeadlineExceeded), sessions.ErrCategoryHostTimeout},
+		// an error already categorized keeps its category
+		{fmt.Errorf("timeout: failed to setup contract in 1m0s: %w", signErr), sessions.ErrCategoryContractSignFailed},
+		{errNoShardHost, ""},
+	} {
+		err := categorizeShardError(c.err)
+		if got := sessions.ErrorCategoryOf(err); got != c.want {
+			t.Errorf("%q: expected category %q, got %q", c.err, c.want, got)
+		}
+		// the underlying error is kept for the logs
+		if !errors.Is(err, c.err) || err.Error() != c.err.Error() {
+			t.Errorf("%q: underlying error lost, got %q", c.err, err)
+		}
+	}
+}
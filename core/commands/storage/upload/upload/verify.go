@@ -0,0 +1,184 @@
+package upload
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bittorrent/go-btfs/core/commands/storage/challenge"
+	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+	"github.com/bittorrent/go-btfs/core/corehttp/remote"
+	"github.com/bittorrent/go-btfs/utils"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+	"github.com/bittorrent/interface-go-btfs-core/options"
+
+	cidlib "github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"golang.org/x/sync/errgroup"
+)
+
+// ErrShardNotProven is returned when a host fails the possession challenge
+// of its shard after the upload.
+var ErrShardNotProven = errors.New("host failed to prove possession of shard")
+
+// StorageUploadChallengePath is the path hosts serve StorageUploadChallengeCmd
+// at to renters, over P2P only.
+const StorageUploadChallengePath = "/storage/upload/challenge"
+
+var StorageUploadChallengeCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Prove possession of an uploaded shard.",
+		ShortDescription: `
+This command (on host) answers the challenge sent by a renter right after an
+upload to check that the shard is stored. Only local blocks are used to solve
+the challenge, nothing is fetched from the network.`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("file-hash", true, false, "File root multihash of the uploaded file."),
+		cmds.StringArg("shard-hash", true, false, "Shard multihash stored at this host."),
+		cmds.StringArg("chunk-index", true, false, "Chunk index for this challenge."),
+		cmds.StringArg("nonce", true, false, "Nonce for this challenge. A random UUIDv4 string."),
+	},
+	RunTimeout: 1 * time.Minute,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		err := utils.CheckSimpleMode(env)
+		if err != nil {
+			return err
+		}
+
+		ctxParams, err := uh.ExtractContextParams(req, env)
+		if err != nil {
+			return err
+		}
+		if !ctxParams.Cfg.Experimental.StorageHostEnabled {
+			return fmt.Errorf("storage host api not enabled")
+		}
+		fileHash, err := cidlib.Parse(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		shardHash, err := cidlib.Parse(req.Arguments[1])
+		if err != nil {
+			return err
+		}
+		chunkIndex, err := strconv.Atoi(req.Arguments[2])
+		if err != nil {
+			return err
+		}
+		api, err := ctxParams.Api.WithOptions(options.Api.Offline(true))
+		if err != nil {
+			return err
+		}
+		sc, err := challenge.NewStorageChallengeResponse(req.Context, ctxParams.N, api, fileHash, shardHash, "", false, 0)
+		if err != nil {
+			return err
+		}
+		if err := sc.SolveChallenge(chunkIndex, req.Arguments[3]); err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &challenge.StorageChallengeRes{Answer: sc.Hash})
+	},
+	Type: challenge.StorageChallengeRes{},
+}
+
+// shardHost is a shard of a session along with the host storing it.
+type shardHost struct {
+	index int
+	hash  string
+	host  peer.ID
+}
+
+// verifyAfterUpload challenges the host of every shard of rss to prove it
+// stores the shard. It runs once the session is complete, when rss.Ctx is
+// already canceled, so it uses its own context. Each host call is bounded by
+// opts.Timeouts.Verify.
+func verifyAfterUpload(rss *sessions.RenterSession, opts *UploadShardOptions) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	root, err := cidlib.Parse(rss.Hash)
+	if err != nil {
+		return err
+	}
+	shards := make([]shardHost, 0, len(rss.ShardHashes))
+	for i, h := range rss.ShardHashes {
+		shard, err := sessions.GetRenterShard(rss.CtxParams, rss.SsId, h, i)
+		if err != nil {
+			return err
+		}
+		contracts, err := shard.Contracts()
+		if err != nil {
+			return err
+		}
+		host, err := peer.Decode(contracts.SignedGuardContract.HostPid)
+		if err != nil {
+			return err
+		}
+		shards = append(shards, shardHost{index: i, hash: h, host: host})
+	}
+
+	return verifyShards(ctx, shards, opts.Parallelism, func(ctx context.Context, s shardHost) error {
+		err := proveShard(ctx, rss.CtxParams, root, s, opts.Timeouts.Verify)
+		recordHostResult(ctx, rss, s.host.String(), err)
+		return err
+	})
+}
+
+// proveShard challenges the host of s to prove it stores the shard of the
+// file root. The host call is bounded by timeout.
+func proveShard(ctx context.Context, ctxParams *uh.ContextParams, root cidlib.Cid, s shardHost,
+	timeout time.Duration) error {
+	shardCid, err := cidlib.Parse(s.hash)
+	if err != nil {
+		return err
+	}
+	sc, err := challenge.NewStorageChallenge(ctx, ctxParams.N, ctxParams.Api, root, shardCid)
+	if err != nil {
+		return err
+	}
+	if err := sc.GenChallenge(); err != nil {
+		return err
+	}
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	output, err := remote.P2PCall(callCtx, ctxParams.N, ctxParams.Api, s.host, StorageUploadChallengePath,
+		root.String(),
+		s.hash,
+		sc.CIndex,
+		sc.Nonce,
+	)
+	if err != nil {
+		return err
+	}
+	var scr challenge.StorageChallengeRes
+	if err := json.Unmarshal(output, &scr); err != nil {
+		return err
+	}
+	if scr.Answer != sc.Hash {
+		return errors.New("wrong challenge answer")
+	}
+	return nil
+}
+
+// verifyShards runs prove for every shard, at most parallelism at once, and
+// returns the first failure.
+func verifyShards(ctx context.Context, shards []shardHost, parallelism int,
+	prove func(ctx context.Context, s shardHost) error) error {
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.SetLimit(parallelism)
+	for _, s := range shards {
+		s := s
+		eg.Go(func() error {
+			if err := prove(ctx, s); err != nil {
+				return fmt.Errorf("%w %d (%s) on %s: %v", ErrShardNotProven, s.index, s.hash, s.host, err)
+			}
+			return nil
+		})
+	}
+	return eg.Wait()
+}
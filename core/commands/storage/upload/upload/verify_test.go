@@ -0,0 +1,37 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestVerifyShards(t *testing.T) {
+	shards := []shardHost{{index: 0, hash: "a"}, {index: 1, hash: "b"}, {index: 2, hash: "c"}}
+
+	var mu sync.Mutex
+	proven := make(map[string]bool)
+	err := verifyShards(context.Background(), shards, 2, func(ctx context.Context, s shardHost) error {
+		mu.Lock()
+		defer mu.Unlock()
+		proven[s.hash] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proven) != len(shards) {
+		t.Fatalf("expected %d shards challenged, got %d", len(shards), len(proven))
+	}
+
+	err = verifyShards(context.Background(), shards, 2, func(ctx context.Context, s shardHost) error {
+		if s.hash == "b" {
+			return errors.New("wrong challenge answer")
+		}
+		return nil
+	})
+	if !errors.Is(err, ErrShardNotProven) {
+		t.Fatalf("expected ErrShardNotProven, got %v", err)
+	}
+}
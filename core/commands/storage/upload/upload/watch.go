@@ -0,0 +1,110 @@
+package upload
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+	"github.com/bittorrent/go-btfs/utils"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+)
+
+var StorageUploadWatchCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Stream the state transitions of an upload session.",
+		ShortDescription: `
+This command prints the current state of the session, then each state it
+enters as the upload goes, until it completes or fails. The contract of each
+shard is followed as well, from contract-signing to init-sent and confirmed, or
+errored, along with the host it is set up with. Only the transitions of
+sessions run by this node are seen. A client which reads too slowly misses
+transitions, the upload never waits for it.`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("session-id", true, false, "ID for the entire storage upload session."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		err := utils.CheckSimpleMode(env)
+		if err != nil {
+			return err
+		}
+		ctxParams, err := helper.ExtractContextParams(req, env)
+		if err != nil {
+			return err
+		}
+		if !ctxParams.Cfg.Experimental.StorageClientEnabled {
+			return fmt.Errorf("storage client api not enabled")
+		}
+		ssId := req.Arguments[0]
+		rss, err := sessions.GetRenterSession(ctxParams, ssId, "", make([]string, 0))
+		if err != nil {
+			return err
+		}
+
+		// subscribe before reading the status, not to miss a transition
+		events, unsubscribe := rss.Subscribe()
+		defer unsubscribe()
+		status, err := rss.Status()
+		if err != nil {
+			return err
+		}
+		if err := res.Emit(&sessions.SessionEvent{
+			SessionId: ssId,
+			To:        status.Status,
+			Message:   status.Message,
+			Time:      status.LastUpdated,
+		}); err != nil {
+			return err
+		}
+		if watchDone(status.Status) {
+			return nil
+		}
+		for {
+			select {
+			case ev := <-events:
+				if err := res.Emit(ev); err != nil {
+					return err
+				}
+				if watchDone(ev.To) {
+					return nil
+				}
+			case <-req.Context.Done():
+				return req.Context.Err()
+			}
+		}
+	},
+	Type: sessions.SessionEvent{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, ev *sessions.SessionEvent) error {
+			if sh := ev.Shard; sh != nil {
+				fmt.Fprintf(w, "[%s] shard %d %s", ev.Time.Format(time.RFC3339), sh.ShardIndex, sh.State)
+				if sh.HostID != "" {
+					fmt.Fprintf(w, " with host %s", sh.HostID)
+				}
+				if sh.Err != "" {
+					fmt.Fprintf(w, ": %s", sh.Err)
+				}
+				fmt.Fprintln(w)
+				return nil
+			}
+			fmt.Fprintf(w, "[%s] %s", ev.Time.Format(time.RFC3339), ev.To)
+			if ev.Category != "" {
+				fmt.Fprintf(w, " (%s)", ev.Category)
+			}
+			if ev.Message != "" {
+				fmt.Fprintf(w, ": %s", ev.Message)
+			}
+			fmt.Fprintln(w)
+			return nil
+		}),
+	},
+}
+
+// watchDone reports whether a session in status makes no more transitions
+// without being resumed.
+func watchDone(status string) bool {
+	return status == sessions.RssCompleteStatus || status == sessions.RssErrorStatus
+}
@@ -80,23 +80,27 @@ type IpfsNode struct {
 	IPLDFetcherFactory   fetcher.Factory           `name:"ipldFetcher"`   // fetcher that paths over the IPLD data model
 	UnixFSFetcherFactory fetcher.Factory           `name:"unixfsFetcher"` // fetcher that interprets UnixFS data
 	Reporter             *metrics.BandwidthCounter `optional:"true"`
+	DataBandwidth        *node.DataBandwidth       // bytes moved by adds and bitswap
 	Discovery            discovery.Service         `optional:"true"`
 	FilesRoot            *mfs.Root
+	FilesBatch           *node.FilesBatch // suspends the writes of the MFS root for bulk operations
 	RecordValidator      record.Validator
 	// Statestore      storage.StateStorer
 
 	// Online
-	PeerHost      p2phost.Host               `optional:"true"` // the network host (server+client)
-	Peering       peering.PeeringService     `optional:"true"`
-	Filters       *ma.Filters                `optional:"true"`
-	Bootstrapper  io.Closer                  `optional:"true"` // the periodic bootstrapper
-	Routing       irouting.ProvideManyRouter `optional:"true"` // the routing system. recommend ipfs-dht
-	DNSResolver   *madns.Resolver            // the DNS resolver
-	Exchange      exchange.Interface         // the block exchange + strategy (bitswap)
-	Namesys       namesys.NameSystem         // the name system, resolves paths to hashes
-	Provider      provider.System            // the value provider system
-	IpnsRepub     *ipnsrp.Republisher        `optional:"true"`
-	GraphExchange graphsync.GraphExchange    `optional:"true"`
+	PeerHost       p2phost.Host                `optional:"true"` // the network host (server+client)
+	Peering        peering.PeeringService      `optional:"true"`
+	Filters        *ma.Filters                 `optional:"true"`
+	Bootstrapper   io.Closer                   `optional:"true"` // the periodic bootstrapper
+	Routing        irouting.ProvideManyRouter  `optional:"true"` // the routing system. recommend ipfs-dht
+	Routers        irouting.NamedRouters       `optional:"true"` // the routers which can be targeted by name
+	DNSResolver    *madns.Resolver             // the DNS resolver
+	Exchange       exchange.Interface          // the block exchange + strategy (bitswap)
+	BitswapProvide *node.BitswapProvideControl `optional:"true"` // toggles bitswap providing at runtime
+	Namesys        namesys.NameSystem          // the name system, resolves paths to hashes
+	Provider       provider.System             // the value provider system
+	IpnsRepub      *ipnsrp.Republisher         `optional:"true"`
+	GraphExchange  graphsync.GraphExchange     `optional:"true"`
 
 	ResourceManager network.ResourceManager `optional:"true"`
 
@@ -63,6 +63,7 @@ type CoreAPI struct {
 	peerHost             p2phost.Host
 	recordValidator      record.Validator
 	exchange             exchange.Interface
+	bandwidth            *node.DataBandwidth
 
 	namesys namesys.NameSystem
 	routing routing.Routing
@@ -177,6 +178,7 @@ func (api *CoreAPI) WithOptions(opts ...options.ApiOption) (coreiface.CoreAPI, e
 		namesys:         n.Namesys,
 		recordValidator: n.RecordValidator,
 		exchange:        n.Exchange,
+		bandwidth:       n.DataBandwidth,
 		routing:         n.Routing,
 
 		provider: n.Provider,
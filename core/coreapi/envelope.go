@@ -0,0 +1,123 @@
+package coreapi
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	ecies "github.com/bittorrent/go-eccrypto"
+)
+
+// envelopeCipher is the only cipher of the content of an envelope so far.
+const envelopeCipher = "aes-256-gcm"
+
+var (
+	errNotEncrypted = errors.New("the file is not encrypted")
+	errNotRecipient = errors.New("the file is not encrypted for this private key")
+)
+
+// encryptEnvelope describes a file encrypted for several recipients. It is
+// stored in the token metadata of the file under "Envelope":
+//
+//	{
+//	  "Envelope": {
+//	    "Cipher": "aes-256-gcm",
+//	    "Nonce": "<hex>",
+//	    "Recipients": [
+//	      {"PublicKey": "<hex>", "Key": "<ciphertext>", "Metadata": {<ecies metadata>}},
+//	      ...
+//	    ]
+//	  }
+//	}
+//
+// The content of the file is encrypted once with a random 32 bytes key,
+// using AES-256-GCM with the nonce and no additional data. The hex encoded
+// key is then encrypted with ECIES for each recipient, exactly like a file
+// encrypted for a single public key, with Key and Metadata holding the
+// ciphertext and the metadata ecies.Encrypt returns.
+type encryptEnvelope struct {
+	Cipher     string
+	Nonce      string
+	Recipients []envelopeRecipient
+}
+
+type envelopeRecipient struct {
+	PublicKey string
+	Key       string
+	Metadata  *ecies.EciesMetadata
+}
+
+// sealEnvelope encrypts plaintext for all the public keys.
+func sealEnvelope(pubKeys []string, plaintext []byte) (*encryptEnvelope, []byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+	gcm, err := envelopeGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+
+	env := &encryptEnvelope{
+		Cipher:     envelopeCipher,
+		Nonce:      hex.EncodeToString(nonce),
+		Recipients: make([]envelopeRecipient, 0, len(pubKeys)),
+	}
+	for _, pk := range pubKeys {
+		ct, meta, err := ecies.Encrypt(pk, []byte(hex.EncodeToString(key)))
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't encrypt for public key %s: %w", pk, err)
+		}
+		env.Recipients = append(env.Recipients, envelopeRecipient{PublicKey: pk, Key: ct, Metadata: meta})
+	}
+	return env, gcm.Seal(nil, nonce, plaintext, nil), nil
+}
+
+// open decrypts ciphertext with the first recipient key privKey can
+// decrypt.
+func (env *encryptEnvelope) open(privKey string, ciphertext []byte) ([]byte, error) {
+	if env.Cipher != envelopeCipher {
+		return nil, fmt.Errorf("unsupported envelope cipher %q", env.Cipher)
+	}
+	nonce, err := hex.DecodeString(env.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range env.Recipients {
+		if r.Metadata == nil {
+			continue
+		}
+		hexKey, err := ecies.Decrypt(privKey, r.Key, r.Metadata)
+		if err != nil {
+			continue
+		}
+		key, err := hex.DecodeString(hexKey)
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := envelopeGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(nonce) != gcm.NonceSize() {
+			return nil, fmt.Errorf("invalid envelope nonce length %d", len(nonce))
+		}
+		return gcm.Open(nil, nonce, ciphertext, nil)
+	}
+	return nil, errNotRecipient
+}
+
+func envelopeGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
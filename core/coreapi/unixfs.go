@@ -34,6 +34,7 @@ import (
 	cidutil "github.com/ipfs/go-cidutil"
 	filestore "github.com/ipfs/go-filestore"
 	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offlinexch "github.com/ipfs/go-ipfs-exchange-offline"
 	ipld "github.com/ipfs/go-ipld-format"
 	dag "github.com/ipfs/go-merkledag"
 	dagtest "github.com/ipfs/go-merkledag/test"
@@ -102,6 +103,16 @@ func (api *UnixfsAPI) Add(ctx context.Context, filesNode files.Node, opts ...opt
 	exch := api.exchange
 	pinning := api.pinning
 
+	provide, err := api.provideStrategy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if provide != coreunix.ProvideAll {
+		// bitswap announces every block it stores, an offline exchange
+		// stores them without announcing
+		exch = offlinexch.Exchange(addblockstore)
+	}
+
 	if settings.OnlyHash {
 		node, err := getOrCreateNilNode()
 		if err != nil {
@@ -113,7 +124,26 @@ func (api *UnixfsAPI) Add(ctx context.Context, filesNode files.Node, opts ...opt
 	}
 
 	bserv := blockservice.New(addblockstore, exch) // hash security 001
-	dserv := dag.NewDAGService(bserv)
+	var dserv ipld.DAGService = dag.NewDAGService(bserv)
+	// count what reaches the blockstore, after the encryption and whatever
+	// the wrappers below leave out
+	bandwidth := api.bandwidth
+	if settings.OnlyHash {
+		bandwidth = nil
+	}
+	if bandwidth != nil && !settings.NoCopy {
+		dserv = &countDagService{DAGService: dserv, count: bandwidth.AddOut}
+	}
+	if stats := coreunix.GetDedupStats(ctx); stats != nil {
+		dserv = coreunix.NewDedupStatsDAGService(dserv, addblockstore, stats)
+	}
+	dedupAgainst := coreunix.GetDedupAgainst(ctx)
+	if dedupAgainst != nil && !settings.OnlyHash {
+		dserv = coreunix.NewDedupAgainstDAGService(dserv, addblockstore, dedupAgainst)
+	}
+	if car := coreunix.GetCarBuilder(ctx); car != nil {
+		dserv = coreunix.NewCarDAGService(dserv, car)
+	}
 
 	// add a sync call to the DagService
 	// this ensures that data written to the DagService is persisted to the underlying datastore
@@ -147,7 +177,8 @@ func (api *UnixfsAPI) Add(ctx context.Context, filesNode files.Node, opts ...opt
 		fileAdder.Out = settings.Events
 		fileAdder.Progress = settings.Progress
 	}
-	fileAdder.Pin = settings.Pin && !settings.OnlyHash
+	// pinning would fetch the leaves left to the remote peer
+	fileAdder.Pin = settings.Pin && !settings.OnlyHash && dedupAgainst == nil
 	fileAdder.Silent = settings.Silent
 	fileAdder.RawLeaves = settings.RawLeaves
 	fileAdder.NoCopy = settings.NoCopy
@@ -157,6 +188,32 @@ func (api *UnixfsAPI) Add(ctx context.Context, filesNode files.Node, opts ...opt
 	fileAdder.PreserveMtime = settings.PreserveMtime
 	fileAdder.FileMode = settings.Mode
 	fileAdder.FileMtime = settings.Mtime
+	fileAdder.MaxDepth = coreunix.GetMaxDepth(ctx)
+	fileAdder.Exclude = coreunix.GetExcludePatterns(ctx)
+	fileAdder.RecordSHA256 = coreunix.GetRecordSHA256(ctx)
+	fileAdder.SkipPinned = coreunix.GetSkipPinned(ctx)
+	fileAdder.PreserveOwner = coreunix.GetPreserveOwner(ctx)
+	fileAdder.ShardThreshold = coreunix.GetShardThreshold(ctx)
+	if codec := coreunix.GetRootCodec(ctx); codec != 0 {
+		if _, ok := filesNode.(files.Directory); ok && codec != cid.DagProtobuf {
+			return nil, errors.New("the root of a directory is always dag-pb")
+		}
+		fileAdder.RootCodec = codec
+	}
+	// encrypted files are counted before they are encrypted, the adder only
+	// reads the ciphertext
+	if bandwidth != nil && !settings.Encrypt {
+		fileAdder.CountRead = bandwidth.AddIn
+	}
+	if !settings.OnlyHash {
+		if fileAdder.Policy, err = api.addPolicy(); err != nil {
+			return nil, err
+		}
+		fileAdder.Name = coreunix.GetAddName(ctx)
+	}
+	if coreunix.GetResume(ctx) && !settings.OnlyHash {
+		fileAdder.Checkpoints = api.repo.Datastore()
+	}
 
 	switch settings.Layout {
 	case options.BalancedLayout:
@@ -184,10 +241,38 @@ func (api *UnixfsAPI) Add(ctx context.Context, filesNode files.Node, opts ...opt
 			return nil, err
 		}
 
-		fileAdder.SetMfsRoot(mr)
+		fileAdder.SetMfsRootDAG(mr, md)
 	}
 
-	if settings.Encrypt {
+	recipients, multi := coreunix.GetEncryptRecipients(ctx)
+	if settings.Encrypt && multi {
+		pubKeys, err := recipientPubKeys(recipients)
+		if err != nil {
+			return nil, err
+		}
+		switch f := filesNode.(type) {
+		case files.File:
+			bytes, err := ioutil.ReadAll(f)
+			if err != nil {
+				return nil, err
+			}
+			if bandwidth != nil {
+				bandwidth.AddIn(len(bytes))
+			}
+			env, ciphertext, err := sealEnvelope(pubKeys, bytes)
+			if err != nil {
+				return nil, err
+			}
+			settings.TokenMetadata, err = api.appendMetaMap(settings.TokenMetadata,
+				map[string]interface{}{"Envelope": env})
+			if err != nil {
+				return nil, err
+			}
+			filesNode = files.NewBytesFile(ciphertext)
+		default:
+			return nil, notSupport(f)
+		}
+	} else if settings.Encrypt {
 		pubKey := settings.Pubkey
 		if pubKey == "" {
 			peerId := settings.PeerId
@@ -196,7 +281,7 @@ func (api *UnixfsAPI) Add(ctx context.Context, filesNode files.Node, opts ...opt
 			}
 			pubKey, err = peerId2pubkey(peerId)
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("can't resolve the public key of peer %s: %w", peerId, err)
 			}
 		}
 		// log.Infof("The file will be encrypted with pubkey: %s", settings.Pubkey)
@@ -206,6 +291,9 @@ func (api *UnixfsAPI) Add(ctx context.Context, filesNode files.Node, opts ...opt
 			if err != nil {
 				return nil, err
 			}
+			if bandwidth != nil {
+				bandwidth.AddIn(len(bytes))
+			}
 
 			ciphertext, metadata, err := ecies.Encrypt(pubKey, bytes)
 			if err != nil {
@@ -232,6 +320,7 @@ func (api *UnixfsAPI) Add(ctx context.Context, filesNode files.Node, opts ...opt
 
 	if settings.PinDuration != 0 {
 		fileAdder.PinDuration = settings.PinDuration
+		fileAdder.PinRootOnly = coreunix.GetPinRootOnly(ctx)
 	}
 	// This block is intentionally placed here so that
 	// any execution case can append metadata
@@ -271,8 +360,13 @@ func (api *UnixfsAPI) Add(ctx context.Context, filesNode files.Node, opts ...opt
 	if nd == nil {
 		return nil, errors.New("unexpected nil value for ipld.Node")
 	}
+	if fileAdder.Pin && settings.PinDuration > 0 {
+		if err := coreunix.SetPinDuration(ctx, api.repo.Datastore(), nd.Cid(), settings.PinDuration); err != nil {
+			return nil, err
+		}
+	}
 
-	if !settings.OnlyHash {
+	if !settings.OnlyHash && provide != coreunix.ProvideNone {
 		if err := api.provider.Provide(nd.Cid()); err != nil {
 			return nil, err
 		}
@@ -285,6 +379,23 @@ func notSupport(f interface{}) error {
 	return fmt.Errorf("not support: %v", f)
 }
 
+// recipientPubKeys returns the public keys of all the recipients, resolving
+// the peer IDs.
+func recipientPubKeys(recipients coreunix.EncryptRecipients) ([]string, error) {
+	pubKeys := append([]string(nil), recipients.PublicKeys...)
+	for _, id := range recipients.PeerIds {
+		pk, err := peerId2pubkey(id)
+		if err != nil {
+			return nil, fmt.Errorf("can't resolve the public key of peer %s: %w", id, err)
+		}
+		pubKeys = append(pubKeys, pk)
+	}
+	if len(pubKeys) == 0 {
+		return nil, errors.New("no recipient to encrypt for")
+	}
+	return pubKeys, nil
+}
+
 func peerId2pubkey(peerId string) (string, error) {
 	id, err := peer.Decode(peerId)
 	if err != nil {
@@ -336,21 +447,40 @@ func (api *UnixfsAPI) Get(ctx context.Context, p path.Path, opts ...options.Unix
 			if err != nil {
 				return nil, err
 			}
+			if len(mbytes) == 0 {
+				return nil, errNotEncrypted
+			}
 
-			t := &ecies.EciesMetadata{}
-			err = json.Unmarshal(mbytes, t)
+			bytes, err := ioutil.ReadAll(f)
 			if err != nil {
 				return nil, err
 			}
 
-			bytes, err := ioutil.ReadAll(f)
+			var sealed struct{ Envelope *encryptEnvelope }
+			if err := json.Unmarshal(mbytes, &sealed); err != nil {
+				return nil, err
+			}
+			if sealed.Envelope != nil {
+				plaintext, err := sealed.Envelope.open(privKey, bytes)
+				if err != nil {
+					return nil, err
+				}
+				node = files.NewBytesFile(plaintext)
+				break
+			}
+
+			t := &ecies.EciesMetadata{}
+			err = json.Unmarshal(mbytes, t)
 			if err != nil {
 				return nil, err
 			}
+			if len(t.Iv) == 0 || len(t.EphemPublicKey) == 0 {
+				return nil, errNotEncrypted
+			}
 
 			s, err := ecies.Decrypt(privKey, string(bytes), t)
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("%w: %s", errNotRecipient, err)
 			}
 			node = files.NewBytesFile([]byte(s))
 		default:
@@ -733,6 +863,36 @@ func (api *UnixfsAPI) GetMetadata(ctx context.Context, p path.Path) ([]byte, err
 	}
 }
 
+// provideStrategy returns the provide strategy of the add, the one set in
+// ctx or else the one of the config.
+func (api *UnixfsAPI) provideStrategy(ctx context.Context) (coreunix.ProvideStrategy, error) {
+	if p := coreunix.GetProvideStrategy(ctx); p != "" {
+		return p, nil
+	}
+	v, err := api.repo.GetConfigKey(coreunix.ProvideStrategyKey)
+	if err != nil {
+		// the key is absent from the config file when no strategy is set
+		return coreunix.ProvideAll, nil
+	}
+	s, _ := v.(string)
+	p, err := coreunix.ParseProvideStrategy(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s: %w", coreunix.ProvideStrategyKey, err)
+	}
+	return p, nil
+}
+
+// addPolicy returns the add policy of the config, read from the config file
+// so that it can be changed at runtime, or nil if none is set.
+func (api *UnixfsAPI) addPolicy() (*coreunix.AddPolicy, error) {
+	v, err := api.repo.GetConfigKey(coreunix.AddPolicyKey)
+	if err != nil {
+		// the key is absent from the config file when no policy is set
+		return nil, nil
+	}
+	return coreunix.ParseAddPolicy(v)
+}
+
 func (api *UnixfsAPI) appendMetaMap(tokenMetadata string, metaMap map[string]interface{}) (string, error) {
 	if metaMap == nil {
 		return "", nil
@@ -773,3 +933,27 @@ type syncDagService struct {
 func (s *syncDagService) Sync() error {
 	return s.syncFn()
 }
+
+// countDagService calls count with the size of the nodes added through it.
+type countDagService struct {
+	ipld.DAGService
+	count func(n int)
+}
+
+func (s *countDagService) Add(ctx context.Context, nd ipld.Node) error {
+	if err := s.DAGService.Add(ctx, nd); err != nil {
+		return err
+	}
+	s.count(len(nd.RawData()))
+	return nil
+}
+
+func (s *countDagService) AddMany(ctx context.Context, nds []ipld.Node) error {
+	if err := s.DAGService.AddMany(ctx, nds); err != nil {
+		return err
+	}
+	for _, nd := range nds {
+		s.count(len(nd.RawData()))
+	}
+	return nil
+}
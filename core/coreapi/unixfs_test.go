@@ -1,9 +1,13 @@
 package coreapi
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"testing"
 
 	"github.com/bittorrent/interface-go-btfs-core/path"
+	ci "github.com/libp2p/go-libp2p/core/crypto"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -51,3 +55,44 @@ func TestSplitPath(t *testing.T) {
 		})
 	}
 }
+
+func TestEncryptEnvelope(t *testing.T) {
+	var privKeys, pubKeys []string
+	for i := 0; i < 3; i++ {
+		priv, pub, err := ci.GenerateSecp256k1Key(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		privBytes, err := ci.MarshalPrivateKey(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubBytes, err := ci.MarshalPublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		privKeys = append(privKeys, hex.EncodeToString(privBytes[4:]))
+		pubKeys = append(pubKeys, hex.EncodeToString(pubBytes[4:]))
+	}
+
+	plaintext := []byte("sealed for two recipients")
+	env, ciphertext, err := sealEnvelope(pubKeys[:2], plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(env.Recipients) != 2 {
+		t.Fatalf("expected 2 recipients, got %d", len(env.Recipients))
+	}
+	for _, privKey := range privKeys[:2] {
+		b, err := env.open(privKey, ciphertext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, plaintext) {
+			t.Fatalf("unexpected plaintext %q", b)
+		}
+	}
+	if _, err := env.open(privKeys[2], ciphertext); err == nil {
+		t.Fatal("expected a key which isn't a recipient to fail")
+	}
+}
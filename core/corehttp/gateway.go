@@ -121,7 +121,12 @@ func newGatewayBackend(n *core.IpfsNode) (gateway.IPFSBackend, error) {
 		}
 	}
 
-	gw, err := gateway.NewBlocksGateway(bserv, gateway.WithValueStore(vsRouting), gateway.WithNameSystem(nsys))
+	limits, err := node.GatewayFetchLimits(n.Repo.GetConfigKey)
+	if err != nil {
+		return nil, err
+	}
+
+	gw, err := gateway.NewBlocksGateway(bserv, gateway.WithValueStore(vsRouting), gateway.WithNameSystem(nsys), gateway.WithFetchLimits(limits))
 	if err != nil {
 		return nil, err
 	}
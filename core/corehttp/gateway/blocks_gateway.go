@@ -12,6 +12,7 @@ import (
 	"go.uber.org/multierr"
 
 	files "github.com/bittorrent/go-btfs-files"
+	"github.com/bittorrent/go-btfs/core/fetchlimit"
 	"github.com/bittorrent/go-btfs/namesys"
 	"github.com/bittorrent/go-btfs/namesys/resolve"
 	ufile "github.com/bittorrent/go-unixfs/file"
@@ -60,8 +61,9 @@ type BlocksGateway struct {
 var _ IPFSBackend = (*BlocksGateway)(nil)
 
 type gwOptions struct {
-	ns namesys.NameSystem
-	vs routing.ValueStore
+	ns     namesys.NameSystem
+	vs     routing.ValueStore
+	limits fetchlimit.Limits
 }
 
 // WithNameSystem sets the name system to use for the gateway. If not set it will use a default DNSLink resolver
@@ -73,6 +75,15 @@ func WithNameSystem(ns namesys.NameSystem) BlockGatewayOption {
 	}
 }
 
+// WithFetchLimits caps the links followed and blocks loaded when resolving
+// a path. The default is unlimited.
+func WithFetchLimits(limits fetchlimit.Limits) BlockGatewayOption {
+	return func(opts *gwOptions) error {
+		opts.limits = limits
+		return nil
+	}
+}
+
 // WithValueStore sets the ValueStore to use for the gateway
 func WithValueStore(vs routing.ValueStore) BlockGatewayOption {
 	return func(opts *gwOptions) error {
@@ -102,7 +113,7 @@ func NewBlocksGateway(blockService blockservice.BlockService, opts ...BlockGatew
 		}
 		return basicnode.Prototype.Any, nil
 	})
-	fetcher := fetcherConfig.WithReifier(unixfsnode.Reify)
+	fetcher := fetchlimit.NewFactory(fetcherConfig.WithReifier(unixfsnode.Reify), compiledOptions.limits, blockService.Blockstore())
 	r := resolver.NewBasicResolver(fetcher)
 
 	// Setup a name system so that we are able to resolve /ipns links.
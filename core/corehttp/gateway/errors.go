@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/bittorrent/go-btfs/core/fetchlimit"
 	"github.com/ipfs/go-cid"
 	ipld "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/go-path/resolver"
@@ -145,6 +146,9 @@ func webError(w http.ResponseWriter, err error, defaultCode int) {
 		code = http.StatusNotFound
 	case errors.Is(err, context.DeadlineExceeded):
 		code = http.StatusGatewayTimeout
+	case errors.Is(err, fetchlimit.ErrLimitExceeded):
+		// retrying won't help, the content needs more than the gateway allows
+		code = http.StatusUnprocessableEntity
 	}
 
 	// Handle explicit code in ErrorResponse
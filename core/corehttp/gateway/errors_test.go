@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/bittorrent/go-btfs/core/fetchlimit"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -62,4 +63,11 @@ func TestWebError(t *testing.T) {
 		assert.Equal(t, http.StatusServiceUnavailable, w.Result().StatusCode)
 		assert.Equal(t, "50", w.Result().Header.Get("Retry-After"))
 	})
+
+	t.Run("422 Unprocessable Entity when a fetch limit is exceeded", func(t *testing.T) {
+		err := fmt.Errorf("resolving: %w", &fetchlimit.LimitError{Limit: "blocks", Max: 10})
+		w := httptest.NewRecorder()
+		webError(w, err, http.StatusInternalServerError)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Result().StatusCode)
+	})
 }
@@ -0,0 +1,110 @@
+package corerepo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/bittorrent/go-btfs/core"
+	"github.com/bittorrent/go-btfs/core/node"
+
+	cid "github.com/ipfs/go-cid"
+	pin "github.com/ipfs/go-ipfs-pinner"
+)
+
+// PinSnapshotVersion is the version of the format written by ExportPins.
+const PinSnapshotVersion = 1
+
+// PinSnapshot is a backup of the pin set. Indirect pins are not listed, they
+// are restored by pinning the recursive roots again.
+type PinSnapshot struct {
+	Version   int
+	Recursive []cid.Cid
+	Direct    []cid.Cid
+}
+
+// ExportPins writes a snapshot of the pin set of n to w as JSON. The pin lock
+// is held while the snapshot is taken so that GC and concurrent adds can't
+// leave it half updated, and the pinner and blockstore are flushed first so
+// that the snapshot only refers to persisted pins.
+func ExportPins(ctx context.Context, n *core.IpfsNode, w io.Writer) (*PinSnapshot, error) {
+	defer n.GCLocker.PinLock(ctx).Unlock(ctx)
+
+	if err := n.Pinning.Flush(ctx); err != nil {
+		return nil, err
+	}
+	if err := node.SyncBlockstore(ctx, n.Repo.Datastore()); err != nil {
+		return nil, err
+	}
+
+	rkeys, err := n.Pinning.RecursiveKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dkeys, err := n.Pinning.DirectKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	snap := &PinSnapshot{
+		Version:   PinSnapshotVersion,
+		Recursive: rkeys,
+		Direct:    dkeys,
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(snap); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// ImportPins pins everything listed in a snapshot written by ExportPins.
+// Objects missing locally are fetched if n is online. Objects already pinned
+// accordingly are skipped. It returns the number of new pins.
+func ImportPins(ctx context.Context, n *core.IpfsNode, r io.Reader) (int, error) {
+	var snap PinSnapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return 0, fmt.Errorf("invalid pin snapshot: %w", err)
+	}
+	if snap.Version != PinSnapshotVersion {
+		return 0, fmt.Errorf("unsupported pin snapshot version %d", snap.Version)
+	}
+
+	defer n.GCLocker.PinLock(ctx).Unlock(ctx)
+
+	count := 0
+	pinAll := func(keys []cid.Cid, recursive bool) error {
+		// a recursive pin is only satisfied by another recursive pin, a
+		// direct one by any pin
+		mode := pin.Any
+		if recursive {
+			mode = pin.Recursive
+		}
+		for _, c := range keys {
+			if _, pinned, err := n.Pinning.IsPinnedWithType(ctx, c, mode); err != nil {
+				return err
+			} else if pinned {
+				continue
+			}
+			nd, err := n.DAG.Get(ctx, c)
+			if err != nil {
+				return fmt.Errorf("pin %s: %w", c, err)
+			}
+			if err := n.Pinning.Pin(ctx, nd, recursive); err != nil {
+				return fmt.Errorf("pin %s: %w", c, err)
+			}
+			count++
+		}
+		return nil
+	}
+	// recursive first, so that direct pins covered by them are skipped
+	if err := pinAll(snap.Recursive, true); err != nil {
+		return count, err
+	}
+	if err := pinAll(snap.Direct, false); err != nil {
+		return count, err
+	}
+	return count, n.Pinning.Flush(ctx)
+}
@@ -0,0 +1,163 @@
+package corerepo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+// ScrubCursorKey is the datastore key holding the last block checked by a
+// scrub, so that an interrupted scrub continues after it. It is removed once
+// a scrub goes through all the blocks.
+var ScrubCursorKey = datastore.NewKey("/local/repo-scrub")
+
+// scrubCheckpointInterval is how many blocks are checked between two saves of
+// the scrub cursor.
+const scrubCheckpointInterval = 1024
+
+// ErrBlockMismatch is reported for a block whose content doesn't hash to its
+// CID.
+var ErrBlockMismatch = errors.New("block content doesn't match its CID")
+
+// ScrubOptions configures Scrub.
+type ScrubOptions struct {
+	// Sample is the percentage of the blocks to check, picked at random.
+	// 100 checks all of them.
+	Sample float64
+	// Corrupt is called for every block which doesn't match its CID.
+	// Returning an error stops the scrub.
+	Corrupt func(ctx context.Context, c cid.Cid, err error) error
+	// Unreadable is called for every block which can't be read, which
+	// doesn't tell whether it is corrupt. Returning an error stops the scrub.
+	Unreadable func(ctx context.Context, c cid.Cid, err error) error
+}
+
+// ScrubStats counts the blocks a scrub went through.
+type ScrubStats struct {
+	Checked    uint64
+	Corrupt    uint64
+	Unreadable uint64
+	// Skipped counts the blocks already checked by the interrupted scrub
+	// this one continues.
+	Skipped uint64
+}
+
+// Scrub reads every block of bs, or a sample of them, and checks that its
+// content hashes to its CID. Blocks are gone through in the order of the
+// datastore, and the last one seen is saved in ds under ScrubCursorKey every
+// so often, so that a scrub interrupted by ctx continues after it. A scrub
+// whose last block is gone since starts over.
+func Scrub(ctx context.Context, bs bstore.Blockstore, ds datastore.Datastore, opts ScrubOptions) (ScrubStats, error) {
+	cursor, err := ds.Get(ctx, ScrubCursorKey)
+	if err == datastore.ErrNotFound {
+		cursor = nil
+	} else if err != nil {
+		return ScrubStats{}, err
+	}
+	stats, found, err := scrubAfter(ctx, bs, ds, cursor, opts)
+	if err == nil && !found {
+		stats, _, err = scrubAfter(ctx, bs, ds, nil, opts)
+	}
+	if err != nil {
+		return stats, err
+	}
+	return stats, ds.Delete(ctx, ScrubCursorKey)
+}
+
+// scrubAfter checks the blocks of bs which come after cursor, or all of them
+// if it is nil. found is false if the cursor was never seen, in which case
+// no block was checked.
+func scrubAfter(ctx context.Context, bs bstore.Blockstore, ds datastore.Datastore, cursor []byte,
+	opts ScrubOptions) (stats ScrubStats, found bool, err error) {
+	// stops listing the keys when returning early
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	keys, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		return stats, false, err
+	}
+
+	found = cursor == nil
+	var last []byte
+	unsaved := 0
+	save := func() error {
+		unsaved = 0
+		if last == nil {
+			return nil
+		}
+		return ds.Put(ctx, ScrubCursorKey, last)
+	}
+	for k := range keys {
+		if !found {
+			stats.Skipped++
+			found = bytes.Equal(k.Hash(), cursor)
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			if serr := save(); serr != nil {
+				log.Errorf("can't save the scrub cursor: %s", serr)
+			}
+			return stats, found, err
+		}
+		if opts.Sample >= 100 || rand.Float64()*100 < opts.Sample {
+			stats.Checked++
+			err := scrubBlock(ctx, bs, k)
+			switch {
+			case err == nil:
+			case errors.Is(err, ErrBlockMismatch):
+				stats.Corrupt++
+				if opts.Corrupt != nil {
+					if err := opts.Corrupt(ctx, k, err); err != nil {
+						return stats, found, err
+					}
+				}
+			default:
+				stats.Unreadable++
+				if opts.Unreadable != nil {
+					if err := opts.Unreadable(ctx, k, err); err != nil {
+						return stats, found, err
+					}
+				}
+			}
+		}
+		last = k.Hash()
+		if unsaved++; unsaved >= scrubCheckpointInterval {
+			if err := save(); err != nil {
+				return stats, found, err
+			}
+		}
+	}
+	// the keys stop early when ctx is done
+	if err := ctx.Err(); err != nil {
+		if serr := save(); serr != nil {
+			log.Errorf("can't save the scrub cursor: %s", serr)
+		}
+		return stats, found, err
+	}
+	return stats, found, nil
+}
+
+// scrubBlock returns ErrBlockMismatch when the block at c doesn't hash to
+// it, or the error reading it.
+func scrubBlock(ctx context.Context, bs bstore.Blockstore, c cid.Cid) error {
+	b, err := bs.Get(ctx, c)
+	if err == bstore.ErrHashMismatch {
+		return ErrBlockMismatch
+	}
+	if err != nil {
+		return err
+	}
+	sum, err := c.Prefix().Sum(b.RawData())
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sum.Hash(), c.Hash()) {
+		return ErrBlockMismatch
+	}
+	return nil
+}
@@ -2,16 +2,19 @@ package coreunix
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	gopath "path"
 	"strconv"
+	"strings"
 	"time"
 
-	chunker "github.com/bittorrent/go-btfs-chunker"
 	files "github.com/bittorrent/go-btfs-files"
 	"github.com/bittorrent/go-mfs"
 	"github.com/bittorrent/go-unixfs"
@@ -23,6 +26,7 @@ import (
 	coreiface "github.com/bittorrent/interface-go-btfs-core"
 	"github.com/bittorrent/interface-go-btfs-core/path"
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
 	bstore "github.com/ipfs/go-ipfs-blockstore"
 	pin "github.com/ipfs/go-ipfs-pinner"
 	posinfo "github.com/ipfs/go-ipfs-posinfo"
@@ -38,6 +42,87 @@ const progressReaderIncrement = 1024 * 256
 
 var liveCacheSize = uint64(256 << 10)
 
+// ErrDepthLimitExceeded indicates that the max depth has been exceeded.
+var ErrDepthLimitExceeded = fmt.Errorf("depth limit exceeded")
+
+type maxDepthKey struct{}
+
+// SetMaxDepth limits how many directory levels below the added root the
+// adder descends into. A negative depth means unlimited.
+func SetMaxDepth(ctx context.Context, depth int) context.Context {
+	return context.WithValue(ctx, maxDepthKey{}, depth)
+}
+
+// GetMaxDepth returns the depth set by SetMaxDepth, or -1 if none was set.
+func GetMaxDepth(ctx context.Context) int {
+	if depth, ok := ctx.Value(maxDepthKey{}).(int); ok {
+		return depth
+	}
+	return -1
+}
+
+type pinRootOnlyKey struct{}
+
+// SetPinRootOnly makes the adder pin only the root CID of the add.
+func SetPinRootOnly(ctx context.Context, rootOnly bool) context.Context {
+	return context.WithValue(ctx, pinRootOnlyKey{}, rootOnly)
+}
+
+// GetPinRootOnly returns the value set by SetPinRootOnly.
+func GetPinRootOnly(ctx context.Context) bool {
+	rootOnly, _ := ctx.Value(pinRootOnlyKey{}).(bool)
+	return rootOnly
+}
+
+type recordSHA256Key struct{}
+
+// SetRecordSHA256 makes the adder report the SHA-256 of the content of every
+// added file.
+func SetRecordSHA256(ctx context.Context, record bool) context.Context {
+	return context.WithValue(ctx, recordSHA256Key{}, record)
+}
+
+// GetRecordSHA256 returns the value set by SetRecordSHA256.
+func GetRecordSHA256(ctx context.Context) bool {
+	record, _ := ctx.Value(recordSHA256Key{}).(bool)
+	return record
+}
+
+type skipPinnedKey struct{}
+
+// SetSkipPinned gives the adder the expected CIDs of files, by path
+// relative to the added root. See Adder.SkipPinned.
+func SetSkipPinned(ctx context.Context, hints map[string]cid.Cid) context.Context {
+	return context.WithValue(ctx, skipPinnedKey{}, hints)
+}
+
+// GetSkipPinned returns the CIDs set by SetSkipPinned, or nil.
+func GetSkipPinned(ctx context.Context) map[string]cid.Cid {
+	hints, _ := ctx.Value(skipPinnedKey{}).(map[string]cid.Cid)
+	return hints
+}
+
+// EncryptRecipients lists who a file added with encryption is encrypted for,
+// by public key or by peer ID.
+type EncryptRecipients struct {
+	PublicKeys []string
+	PeerIds    []string
+}
+
+type encryptRecipientsKey struct{}
+
+// SetEncryptRecipients makes an encrypted add seal the file for all the
+// recipients, in place of the single public key or peer ID of the options.
+func SetEncryptRecipients(ctx context.Context, recipients EncryptRecipients) context.Context {
+	return context.WithValue(ctx, encryptRecipientsKey{}, recipients)
+}
+
+// GetEncryptRecipients returns the recipients set by SetEncryptRecipients.
+func GetEncryptRecipients(ctx context.Context) (EncryptRecipients, bool) {
+	recipients, ok := ctx.Value(encryptRecipientsKey{}).(EncryptRecipients)
+	return recipients, ok
+}
+
 type Link struct {
 	Name, Hash string
 	Size       uint64
@@ -67,6 +152,7 @@ func NewAdder(ctx context.Context, p pin.Pinner, bs bstore.GCLocker, ds ipld.DAG
 		Chunker:          "",
 		TokenMetadata:    "",
 		PinDuration:      0,
+		MaxDepth:         -1,
 	}, nil
 }
 
@@ -96,11 +182,61 @@ type Adder struct {
 	liveNodes        uint64
 	TokenMetadata    string
 	PinDuration      int64
-
+	// PinRootOnly pins only the root CID (direct pin) instead of the whole
+	// DAG, so the blocks below it follow the default GC.
+	PinRootOnly bool
+	// MaxDepth is the number of directory levels below the added root
+	// that may be added. A negative value means unlimited.
+	MaxDepth int
+	// Exclude holds glob patterns of directory entries to skip.
+	Exclude []string
+	// RecordSHA256 hashes the content of every file as it is read, and
+	// sends an AddChecksumEvent in place of its AddEvent.
+	RecordSHA256 bool
+	// SkipPinned holds the expected CIDs of files by path. A file whose
+	// expected CID is pinned isn't read, the pinned node is added in its
+	// place and an AddSkippedEvent is sent. The CID is only a hint, nothing
+	// checks it matches the content of the file.
+	SkipPinned map[string]cid.Cid
+	// Checkpoints stores the leaves of the files being added, so that the
+	// add of a file which failed can continue from its last checkpoint
+	// instead of chunking the file again. Files are identified by path,
+	// size and modification time only, see SetResume.
+	Checkpoints datastore.Datastore
+	checkpoint  *checkpointDAGService
+	// Policy, if set, rejects files before they are added, see AddPolicy.
+	Policy *AddPolicy
+	// Name is the name of the added node, the add policy checks it for a
+	// file added alone.
+	Name string
+
+	// Mode and mtime are resolved independently of each other: when
+	// PreserveMode (PreserveMtime) is set the value is read from each added
+	// file, otherwise FileMode (FileMtime) is stored as given.
 	PreserveMtime bool
 	PreserveMode  bool
 	FileMode      os.FileMode
 	FileMtime     time.Time
+	// PreserveOwner records the uid and gid of each added file, read from
+	// its stat, under OwnerMetadataKey in its token metadata. Directories,
+	// symlinks and files with no stat, e.g. received by a daemon, have no
+	// owner recorded.
+	PreserveOwner bool
+	owner         *FileOwner
+	// ShardThreshold, if set, HAMT shards the added directories whose links
+	// take that many bytes or more and only those, whatever
+	// Experimental.ShardingEnabled, so that their CIDs don't depend on the
+	// config of the node.
+	ShardThreshold int
+	resharded      *resharder
+	// RootCodec, if set, is the codec of the root of an added file, one of
+	// RootCodecs. Directories always have dag-pb roots.
+	RootCodec uint64
+	// CountRead, if set, is called with the number of bytes read from the
+	// added files as they are read.
+	CountRead func(n int)
+	// mfsDag holds the directories, when set by SetMfsRootDAG
+	mfsDag ipld.DAGService
 }
 
 func (adder *Adder) GcLocker() bstore.GCLocker {
@@ -126,9 +262,16 @@ func (adder *Adder) SetMfsRoot(r *mfs.Root) {
 	adder.mroot = r
 }
 
+// SetMfsRootDAG is SetMfsRoot for a root whose directories are stored in ds
+// rather than in the DAGService of the adder, e.g. when only hashing.
+func (adder *Adder) SetMfsRootDAG(r *mfs.Root, ds ipld.DAGService) {
+	adder.mroot = r
+	adder.mfsDag = ds
+}
+
 // Constructs a node from reader's data, and adds it. Doesn't pin.
 func (adder *Adder) add(reader io.Reader, dirTreeBytes []byte) (ipld.Node, error) {
-	chnk, err := chunker.FromString(reader, adder.Chunker)
+	chnk, err := newSplitter(reader, adder.Chunker)
 	if err != nil {
 		return nil, err
 	}
@@ -143,6 +286,12 @@ func (adder *Adder) add(reader io.Reader, dirTreeBytes []byte) (ipld.Node, error
 			return nil, err
 		}
 	}
+	if adder.owner != nil && dirTreeBytes == nil {
+		metaBytes, err = adder.appendMetadataObject(metaBytes, map[string]*FileOwner{OwnerMetadataKey: adder.owner})
+		if err != nil {
+			return nil, err
+		}
+	}
 	// This `if conditional statement` makes sure this block is
 	// executed only one time for directory addition use case.
 	if adder.MetadataDag == nil {
@@ -175,8 +324,12 @@ func (adder *Adder) add(reader io.Reader, dirTreeBytes []byte) (ipld.Node, error
 	// Merge regular metadata and encoded directory tree.
 	metaBytes = ftutil.CreateMetadataList(metaBytes, dirTreeBytes)
 
+	var dagserv ipld.DAGService = adder.bufferedDS
+	if adder.checkpoint != nil {
+		dagserv = adder.checkpoint
+	}
 	params := ihelper.DagBuilderParams{
-		Dagserv:       adder.bufferedDS,
+		Dagserv:       dagserv,
 		RawLeaves:     adder.RawLeaves,
 		Maxlinks:      ihelper.DefaultLinksPerBlock,
 		NoCopy:        adder.NoCopy,
@@ -220,6 +373,11 @@ func (adder *Adder) add(reader io.Reader, dirTreeBytes []byte) (ipld.Node, error
 	if err != nil {
 		return nil, err
 	}
+	if dirTreeBytes == nil {
+		if nd, err = adder.applyRootCodec(nd); err != nil {
+			return nil, err
+		}
+	}
 
 	return nd, adder.bufferedDS.Commit()
 }
@@ -285,7 +443,11 @@ func (adder *Adder) PinRoot(ctx context.Context, root ipld.Node) error {
 		}
 		adder.tempRoot = rnk
 	}
-	adder.pinning.PinWithMode(rnk, pin.Recursive)
+	mode := pin.Recursive
+	if adder.PinRootOnly {
+		mode = pin.Direct
+	}
+	adder.pinning.PinWithMode(rnk, mode)
 	return adder.pinning.Flush(ctx)
 }
 
@@ -318,6 +480,11 @@ func (adder *Adder) outputDirs(path string, fsn mfs.FSNode) error {
 		if err != nil {
 			return err
 		}
+		if adder.resharded != nil {
+			if out, ok := adder.resharded.done[nd.Cid()]; ok {
+				nd = out
+			}
+		}
 
 		return outputDagnode(adder.Out, path, nd)
 	default:
@@ -326,6 +493,14 @@ func (adder *Adder) outputDirs(path string, fsn mfs.FSNode) error {
 }
 
 func (adder *Adder) addNode(node ipld.Node, path string) error {
+	return adder.addNodeWithOutput(node, path, func(node ipld.Node, path string) error {
+		return outputFileDagnode(adder.Out, path, node, adder.FileMode, adder.FileMtime)
+	})
+}
+
+// addNodeWithOutput is like addNode, with output sending the event of the
+// added node unless the adder is silent.
+func (adder *Adder) addNodeWithOutput(node ipld.Node, path string, output func(node ipld.Node, path string) error) error {
 	// patch it into the root
 	if path == "" {
 		path = node.Cid().String()
@@ -367,7 +542,7 @@ func (adder *Adder) addNode(node ipld.Node, path string) error {
 	}
 
 	if !adder.Silent {
-		return outputDagnode(adder.Out, path, node)
+		return output(node, path)
 	}
 	return nil
 }
@@ -446,6 +621,17 @@ func (adder *Adder) addToMfs(ctx context.Context, file files.Node) (ipld.Node, e
 	if err != nil {
 		return nil, err
 	}
+	if adder.ShardThreshold > 0 {
+		var dserv ipld.DAGService = adder.dagService
+		if adder.mfsDag != nil {
+			// the rebuilt directories are added like the files
+			dserv = fallbackDAG{DAGService: adder.dagService, fallback: adder.mfsDag}
+		}
+		adder.resharded = newResharder(dserv, adder.ShardThreshold)
+		if nd, err = adder.resharded.reshard(ctx, nd); err != nil {
+			return nil, err
+		}
+	}
 
 	// output directory events
 	err = adder.outputDirs(name, root)
@@ -482,6 +668,13 @@ func (adder *Adder) addFileNode(ctx context.Context, path string, file files.Nod
 		adder.FileMode = file.Mode()
 	}
 
+	if adder.PreserveOwner {
+		adder.owner = nil
+		if fi, ok := file.(files.FileInfo); ok {
+			adder.owner = StatOwner(fi.Stat())
+		}
+	}
+
 	if adder.liveNodes >= liveCacheSize {
 		// TODO: A smarter cache that uses some sort of lru cache with an eviction handler
 		mr, err := adder.mfsRoot()
@@ -502,6 +695,12 @@ func (adder *Adder) addFileNode(ctx context.Context, path string, file files.Nod
 	case *files.Symlink:
 		return adder.addSymlink(path, f)
 	case files.File:
+		if c, ok := adder.SkipPinned[path]; ok {
+			skipped, err := adder.addPinned(ctx, path, c)
+			if err != nil || skipped {
+				return err
+			}
+		}
 		return adder.addFile(path, f)
 	default:
 		return errors.New("unknown file type")
@@ -521,13 +720,58 @@ func (adder *Adder) addSymlink(path string, l *files.Symlink) error {
 		return err
 	}
 
-	return adder.addNode(dagnode, path)
+	// the link is stored as is, never followed, so that dangling links and
+	// links out of the added directory are kept too
+	return adder.addNodeWithOutput(dagnode, path, func(node ipld.Node, path string) error {
+		return outputSymlinkDagnode(adder.Out, path, node, l.Target)
+	})
+}
+
+// addPinned adds the node of c at path in place of the file, if c is pinned
+// with all its blocks, and reports whether it did.
+func (adder *Adder) addPinned(ctx context.Context, path string, c cid.Cid) (bool, error) {
+	mode, pinned, err := adder.pinning.IsPinned(ctx, c)
+	if err != nil {
+		return false, err
+	}
+	// a direct pin doesn't keep the blocks below the root
+	if !pinned || mode == "direct" {
+		return false, nil
+	}
+	nd, err := adder.dagService.Get(ctx, c)
+	if err != nil {
+		return false, err
+	}
+	log.Debugf("skipping %s, already pinned as %s", path, c)
+	return true, adder.addNodeWithOutput(nd, path, func(node ipld.Node, path string) error {
+		return outputSkippedDagnode(adder.Out, path, node)
+	})
 }
 
 func (adder *Adder) addFile(path string, file files.File) error {
 	// if the progress flag was specified, wrap the file so that we can send
 	// progress updates to the client (over the output channel)
 	var reader io.Reader = file
+	if adder.Checkpoints != nil && adder.TokenMetadata == "" && adder.owner == nil {
+		var err error
+		if reader, err = adder.resumeFile(path, file); err != nil {
+			return err
+		}
+	}
+	if adder.Policy != nil {
+		var err error
+		if reader, err = adder.applyPolicy(path, file, reader); err != nil {
+			return err
+		}
+	}
+	var sum hash.Hash
+	if adder.RecordSHA256 {
+		sum = sha256.New()
+		reader = newHashReader(reader, sum)
+	}
+	if adder.CountRead != nil {
+		reader = newCountReader(reader, adder.CountRead)
+	}
 	if adder.Progress {
 		rdr := &progressReader{file: reader, path: path, out: adder.Out}
 		if fi, ok := file.(files.FileInfo); ok {
@@ -538,12 +782,20 @@ func (adder *Adder) addFile(path string, file files.File) error {
 	}
 
 	dagnode, err := adder.add(reader, nil)
+	if adder.checkpoint != nil {
+		err = adder.finishCheckpoint(err)
+	}
 	if err != nil {
 		return err
 	}
 
 	// patch it into the root
-	return adder.addNode(dagnode, path)
+	if sum == nil {
+		return adder.addNode(dagnode, path)
+	}
+	return adder.addNodeWithOutput(dagnode, path, func(node ipld.Node, path string) error {
+		return outputChecksumDagnode(adder.Out, path, node, adder.FileMode, adder.FileMtime, hex.EncodeToString(sum.Sum(nil)))
+	})
 }
 
 func (adder *Adder) addDir(ctx context.Context, path string, dir files.Directory, toplevel bool) error {
@@ -567,6 +819,14 @@ func (adder *Adder) addDir(ctx context.Context, path string, dir files.Directory
 	it := dir.Entries()
 	for it.Next() {
 		fpath := gopath.Join(path, it.Name())
+		if adder.excluded(fpath) {
+			continue
+		}
+		if _, ok := it.Node().(files.Directory); ok {
+			if err := adder.checkDepth(fpath); err != nil {
+				return err
+			}
+		}
 		err := adder.addFileNode(ctx, fpath, it.Node(), false)
 		if err != nil {
 			return err
@@ -576,6 +836,19 @@ func (adder *Adder) addDir(ctx context.Context, path string, dir files.Directory
 	return it.Err()
 }
 
+// checkDepth returns ErrDepthLimitExceeded if the directory at path,
+// relative to the added root, is more than MaxDepth levels below it. Files
+// don't make a level, so the ones of the deepest directories are added.
+func (adder *Adder) checkDepth(path string) error {
+	if adder.MaxDepth < 0 {
+		return nil
+	}
+	if depth := strings.Count(path, "/") + 1; depth > adder.MaxDepth {
+		return fmt.Errorf("%w: %s", ErrDepthLimitExceeded, path)
+	}
+	return nil
+}
+
 // convertMetadataToBytes converts token metadata in JSON string to
 // byte array in JSON encoding.
 func (adder *Adder) convertMetadataToBytes(checkString bool) ([]byte, error) {
@@ -651,6 +924,126 @@ func outputDagnode(out chan<- interface{}, name string, dn ipld.Node) error {
 	return nil
 }
 
+// AddMtimeEvent is sent in place of a coreiface.AddEvent for every file
+// whose mtime has a sub-second part, which coreiface.AddEvent.Mtime drops.
+type AddMtimeEvent struct {
+	coreiface.AddEvent
+	MtimeNsec uint32
+}
+
+// outputFileDagnode is like outputDagnode but also reports the UnixFS mode
+// and mtime stored in the file node.
+func outputFileDagnode(out chan<- interface{}, name string, dn ipld.Node, mode os.FileMode, mtime time.Time) error {
+	if out == nil {
+		return nil
+	}
+
+	o, err := getOutput(dn)
+	if err != nil {
+		return err
+	}
+
+	o.Name = name
+	o.Mode = mode
+	if !mtime.IsZero() {
+		o.Mtime = mtime.Unix()
+		if nsec := mtime.Nanosecond(); nsec != 0 {
+			out <- &AddMtimeEvent{
+				AddEvent:  *o,
+				MtimeNsec: uint32(nsec),
+			}
+			return nil
+		}
+	}
+	out <- o
+
+	return nil
+}
+
+// AddSymlinkEvent is sent in place of a coreiface.AddEvent for every
+// symlink added, with the path it points to.
+type AddSymlinkEvent struct {
+	coreiface.AddEvent
+	Target string
+}
+
+func outputSymlinkDagnode(out chan<- interface{}, name string, dn ipld.Node, target string) error {
+	if out == nil {
+		return nil
+	}
+
+	o, err := getOutput(dn)
+	if err != nil {
+		return err
+	}
+
+	o.Name = name
+	out <- &AddSymlinkEvent{
+		AddEvent: *o,
+		Target:   target,
+	}
+
+	return nil
+}
+
+// AddSkippedEvent is sent in place of a coreiface.AddEvent for every file
+// skipped because its expected CID is already pinned.
+type AddSkippedEvent struct {
+	coreiface.AddEvent
+}
+
+func outputSkippedDagnode(out chan<- interface{}, name string, dn ipld.Node) error {
+	if out == nil {
+		return nil
+	}
+
+	o, err := getOutput(dn)
+	if err != nil {
+		return err
+	}
+
+	o.Name = name
+	out <- &AddSkippedEvent{AddEvent: *o}
+
+	return nil
+}
+
+// AddChecksumEvent is sent in place of a coreiface.AddEvent for every file
+// added with RecordSHA256, with the SHA-256 of its content. MtimeNsec is the
+// sub-second part of the mtime, as in AddMtimeEvent.
+type AddChecksumEvent struct {
+	coreiface.AddEvent
+	SHA256    string
+	MtimeNsec uint32
+}
+
+func outputChecksumDagnode(out chan<- interface{}, name string, dn ipld.Node, mode os.FileMode, mtime time.Time,
+	sum string) error {
+	if out == nil {
+		return nil
+	}
+
+	o, err := getOutput(dn)
+	if err != nil {
+		return err
+	}
+
+	o.Name = name
+	o.Mode = mode
+	var nsec uint32
+	if !mtime.IsZero() {
+		o.Mtime = mtime.Unix()
+		nsec = uint32(mtime.Nanosecond())
+	}
+	out <- &AddChecksumEvent{
+		AddEvent:  *o,
+		SHA256:    sum,
+		MtimeNsec: nsec,
+	}
+
+	return nil
+}
+
 // from core/commands/object.go
 func getOutput(dagnode ipld.Node) (*coreiface.AddEvent, error) {
 	c := dagnode.Cid()
@@ -690,6 +1083,66 @@ func (i *progressReader) Read(p []byte) (int, error) {
 	return n, err
 }
 
+// newHashReader returns a reader writing everything read from file to h,
+// which keeps the files.FileInfo of file needed by the filestore.
+func newHashReader(file io.Reader, h hash.Hash) io.Reader {
+	r := &hashReader{file: io.TeeReader(file, h)}
+	if fi, ok := file.(files.FileInfo); ok {
+		return &hashReader2{r, fi}
+	}
+	return r
+}
+
+type hashReader struct {
+	file io.Reader
+}
+
+func (r *hashReader) Read(p []byte) (int, error) {
+	return r.file.Read(p)
+}
+
+type hashReader2 struct {
+	*hashReader
+	files.FileInfo
+}
+
+func (r *hashReader2) Read(p []byte) (int, error) {
+	return r.hashReader.Read(p)
+}
+
+// newCountReader returns a reader calling count with the number of bytes
+// read from file, which keeps the files.FileInfo of file needed by the
+// filestore.
+func newCountReader(file io.Reader, count func(n int)) io.Reader {
+	r := &countReader{file: file, count: count}
+	if fi, ok := file.(files.FileInfo); ok {
+		return &countReader2{r, fi}
+	}
+	return r
+}
+
+type countReader struct {
+	file  io.Reader
+	count func(n int)
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.file.Read(p)
+	if n > 0 {
+		r.count(n)
+	}
+	return n, err
+}
+
+type countReader2 struct {
+	*countReader
+	files.FileInfo
+}
+
+func (r *countReader2) Read(p []byte) (int, error) {
+	return r.countReader.Read(p)
+}
+
 type progressReader2 struct {
 	*progressReader
 	files.FileInfo
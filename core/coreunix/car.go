@@ -0,0 +1,135 @@
+package coreunix
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	gocar "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	gocarv2 "github.com/ipld/go-car/v2"
+)
+
+// CarBuilder collects the blocks of an add to write them out as a CARv2
+// once the roots are known. Blocks are spooled to a temporary file.
+type CarBuilder struct {
+	mu   sync.Mutex
+	seen *cid.Set
+	tmp  *os.File
+}
+
+// NewCarBuilder spools blocks into a temporary file in dir, or in the
+// default temporary directory if dir is empty. Close removes it.
+func NewCarBuilder(dir string) (*CarBuilder, error) {
+	tmp, err := os.CreateTemp(dir, "btfs-add-*.blocks")
+	if err != nil {
+		return nil, err
+	}
+	return &CarBuilder{seen: cid.NewSet(), tmp: tmp}, nil
+}
+
+func (b *CarBuilder) put(nd ipld.Node) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.seen.Visit(nd.Cid()) {
+		return nil
+	}
+	return carutil.LdWrite(b.tmp, nd.Cid().Bytes(), nd.RawData())
+}
+
+// WriteTo writes a CARv2 with the given roots and all collected blocks to
+// w and returns its size.
+func (b *CarBuilder) WriteTo(w io.Writer, roots ...cid.Cid) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// go-car can only index and wrap a CARv1 once it is complete on disk.
+	dir := filepath.Dir(b.tmp.Name())
+	v1, err := os.CreateTemp(dir, "btfs-add-*.car")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(v1.Name())
+	defer v1.Close()
+
+	if err := gocar.WriteHeader(&gocar.CarHeader{Roots: roots, Version: 1}, v1); err != nil {
+		return 0, err
+	}
+	if _, err := b.tmp.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if _, err := io.Copy(v1, b.tmp); err != nil {
+		return 0, err
+	}
+	if err := v1.Close(); err != nil {
+		return 0, err
+	}
+
+	v2Name := v1.Name() + "v2"
+	defer os.Remove(v2Name)
+	if err := gocarv2.WrapV1File(v1.Name(), v2Name); err != nil {
+		return 0, err
+	}
+	v2, err := os.Open(v2Name)
+	if err != nil {
+		return 0, err
+	}
+	defer v2.Close()
+	return io.Copy(w, v2)
+}
+
+// Close removes the spooled blocks.
+func (b *CarBuilder) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err := b.tmp.Close()
+	if rerr := os.Remove(b.tmp.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+type carBuilderKey struct{}
+
+// SetCarBuilder makes the adder copy every block it writes into b.
+func SetCarBuilder(ctx context.Context, b *CarBuilder) context.Context {
+	return context.WithValue(ctx, carBuilderKey{}, b)
+}
+
+// GetCarBuilder returns the builder set by SetCarBuilder, or nil.
+func GetCarBuilder(ctx context.Context) *CarBuilder {
+	b, _ := ctx.Value(carBuilderKey{}).(*CarBuilder)
+	return b
+}
+
+// carDAGService copies added nodes into a CarBuilder before handing them to
+// the underlying DAGService.
+type carDAGService struct {
+	ipld.DAGService
+	car *CarBuilder
+}
+
+// NewCarDAGService wraps ds so that every added node is also collected by b.
+func NewCarDAGService(ds ipld.DAGService, b *CarBuilder) ipld.DAGService {
+	return &carDAGService{DAGService: ds, car: b}
+}
+
+func (d *carDAGService) Add(ctx context.Context, nd ipld.Node) error {
+	if err := d.car.put(nd); err != nil {
+		return err
+	}
+	return d.DAGService.Add(ctx, nd)
+}
+
+func (d *carDAGService) AddMany(ctx context.Context, nds []ipld.Node) error {
+	for _, nd := range nds {
+		if err := d.car.put(nd); err != nil {
+			return err
+		}
+	}
+	return d.DAGService.AddMany(ctx, nds)
+}
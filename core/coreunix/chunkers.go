@@ -0,0 +1,108 @@
+package coreunix
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	chunker "github.com/bittorrent/go-btfs-chunker"
+)
+
+// SplitterFunc makes the splitter of a registered chunker for r. params is
+// what follows the name and its dash in the chunker string: "" for
+// --chunker=name and "64-512" for --chunker=name-64-512.
+//
+// The splitter returns the chunks of r from NextBytes, then io.EOF. Its
+// ChunkSize is the size of the chunks, or 0 if it varies, and its MetaData
+// is nil unless the chunks need metadata to be read back, like the
+// reed-solomon ones. SetIsDir is called before the first chunk when r is the
+// encoded tree of a directory rather than a file.
+type SplitterFunc func(r io.Reader, params string) (chunker.Splitter, error)
+
+// builtinChunkers are the chunkers chunker.FromString parses. Registered
+// chunkers can't take their names.
+var builtinChunkers = []string{"size", "rabin", "buzhash", "reed-solomon"}
+
+var (
+	chunkersLk sync.RWMutex
+	chunkers   = make(map[string]SplitterFunc)
+)
+
+// RegisterChunker makes --chunker=name, and name-[params], split files with
+// the splitters of f. It is meant to be called from an init function, the
+// name must not contain dashes nor be one of the built-in chunkers.
+func RegisterChunker(name string, f SplitterFunc) error {
+	if name == "" || strings.Contains(name, "-") {
+		return fmt.Errorf("invalid chunker name %q: it must be non-empty and without dashes", name)
+	}
+	if f == nil {
+		return fmt.Errorf("chunker %s registered without a splitter", name)
+	}
+	for _, b := range builtinChunkers {
+		if name == b {
+			return fmt.Errorf("chunker %s is built in", name)
+		}
+	}
+	chunkersLk.Lock()
+	defer chunkersLk.Unlock()
+	if _, ok := chunkers[name]; ok {
+		return fmt.Errorf("chunker %s is already registered", name)
+	}
+	chunkers[name] = f
+	return nil
+}
+
+// Chunkers returns the names of the built-in and registered chunkers.
+func Chunkers() []string {
+	chunkersLk.RLock()
+	registered := make([]string, 0, len(chunkers))
+	for name := range chunkers {
+		registered = append(registered, name)
+	}
+	chunkersLk.RUnlock()
+	sort.Strings(registered)
+	return append(append([]string{}, builtinChunkers...), registered...)
+}
+
+// CheckChunker fails with the available chunkers if s doesn't name one of
+// them. The parameters of s are only checked by its splitter.
+func CheckChunker(s string) error {
+	_, _, err := lookupChunker(s)
+	return err
+}
+
+// lookupChunker returns the registered chunker named by s and its params,
+// or a nil SplitterFunc for a built-in chunker.
+func lookupChunker(s string) (SplitterFunc, string, error) {
+	if s == "" {
+		return nil, "", nil
+	}
+	for _, b := range builtinChunkers {
+		if s == b || strings.HasPrefix(s, b+"-") {
+			return nil, "", nil
+		}
+	}
+	name, params, _ := strings.Cut(s, "-")
+	chunkersLk.RLock()
+	f, ok := chunkers[name]
+	chunkersLk.RUnlock()
+	if !ok {
+		return nil, "", fmt.Errorf("unknown chunker %q, available chunkers: %s", s, strings.Join(Chunkers(), ", "))
+	}
+	return f, params, nil
+}
+
+// newSplitter returns the splitter of r for the chunker string s, built in
+// or registered.
+func newSplitter(r io.Reader, s string) (chunker.Splitter, error) {
+	f, params, err := lookupChunker(s)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		return chunker.FromString(r, s)
+	}
+	return f(r, params)
+}
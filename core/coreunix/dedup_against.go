@@ -0,0 +1,115 @@
+package coreunix
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// DedupAgainst skips storing the leaf blocks of an add which a remote peer
+// already has. The nodes with links are still stored, so that the DAG can be
+// walked locally, the skipped leaves are fetched from the network when read.
+type DedupAgainst struct {
+	// Has tells, in order, which of cids the peer has.
+	Has func(ctx context.Context, cids []cid.Cid) ([]bool, error)
+
+	skippedBlocks uint64
+	skippedBytes  uint64
+}
+
+// SkippedBlocks returns the number of leaves not stored because the peer
+// has them.
+func (d *DedupAgainst) SkippedBlocks() uint64 {
+	return atomic.LoadUint64(&d.skippedBlocks)
+}
+
+// SkippedBytes returns the size of the leaves not stored.
+func (d *DedupAgainst) SkippedBytes() uint64 {
+	return atomic.LoadUint64(&d.skippedBytes)
+}
+
+type dedupAgainstKey struct{}
+
+// SetDedupAgainst makes the adder skip the leaves d reports the peer has.
+func SetDedupAgainst(ctx context.Context, d *DedupAgainst) context.Context {
+	return context.WithValue(ctx, dedupAgainstKey{}, d)
+}
+
+// GetDedupAgainst returns the DedupAgainst set by SetDedupAgainst, or nil.
+func GetDedupAgainst(ctx context.Context) *DedupAgainst {
+	d, _ := ctx.Value(dedupAgainstKey{}).(*DedupAgainst)
+	return d
+}
+
+// dedupAgainstDAGService drops the leaves the peer of d has before handing
+// the nodes to the underlying DAGService.
+type dedupAgainstDAGService struct {
+	ipld.DAGService
+	bs hasser
+	d  *DedupAgainst
+}
+
+// NewDedupAgainstDAGService wraps ds so that leaves not in bs are only
+// stored if the peer of d doesn't have them. The peer is asked once per
+// batch of nodes.
+func NewDedupAgainstDAGService(ds ipld.DAGService, bs hasser, d *DedupAgainst) ipld.DAGService {
+	return &dedupAgainstDAGService{
+		DAGService: ds,
+		bs:         bs,
+		d:          d,
+	}
+}
+
+func (d *dedupAgainstDAGService) Add(ctx context.Context, nd ipld.Node) error {
+	return d.AddMany(ctx, []ipld.Node{nd})
+}
+
+func (d *dedupAgainstDAGService) AddMany(ctx context.Context, nds []ipld.Node) error {
+	// leaves already stored locally are cheaper to write again than to ask for
+	var leaves []cid.Cid
+	for _, nd := range nds {
+		if len(nd.Links()) > 0 {
+			continue
+		}
+		has, err := d.bs.Has(ctx, nd.Cid())
+		if err != nil {
+			return err
+		}
+		if !has {
+			leaves = append(leaves, nd.Cid())
+		}
+	}
+	if len(leaves) == 0 {
+		return d.DAGService.AddMany(ctx, nds)
+	}
+
+	has, err := d.d.Has(ctx, leaves)
+	if err != nil {
+		return fmt.Errorf("query the blocks of the remote peer: %w", err)
+	}
+	if len(has) != len(leaves) {
+		return fmt.Errorf("remote peer answered for %d blocks, %d were asked", len(has), len(leaves))
+	}
+	remote := cid.NewSet()
+	for i, c := range leaves {
+		if has[i] {
+			remote.Add(c)
+		}
+	}
+	keep := make([]ipld.Node, 0, len(nds))
+	for _, nd := range nds {
+		if remote.Has(nd.Cid()) {
+			atomic.AddUint64(&d.d.skippedBlocks, 1)
+			atomic.AddUint64(&d.d.skippedBytes, uint64(len(nd.RawData())))
+			continue
+		}
+		keep = append(keep, nd)
+	}
+	if len(keep) == 0 {
+		return nil
+	}
+	return d.DAGService.AddMany(ctx, keep)
+}
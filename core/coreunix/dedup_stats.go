@@ -0,0 +1,98 @@
+package coreunix
+
+import (
+	"context"
+	"sync/atomic"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// DedupStats tallies how many of the blocks written by an add were
+// already present in the blockstore.
+type DedupStats struct {
+	totalBlocks uint64
+	newBlocks   uint64
+	dedupBytes  uint64
+}
+
+// TotalBlocks returns the number of blocks produced by the add.
+func (s *DedupStats) TotalBlocks() uint64 {
+	return atomic.LoadUint64(&s.totalBlocks)
+}
+
+// NewBlocks returns the number of blocks that were not yet stored.
+func (s *DedupStats) NewBlocks() uint64 {
+	return atomic.LoadUint64(&s.newBlocks)
+}
+
+// DedupBytes returns the size of the blocks that were already stored.
+func (s *DedupStats) DedupBytes() uint64 {
+	return atomic.LoadUint64(&s.dedupBytes)
+}
+
+type dedupStatsKey struct{}
+
+// SetDedupStats makes the adder record deduplication stats into s.
+func SetDedupStats(ctx context.Context, s *DedupStats) context.Context {
+	return context.WithValue(ctx, dedupStatsKey{}, s)
+}
+
+// GetDedupStats returns the stats set by SetDedupStats, or nil.
+func GetDedupStats(ctx context.Context) *DedupStats {
+	s, _ := ctx.Value(dedupStatsKey{}).(*DedupStats)
+	return s
+}
+
+type hasser interface {
+	Has(context.Context, cid.Cid) (bool, error)
+}
+
+// dedupStatsDAGService counts new and already stored nodes before handing
+// them to the underlying DAGService.
+type dedupStatsDAGService struct {
+	ipld.DAGService
+	bs    hasser
+	stats *DedupStats
+}
+
+// NewDedupStatsDAGService wraps ds so that every added node is checked
+// against bs and tallied into stats.
+func NewDedupStatsDAGService(ds ipld.DAGService, bs hasser, stats *DedupStats) ipld.DAGService {
+	return &dedupStatsDAGService{
+		DAGService: ds,
+		bs:         bs,
+		stats:      stats,
+	}
+}
+
+func (d *dedupStatsDAGService) Add(ctx context.Context, nd ipld.Node) error {
+	if err := d.count(ctx, nd); err != nil {
+		return err
+	}
+	return d.DAGService.Add(ctx, nd)
+}
+
+func (d *dedupStatsDAGService) AddMany(ctx context.Context, nds []ipld.Node) error {
+	for _, nd := range nds {
+		if err := d.count(ctx, nd); err != nil {
+			return err
+		}
+	}
+	return d.DAGService.AddMany(ctx, nds)
+}
+
+func (d *dedupStatsDAGService) count(ctx context.Context, b blocks.Block) error {
+	has, err := d.bs.Has(ctx, b.Cid())
+	if err != nil {
+		return err
+	}
+	atomic.AddUint64(&d.stats.totalBlocks, 1)
+	if has {
+		atomic.AddUint64(&d.stats.dedupBytes, uint64(len(b.RawData())))
+	} else {
+		atomic.AddUint64(&d.stats.newBlocks, 1)
+	}
+	return nil
+}
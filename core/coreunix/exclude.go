@@ -0,0 +1,50 @@
+package coreunix
+
+import (
+	"context"
+	"fmt"
+	gopath "path"
+	"strings"
+)
+
+type excludeKey struct{}
+
+// SetExcludePatterns makes the adder skip directory entries matching any of
+// the given glob patterns. The patterns must have been validated with
+// ValidateExcludePatterns.
+func SetExcludePatterns(ctx context.Context, patterns []string) context.Context {
+	return context.WithValue(ctx, excludeKey{}, patterns)
+}
+
+// GetExcludePatterns returns the patterns set by SetExcludePatterns.
+func GetExcludePatterns(ctx context.Context) []string {
+	patterns, _ := ctx.Value(excludeKey{}).([]string)
+	return patterns
+}
+
+// ValidateExcludePatterns checks that every pattern is a well-formed glob.
+func ValidateExcludePatterns(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := gopath.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid exclude pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// excluded reports whether path, relative to the added root, matches one of
+// the Exclude patterns. Patterns without a slash are also matched against
+// the last path element, so that "*.log" excludes log files at any depth.
+func (adder *Adder) excluded(path string) bool {
+	for _, p := range adder.Exclude {
+		if ok, _ := gopath.Match(p, path); ok {
+			return true
+		}
+		if !strings.Contains(p, "/") {
+			if ok, _ := gopath.Match(p, gopath.Base(path)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package coreunix
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	ftutil "github.com/bittorrent/go-unixfs/util"
+)
+
+// OwnerMetadataKey is the token metadata key holding the FileOwner of a file
+// added with Adder.PreserveOwner.
+const OwnerMetadataKey = "Owner"
+
+// FileOwner is the numeric owner of an added file.
+type FileOwner struct {
+	Uid uint32
+	Gid uint32
+}
+
+type preserveOwnerKey struct{}
+
+// SetPreserveOwner makes the adder record the owner of every added file.
+func SetPreserveOwner(ctx context.Context, preserve bool) context.Context {
+	return context.WithValue(ctx, preserveOwnerKey{}, preserve)
+}
+
+// GetPreserveOwner returns the value set by SetPreserveOwner.
+func GetPreserveOwner(ctx context.Context) bool {
+	preserve, _ := ctx.Value(preserveOwnerKey{}).(bool)
+	return preserve
+}
+
+// StatOwner returns the owner of the file fi describes, or nil when the
+// platform has none, as on Windows.
+func StatOwner(fi os.FileInfo) *FileOwner {
+	if fi == nil {
+		return nil
+	}
+	return statOwner(fi)
+}
+
+// ReadFileOwner returns the owner recorded in meta, the token metadata of a
+// file as returned by GetMetaData, or nil if none was.
+func ReadFileOwner(meta []byte) (*FileOwner, error) {
+	b := ftutil.GetMetadataElement(meta)
+	if len(b) == 0 {
+		return nil, nil
+	}
+	var m struct {
+		Owner *FileOwner
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return m.Owner, nil
+}
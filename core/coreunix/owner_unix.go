@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package coreunix
+
+import (
+	"os"
+	"syscall"
+)
+
+func statOwner(fi os.FileInfo) *FileOwner {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return &FileOwner{Uid: st.Uid, Gid: st.Gid}
+}
@@ -0,0 +1,8 @@
+package coreunix
+
+import "os"
+
+// Windows files have no uid and gid.
+func statOwner(os.FileInfo) *FileOwner {
+	return nil
+}
@@ -0,0 +1,124 @@
+package coreunix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+// pinDurationPrefix holds, in the repo datastore, when the pins made for a
+// duration, e.g. with 'btfs add --pin-duration-count', expire.
+var pinDurationPrefix = datastore.NewKey("/local/pin-durations")
+
+// ErrNoPinDuration is returned for CIDs which weren't pinned for a duration.
+var ErrNoPinDuration = errors.New("not pinned with a duration")
+
+// pinDay is the unit of pin durations.
+const pinDay = 24 * time.Hour
+
+func pinDurationKey(c cid.Cid) datastore.Key {
+	return pinDurationPrefix.ChildString(c.String())
+}
+
+// PinDuration is the duration of a pin.
+type PinDuration struct {
+	Cid cid.Cid `json:"-"`
+	// Pinned is when the pin was made for Days days.
+	Pinned time.Time
+	Days   int64
+	// ExtendedDays is the sum of the extensions of the pin.
+	ExtendedDays int64 `json:",omitempty"`
+	Expires      time.Time
+}
+
+// SetPinDuration records that the pin of c expires in days from now.
+func SetPinDuration(ctx context.Context, d datastore.Datastore, c cid.Cid, days int64) error {
+	if days <= 0 {
+		return fmt.Errorf("invalid pin duration %d, must be a positive number of days", days)
+	}
+	now := time.Now().UTC()
+	return putPinDuration(ctx, d, &PinDuration{
+		Cid:     c,
+		Pinned:  now,
+		Days:    days,
+		Expires: now.Add(time.Duration(days) * pinDay),
+	})
+}
+
+// GetPinDuration returns the duration of the pin of c, or ErrNoPinDuration.
+func GetPinDuration(ctx context.Context, d datastore.Datastore, c cid.Cid) (*PinDuration, error) {
+	b, err := d.Get(ctx, pinDurationKey(c))
+	if errors.Is(err, datastore.ErrNotFound) {
+		return nil, ErrNoPinDuration
+	}
+	if err != nil {
+		return nil, err
+	}
+	pd := &PinDuration{Cid: c}
+	if err := json.Unmarshal(b, pd); err != nil {
+		return nil, fmt.Errorf("invalid pin duration of %s: %w", c, err)
+	}
+	return pd, nil
+}
+
+// ListPinDurations returns the durations of all the pins made for a
+// duration.
+func ListPinDurations(ctx context.Context, d datastore.Datastore) ([]*PinDuration, error) {
+	res, err := d.Query(ctx, dsq.Query{Prefix: pinDurationPrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var pds []*PinDuration
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		c, err := cid.Decode(datastore.RawKey(r.Key).BaseNamespace())
+		if err != nil {
+			log.Errorf("invalid pin duration key %s: %s", r.Key, err)
+			continue
+		}
+		pd := &PinDuration{Cid: c}
+		if err := json.Unmarshal(r.Value, pd); err != nil {
+			log.Errorf("invalid pin duration of %s: %s", c, err)
+			continue
+		}
+		pds = append(pds, pd)
+	}
+	return pds, nil
+}
+
+// ExtendPinDuration pushes the expiry of the pin of c back by days, counted
+// from now if it already expired, and returns the new expiry. It fails with
+// ErrNoPinDuration if c wasn't pinned for a duration.
+func ExtendPinDuration(ctx context.Context, d datastore.Datastore, c cid.Cid, days int64) (time.Time, error) {
+	if days <= 0 {
+		return time.Time{}, fmt.Errorf("invalid pin duration %d, must be a positive number of days", days)
+	}
+	pd, err := GetPinDuration(ctx, d, c)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if now := time.Now().UTC(); pd.Expires.Before(now) {
+		pd.Expires = now
+	}
+	pd.Expires = pd.Expires.Add(time.Duration(days) * pinDay)
+	pd.ExtendedDays += days
+	return pd.Expires, putPinDuration(ctx, d, pd)
+}
+
+func putPinDuration(ctx context.Context, d datastore.Datastore, pd *PinDuration) error {
+	b, err := json.Marshal(pd)
+	if err != nil {
+		return err
+	}
+	return d.Put(ctx, pinDurationKey(pd.Cid), b)
+}
@@ -0,0 +1,178 @@
+package coreunix
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	gopath "path"
+	"strings"
+
+	files "github.com/bittorrent/go-btfs-files"
+	humanize "github.com/dustin/go-humanize"
+)
+
+// AddPolicyKey is the config key of the add policy, an object like
+//
+//	{"MaxFileSize": "1GB", "BlockedExtensions": [".exe"], "BlockedTypes": ["application/zip"]}
+//
+// It is read from the config file on every add, so changing it with
+// 'btfs config --json' applies without a restart.
+const AddPolicyKey = "AddPolicy"
+
+// ErrAddPolicy is returned by adds of files the add policy rejects.
+var ErrAddPolicy = errors.New("rejected by the add policy")
+
+// sniffLen is how much of a file is read to detect its type, as much as
+// http.DetectContentType looks at.
+const sniffLen = 512
+
+// AddPolicy rejects files by size, extension or detected type before they
+// are added.
+type AddPolicy struct {
+	// MaxFileSize is the size above which files are rejected, 0 means no
+	// limit.
+	MaxFileSize uint64
+	// BlockedExtensions are matched against the end of file names, case
+	// insensitively, e.g. ".exe".
+	BlockedExtensions []string
+	// BlockedTypes are MIME types, without parameters, as detected from
+	// the first bytes of the files by http.DetectContentType.
+	BlockedTypes []string
+}
+
+// ParseAddPolicy reads the add policy from v, the value of AddPolicyKey in
+// the config. It returns nil when v sets no rule.
+func ParseAddPolicy(v interface{}) (*AddPolicy, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var raw struct {
+		MaxFileSize       string
+		BlockedExtensions []string
+		BlockedTypes      []string
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", AddPolicyKey, err)
+	}
+	p := &AddPolicy{}
+	if raw.MaxFileSize != "" {
+		if p.MaxFileSize, err = humanize.ParseBytes(raw.MaxFileSize); err != nil {
+			return nil, fmt.Errorf("invalid %s.MaxFileSize %q: %w", AddPolicyKey, raw.MaxFileSize, err)
+		}
+	}
+	for _, ext := range raw.BlockedExtensions {
+		if ext = strings.ToLower(strings.TrimSpace(ext)); ext != "" {
+			p.BlockedExtensions = append(p.BlockedExtensions, ext)
+		}
+	}
+	for _, t := range raw.BlockedTypes {
+		mt, _, err := mime.ParseMediaType(t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s.BlockedTypes %q: %w", AddPolicyKey, t, err)
+		}
+		p.BlockedTypes = append(p.BlockedTypes, mt)
+	}
+	if p.MaxFileSize == 0 && len(p.BlockedExtensions) == 0 && len(p.BlockedTypes) == 0 {
+		return nil, nil
+	}
+	return p, nil
+}
+
+type addNameKey struct{}
+
+// SetAddName sets the name the client gave to the added node. A file added
+// alone has no path in the adder, the add policy checks its extension
+// against name instead.
+func SetAddName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, addNameKey{}, name)
+}
+
+// GetAddName returns the name set by SetAddName.
+func GetAddName(ctx context.Context) string {
+	name, _ := ctx.Value(addNameKey{}).(string)
+	return name
+}
+
+// checkName rejects name if it ends with a blocked extension.
+func (p *AddPolicy) checkName(name string) error {
+	lower := strings.ToLower(name)
+	for _, ext := range p.BlockedExtensions {
+		if strings.HasSuffix(lower, ext) {
+			return fmt.Errorf("%w: %s has blocked extension %s", ErrAddPolicy, name, ext)
+		}
+	}
+	return nil
+}
+
+// checkType rejects name if contentType is blocked.
+func (p *AddPolicy) checkType(name string, contentType string) error {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mt = contentType
+	}
+	for _, t := range p.BlockedTypes {
+		if mt == t {
+			return fmt.Errorf("%w: %s has blocked type %s", ErrAddPolicy, name, t)
+		}
+	}
+	return nil
+}
+
+// applyPolicy checks the file at path against the policy, before anything
+// of it is stored, and returns the reader to add it from in place of
+// reader, which must read the file from its start. The size of files which
+// don't know it is only checked as they are read, the add then fails once
+// the file goes over the limit.
+func (adder *Adder) applyPolicy(path string, file files.File, reader io.Reader) (io.Reader, error) {
+	p := adder.Policy
+	name := path
+	if name == "" {
+		name = adder.Name
+	}
+	if err := p.checkName(gopath.Base(name)); err != nil {
+		return nil, err
+	}
+	if p.MaxFileSize > 0 {
+		size, err := file.Size()
+		if err == nil && uint64(size) > p.MaxFileSize {
+			return nil, fmt.Errorf("%w: %s is %d bytes, the limit is %d bytes", ErrAddPolicy, name, size, p.MaxFileSize)
+		}
+		if err != nil {
+			reader = &policySizeReader{r: reader, name: name, max: p.MaxFileSize}
+		}
+	}
+	if len(p.BlockedTypes) > 0 {
+		br := bufio.NewReaderSize(reader, sniffLen)
+		head, err := br.Peek(sniffLen)
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return nil, err
+		}
+		if err := p.checkType(name, http.DetectContentType(head)); err != nil {
+			return nil, err
+		}
+		reader = br
+	}
+	return reader, nil
+}
+
+// policySizeReader fails once more than max bytes are read.
+type policySizeReader struct {
+	r    io.Reader
+	name string
+	max  uint64
+	read uint64
+}
+
+func (r *policySizeReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if r.read += uint64(n); r.read > r.max {
+		return 0, fmt.Errorf("%w: %s is larger than %d bytes", ErrAddPolicy, r.name, r.max)
+	}
+	return n, err
+}
@@ -0,0 +1,57 @@
+package coreunix
+
+import (
+	"context"
+	"fmt"
+)
+
+// ProvideStrategyKey is the config key of the default provide strategy of
+// adds, one of the ProvideStrategy values. Adds announce all their blocks
+// when it is not set.
+const ProvideStrategyKey = "AddProvideStrategy"
+
+// ProvideStrategy selects which CIDs of an add are announced to the routing
+// system as the add stores them.
+type ProvideStrategy string
+
+const (
+	// ProvideAll announces every new block of the add, as bitswap stores
+	// it, and the root.
+	ProvideAll ProvideStrategy = "all"
+	// ProvideRoots only announces the root of the add. The blocks below it
+	// can still be fetched from the node by peers which found it through
+	// the root, but not looked up on their own until the reprovider
+	// announces them, depending on Reprovider.Strategy.
+	ProvideRoots ProvideStrategy = "roots"
+	// ProvideNone announces nothing. The content is only found through
+	// peers already connected to the node, or once the reprovider runs.
+	ProvideNone ProvideStrategy = "none"
+)
+
+// ParseProvideStrategy returns the provide strategy named s. An empty s is
+// ProvideAll.
+func ParseProvideStrategy(s string) (ProvideStrategy, error) {
+	switch p := ProvideStrategy(s); p {
+	case "":
+		return ProvideAll, nil
+	case ProvideAll, ProvideRoots, ProvideNone:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown provide strategy %q, expected %s, %s or %s", s, ProvideAll, ProvideRoots, ProvideNone)
+	}
+}
+
+type provideStrategyKey struct{}
+
+// SetProvideStrategy makes the add announce the CIDs selected by p, in place
+// of the strategy of the config.
+func SetProvideStrategy(ctx context.Context, p ProvideStrategy) context.Context {
+	return context.WithValue(ctx, provideStrategyKey{}, p)
+}
+
+// GetProvideStrategy returns the strategy set by SetProvideStrategy, or "" if
+// none was.
+func GetProvideStrategy(ctx context.Context) ProvideStrategy {
+	p, _ := ctx.Value(provideStrategyKey{}).(ProvideStrategy)
+	return p
+}
@@ -90,6 +90,9 @@ func (rsadder *ReedSolomonAdder) AddAllAndPin(ctx context.Context, file files.No
 		}
 	} else {
 		var reader io.Reader = io.MultiReader(rsadder.InfileReaders...)
+		if rsadder.CountRead != nil {
+			reader = newCountReader(reader, rsadder.CountRead)
+		}
 		if rsadder.Progress {
 			rdr := &progressReader{file: reader, path: "", out: rsadder.Out}
 			if file, ok := file.(files.Node); ok {
@@ -179,6 +182,12 @@ func (rsadder *ReedSolomonAdder) addDir(ctx context.Context, path string, dir fi
 	var size uint64
 	for it.Next() {
 		fpath := gopath.Join(path, it.Name())
+		if rsadder.excluded(fpath) {
+			continue
+		}
+		if err := rsadder.checkDepth(fpath); err != nil {
+			return nil, err
+		}
 		child, err := rsadder.addFileNode(ctx, fpath, it.Node(), fList, false)
 		if err != nil {
 			return nil, err
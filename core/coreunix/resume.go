@@ -0,0 +1,277 @@
+package coreunix
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	files "github.com/bittorrent/go-btfs-files"
+	"github.com/bittorrent/go-unixfs"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+// checkpointInterval is how many leaves are added between two saves of the
+// checkpoint of a file.
+const checkpointInterval = 64
+
+var checkpointPrefix = datastore.NewKey("/local/resumable-add")
+
+type resumeKey struct{}
+
+// SetResume makes the adder checkpoint the files it adds to the datastore,
+// and continue from the checkpoint of a file added before. See
+// Adder.Checkpoints.
+func SetResume(ctx context.Context, resume bool) context.Context {
+	return context.WithValue(ctx, resumeKey{}, resume)
+}
+
+// GetResume returns the value set by SetResume.
+func GetResume(ctx context.Context) bool {
+	resume, _ := ctx.Value(resumeKey{}).(bool)
+	return resume
+}
+
+// addCheckpoint lists the leaves of the start of a file, in order, with
+// the size of the data each holds.
+type addCheckpoint struct {
+	Leaves []string
+	Sizes  []uint64
+}
+
+func (c *addCheckpoint) offset() int64 {
+	var n uint64
+	for _, s := range c.Sizes {
+		n += s
+	}
+	return int64(n)
+}
+
+// checkpointKey identifies a file by its path, size and modification time,
+// and by the options that change how it is chunked and encoded.
+func (adder *Adder) checkpointKey(path string, file files.File) (datastore.Key, error) {
+	size, err := file.Size()
+	if err != nil {
+		return datastore.Key{}, err
+	}
+	var mtime int64
+	if fi, ok := file.(files.FileInfo); ok {
+		if fi.AbsPath() != "" {
+			path = fi.AbsPath()
+		}
+		if st := fi.Stat(); st != nil {
+			mtime = st.ModTime().UnixNano()
+		}
+	}
+	var prefix string
+	if adder.CidBuilder != nil {
+		if p, ok := adder.CidBuilder.(cid.Prefix); ok {
+			prefix = fmt.Sprintf("%d-%d-%d", p.Version, p.Codec, p.MhType)
+		}
+	}
+	h := sha256.New()
+	for _, s := range []string{path, strconv.FormatInt(size, 10), strconv.FormatInt(mtime, 10),
+		adder.Chunker, strconv.FormatBool(adder.RawLeaves), strconv.FormatBool(adder.Trickle), prefix} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return checkpointPrefix.ChildString(hex.EncodeToString(h.Sum(nil))), nil
+}
+
+// loadCheckpoint returns the checkpoint at key, cut at the first leaf which
+// is no longer stored, or an empty checkpoint if there is none.
+func (adder *Adder) loadCheckpoint(ctx context.Context, key datastore.Key) (*addCheckpoint, error) {
+	cp := new(addCheckpoint)
+	b, err := adder.Checkpoints.Get(ctx, key)
+	if err == datastore.ErrNotFound {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, cp); err != nil || len(cp.Leaves) != len(cp.Sizes) {
+		log.Warnf("ignoring invalid add checkpoint %s", key)
+		return new(addCheckpoint), nil
+	}
+	bs, ok := adder.gcLocker.(hasser)
+	if !ok {
+		return new(addCheckpoint), nil
+	}
+	for i, l := range cp.Leaves {
+		c, err := cid.Decode(l)
+		if err != nil {
+			return nil, err
+		}
+		// leaves may have been garbage collected, or not flushed
+		if has, err := bs.Has(ctx, c); err != nil {
+			return nil, err
+		} else if !has {
+			cp.Leaves, cp.Sizes = cp.Leaves[:i], cp.Sizes[:i]
+			break
+		}
+	}
+	return cp, nil
+}
+
+// resumeFile returns the reader of file for its add to continue from its
+// checkpoint, and starts recording its checkpoint.
+func (adder *Adder) resumeFile(path string, file files.File) (io.Reader, error) {
+	key, err := adder.checkpointKey(path, file)
+	if err != nil {
+		return nil, err
+	}
+	cp, err := adder.loadCheckpoint(adder.ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := adder.resumeReader(adder.ctx, cp, file)
+	if err != nil {
+		return nil, err
+	}
+	adder.checkpoint = &checkpointDAGService{
+		DAGService: adder.bufferedDS,
+		store:      adder.Checkpoints,
+		key:        key,
+	}
+	return reader, nil
+}
+
+// finishCheckpoint removes the checkpoint of a file once it is added, or
+// flushes the leaves added so far and saves it when the add failed.
+func (adder *Adder) finishCheckpoint(addErr error) error {
+	c := adder.checkpoint
+	adder.checkpoint = nil
+	if addErr == nil {
+		return c.store.Delete(adder.ctx, c.key)
+	}
+	// leaves which can't be flushed are cut from the checkpoint when it
+	// is loaded
+	_ = adder.bufferedDS.Commit()
+	if err := c.save(adder.ctx); err != nil {
+		log.Warnf("can't save add checkpoint %s: %s", c.key, err)
+	}
+	return addErr
+}
+
+// resumeReader reads the data of the leaves of cp, then file from the end
+// of the last leaf on.
+func (adder *Adder) resumeReader(ctx context.Context, cp *addCheckpoint, file files.File) (io.Reader, error) {
+	offset := cp.offset()
+	if offset == 0 {
+		return file, nil
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		// files streamed from the client can't seek, skip the start
+		if _, err := io.CopyN(io.Discard, file, offset); err != nil {
+			return nil, err
+		}
+	}
+	log.Infof("resuming add at offset %d from %d stored leaves", offset, len(cp.Leaves))
+	return io.MultiReader(&leafReader{ctx: ctx, ds: adder.dagService, leaves: cp.Leaves}, file), nil
+}
+
+// leafReader reads the data of stored leaves, one at a time.
+type leafReader struct {
+	ctx    context.Context
+	ds     ipld.DAGService
+	leaves []string
+	buf    []byte
+}
+
+func (r *leafReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if len(r.leaves) == 0 {
+			return 0, io.EOF
+		}
+		c, err := cid.Decode(r.leaves[0])
+		if err != nil {
+			return 0, err
+		}
+		nd, err := r.ds.Get(r.ctx, c)
+		if err != nil {
+			return 0, err
+		}
+		if r.buf, err = leafData(nd); err != nil {
+			return 0, err
+		}
+		r.leaves = r.leaves[1:]
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func leafData(nd ipld.Node) ([]byte, error) {
+	switch n := nd.(type) {
+	case *dag.RawNode:
+		return n.RawData(), nil
+	case *dag.ProtoNode:
+		fsn, err := unixfs.FSNodeFromBytes(n.Data())
+		if err != nil {
+			return nil, err
+		}
+		return fsn.Data(), nil
+	default:
+		return nil, fmt.Errorf("unexpected leaf node %T", nd)
+	}
+}
+
+// checkpointDAGService records the leaves of a file as the layout adds them,
+// in file order, and saves them every checkpointInterval leaves.
+type checkpointDAGService struct {
+	ipld.DAGService
+	store   datastore.Datastore
+	key     datastore.Key
+	cp      addCheckpoint
+	unsaved int
+}
+
+func (c *checkpointDAGService) Add(ctx context.Context, nd ipld.Node) error {
+	if err := c.DAGService.Add(ctx, nd); err != nil {
+		return err
+	}
+	return c.record(ctx, nd)
+}
+
+func (c *checkpointDAGService) AddMany(ctx context.Context, nds []ipld.Node) error {
+	if err := c.DAGService.AddMany(ctx, nds); err != nil {
+		return err
+	}
+	for _, nd := range nds {
+		if err := c.record(ctx, nd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *checkpointDAGService) record(ctx context.Context, nd ipld.Node) error {
+	if len(nd.Links()) > 0 {
+		return nil
+	}
+	data, err := leafData(nd)
+	if err != nil {
+		return err
+	}
+	c.cp.Leaves = append(c.cp.Leaves, nd.Cid().String())
+	c.cp.Sizes = append(c.cp.Sizes, uint64(len(data)))
+	if c.unsaved++; c.unsaved >= checkpointInterval {
+		return c.save(ctx)
+	}
+	return nil
+}
+
+func (c *checkpointDAGService) save(ctx context.Context) error {
+	b, err := json.Marshal(&c.cp)
+	if err != nil {
+		return err
+	}
+	c.unsaved = 0
+	return c.store.Put(ctx, c.key, b)
+}
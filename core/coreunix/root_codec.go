@@ -0,0 +1,87 @@
+package coreunix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bittorrent/go-unixfs"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+// ErrRawRootTooLarge is returned when a file added with a raw root is split
+// in several chunks.
+var ErrRawRootTooLarge = errors.New("a raw root needs the file to fit in a single chunk")
+
+// RootCodecs are the codecs the root of an added file can be given, by
+// name.
+var RootCodecs = map[string]uint64{
+	"dag-pb": cid.DagProtobuf,
+	"raw":    cid.Raw,
+}
+
+// ParseRootCodec returns the codec of RootCodecs named name.
+func ParseRootCodec(name string) (uint64, error) {
+	if codec, ok := RootCodecs[name]; ok {
+		return codec, nil
+	}
+	names := make([]string, 0, len(RootCodecs))
+	for n := range RootCodecs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return 0, fmt.Errorf("unsupported codec %q, supported codecs: %s", name, strings.Join(names, ", "))
+}
+
+type rootCodecKey struct{}
+
+// SetRootCodec makes the adder give the root of an added file the codec,
+// one of RootCodecs. See Adder.RootCodec.
+func SetRootCodec(ctx context.Context, codec uint64) context.Context {
+	return context.WithValue(ctx, rootCodecKey{}, codec)
+}
+
+// GetRootCodec returns the codec set by SetRootCodec, or 0 if none was set.
+func GetRootCodec(ctx context.Context) uint64 {
+	codec, _ := ctx.Value(rootCodecKey{}).(uint64)
+	return codec
+}
+
+// applyRootCodec returns root, the root of a file, with the codec
+// adder.RootCodec. A raw root can't be made of several blocks, the file must
+// fit in a single raw leaf. A raw leaf is wrapped in a dag-pb node for a
+// dag-pb root.
+func (adder *Adder) applyRootCodec(root ipld.Node) (ipld.Node, error) {
+	codec := root.Cid().Prefix().Codec
+	if adder.RootCodec == 0 || codec == adder.RootCodec {
+		return root, nil
+	}
+	switch adder.RootCodec {
+	case cid.Raw:
+		return nil, ErrRawRootTooLarge
+	case cid.DagProtobuf:
+		fsn := unixfs.NewFSNode(unixfs.TFile)
+		fsn.AddBlockSize(uint64(len(root.RawData())))
+		data, err := fsn.GetBytes()
+		if err != nil {
+			return nil, err
+		}
+		nd := dag.NodeWithData(data)
+		if err := nd.SetCidBuilder(adder.CidBuilder); err != nil {
+			return nil, err
+		}
+		if err := nd.AddNodeLink("", root); err != nil {
+			return nil, err
+		}
+		if err := adder.bufferedDS.Add(adder.ctx, nd); err != nil {
+			return nil, err
+		}
+		return nd, nil
+	default:
+		return nil, fmt.Errorf("unsupported root codec %d", adder.RootCodec)
+	}
+}
@@ -0,0 +1,163 @@
+package coreunix
+
+import (
+	"context"
+
+	"github.com/bittorrent/go-unixfs"
+	"github.com/bittorrent/go-unixfs/hamt"
+	uio "github.com/bittorrent/go-unixfs/io"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+type shardThresholdKey struct{}
+
+// SetShardThreshold makes the adder shard the added directories whose links
+// take threshold bytes or more, and only those. See Adder.ShardThreshold.
+func SetShardThreshold(ctx context.Context, threshold int) context.Context {
+	return context.WithValue(ctx, shardThresholdKey{}, threshold)
+}
+
+// GetShardThreshold returns the threshold set by SetShardThreshold, or 0.
+func GetShardThreshold(ctx context.Context) int {
+	threshold, _ := ctx.Value(shardThresholdKey{}).(int)
+	return threshold
+}
+
+// resharder rebuilds the directories of an added DAG so that exactly those
+// whose links take threshold bytes or more are HAMT sharded, the way
+// go-unixfs estimates the size of a directory: the length of the name plus
+// the one of the CID of each link.
+type resharder struct {
+	dserv     ipld.DAGService
+	threshold int
+	// done maps the directories rebuilt, by their CID as added, to their
+	// new node, those left as is included
+	done map[cid.Cid]ipld.Node
+}
+
+func newResharder(dserv ipld.DAGService, threshold int) *resharder {
+	return &resharder{dserv: dserv, threshold: threshold, done: make(map[cid.Cid]ipld.Node)}
+}
+
+// reshard returns nd, with its directories rebuilt if it is one.
+func (r *resharder) reshard(ctx context.Context, nd ipld.Node) (ipld.Node, error) {
+	if out, ok := r.done[nd.Cid()]; ok {
+		return out, nil
+	}
+	pn, ok := nd.(*dag.ProtoNode)
+	if !ok {
+		return nd, nil // a raw leaf
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		return nil, err
+	}
+	sharded := fsn.Type() == unixfs.THAMTShard
+	if fsn.Type() != unixfs.TDirectory && !sharded {
+		return nd, nil
+	}
+
+	dir, err := uio.NewDirectoryFromNode(r.dserv, nd)
+	if err != nil {
+		return nil, err
+	}
+	links, err := dir.Links(ctx)
+	if err != nil {
+		return nil, err
+	}
+	children := make([]ipld.Node, len(links))
+	changed := false
+	size := 0
+	for i, l := range links {
+		child, err := l.GetNode(ctx, r.dserv)
+		if err != nil {
+			return nil, err
+		}
+		if children[i], err = r.reshard(ctx, child); err != nil {
+			return nil, err
+		}
+		changed = changed || !children[i].Cid().Equals(l.Cid)
+		size += len(l.Name) + children[i].Cid().ByteLen()
+	}
+
+	out := nd
+	if shard := size >= r.threshold; changed || shard != sharded {
+		if shard {
+			out, err = r.shardDir(ctx, pn.CidBuilder(), links, children)
+		} else {
+			out, err = r.basicDir(ctx, pn, sharded, links, children)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	r.done[nd.Cid()] = out
+	return out, nil
+}
+
+func (r *resharder) shardDir(ctx context.Context, builder cid.Builder, links []*ipld.Link, children []ipld.Node) (ipld.Node, error) {
+	shard, err := hamt.NewShard(r.dserv, uio.DefaultShardWidth)
+	if err != nil {
+		return nil, err
+	}
+	shard.SetCidBuilder(builder)
+	for i, l := range links {
+		if err := shard.Set(ctx, l.Name, children[i]); err != nil {
+			return nil, err
+		}
+	}
+	nd, err := shard.Node()
+	if err != nil {
+		return nil, err
+	}
+	return nd, r.dserv.Add(ctx, nd)
+}
+
+// basicDir keeps the data, e.g. the mode and mtime, of a directory which
+// wasn't sharded.
+func (r *resharder) basicDir(ctx context.Context, pn *dag.ProtoNode, sharded bool, links []*ipld.Link, children []ipld.Node) (ipld.Node, error) {
+	nd := unixfs.EmptyDirNode()
+	if !sharded {
+		nd = dag.NodeWithData(pn.Data())
+	}
+	nd.SetCidBuilder(pn.CidBuilder())
+	for i, l := range links {
+		if err := nd.AddNodeLink(l.Name, children[i]); err != nil {
+			return nil, err
+		}
+	}
+	return nd, r.dserv.Add(ctx, nd)
+}
+
+// fallbackDAG is a DAGService reading the nodes it doesn't have from
+// fallback, e.g. the directories of an add kept apart from its files.
+type fallbackDAG struct {
+	ipld.DAGService
+	fallback ipld.NodeGetter
+}
+
+func (d fallbackDAG) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	nd, err := d.DAGService.Get(ctx, c)
+	if ipld.IsNotFound(err) {
+		return d.fallback.Get(ctx, c)
+	}
+	return nd, err
+}
+
+func (d fallbackDAG) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	out := make(chan *ipld.NodeOption, len(cids))
+	go func() {
+		defer close(out)
+		for _, c := range cids {
+			nd, err := d.Get(ctx, c)
+			select {
+			case out <- &ipld.NodeOption{Node: nd, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
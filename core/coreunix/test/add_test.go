@@ -3,30 +3,46 @@ package test
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/bittorrent/go-btfs/core"
+	"github.com/bittorrent/go-btfs/core/coreapi"
 	"github.com/bittorrent/go-btfs/core/coreunix"
+	corenode "github.com/bittorrent/go-btfs/core/node"
 	"github.com/bittorrent/go-btfs/gc"
 	"github.com/bittorrent/go-btfs/repo"
 
+	chunker "github.com/bittorrent/go-btfs-chunker"
 	config "github.com/bittorrent/go-btfs-config"
 	files "github.com/bittorrent/go-btfs-files"
+	ft "github.com/bittorrent/go-unixfs"
+	uio "github.com/bittorrent/go-unixfs/io"
 	coreiface "github.com/bittorrent/interface-go-btfs-core"
+	"github.com/bittorrent/interface-go-btfs-core/path"
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-blockservice"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
 	syncds "github.com/ipfs/go-datastore/sync"
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	pi "github.com/ipfs/go-ipfs-posinfo"
+	ipld "github.com/ipfs/go-ipld-format"
 	dag "github.com/ipfs/go-merkledag"
+	gocarv2 "github.com/ipld/go-car/v2"
 )
 
 // TODO: FIX ME
@@ -394,3 +410,993 @@ func (fi *dummyFileInfo) Mode() os.FileMode  { return 0 }
 func (fi *dummyFileInfo) ModTime() time.Time { return fi.modTime }
 func (fi *dummyFileInfo) IsDir() bool        { return false }
 func (fi *dummyFileInfo) Sys() interface{}   { return nil }
+
+type statFileInfo struct {
+	dummyFileInfo
+	mode os.FileMode
+}
+
+func (fi *statFileInfo) Mode() os.FileMode { return fi.mode }
+
+func TestAddModeAndMtimeCombinations(t *testing.T) {
+	fileMode := os.FileMode(0640)
+	fileMtime := time.Unix(1600000000, 0)
+	fixedMode := os.FileMode(0755)
+	fixedMtime := time.Unix(1700000000, 0)
+
+	cases := []struct {
+		name          string
+		preserveMode  bool
+		preserveMtime bool
+		expectedMode  os.FileMode
+		expectedMtime time.Time
+	}{
+		{"preserve mode and mtime", true, true, fileMode, fileMtime},
+		{"preserve mode, fixed mtime", true, false, fileMode, fixedMtime},
+		{"fixed mode, preserve mtime", false, true, fixedMode, fileMtime},
+		{"fixed mode and mtime", false, false, fixedMode, fixedMtime},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			node := HelpTestMockRepo(t, nil)
+			adder, err := coreunix.NewAdder(ctx, node.Pinning, node.Blockstore, node.DAG)
+			if err != nil {
+				t.Fatal(err)
+			}
+			adder.PreserveMode = c.preserveMode
+			adder.PreserveMtime = c.preserveMtime
+			if !c.preserveMode {
+				adder.FileMode = fixedMode
+			}
+			if !c.preserveMtime {
+				adder.FileMtime = fixedMtime
+			}
+
+			stat := &statFileInfo{
+				dummyFileInfo: dummyFileInfo{name: "file", size: 4, modTime: fileMtime},
+				mode:          fileMode,
+			}
+			file := files.NewReaderStatFile(bytes.NewReader([]byte("data")), stat)
+
+			nd, err := adder.AddAllAndPin(ctx, file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fsn, err := ft.ExtractFSNode(nd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fsn.Mode() != c.expectedMode {
+				t.Fatalf("expected mode %o, got %o", c.expectedMode, fsn.Mode())
+			}
+			if !fsn.ModTime().Equal(c.expectedMtime) {
+				t.Fatalf("expected mtime %s, got %s", c.expectedMtime, fsn.ModTime())
+			}
+		})
+	}
+}
+
+func TestAddDedupStats(t *testing.T) {
+	ctx := context.Background()
+	node := HelpTestMockRepo(t, nil)
+
+	data := make([]byte, 1024*1024)
+	rand.New(rand.NewSource(3)).Read(data) // Rand.Read never returns an error
+
+	addOnce := func() *coreunix.DedupStats {
+		stats := new(coreunix.DedupStats)
+		dserv := coreunix.NewDedupStatsDAGService(node.DAG, node.Blockstore, stats)
+		adder, err := coreunix.NewAdder(ctx, node.Pinning, node.Blockstore, dserv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := adder.AddAllAndPin(ctx, files.NewBytesFile(data)); err != nil {
+			t.Fatal(err)
+		}
+		return stats
+	}
+
+	first := addOnce()
+	if first.NewBlocks() == 0 {
+		t.Fatal("expected new blocks on first add")
+	}
+	if first.DedupBytes() >= uint64(len(data)) {
+		t.Fatalf("expected file data not to be deduplicated, got %d bytes", first.DedupBytes())
+	}
+
+	second := addOnce()
+	if second.TotalBlocks() != first.TotalBlocks() {
+		t.Fatalf("expected %d blocks, got %d", first.TotalBlocks(), second.TotalBlocks())
+	}
+	if second.NewBlocks() != 0 {
+		t.Fatalf("expected no new blocks, got %d", second.NewBlocks())
+	}
+	if second.DedupBytes() < uint64(len(data)) {
+		t.Fatalf("expected at least %d deduplicated bytes, got %d", len(data), second.DedupBytes())
+	}
+}
+
+func TestAddDedupAgainst(t *testing.T) {
+	ctx := context.Background()
+	local := HelpTestMockRepo(t, nil)
+	peer := HelpTestMockRepo(t, nil)
+
+	data := make([]byte, 1024*1024)
+	rand.New(rand.NewSource(9)).Read(data) // Rand.Read never returns an error
+
+	add := func(node *core.IpfsNode, d *coreunix.DedupAgainst) ipld.Node {
+		var dserv ipld.DAGService = node.DAG
+		if d != nil {
+			dserv = coreunix.NewDedupAgainstDAGService(dserv, node.Blockstore, d)
+		}
+		adder, err := coreunix.NewAdder(ctx, node.Pinning, node.Blockstore, dserv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adder.Pin = false
+		nd, err := adder.AddAllAndPin(ctx, files.NewBytesFile(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return nd
+	}
+	synced := add(peer, nil)
+
+	var asked int
+	d := &coreunix.DedupAgainst{
+		Has: func(ctx context.Context, cids []cid.Cid) ([]bool, error) {
+			asked += len(cids)
+			has := make([]bool, len(cids))
+			for i, c := range cids {
+				var err error
+				if has[i], err = peer.Blockstore.Has(ctx, c); err != nil {
+					return nil, err
+				}
+			}
+			return has, nil
+		},
+	}
+	root := add(local, d)
+	if !root.Cid().Equals(synced.Cid()) {
+		t.Fatalf("expected the DAG to be computed in full, got %s, expected %s", root.Cid(), synced.Cid())
+	}
+	if len(root.Links()) == 0 || d.SkippedBlocks() != uint64(len(root.Links())) || asked != len(root.Links()) {
+		t.Fatalf("expected the %d leaves to be asked about and skipped, asked %d, skipped %d",
+			len(root.Links()), asked, d.SkippedBlocks())
+	}
+	if d.SkippedBytes() < uint64(len(data)) {
+		t.Fatalf("expected at least %d skipped bytes, got %d", len(data), d.SkippedBytes())
+	}
+	if has, err := local.Blockstore.Has(ctx, root.Cid()); err != nil || !has {
+		t.Fatalf("expected the root to be stored, got %t, %v", has, err)
+	}
+	for _, l := range root.Links() {
+		if has, err := local.Blockstore.Has(ctx, l.Cid); err != nil || has {
+			t.Fatalf("expected leaf %s not to be stored, got %t, %v", l.Cid, has, err)
+		}
+	}
+}
+
+// quotaDAG returns a DAG service storing blocks in the blockstore of node,
+// held to quota.
+func quotaDAG(t *testing.T, node *core.IpfsNode, quota uint64) ipld.DAGService {
+	bs, err := corenode.NewQuotaBlockstore(node.Blockstore,
+		func() (uint64, error) { return quota, nil },
+		func() (uint64, error) { return 0, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dag.NewDAGService(blockservice.New(bs, nil))
+}
+
+func TestAddStorageQuota(t *testing.T) {
+	ctx := context.Background()
+	node := HelpTestMockRepo(t, nil)
+
+	data := make([]byte, 1024*1024)
+	rand.New(rand.NewSource(4)).Read(data) // Rand.Read never returns an error
+
+	add := func(quota uint64) error {
+		adder, err := coreunix.NewAdder(ctx, node.Pinning, node.Blockstore, quotaDAG(t, node, quota))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = adder.AddAllAndPin(ctx, files.NewBytesFile(data))
+		return err
+	}
+
+	if err := add(uint64(len(data)) / 2); !errors.Is(err, corenode.ErrQuotaExceeded) {
+		t.Fatalf("expected %v, got %v", corenode.ErrQuotaExceeded, err)
+	}
+	if err := add(2 * uint64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	// the blocks are stored now, adding them again takes no room
+	if err := add(1); err != nil {
+		t.Fatalf("expected stored blocks not to count, got %v", err)
+	}
+}
+
+func TestAddMaxDepth(t *testing.T) {
+	tree := func() files.Directory {
+		return files.NewMapDirectory(map[string]files.Node{
+			"a": files.NewMapDirectory(map[string]files.Node{
+				"b": files.NewMapDirectory(map[string]files.Node{
+					"c": files.NewBytesFile([]byte("deep")),
+				}),
+			}),
+		})
+	}
+
+	for _, c := range []struct {
+		maxDepth int
+		fail     bool
+	}{
+		{-1, false},
+		{3, false},
+		// c is a file in the directory at depth 2
+		{2, false},
+		{1, true},
+		{0, true},
+	} {
+		ctx := context.Background()
+		node := HelpTestMockRepo(t, nil)
+		adder, err := coreunix.NewAdder(ctx, node.Pinning, node.Blockstore, node.DAG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adder.MaxDepth = c.maxDepth
+
+		_, err = adder.AddAllAndPin(ctx, tree())
+		if !c.fail {
+			if err != nil {
+				t.Fatalf("max depth %d: %s", c.maxDepth, err)
+			}
+			continue
+		}
+		if !errors.Is(err, coreunix.ErrDepthLimitExceeded) {
+			t.Fatalf("max depth %d: expected ErrDepthLimitExceeded, got %v", c.maxDepth, err)
+		}
+	}
+
+	// the files of the added root are at depth 0
+	ctx := context.Background()
+	node := HelpTestMockRepo(t, nil)
+	adder, err := coreunix.NewAdder(ctx, node.Pinning, node.Blockstore, node.DAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adder.MaxDepth = 0
+	_, err = adder.AddAllAndPin(ctx, files.NewMapDirectory(map[string]files.Node{
+		"a": files.NewBytesFile([]byte("a")),
+		"b": files.NewBytesFile([]byte("b")),
+	}))
+	if err != nil {
+		t.Fatalf("expected the files of the root to be added with max depth 0, got %v", err)
+	}
+}
+
+func TestAddExclude(t *testing.T) {
+	ctx := context.Background()
+	node := HelpTestMockRepo(t, nil)
+	adder, err := coreunix.NewAdder(ctx, node.Pinning, node.Blockstore, node.DAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adder.Exclude = []string{"*.log", "skip"}
+
+	dir := files.NewMapDirectory(map[string]files.Node{
+		"keep.txt": files.NewBytesFile([]byte("keep")),
+		"a.log":    files.NewBytesFile([]byte("log")),
+		"skip": files.NewMapDirectory(map[string]files.Node{
+			"inner.txt": files.NewBytesFile([]byte("inner")),
+		}),
+		"sub": files.NewMapDirectory(map[string]files.Node{
+			"b.log":  files.NewBytesFile([]byte("log")),
+			"ok.txt": files.NewBytesFile([]byte("ok")),
+		}),
+	})
+
+	nd, err := adder.AddAllAndPin(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, l := range nd.Links() {
+		names = append(names, l.Name)
+	}
+	if len(names) != 2 || names[0] != "keep.txt" || names[1] != "sub" {
+		t.Fatalf("unexpected root entries: %v", names)
+	}
+	sub, err := nd.Links()[1].GetNode(ctx, node.DAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if links := sub.Links(); len(links) != 1 || links[0].Name != "ok.txt" {
+		t.Fatalf("expected only ok.txt in sub, got %v", links)
+	}
+
+	if err := coreunix.ValidateExcludePatterns([]string{"[a-"}); err == nil {
+		t.Fatal("expected invalid pattern to be rejected")
+	}
+}
+
+func TestAddPinRootOnly(t *testing.T) {
+	ctx := context.Background()
+	node := HelpTestMockRepo(t, nil)
+	adder, err := coreunix.NewAdder(ctx, node.Pinning, node.Blockstore, node.DAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adder.PinDuration = 30
+	adder.PinRootOnly = true
+
+	dir := files.NewMapDirectory(map[string]files.Node{
+		"file": files.NewBytesFile([]byte("data")),
+	})
+	nd, err := adder.AddAllAndPin(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mode, pinned, err := node.Pinning.IsPinned(ctx, nd.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pinned || mode != "direct" {
+		t.Fatalf("expected root to be pinned directly, got pinned=%v mode=%q", pinned, mode)
+	}
+	_, pinned, err = node.Pinning.IsPinned(ctx, nd.Links()[0].Cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinned {
+		t.Fatal("expected child not to be pinned")
+	}
+}
+
+func TestAddToCar(t *testing.T) {
+	ctx := context.Background()
+	node := HelpTestMockRepo(t, nil)
+
+	car, err := coreunix.NewCarBuilder(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer car.Close()
+
+	data := make([]byte, 1024*1024)
+	rand.New(rand.NewSource(4)).Read(data) // Rand.Read never returns an error
+
+	adder, err := coreunix.NewAdder(ctx, node.Pinning, node.Blockstore, coreunix.NewCarDAGService(node.DAG, car))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := adder.AddAllAndPin(ctx, files.NewBytesFile(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	size, err := car.WriteTo(&buf, root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(buf.Len()) {
+		t.Fatalf("expected size %d, got %d", buf.Len(), size)
+	}
+
+	br, err := gocarv2.NewBlockReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if br.Version != 2 {
+		t.Fatalf("expected a CARv2, got version %d", br.Version)
+	}
+	if len(br.Roots) != 1 || !br.Roots[0].Equals(root.Cid()) {
+		t.Fatalf("expected root %s, got %v", root.Cid(), br.Roots)
+	}
+	found := false
+	for {
+		b, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		found = found || b.Cid().Equals(root.Cid())
+	}
+	if !found {
+		t.Fatal("expected the root block in the CAR")
+	}
+}
+
+func TestAddSymlinks(t *testing.T) {
+	ctx := context.Background()
+	node := HelpTestMockRepo(t, nil)
+	out := make(chan interface{}, 16)
+	adder, err := coreunix.NewAdder(ctx, node.Pinning, node.Blockstore, node.DAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adder.Out = out
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("file", filepath.Join(dir, "internal")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("missing", filepath.Join(dir, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Lstat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf, err := files.NewSerialFile(dir, false, stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nd, err := adder.AddAllAndPin(ctx, sf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+
+	targets := make(map[string]string)
+	for o := range out {
+		if e, ok := o.(*coreunix.AddSymlinkEvent); ok {
+			targets[e.Name] = e.Target
+		}
+	}
+	if len(targets) != 2 || targets["internal"] != "file" || targets["dangling"] != "missing" {
+		t.Fatalf("unexpected symlink events: %v", targets)
+	}
+
+	for _, l := range nd.Links() {
+		if l.Name == "file" {
+			continue
+		}
+		ln, err := l.GetNode(ctx, node.DAG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fsn, err := ft.FSNodeFromBytes(ln.(*dag.ProtoNode).Data())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fsn.Type() != ft.TSymlink {
+			t.Fatalf("expected %s to be a symlink node, got %s", l.Name, fsn.Type())
+		}
+		if target := string(fsn.Data()); target != targets[l.Name] {
+			t.Fatalf("expected %s to point to %s, got %s", l.Name, targets[l.Name], target)
+		}
+	}
+}
+
+func TestAddRecordSHA256(t *testing.T) {
+	ctx := context.Background()
+	node := HelpTestMockRepo(t, nil)
+	out := make(chan interface{}, 16)
+	adder, err := coreunix.NewAdder(ctx, node.Pinning, node.Blockstore, node.DAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adder.Out = out
+	adder.RecordSHA256 = true
+
+	content := map[string][]byte{
+		"a":     []byte("first file"),
+		"sub/b": bytes.Repeat([]byte("second file"), 100000),
+	}
+	dir := files.NewMapDirectory(map[string]files.Node{
+		"a": files.NewBytesFile(content["a"]),
+		"sub": files.NewMapDirectory(map[string]files.Node{
+			"b": files.NewBytesFile(content["sub/b"]),
+		}),
+	})
+	if _, err := adder.AddAllAndPin(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+
+	sums := make(map[string]string)
+	for o := range out {
+		if e, ok := o.(*coreunix.AddChecksumEvent); ok {
+			sums[e.Name] = e.SHA256
+		}
+	}
+	if len(sums) != len(content) {
+		t.Fatalf("expected a checksum for each of the %d files, got %v", len(content), sums)
+	}
+	for name, b := range content {
+		want := sha256.Sum256(b)
+		if sums[name] != hex.EncodeToString(want[:]) {
+			t.Fatalf("unexpected checksum for %s: %s", name, sums[name])
+		}
+	}
+}
+
+func TestAddPreserveMtimeNsec(t *testing.T) {
+	ctx := context.Background()
+	node := HelpTestMockRepo(t, nil)
+	for _, recordSHA256 := range []bool{false, true} {
+		out := make(chan interface{}, 16)
+		adder, err := coreunix.NewAdder(ctx, node.Pinning, node.Blockstore, node.DAG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adder.Out = out
+		adder.PreserveMtime = true
+		adder.RecordSHA256 = recordSHA256
+
+		mtime := time.Unix(1600000000, 123456789)
+		stat := &dummyFileInfo{name: "file", size: 4, modTime: mtime}
+		file := files.NewReaderStatFile(bytes.NewReader([]byte("data")), stat)
+		if _, err := adder.AddAllAndPin(ctx, file); err != nil {
+			t.Fatal(err)
+		}
+		close(out)
+
+		var sec int64
+		var nsec uint32
+		for o := range out {
+			switch e := o.(type) {
+			case *coreunix.AddMtimeEvent:
+				sec, nsec = e.Mtime, e.MtimeNsec
+			case *coreunix.AddChecksumEvent:
+				sec, nsec = e.Mtime, e.MtimeNsec
+			}
+		}
+		if sec != mtime.Unix() || nsec != uint32(mtime.Nanosecond()) {
+			t.Fatalf("expected mtime %d.%09d to be reported, got %d.%09d", mtime.Unix(), mtime.Nanosecond(), sec, nsec)
+		}
+	}
+}
+
+func TestAddSkipPinned(t *testing.T) {
+	ctx := context.Background()
+	node := HelpTestMockRepo(t, nil)
+	add := func(pin bool, hints map[string]cid.Cid, f files.Node) (ipld.Node, []interface{}) {
+		out := make(chan interface{}, 16)
+		adder, err := coreunix.NewAdder(ctx, node.Pinning, node.Blockstore, node.DAG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adder.Out = out
+		adder.Pin = pin
+		adder.SkipPinned = hints
+		nd, err := adder.AddAllAndPin(ctx, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		close(out)
+		var events []interface{}
+		for o := range out {
+			events = append(events, o)
+		}
+		return nd, events
+	}
+
+	pinned, _ := add(true, nil, files.NewBytesFile([]byte("pinned file")))
+	unpinned, _ := add(false, nil, files.NewBytesFile([]byte("unpinned file")))
+
+	dir := files.NewMapDirectory(map[string]files.Node{
+		"a": files.NewBytesFile([]byte("pinned file")),
+		"b": files.NewBytesFile([]byte("unpinned file")),
+		"c": files.NewBytesFile([]byte("no hint")),
+	})
+	_, events := add(true, map[string]cid.Cid{"a": pinned.Cid(), "b": unpinned.Cid()}, dir)
+
+	skipped := make(map[string]cid.Cid)
+	added := make(map[string]bool)
+	for _, o := range events {
+		switch e := o.(type) {
+		case *coreunix.AddSkippedEvent:
+			skipped[e.Name] = e.Path.Cid()
+		case *coreiface.AddEvent:
+			added[e.Name] = true
+		}
+	}
+	if len(skipped) != 1 || skipped["a"] != pinned.Cid() {
+		t.Fatalf("expected only a to be skipped as %s, got %v", pinned.Cid(), skipped)
+	}
+	if !added["b"] || !added["c"] {
+		t.Fatalf("expected b and c to be added, got %v", added)
+	}
+}
+
+func TestAddResume(t *testing.T) {
+	ctx := context.Background()
+	node := HelpTestMockRepo(t, nil)
+	checkpoints := syncds.MutexWrap(datastore.NewMapDatastore())
+
+	data := make([]byte, 1024*1024)
+	rand.New(rand.NewSource(5)).Read(data) // Rand.Read never returns an error
+
+	add := func(dserv ipld.DAGService, store datastore.Datastore) (ipld.Node, error) {
+		adder, err := coreunix.NewAdder(ctx, node.Pinning, node.Blockstore, dserv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adder.Chunker = "size-4096"
+		adder.Checkpoints = store
+		return adder.AddAllAndPin(ctx, files.NewBytesFile(data))
+	}
+	countCheckpoints := func() int {
+		res, err := checkpoints.Query(ctx, dsq.Query{Prefix: "/local/resumable-add", KeysOnly: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := res.Rest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(entries)
+	}
+
+	// the quota makes the first add fail midway
+	quota := quotaDAG(node, uint64(len(data))/2)
+	if _, err := add(quota, checkpoints); !errors.Is(err, corenode.ErrQuotaExceeded) {
+		t.Fatalf("expected %v, got %v", corenode.ErrQuotaExceeded, err)
+	}
+	if n := countCheckpoints(); n != 1 {
+		t.Fatalf("expected the failed add to leave 1 checkpoint, got %d", n)
+	}
+
+	resumed, err := add(node.DAG, checkpoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countCheckpoints(); n != 0 {
+		t.Fatalf("expected the checkpoint to be removed, got %d", n)
+	}
+	plain, err := add(node.DAG, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resumed.Cid().Equals(plain.Cid()) {
+		t.Fatalf("resumed add got %s, expected %s", resumed.Cid(), plain.Cid())
+	}
+}
+
+func TestAddPolicy(t *testing.T) {
+	policy, err := coreunix.ParseAddPolicy(map[string]interface{}{
+		"MaxFileSize":       "1KiB",
+		"BlockedExtensions": []interface{}{".EXE"},
+		"BlockedTypes":      []interface{}{"image/png"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	png := append([]byte("\x89PNG\x0D\x0A\x1A\x0A"), make([]byte, 100)...)
+
+	for _, c := range []struct {
+		name   string
+		file   files.Node
+		reject bool
+	}{
+		{"ok.txt", files.NewBytesFile([]byte("ok")), false},
+		{"setup.exe", files.NewBytesFile([]byte("ok")), true},
+		{"dir", files.NewMapDirectory(map[string]files.Node{
+			"sub": files.NewMapDirectory(map[string]files.Node{
+				"Setup.Exe": files.NewBytesFile([]byte("ok")),
+			}),
+		}), true},
+		{"big.txt", files.NewBytesFile(make([]byte, 2048)), true},
+		// the size of a stream is only known once it is read
+		{"stream.txt", files.NewReaderFile(bytes.NewReader(make([]byte, 2048))), true},
+		{"image.txt", files.NewBytesFile(png), true},
+	} {
+		ctx := context.Background()
+		node := HelpTestMockRepo(t, nil)
+		adder, err := coreunix.NewAdder(ctx, node.Pinning, node.Blockstore, node.DAG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adder.Policy = policy
+		adder.Name = c.name
+
+		_, err = adder.AddAllAndPin(ctx, c.file)
+		if !c.reject {
+			if err != nil {
+				t.Fatalf("%s: %s", c.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, coreunix.ErrAddPolicy) {
+			t.Fatalf("%s: expected %v, got %v", c.name, coreunix.ErrAddPolicy, err)
+		}
+	}
+
+	if policy, err := coreunix.ParseAddPolicy(map[string]interface{}{}); err != nil || policy != nil {
+		t.Fatalf("expected no policy without rules, got %v, %v", policy, err)
+	}
+	if _, err := coreunix.ParseAddPolicy(map[string]interface{}{"MaxFileSize": "lots"}); err == nil {
+		t.Fatal("expected an invalid size to be rejected")
+	}
+}
+
+func TestPinDuration(t *testing.T) {
+	ctx := context.Background()
+	d := syncds.MutexWrap(datastore.NewMapDatastore())
+	timed := dag.NewRawNode([]byte("timed")).Cid()
+	untimed := dag.NewRawNode([]byte("untimed")).Cid()
+
+	if err := coreunix.SetPinDuration(ctx, d, timed, 10); err != nil {
+		t.Fatal(err)
+	}
+	pd, err := coreunix.GetPinDuration(ctx, d, timed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extended, err := coreunix.ExtendPinDuration(ctx, d, timed, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := extended.Sub(pd.Expires); got != 5*24*time.Hour {
+		t.Fatalf("expected the pin to be extended by 5 days, got %s", got)
+	}
+	pds, err := coreunix.ListPinDurations(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pds) != 1 || pds[0].Cid != timed || pds[0].Days != 10 || pds[0].ExtendedDays != 5 || !pds[0].Expires.Equal(extended) {
+		t.Fatalf("unexpected pin durations %+v", pds)
+	}
+	if _, err := coreunix.ExtendPinDuration(ctx, d, untimed, 5); !errors.Is(err, coreunix.ErrNoPinDuration) {
+		t.Fatalf("expected ErrNoPinDuration, got %v", err)
+	}
+}
+
+func TestAddPreserveOwner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no file owners on windows")
+	}
+	ctx := context.Background()
+	node := HelpTestMockRepo(t, nil)
+	adder, err := coreunix.NewAdder(ctx, node.Pinning, node.Blockstore, node.DAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adder.PreserveOwner = true
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("owned data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Lstat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf, err := files.NewSerialFile(dir, false, stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := adder.AddAllAndPin(ctx, sf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api, err := coreapi.NewCoreAPI(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := coreunix.GetMetaData(ctx, api, path.Join(path.IpfsPath(nd.Cid()), "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, err := coreunix.ReadFileOwner(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner == nil || int(owner.Uid) != os.Getuid() || int(owner.Gid) != os.Getgid() {
+		t.Fatalf("expected the owner %d:%d to be recorded, got %+v", os.Getuid(), os.Getgid(), owner)
+	}
+
+	r, err := api.Unixfs().Get(ctx, path.Join(path.IpfsPath(nd.Cid()), "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(files.ToFile(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "owned data" {
+		t.Fatalf("expected the content to be kept apart from the owner, got %q", b)
+	}
+}
+
+func TestAddShardThreshold(t *testing.T) {
+	ctx := context.Background()
+	node := HelpTestMockRepo(t, nil)
+	entries := make(map[string]files.Node)
+	for i := 0; i < 20; i++ {
+		entries[fmt.Sprintf("file%02d", i)] = files.NewBytesFile([]byte(fmt.Sprintf("content %d", i)))
+	}
+	add := func(threshold int) ipld.Node {
+		adder, err := coreunix.NewAdder(ctx, node.Pinning, node.Blockstore, node.DAG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adder.ShardThreshold = threshold
+		nd, err := adder.AddAllAndPin(ctx, files.NewMapDirectory(entries))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return nd
+	}
+	check := func(nd ipld.Node, sharded bool) {
+		t.Helper()
+		fsn, err := ft.FSNodeFromBytes(nd.(*dag.ProtoNode).Data())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (fsn.Type() == ft.THAMTShard) != sharded {
+			t.Fatalf("expected the directory to be sharded: %t, got a node of type %s", sharded, fsn.Type())
+		}
+		dir, err := uio.NewDirectoryFromNode(node.DAG, nd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		links, err := dir.Links(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(links) != len(entries) {
+			t.Fatalf("expected %d entries, got %d", len(entries), len(links))
+		}
+	}
+
+	basic := add(0)
+	check(basic, false)
+	check(add(100), true)
+	if nd := add(1 << 20); !nd.Cid().Equals(basic.Cid()) {
+		t.Fatalf("expected a directory under the threshold to be left as is, got %s", nd.Cid())
+	}
+
+	// the threshold wins over the config of the node
+	uio.UseHAMTSharding = true
+	defer func() { uio.UseHAMTSharding = false }()
+	sharded := add(0)
+	check(sharded, true)
+	if nd := add(1 << 20); !nd.Cid().Equals(basic.Cid()) {
+		t.Fatalf("expected the directory to be unsharded like without sharding, got %s", nd.Cid())
+	}
+	if nd := add(100); !nd.Cid().Equals(sharded.Cid()) {
+		t.Fatalf("expected the directory to be sharded like by the config, got %s", nd.Cid())
+	}
+}
+
+func TestParseProvideStrategy(t *testing.T) {
+	for s, expected := range map[string]coreunix.ProvideStrategy{
+		"":      coreunix.ProvideAll,
+		"all":   coreunix.ProvideAll,
+		"roots": coreunix.ProvideRoots,
+		"none":  coreunix.ProvideNone,
+	} {
+		p, err := coreunix.ParseProvideStrategy(s)
+		if err != nil {
+			t.Fatalf("%q: %s", s, err)
+		}
+		if p != expected {
+			t.Fatalf("%q: expected %s, got %s", s, expected, p)
+		}
+	}
+	if _, err := coreunix.ParseProvideStrategy("pinned"); err == nil {
+		t.Fatal("expected an unknown strategy to be rejected")
+	}
+}
+
+func TestRegisterChunker(t *testing.T) {
+	ctx := context.Background()
+	node := HelpTestMockRepo(t, nil)
+
+	err := coreunix.RegisterChunker("fixed", func(r io.Reader, params string) (chunker.Splitter, error) {
+		size, err := strconv.ParseInt(params, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return chunker.NewSizeSplitter(r, size), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"fixed", "rabin", "with-dash", ""} {
+		if err := coreunix.RegisterChunker(name, nil); err == nil {
+			t.Fatalf("%q: expected the registration to fail", name)
+		}
+	}
+
+	data := make([]byte, 64*1024)
+	rand.New(rand.NewSource(7)).Read(data) // Rand.Read never returns an error
+	add := func(c string) (ipld.Node, error) {
+		adder, err := coreunix.NewAdder(ctx, node.Pinning, node.Blockstore, node.DAG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adder.Chunker = c
+		return adder.AddAllAndPin(ctx, files.NewBytesFile(data))
+	}
+	custom, err := add("fixed-4096")
+	if err != nil {
+		t.Fatal(err)
+	}
+	builtin, err := add("size-4096")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !custom.Cid().Equals(builtin.Cid()) {
+		t.Fatalf("expected the registered chunker to split like size-4096, got %s and %s", custom.Cid(), builtin.Cid())
+	}
+
+	_, err = add("nosuchchunker")
+	if err == nil || !strings.Contains(err.Error(), "fixed") || !strings.Contains(err.Error(), "buzhash") {
+		t.Fatalf("expected the error to list the available chunkers, got %v", err)
+	}
+}
+
+func TestAddRootCodec(t *testing.T) {
+	ctx := context.Background()
+	node := HelpTestMockRepo(t, nil)
+
+	add := func(data []byte, codec uint64) (ipld.Node, error) {
+		adder, err := coreunix.NewAdder(ctx, node.Pinning, node.Blockstore, node.DAG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adder.Chunker = "size-4096"
+		adder.RawLeaves = true
+		adder.RootCodec = codec
+		return adder.AddAllAndPin(ctx, files.NewBytesFile(data))
+	}
+
+	small := []byte("a single chunk")
+	leaf, err := add(small, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.Cid().Prefix().Codec != cid.Raw {
+		t.Fatalf("expected a raw root for a single raw leaf, got %s", leaf.Cid())
+	}
+	raw, err := add(small, cid.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !raw.Cid().Equals(leaf.Cid()) {
+		t.Fatalf("expected the raw root %s, got %s", leaf.Cid(), raw.Cid())
+	}
+
+	pb, err := add(small, cid.DagProtobuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pb.Cid().Prefix().Codec != cid.DagProtobuf {
+		t.Fatalf("expected a dag-pb root, got %s", pb.Cid())
+	}
+	links := pb.Links()
+	if len(links) != 1 || !links[0].Cid.Equals(leaf.Cid()) {
+		t.Fatalf("expected the dag-pb root to link to the raw leaf %s, got %v", leaf.Cid(), links)
+	}
+	r, err := uio.NewDagReader(ctx, pb, node.DAG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, small) {
+		t.Fatalf("expected %q read back, got %q", small, content)
+	}
+
+	if _, err := add(make([]byte, 3*4096), cid.Raw); !errors.Is(err, coreunix.ErrRawRootTooLarge) {
+		t.Fatalf("expected %v, got %v", coreunix.ErrRawRootTooLarge, err)
+	}
+}
@@ -0,0 +1,237 @@
+// Package fetchlimit bounds the work done by IPLD fetchers, so that
+// traversing maliciously wide or deep DAGs can't exhaust the node.
+package fetchlimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-fetcher"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// ErrLimitExceeded is matched by every *LimitError.
+var ErrLimitExceeded = errors.New("fetch limit exceeded")
+
+// LimitError is returned when a traversal exceeds one of its Limits.
+type LimitError struct {
+	// Limit is "links", "blocks", "bytes" or "duration".
+	Limit string
+	// Max is the limit exceeded, in nanoseconds for "duration".
+	Max int64
+}
+
+func (e *LimitError) Error() string {
+	if e.Limit == "duration" {
+		return fmt.Sprintf("fetch limit exceeded: took more than %s", time.Duration(e.Max))
+	}
+	return fmt.Sprintf("fetch limit exceeded: more than %d %s", e.Max, e.Limit)
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// Limits caps a single traversal, i.e. everything fetched through one
+// fetcher session. Zero means unlimited.
+type Limits struct {
+	// MaxLinks is the number of links followed. A block reachable through
+	// several links counts once per link.
+	MaxLinks int
+	// MaxBlocks is the number of distinct blocks loaded.
+	MaxBlocks int
+	// MaxBytes is the total size of the distinct blocks loaded. It is only
+	// enforced by factories given a BlockSizer.
+	MaxBytes int64
+	// MaxDuration bounds how long blocks are loaded for, from the start of
+	// the session.
+	MaxDuration time.Duration
+}
+
+// Unlimited reports whether l doesn't limit anything.
+func (l Limits) Unlimited() bool {
+	return l.MaxLinks <= 0 && l.MaxBlocks <= 0 && l.MaxBytes <= 0 && l.MaxDuration <= 0
+}
+
+// Tighten returns the tighter of l and o for each limit, where 0 means
+// unlimited.
+func (l Limits) Tighten(o Limits) Limits {
+	if o.MaxLinks > 0 && (l.MaxLinks <= 0 || o.MaxLinks < l.MaxLinks) {
+		l.MaxLinks = o.MaxLinks
+	}
+	if o.MaxBlocks > 0 && (l.MaxBlocks <= 0 || o.MaxBlocks < l.MaxBlocks) {
+		l.MaxBlocks = o.MaxBlocks
+	}
+	if o.MaxBytes > 0 && (l.MaxBytes <= 0 || o.MaxBytes < l.MaxBytes) {
+		l.MaxBytes = o.MaxBytes
+	}
+	if o.MaxDuration > 0 && (l.MaxDuration <= 0 || o.MaxDuration < l.MaxDuration) {
+		l.MaxDuration = o.MaxDuration
+	}
+	return l
+}
+
+// BlockSizer gives the size of blocks, usually the blockstore the fetched
+// blocks end up in.
+type BlockSizer interface {
+	GetSize(ctx context.Context, c cid.Cid) (int, error)
+}
+
+// NewFactory returns a factory whose fetchers enforce l. f is returned as is
+// if l is unlimited. sizes is only needed to enforce MaxBytes.
+func NewFactory(f fetcher.Factory, l Limits, sizes BlockSizer) fetcher.Factory {
+	if l.Unlimited() {
+		return f
+	}
+	return &factory{Factory: f, limits: l, sizes: sizes}
+}
+
+type factory struct {
+	fetcher.Factory
+	limits Limits
+	sizes  BlockSizer
+}
+
+func (f *factory) NewSession(ctx context.Context) fetcher.Fetcher {
+	lf := &limitedFetcher{
+		limits: f.limits,
+		sizes:  f.sizes,
+		paths:  make(map[string]struct{}),
+		blocks: make(map[string]struct{}),
+		sized:  make(map[string]struct{}),
+	}
+	if f.limits.MaxDuration > 0 {
+		// the session loads blocks with its own context, ending it at the
+		// deadline aborts the loads in flight
+		lf.deadline = time.Now().Add(f.limits.MaxDuration)
+		ctx, lf.cancel = context.WithDeadline(ctx, lf.deadline)
+	}
+	lf.Fetcher = f.Factory.NewSession(ctx)
+	return lf
+}
+
+type limitedFetcher struct {
+	fetcher.Fetcher
+	limits   Limits
+	sizes    BlockSizer
+	deadline time.Time
+	// cancel releases the context of the session, which is released at the
+	// deadline anyway as sessions aren't closed.
+	cancel context.CancelFunc
+
+	mu sync.Mutex
+	// direct counts the blocks loaded with BlockOfType, paths holds the
+	// paths blocks were loaded from during traversals and blocks the loaded
+	// links. They are bounded by the limits, as fetching is aborted once one
+	// of them is exceeded.
+	direct int
+	paths  map[string]struct{}
+	blocks map[string]struct{}
+	// sized holds the loaded blocks counted in bytes.
+	sized map[string]struct{}
+	bytes int64
+}
+
+func (f *limitedFetcher) NodeMatching(ctx context.Context, root ipld.Node, selector ipld.Node, cb fetcher.FetchCallback) error {
+	return f.durationErr(f.Fetcher.NodeMatching(ctx, root, selector, f.wrap(ctx, cb)))
+}
+
+func (f *limitedFetcher) BlockOfType(ctx context.Context, link ipld.Link, nodePrototype ipld.NodePrototype) (ipld.Node, error) {
+	f.mu.Lock()
+	f.direct++
+	err := f.record(link)
+	f.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	nd, err := f.Fetcher.BlockOfType(ctx, link, nodePrototype)
+	if err != nil {
+		return nil, f.durationErr(err)
+	}
+	f.mu.Lock()
+	err = f.loaded(ctx, link)
+	f.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return nd, nil
+}
+
+func (f *limitedFetcher) BlockMatchingOfType(ctx context.Context, root ipld.Link, selector ipld.Node, nodePrototype ipld.NodePrototype, cb fetcher.FetchCallback) error {
+	return f.durationErr(f.Fetcher.BlockMatchingOfType(ctx, root, selector, nodePrototype, f.wrap(ctx, cb)))
+}
+
+// durationErr returns the "duration" LimitError for err if it is the
+// session hitting its deadline.
+func (f *limitedFetcher) durationErr(err error) error {
+	if err == nil || f.deadline.IsZero() || !errors.Is(err, context.DeadlineExceeded) || time.Now().Before(f.deadline) {
+		return err
+	}
+	return &LimitError{Limit: "duration", Max: int64(f.limits.MaxDuration)}
+}
+
+// wrap counts the links followed and blocks loaded to reach each result
+// before handing it to cb.
+func (f *limitedFetcher) wrap(ctx context.Context, cb fetcher.FetchCallback) fetcher.FetchCallback {
+	return func(res fetcher.FetchResult) error {
+		if res.LastBlockLink != nil {
+			f.mu.Lock()
+			f.paths[res.LastBlockPath.String()] = struct{}{}
+			err := f.record(res.LastBlockLink)
+			if err == nil {
+				err = f.loaded(ctx, res.LastBlockLink)
+			}
+			f.mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+		return cb(res)
+	}
+}
+
+// record adds link to the loaded blocks and checks the limits. f.mu must be
+// held.
+func (f *limitedFetcher) record(link ipld.Link) error {
+	f.blocks[link.String()] = struct{}{}
+	if f.limits.MaxLinks > 0 && f.direct+len(f.paths) > f.limits.MaxLinks {
+		return &LimitError{Limit: "links", Max: int64(f.limits.MaxLinks)}
+	}
+	if f.limits.MaxBlocks > 0 && len(f.blocks) > f.limits.MaxBlocks {
+		return &LimitError{Limit: "blocks", Max: int64(f.limits.MaxBlocks)}
+	}
+	if !f.deadline.IsZero() && time.Now().After(f.deadline) {
+		return &LimitError{Limit: "duration", Max: int64(f.limits.MaxDuration)}
+	}
+	return nil
+}
+
+// loaded adds the size of link, once loaded, to the bytes loaded and checks
+// MaxBytes. f.mu must be held.
+func (f *limitedFetcher) loaded(ctx context.Context, link ipld.Link) error {
+	if f.limits.MaxBytes <= 0 || f.sizes == nil {
+		return nil
+	}
+	cl, ok := link.(cidlink.Link)
+	if !ok {
+		return nil
+	}
+	if _, ok := f.sized[cl.Cid.KeyString()]; ok {
+		return nil
+	}
+	size, err := f.sizes.GetSize(ctx, cl.Cid)
+	if err != nil {
+		// the block isn't stored, it can't be counted
+		return nil
+	}
+	f.sized[cl.Cid.KeyString()] = struct{}{}
+	if f.bytes += int64(size); f.bytes > f.limits.MaxBytes {
+		return &LimitError{Limit: "bytes", Max: f.limits.MaxBytes}
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package fetchlimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-fetcher"
+	fetcherhelpers "github.com/ipfs/go-fetcher/helpers"
+	bsfetcher "github.com/ipfs/go-fetcher/impl/blockservice"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfsnode"
+	dagpb "github.com/ipld/go-codec-dagpb"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/multiformats/go-multihash"
+)
+
+// fakeFetcher reports results loaded from the given paths and links.
+type fakeFetcher struct {
+	fetcher.Fetcher
+	results []fetcher.FetchResult
+}
+
+func (f *fakeFetcher) BlockMatchingOfType(ctx context.Context, root ipld.Link, selector ipld.Node, nodePrototype ipld.NodePrototype, cb fetcher.FetchCallback) error {
+	for _, res := range f.results {
+		if err := cb(res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type fakeFactory struct {
+	f *fakeFetcher
+}
+
+func (f fakeFactory) NewSession(ctx context.Context) fetcher.Fetcher {
+	return f.f
+}
+
+// slowFetcher loads blocks until its session ends.
+type slowFetcher struct {
+	fetcher.Fetcher
+	ctx context.Context
+}
+
+func (f *slowFetcher) BlockOfType(ctx context.Context, link ipld.Link, nodePrototype ipld.NodePrototype) (ipld.Node, error) {
+	<-f.ctx.Done()
+	return nil, f.ctx.Err()
+}
+
+type slowFactory struct{}
+
+func (slowFactory) NewSession(ctx context.Context) fetcher.Fetcher {
+	return &slowFetcher{ctx: ctx}
+}
+
+func testLink(t *testing.T, data string) ipld.Link {
+	h, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cidlink.Link{Cid: cid.NewCidV1(cid.Raw, h)}
+}
+
+func TestLimits(t *testing.T) {
+	a, b := testLink(t, "a"), testLink(t, "b")
+	// b is linked twice from a
+	results := []fetcher.FetchResult{
+		{LastBlockPath: ipld.ParsePath(""), LastBlockLink: a},
+		{LastBlockPath: ipld.ParsePath("0"), LastBlockLink: b},
+		{LastBlockPath: ipld.ParsePath("0"), LastBlockLink: b},
+		{LastBlockPath: ipld.ParsePath("1"), LastBlockLink: b},
+	}
+
+	for _, tc := range []struct {
+		limits Limits
+		err    string
+	}{
+		{limits: Limits{}},
+		{limits: Limits{MaxLinks: 3, MaxBlocks: 2}},
+		{limits: Limits{MaxLinks: 2}, err: "links"},
+		{limits: Limits{MaxBlocks: 1}, err: "blocks"},
+	} {
+		var seen int
+		f := NewFactory(fakeFactory{&fakeFetcher{results: results}}, tc.limits, nil).NewSession(context.Background())
+		err := f.BlockMatchingOfType(context.Background(), a, nil, nil, func(fetcher.FetchResult) error {
+			seen++
+			return nil
+		})
+		if tc.err == "" {
+			if err != nil {
+				t.Fatalf("%+v: unexpected error: %s", tc.limits, err)
+			}
+			if seen != len(results) {
+				t.Fatalf("%+v: expected %d results, got %d", tc.limits, len(results), seen)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("%+v: expected ErrLimitExceeded, got %v", tc.limits, err)
+		}
+		var lerr *LimitError
+		if !errors.As(err, &lerr) || lerr.Limit != tc.err {
+			t.Fatalf("%+v: expected %s limit error, got %v", tc.limits, tc.err, err)
+		}
+	}
+}
+
+// TestWideDAG traverses a root linking width leaves with the fetchers of the
+// gateway.
+func TestWideDAG(t *testing.T) {
+	const width = 100
+	ctx := context.Background()
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	bserv := blockservice.New(bs, offline.Exchange(bs))
+	dag := merkledag.NewDAGService(bserv)
+
+	root := merkledag.NodeWithData(nil)
+	var leavesSize int
+	for i := 0; i < width; i++ {
+		leaf := merkledag.NewRawNode([]byte(fmt.Sprintf("leaf %d", i)))
+		if err := dag.Add(ctx, leaf); err != nil {
+			t.Fatal(err)
+		}
+		if err := root.AddNodeLink(fmt.Sprint(i), leaf); err != nil {
+			t.Fatal(err)
+		}
+		leavesSize += len(leaf.RawData())
+	}
+	if err := dag.Add(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+
+	fetcherConfig := bsfetcher.NewFetcherConfig(bserv)
+	fetcherConfig.PrototypeChooser = dagpb.AddSupportToChooser(func(lnk ipld.Link, lnkCtx ipld.LinkContext) (ipld.NodePrototype, error) {
+		return basicnode.Prototype.Any, nil
+	})
+	unixfsFetcher := fetcherConfig.WithReifier(unixfsnode.Reify)
+
+	for _, tc := range []struct {
+		limits Limits
+		err    string
+	}{
+		{limits: Limits{MaxBlocks: width + 1, MaxBytes: int64(len(root.RawData()) + leavesSize), MaxDuration: time.Minute}},
+		{limits: Limits{MaxBlocks: width / 2}, err: "blocks"},
+		{limits: Limits{MaxLinks: width / 2}, err: "links"},
+		{limits: Limits{MaxBytes: int64(len(root.RawData()))}, err: "bytes"},
+	} {
+		var leaves int
+		f := NewFactory(unixfsFetcher, tc.limits, bs).NewSession(ctx)
+		err := fetcherhelpers.BlockAll(ctx, f, cidlink.Link{Cid: root.Cid()}, func(res fetcher.FetchResult) error {
+			if res.LastBlockLink.(cidlink.Link).Cid.Prefix().Codec == cid.Raw {
+				leaves++
+			}
+			return nil
+		})
+		if tc.err == "" {
+			if err != nil {
+				t.Fatalf("%+v: unexpected error: %s", tc.limits, err)
+			}
+			continue
+		}
+		var lerr *LimitError
+		if !errors.As(err, &lerr) || lerr.Limit != tc.err {
+			t.Fatalf("%+v: expected %s limit error, got %v", tc.limits, tc.err, err)
+		}
+		if leaves >= width {
+			t.Fatalf("%+v: expected the traversal to stop early, got %d leaves", tc.limits, leaves)
+		}
+	}
+}
+
+func TestDuration(t *testing.T) {
+	f := NewFactory(slowFactory{}, Limits{MaxDuration: 10 * time.Millisecond}, nil).NewSession(context.Background())
+	_, err := f.BlockOfType(context.Background(), testLink(t, "a"), nil)
+	var lerr *LimitError
+	if !errors.As(err, &lerr) || lerr.Limit != "duration" {
+		t.Fatalf("expected the load in flight to end with a duration limit error, got %v", err)
+	}
+}
+
+func TestTighten(t *testing.T) {
+	cli := Limits{MaxLinks: 100, MaxBytes: 1 << 20}
+	gw := Limits{MaxLinks: 1000, MaxBlocks: 10, MaxBytes: 1 << 10, MaxDuration: time.Second}
+	expected := Limits{MaxLinks: 100, MaxBlocks: 10, MaxBytes: 1 << 10, MaxDuration: time.Second}
+	if l := cli.Tighten(gw); l != expected {
+		t.Fatalf("expected %+v, got %+v", expected, l)
+	}
+	if l := cli.Tighten(Limits{}); l != cli {
+		t.Fatalf("expected %+v, got %+v", cli, l)
+	}
+}
@@ -0,0 +1,157 @@
+package node
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/bittorrent/go-btfs/core/node/helpers"
+	bsmsg "github.com/ipfs/boxo/bitswap/message"
+	"github.com/ipfs/boxo/bitswap/network"
+	"github.com/ipfs/go-metrics-interface"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// DataBandwidth counts the bytes of data moved by adds and by the bitswap
+// exchange, by direction. The counters are also exported as
+// btfs_data_bandwidth_*.
+type DataBandwidth struct {
+	addIn      atomic.Uint64
+	addOut     atomic.Uint64
+	bitswapIn  atomic.Uint64
+	bitswapOut atomic.Uint64
+
+	addInTotal      metrics.Counter
+	addOutTotal     metrics.Counter
+	bitswapInTotal  metrics.Counter
+	bitswapOutTotal metrics.Counter
+}
+
+// DataBandwidthStats are the totals counted by a DataBandwidth since the
+// node started.
+type DataBandwidthStats struct {
+	// AddIn is the size of the files read by adds, before any encryption.
+	AddIn uint64
+	// AddOut is the size of the blocks adds wrote to the blockstore, which
+	// differs from AddIn by the DAG overhead and the encryption.
+	AddOut uint64
+	// BitswapIn is the size of the blocks received from other peers.
+	BitswapIn uint64
+	// BitswapOut is the size of the blocks sent to other peers.
+	BitswapOut uint64
+}
+
+func NewDataBandwidth(mctx helpers.MetricsCtx) *DataBandwidth {
+	ctx := metrics.CtxSubScope(mctx, "data_bandwidth")
+	return &DataBandwidth{
+		addInTotal: metrics.NewCtx(ctx, "add_in_bytes_total",
+			"Bytes of files read by adds").Counter(),
+		addOutTotal: metrics.NewCtx(ctx, "add_out_bytes_total",
+			"Bytes of blocks written to the blockstore by adds").Counter(),
+		bitswapInTotal: metrics.NewCtx(ctx, "bitswap_in_bytes_total",
+			"Bytes of blocks received with bitswap").Counter(),
+		bitswapOutTotal: metrics.NewCtx(ctx, "bitswap_out_bytes_total",
+			"Bytes of blocks sent with bitswap").Counter(),
+	}
+}
+
+// AddIn counts n bytes of files read by an add.
+func (b *DataBandwidth) AddIn(n int) {
+	b.addIn.Add(uint64(n))
+	b.addInTotal.Add(float64(n))
+}
+
+// AddOut counts n bytes of blocks written by an add.
+func (b *DataBandwidth) AddOut(n int) {
+	b.addOut.Add(uint64(n))
+	b.addOutTotal.Add(float64(n))
+}
+
+func (b *DataBandwidth) countBitswapIn(msg bsmsg.BitSwapMessage) {
+	n := blocksSize(msg)
+	b.bitswapIn.Add(uint64(n))
+	b.bitswapInTotal.Add(float64(n))
+}
+
+func (b *DataBandwidth) countBitswapOut(msg bsmsg.BitSwapMessage) {
+	n := blocksSize(msg)
+	b.bitswapOut.Add(uint64(n))
+	b.bitswapOutTotal.Add(float64(n))
+}
+
+// Stats returns the totals counted so far.
+func (b *DataBandwidth) Stats() DataBandwidthStats {
+	return DataBandwidthStats{
+		AddIn:      b.addIn.Load(),
+		AddOut:     b.addOut.Load(),
+		BitswapIn:  b.bitswapIn.Load(),
+		BitswapOut: b.bitswapOut.Load(),
+	}
+}
+
+// blocksSize returns the size of the blocks carried by msg.
+func blocksSize(msg bsmsg.BitSwapMessage) int {
+	size := 0
+	for _, b := range msg.Blocks() {
+		size += len(b.RawData())
+	}
+	return size
+}
+
+// bandwidthNetwork counts the blocks sent and received through the bitswap
+// network. Messages are counted once sent successfully.
+type bandwidthNetwork struct {
+	network.BitSwapNetwork
+	bw *DataBandwidth
+}
+
+func newBandwidthNetwork(n network.BitSwapNetwork, bw *DataBandwidth) *bandwidthNetwork {
+	return &bandwidthNetwork{BitSwapNetwork: n, bw: bw}
+}
+
+func (n *bandwidthNetwork) Start(receivers ...network.Receiver) {
+	// every receiver gets every message, count them through the first one
+	if len(receivers) > 0 {
+		receivers = append([]network.Receiver{&bandwidthReceiver{Receiver: receivers[0], bw: n.bw}},
+			receivers[1:]...)
+	}
+	n.BitSwapNetwork.Start(receivers...)
+}
+
+func (n *bandwidthNetwork) SendMessage(ctx context.Context, p peer.ID, msg bsmsg.BitSwapMessage) error {
+	if err := n.BitSwapNetwork.SendMessage(ctx, p, msg); err != nil {
+		return err
+	}
+	n.bw.countBitswapOut(msg)
+	return nil
+}
+
+func (n *bandwidthNetwork) NewMessageSender(ctx context.Context, p peer.ID, opts *network.MessageSenderOpts) (network.MessageSender, error) {
+	s, err := n.BitSwapNetwork.NewMessageSender(ctx, p, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &bandwidthSender{MessageSender: s, bw: n.bw}, nil
+}
+
+type bandwidthSender struct {
+	network.MessageSender
+	bw *DataBandwidth
+}
+
+func (s *bandwidthSender) SendMsg(ctx context.Context, msg bsmsg.BitSwapMessage) error {
+	if err := s.MessageSender.SendMsg(ctx, msg); err != nil {
+		return err
+	}
+	s.bw.countBitswapOut(msg)
+	return nil
+}
+
+type bandwidthReceiver struct {
+	network.Receiver
+	bw *DataBandwidth
+}
+
+func (r *bandwidthReceiver) ReceiveMessage(ctx context.Context, sender peer.ID, incoming bsmsg.BitSwapMessage) {
+	r.bw.countBitswapIn(incoming)
+	r.Receiver.ReceiveMessage(ctx, sender, incoming)
+}
@@ -0,0 +1,76 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bittorrent/go-btfs/core/node/helpers"
+	bsmsg "github.com/ipfs/boxo/bitswap/message"
+	"github.com/ipfs/boxo/bitswap/network"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// startedNetwork keeps the receivers it is started with, and fails to send
+// while failing is set.
+type startedNetwork struct {
+	network.BitSwapNetwork
+	receivers []network.Receiver
+	failing   bool
+}
+
+func (n *startedNetwork) Start(receivers ...network.Receiver) {
+	n.receivers = receivers
+}
+
+func (n *startedNetwork) SendMessage(ctx context.Context, p peer.ID, msg bsmsg.BitSwapMessage) error {
+	if n.failing {
+		return errors.New("stream reset")
+	}
+	return nil
+}
+
+type receivingReceiver struct {
+	network.Receiver
+	received int
+}
+
+func (r *receivingReceiver) ReceiveMessage(ctx context.Context, sender peer.ID, incoming bsmsg.BitSwapMessage) {
+	r.received++
+}
+
+func TestBandwidthNetwork(t *testing.T) {
+	bw := NewDataBandwidth(helpers.MetricsCtx(context.Background()))
+	inner := &startedNetwork{}
+	n := newBandwidthNetwork(inner, bw)
+
+	msg := bsmsg.New(false)
+	msg.AddBlock(blocks.NewBlock(make([]byte, 1000)))
+	msg.AddBlock(blocks.NewBlock(make([]byte, 24)))
+
+	if err := n.SendMessage(context.Background(), "", msg); err != nil {
+		t.Fatal(err)
+	}
+	inner.failing = true
+	if err := n.SendMessage(context.Background(), "", msg); err == nil {
+		t.Fatal("expected the send to fail")
+	}
+
+	// both receivers get the message, it is counted once
+	client, server := &receivingReceiver{}, &receivingReceiver{}
+	n.Start(client, server)
+	for _, r := range inner.receivers {
+		r.ReceiveMessage(context.Background(), "", msg)
+	}
+	if client.received != 1 || server.received != 1 {
+		t.Fatalf("expected the message delivered to both receivers, got %d and %d", client.received, server.received)
+	}
+
+	bw.AddIn(100)
+	bw.AddOut(150)
+	expected := DataBandwidthStats{AddIn: 100, AddOut: 150, BitswapIn: 1024, BitswapOut: 1024}
+	if s := bw.Stats(); s != expected {
+		t.Fatalf("expected %+v, got %+v", expected, s)
+	}
+}
@@ -0,0 +1,47 @@
+package node
+
+import (
+	"context"
+	"sync/atomic"
+
+	irouting "github.com/bittorrent/go-btfs/routing"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// BitswapProvideControl switches bitswap announcements of new blocks to the
+// routing system on and off at runtime. The state is kept in memory only, so
+// a restart goes back to the configured value.
+type BitswapProvideControl struct {
+	enabled atomic.Bool
+}
+
+// Enabled reports whether bitswap currently announces blocks.
+func (c *BitswapProvideControl) Enabled() bool {
+	return c.enabled.Load()
+}
+
+// SetEnabled turns bitswap announcements on or off.
+func (c *BitswapProvideControl) SetEnabled(enabled bool) {
+	c.enabled.Store(enabled)
+}
+
+// provideToggleRouter drops provide calls while its control is disabled.
+type provideToggleRouter struct {
+	irouting.ProvideManyRouter
+	control *BitswapProvideControl
+}
+
+func (r *provideToggleRouter) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	if !r.control.Enabled() {
+		return nil
+	}
+	return r.ProvideManyRouter.Provide(ctx, c, announce)
+}
+
+func (r *provideToggleRouter) ProvideMany(ctx context.Context, keys []multihash.Multihash) error {
+	if !r.control.Enabled() {
+		return nil
+	}
+	return r.ProvideManyRouter.ProvideMany(ctx, keys)
+}
@@ -0,0 +1,97 @@
+package node
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dustin/go-humanize"
+	bsmsg "github.com/ipfs/boxo/bitswap/message"
+	"github.com/ipfs/boxo/bitswap/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"golang.org/x/time/rate"
+)
+
+// BitswapOutboundRateConfigKey is the config key holding the maximum rate,
+// in bytes per second, of the blocks bitswap sends to other peers, e.g.
+// "2MB". Unset or "0" means unlimited. It is read when the node starts.
+const BitswapOutboundRateConfigKey = "Internal.Bitswap.OutboundRate"
+
+// bitswapOutboundRate reads BitswapOutboundRateConfigKey through
+// getConfigKey.
+func bitswapOutboundRate(getConfigKey func(string) (interface{}, error)) (uint64, error) {
+	val, err := getConfigKey(BitswapOutboundRateConfigKey)
+	if err != nil || val == nil {
+		return 0, nil // not set
+	}
+	s, ok := val.(string)
+	if !ok {
+		return 0, fmt.Errorf("invalid %s %v, must be a size string", BitswapOutboundRateConfigKey, val)
+	}
+	n, err := humanize.ParseBytes(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", BitswapOutboundRateConfigKey, s, err)
+	}
+	return n, nil
+}
+
+// rateLimitedNetwork delays the messages sent through the bitswap network
+// until the limiter allows the size of the blocks they carry. Messages
+// without blocks, such as wants and haves, are not delayed.
+type rateLimitedNetwork struct {
+	network.BitSwapNetwork
+	limiter *rate.Limiter
+}
+
+func newRateLimitedNetwork(n network.BitSwapNetwork, bytesPerSecond uint64) *rateLimitedNetwork {
+	// the burst is one second worth of bytes, larger messages wait for
+	// several bursts
+	burst := int(bytesPerSecond)
+	return &rateLimitedNetwork{
+		BitSwapNetwork: n,
+		limiter:        rate.NewLimiter(rate.Limit(bytesPerSecond), burst),
+	}
+}
+
+// wait blocks until the blocks of msg may be sent, or ctx is done.
+func (n *rateLimitedNetwork) wait(ctx context.Context, msg bsmsg.BitSwapMessage) error {
+	size := blocksSize(msg)
+	for size > 0 {
+		chunk := size
+		if burst := n.limiter.Burst(); chunk > burst {
+			chunk = burst
+		}
+		// WaitN returns as soon as ctx is done, without consuming tokens
+		if err := n.limiter.WaitN(ctx, chunk); err != nil {
+			return err
+		}
+		size -= chunk
+	}
+	return nil
+}
+
+func (n *rateLimitedNetwork) SendMessage(ctx context.Context, p peer.ID, msg bsmsg.BitSwapMessage) error {
+	if err := n.wait(ctx, msg); err != nil {
+		return err
+	}
+	return n.BitSwapNetwork.SendMessage(ctx, p, msg)
+}
+
+func (n *rateLimitedNetwork) NewMessageSender(ctx context.Context, p peer.ID, opts *network.MessageSenderOpts) (network.MessageSender, error) {
+	s, err := n.BitSwapNetwork.NewMessageSender(ctx, p, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &rateLimitedSender{MessageSender: s, network: n}, nil
+}
+
+type rateLimitedSender struct {
+	network.MessageSender
+	network *rateLimitedNetwork
+}
+
+func (s *rateLimitedSender) SendMsg(ctx context.Context, msg bsmsg.BitSwapMessage) error {
+	if err := s.network.wait(ctx, msg); err != nil {
+		return err
+	}
+	return s.MessageSender.SendMsg(ctx, msg)
+}
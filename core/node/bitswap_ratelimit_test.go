@@ -0,0 +1,72 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	bsmsg "github.com/ipfs/boxo/bitswap/message"
+	"github.com/ipfs/boxo/bitswap/network"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// countingNetwork counts the messages sent through it.
+type countingNetwork struct {
+	network.BitSwapNetwork
+	sent int
+}
+
+func (n *countingNetwork) SendMessage(ctx context.Context, p peer.ID, msg bsmsg.BitSwapMessage) error {
+	n.sent++
+	return nil
+}
+
+func TestRateLimitedNetworkCancel(t *testing.T) {
+	inner := &countingNetwork{}
+	n := newRateLimitedNetwork(inner, 1024)
+
+	msg := bsmsg.New(false)
+	msg.AddBlock(blocks.NewBlock(make([]byte, 1024)))
+
+	// the first message takes the whole burst
+	if err := n.SendMessage(context.Background(), "", msg); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- n.SendMessage(ctx, "", msg)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected %v, got %v", context.Canceled, err)
+		}
+	case <-time.After(time.Second / 2):
+		t.Fatal("throttled send didn't return once canceled")
+	}
+	if inner.sent != 1 {
+		t.Fatalf("expected 1 message sent, got %d", inner.sent)
+	}
+}
+
+func TestBitswapOutboundRate(t *testing.T) {
+	get := func(v interface{}) func(string) (interface{}, error) {
+		return func(string) (interface{}, error) { return v, nil }
+	}
+	if r, err := bitswapOutboundRate(get(nil)); err != nil || r != 0 {
+		t.Fatalf("expected unlimited when unset, got %d (%v)", r, err)
+	}
+	if r, err := bitswapOutboundRate(get("2MB")); err != nil || r != 2000000 {
+		t.Fatalf("expected 2000000, got %d (%v)", r, err)
+	}
+	if _, err := bitswapOutboundRate(get(12.0)); err == nil {
+		t.Fatal("expected an error for a number")
+	}
+}
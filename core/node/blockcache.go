@@ -0,0 +1,138 @@
+package node
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+// BlockCacheSizeConfigKey is the config key holding the size, in MB, of the
+// in-memory block cache on top of the base blockstore. Unset or 0 disables it.
+const BlockCacheSizeConfigKey = "Datastore.BlockCacheSizeMB"
+
+// blockCacheSize reads BlockCacheSizeConfigKey through getConfigKey and
+// returns the cache size in bytes.
+func blockCacheSize(getConfigKey func(string) (interface{}, error)) (int64, error) {
+	val, err := getConfigKey(BlockCacheSizeConfigKey)
+	if err != nil || val == nil {
+		return 0, nil // not set
+	}
+	mb, ok := val.(float64)
+	if !ok || mb < 0 {
+		return 0, fmt.Errorf("invalid %s %v, must be a non-negative number", BlockCacheSizeConfigKey, val)
+	}
+	return int64(mb * 1024 * 1024), nil
+}
+
+// blockCache is a blockstore keeping recently read blocks in memory, up to
+// maxSize bytes of block data, evicting the least recently used ones.
+//
+// It wraps the base blockstore, so that every deletion, by GC or otherwise,
+// goes through it and drops the cached block.
+type blockCache struct {
+	blockstore.Blockstore
+
+	mu      sync.Mutex
+	maxSize int64
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+	// deletes counts the deletions, a block read from the blockstore is
+	// only cached if none happened meanwhile, as it may be the one deleted.
+	deletes uint64
+}
+
+func newBlockCache(bs blockstore.Blockstore, maxSize int64) *blockCache {
+	return &blockCache{
+		Blockstore: bs,
+		maxSize:    maxSize,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+func (c *blockCache) Get(ctx context.Context, k cid.Cid) (blocks.Block, error) {
+	if b, ok := c.get(k); ok {
+		return b, nil
+	}
+
+	c.mu.Lock()
+	deletes := c.deletes
+	c.mu.Unlock()
+	b, err := c.Blockstore.Get(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	c.add(b, deletes)
+	return b, nil
+}
+
+func (c *blockCache) DeleteBlock(ctx context.Context, k cid.Cid) error {
+	err := c.Blockstore.DeleteBlock(ctx, k)
+	c.remove(k)
+	return err
+}
+
+func (c *blockCache) get(k cid.Cid) (blocks.Block, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[k.KeyString()]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(blocks.Block), true
+}
+
+// add caches b, read when deletes deletions had happened.
+func (c *blockCache) add(b blocks.Block, deletes uint64) {
+	size := int64(len(b.RawData()))
+	if size > c.maxSize {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.deletes != deletes {
+		return
+	}
+	key := b.Cid().KeyString()
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(b)
+	c.size += size
+	for c.size > c.maxSize {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+func (c *blockCache) remove(k cid.Cid) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deletes++
+	if e, ok := c.entries[k.KeyString()]; ok {
+		c.removeElement(e)
+	}
+}
+
+func (c *blockCache) removeElement(e *list.Element) {
+	b := c.lru.Remove(e).(blocks.Block)
+	delete(c.entries, b.Cid().KeyString())
+	c.size -= int64(len(b.RawData()))
+}
+
+// purge drops all cached blocks.
+func (c *blockCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	c.size = 0
+}
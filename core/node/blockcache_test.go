@@ -0,0 +1,144 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// countingBlockstore counts the reads of the blockstore it wraps.
+type countingBlockstore struct {
+	blockstore.Blockstore
+	gets int
+	has  int
+}
+
+func (bs *countingBlockstore) Get(ctx context.Context, k cid.Cid) (blocks.Block, error) {
+	bs.gets++
+	return bs.Blockstore.Get(ctx, k)
+}
+
+func (bs *countingBlockstore) Has(ctx context.Context, k cid.Cid) (bool, error) {
+	bs.has++
+	return bs.Blockstore.Has(ctx, k)
+}
+
+func TestBlockCache(t *testing.T) {
+	ctx := context.Background()
+	under := &countingBlockstore{
+		Blockstore: blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore())),
+	}
+	a := blocks.NewBlock(bytes.Repeat([]byte("a"), 40))
+	b := blocks.NewBlock(bytes.Repeat([]byte("b"), 40))
+	c := blocks.NewBlock(bytes.Repeat([]byte("c"), 40))
+	large := blocks.NewBlock(bytes.Repeat([]byte("l"), 101))
+	for _, blk := range []blocks.Block{a, b, c, large} {
+		if err := under.Put(ctx, blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cache := newBlockCache(under, 100)
+	get := func(blk blocks.Block) {
+		t.Helper()
+		got, err := cache.Get(ctx, blk.Cid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.RawData(), blk.RawData()) {
+			t.Fatalf("unexpected data for %s", blk.Cid())
+		}
+	}
+	expectReads := func(gets int) {
+		t.Helper()
+		if under.gets != gets || under.has != 0 {
+			t.Fatalf("expected %d reads below the cache and no has, got %d and %d", gets, under.gets, under.has)
+		}
+	}
+
+	// hits don't read the blockstore
+	get(a)
+	get(a)
+	expectReads(1)
+	get(b)
+	if cache.size != 80 {
+		t.Fatalf("expected 80 cached bytes, got %d", cache.size)
+	}
+
+	// a is used more recently than b, which is evicted to make room for c
+	get(a)
+	get(c)
+	expectReads(3)
+	if cache.size != 80 || len(cache.entries) != 2 {
+		t.Fatalf("expected 2 cached blocks of 80 bytes, got %d of %d bytes", len(cache.entries), cache.size)
+	}
+	get(a)
+	expectReads(3)
+	get(b)
+	expectReads(4)
+
+	// blocks larger than the cache aren't cached
+	get(large)
+	get(large)
+	expectReads(6)
+
+	// deleted blocks are dropped from the cache
+	if err := cache.DeleteBlock(ctx, b.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Get(ctx, b.Cid()); !ipld.IsNotFound(err) {
+		t.Fatalf("expected a deleted block not to be found, got %v", err)
+	}
+	if cache.size != 40 || len(cache.entries) != 1 {
+		t.Fatalf("expected 1 cached block of 40 bytes, got %d of %d bytes", len(cache.entries), cache.size)
+	}
+
+	// a block read before a deletion isn't cached after it
+	deletes := cache.deletes
+	cache.remove(c.Cid())
+	cache.add(c, deletes)
+	if _, ok := cache.entries[c.Cid().KeyString()]; ok {
+		t.Fatal("expected a block read before a deletion not to be cached")
+	}
+
+	cache.purge()
+	if cache.size != 0 || len(cache.entries) != 0 || cache.lru.Len() != 0 {
+		t.Fatal("expected an empty cache after purge")
+	}
+}
+
+func TestBlockCacheSize(t *testing.T) {
+	config := func(val interface{}, err error) func(string) (interface{}, error) {
+		return func(string) (interface{}, error) {
+			return val, err
+		}
+	}
+	for _, c := range []struct {
+		val      interface{}
+		err      error
+		expected int64
+	}{
+		{nil, nil, 0},
+		{nil, errors.New("not set"), 0},
+		{float64(0), nil, 0},
+		{float64(2), nil, 2 << 20},
+		{0.5, nil, 1 << 19},
+	} {
+		size, err := blockCacheSize(config(c.val, c.err))
+		if err != nil || size != c.expected {
+			t.Errorf("%v: expected %d, got %d (%v)", c.val, c.expected, size, err)
+		}
+	}
+	for _, val := range []interface{}{float64(-1), "64", true} {
+		if _, err := blockCacheSize(config(val, nil)); err == nil {
+			t.Errorf("%v: expected an error", val)
+		}
+	}
+}
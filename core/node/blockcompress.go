@@ -0,0 +1,410 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/bittorrent/go-btfs/core/node/helpers"
+	"github.com/bittorrent/go-btfs/repo"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dsq "github.com/ipfs/go-datastore/query"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/klauspost/compress/zstd"
+	"go.uber.org/fx"
+)
+
+// BlockCompressionConfigKey is the config key selecting how blocks are
+// compressed at rest: "zstd", or unset, "" or "none" for no compression.
+// Blocks are always decompressed on read, so it can be turned off again
+// without losing access to the blocks already compressed.
+const BlockCompressionConfigKey = "Datastore.BlockCompression"
+
+// blockCompressionDoneKey records, in the repo datastore, the compression all
+// the blocks of the repo were migrated to.
+var blockCompressionDoneKey = datastore.NewKey("/local/block-compression")
+
+// blockCompressionIndexPrefix is where the repo datastore indexes the blocks
+// stored compressed, with their uncompressed size.
+var blockCompressionIndexPrefix = datastore.NewKey("/local/block-compression/index")
+
+// blockCompressionBatch is how many blocks are migrated at once, while GC is
+// held off.
+const blockCompressionBatch = 256
+
+// compressedMagic starts the stored blocks compressed by blockCompressor,
+// followed by their zstd frame. Only the blocks in the index are read as
+// compressed ones, whatever they start with.
+var compressedMagic = []byte{0xb7, 0xf5, 'b', 't', 'z', 0x00, 0x01, 'z'}
+
+// blockCompression reads BlockCompressionConfigKey through getConfigKey and
+// reports whether blocks are compressed.
+func blockCompression(getConfigKey func(string) (interface{}, error)) (bool, error) {
+	val, err := getConfigKey(BlockCompressionConfigKey)
+	if err != nil || val == nil {
+		return false, nil // not set
+	}
+	s, _ := val.(string)
+	switch s {
+	case "none":
+		return false, nil
+	case "zstd":
+		return true, nil
+	case "":
+		if _, ok := val.(string); ok {
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("invalid %s %v, must be \"zstd\" or \"none\"", BlockCompressionConfigKey, val)
+}
+
+// blockstoreDatastore returns the part of the repo datastore d holding the
+// blocks.
+func blockstoreDatastore(d datastore.Batching) datastore.Batching {
+	return namespace.Wrap(d, blockstore.BlockPrefix)
+}
+
+// blockCompressionIndex returns the part of the repo datastore d indexing
+// the blocks stored compressed.
+func blockCompressionIndex(d datastore.Batching) datastore.Batching {
+	return namespace.Wrap(d, blockCompressionIndexPrefix)
+}
+
+// blocksCompressed reports whether any block of the repo datastore d is
+// stored compressed, even if compression was turned off since.
+func blocksCompressed(ctx context.Context, d datastore.Batching) (bool, error) {
+	res, err := blockCompressionIndex(d).Query(ctx, dsq.Query{KeysOnly: true, Limit: 1})
+	if err != nil {
+		return false, err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return false, err
+	}
+	return len(entries) > 0, nil
+}
+
+// blockCompressor is a datastore holding blocks, compressing them on write
+// when compress is set and decompressing them on read. The keys, hence the
+// CIDs, are those of the uncompressed blocks. The blocks stored compressed
+// are in index, all the others are stored as is.
+type blockCompressor struct {
+	datastore.Batching
+	// index maps the keys of the blocks stored compressed to their
+	// uncompressed size, as a uvarint.
+	index    datastore.Batching
+	compress bool
+	enc      *zstd.Encoder
+	dec      *zstd.Decoder
+}
+
+func newBlockCompressor(d datastore.Batching, index datastore.Batching, compress bool) (*blockCompressor, error) {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &blockCompressor{Batching: d, index: index, compress: compress, enc: enc, dec: dec}, nil
+}
+
+// encode returns the stored form of block, and whether it is compressed. It
+// is only compressed if that makes it smaller.
+func (c *blockCompressor) encode(block []byte) ([]byte, bool) {
+	if !c.compress {
+		return block, false
+	}
+	buf := make([]byte, 0, len(compressedMagic)+len(block)/2)
+	buf = append(buf, compressedMagic...)
+	buf = c.enc.EncodeAll(block, buf)
+	if len(buf) >= len(block) {
+		return block, false
+	}
+	return buf, true
+}
+
+// decode returns the block stored compressed as value.
+func (c *blockCompressor) decode(value []byte, size int) ([]byte, error) {
+	if !bytes.HasPrefix(value, compressedMagic) {
+		// indexed but not written compressed, written back as is since
+		return value, nil
+	}
+	block, err := c.dec.DecodeAll(value[len(compressedMagic):], make([]byte, 0, size))
+	if err != nil {
+		return nil, fmt.Errorf("corrupted compressed block: %w", err)
+	}
+	return block, nil
+}
+
+// compressedSize returns the uncompressed size of the block key if it is
+// stored compressed.
+func (c *blockCompressor) compressedSize(ctx context.Context, key datastore.Key) (int, bool, error) {
+	v, err := c.index.Get(ctx, key)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	size, n := binary.Uvarint(v)
+	if n <= 0 {
+		return 0, false, fmt.Errorf("corrupted compressed block index entry %s", key)
+	}
+	return int(size), true, nil
+}
+
+func (c *blockCompressor) Get(ctx context.Context, key datastore.Key) ([]byte, error) {
+	value, err := c.Batching.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	size, compressed, err := c.compressedSize(ctx, key)
+	if err != nil || !compressed {
+		return value, err
+	}
+	return c.decode(value, size)
+}
+
+// GetSize returns the uncompressed size of the block, from the index for a
+// compressed one.
+func (c *blockCompressor) GetSize(ctx context.Context, key datastore.Key) (int, error) {
+	size, compressed, err := c.compressedSize(ctx, key)
+	if err != nil {
+		return -1, err
+	}
+	if !compressed {
+		return c.Batching.GetSize(ctx, key)
+	}
+	// the index entry may outlive a block removed from the datastore
+	if _, err := c.Batching.GetSize(ctx, key); err != nil {
+		return -1, err
+	}
+	return size, nil
+}
+
+// Put indexes a block stored compressed before storing it, so that it is
+// never read back without being decompressed.
+func (c *blockCompressor) Put(ctx context.Context, key datastore.Key, value []byte) error {
+	stored, compressed := c.encode(value)
+	if !compressed {
+		if err := c.Batching.Put(ctx, key, value); err != nil {
+			return err
+		}
+		return c.index.Delete(ctx, key)
+	}
+	if err := c.index.Put(ctx, key, binary.AppendUvarint(nil, uint64(len(value)))); err != nil {
+		return err
+	}
+	return c.Batching.Put(ctx, key, stored)
+}
+
+func (c *blockCompressor) Delete(ctx context.Context, key datastore.Key) error {
+	if err := c.Batching.Delete(ctx, key); err != nil {
+		return err
+	}
+	return c.index.Delete(ctx, key)
+}
+
+// Query decompresses the values of the results. The sizes of keys only
+// queries are those stored.
+func (c *blockCompressor) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	res, err := c.Batching.Query(ctx, q)
+	if err != nil || q.KeysOnly {
+		return res, err
+	}
+	return dsq.ResultsFromIterator(q, dsq.Iterator{
+		Next: func() (dsq.Result, bool) {
+			r, ok := res.NextSync()
+			if !ok || r.Error != nil {
+				return r, ok
+			}
+			size, compressed, err := c.compressedSize(ctx, datastore.NewKey(r.Key))
+			if err != nil {
+				r.Error = err
+			} else if compressed {
+				r.Value, r.Error = c.decode(r.Value, size)
+				r.Size = len(r.Value)
+			}
+			return r, ok
+		},
+		Close: res.Close,
+	}), nil
+}
+
+func (c *blockCompressor) Batch(ctx context.Context) (datastore.Batch, error) {
+	b, err := c.Batching.Batch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ib, err := c.index.Batch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &blockCompressorBatch{Batch: b, index: ib, c: c}, nil
+}
+
+type blockCompressorBatch struct {
+	datastore.Batch
+	index datastore.Batch
+	c     *blockCompressor
+}
+
+func (b *blockCompressorBatch) Put(ctx context.Context, key datastore.Key, value []byte) error {
+	stored, compressed := b.c.encode(value)
+	if !compressed {
+		if err := b.index.Delete(ctx, key); err != nil {
+			return err
+		}
+		return b.Batch.Put(ctx, key, value)
+	}
+	if err := b.index.Put(ctx, key, binary.AppendUvarint(nil, uint64(len(value)))); err != nil {
+		return err
+	}
+	return b.Batch.Put(ctx, key, stored)
+}
+
+func (b *blockCompressorBatch) Delete(ctx context.Context, key datastore.Key) error {
+	if err := b.index.Delete(ctx, key); err != nil {
+		return err
+	}
+	return b.Batch.Delete(ctx, key)
+}
+
+// Commit commits the index first, compressed blocks are never stored
+// without being indexed.
+func (b *blockCompressorBatch) Commit(ctx context.Context) error {
+	if err := b.index.Commit(ctx); err != nil {
+		return err
+	}
+	return b.Batch.Commit(ctx)
+}
+
+// migrate compresses the blocks stored before compression was enabled,
+// blockCompressionBatch at a time while holding off GC and block removals,
+// which could otherwise delete a block just before it is written back.
+func (c *blockCompressor) migrate(ctx context.Context, locker blockstore.GCLocker) (int, error) {
+	res, err := c.Batching.Query(ctx, dsq.Query{KeysOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	defer res.Close()
+
+	var migrated int
+	for done := false; !done; {
+		keys := make([]datastore.Key, 0, blockCompressionBatch)
+		for len(keys) < blockCompressionBatch {
+			r, ok := res.NextSync()
+			if !ok {
+				done = true
+				break
+			}
+			if r.Error != nil {
+				return migrated, r.Error
+			}
+			keys = append(keys, datastore.NewKey(r.Key))
+		}
+		n, err := c.migrateKeys(ctx, locker, keys)
+		migrated += n
+		if err != nil {
+			return migrated, err
+		}
+	}
+	return migrated, nil
+}
+
+func (c *blockCompressor) migrateKeys(ctx context.Context, locker blockstore.GCLocker, keys []datastore.Key) (int, error) {
+	defer locker.PinLock(ctx).Unlock(ctx)
+
+	var migrated int
+	for _, k := range keys {
+		if err := ctx.Err(); err != nil {
+			return migrated, err
+		}
+		value, err := c.Batching.Get(ctx, k)
+		if errors.Is(err, datastore.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return migrated, err
+		}
+		if _, compressed, err := c.compressedSize(ctx, k); err != nil {
+			return migrated, err
+		} else if compressed {
+			continue
+		}
+		if _, compressed := c.encode(value); !compressed {
+			continue // doesn't compress
+		}
+		if err := c.Put(ctx, k, value); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+// BlockCompressionMigration compresses, in the background, the blocks stored
+// before BlockCompressionConfigKey enabled compression. Once all the blocks
+// were migrated, it isn't run again until compression is turned off and on.
+func BlockCompressionMigration(mctx helpers.MetricsCtx, lc fx.Lifecycle, r repo.Repo, locker blockstore.GCLocker) error {
+	compress, err := blockCompression(r.GetConfigKey)
+	if err != nil {
+		return err
+	}
+	d := r.Datastore()
+	if !compress {
+		// blocks written from now on aren't compressed
+		if err := d.Delete(mctx, blockCompressionDoneKey); err != nil && !errors.Is(err, datastore.ErrNotFound) {
+			return err
+		}
+		return nil
+	}
+	if _, err := d.Get(mctx, blockCompressionDoneKey); err == nil {
+		return nil
+	} else if !errors.Is(err, datastore.ErrNotFound) {
+		return err
+	}
+
+	c, err := newBlockCompressor(blockstoreDatastore(d), blockCompressionIndex(d), true)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(mctx)
+	var stopped chan struct{}
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			stopped = make(chan struct{})
+			go func() {
+				defer close(stopped)
+				logger.Info("compressing the stored blocks in the background")
+				n, err := c.migrate(ctx, locker)
+				if err != nil {
+					if ctx.Err() == nil {
+						logger.Errorf("compressing the stored blocks: %s", err)
+					}
+					return
+				}
+				if err := d.Put(ctx, blockCompressionDoneKey, []byte("zstd")); err != nil {
+					logger.Errorf("compressing the stored blocks: %s", err)
+					return
+				}
+				logger.Infof("compressed %d stored blocks", n)
+			}()
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			cancel()
+			if stopped != nil {
+				<-stopped
+			}
+			return nil
+		},
+	})
+	return nil
+}
@@ -0,0 +1,114 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+func TestBlockCompression(t *testing.T) {
+	ctx := context.Background()
+	d := dssync.MutexWrap(datastore.NewMapDatastore())
+	raw := blockstoreDatastore(d)
+	storedSize := func(b blocks.Block) int {
+		v, err := raw.Get(ctx, dshelp.MultihashToDsKey(b.Cid().Hash()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(v)
+	}
+
+	old := blocks.NewBlock(bytes.Repeat([]byte("stored before compression "), 100))
+	text := blocks.NewBlock(bytes.Repeat([]byte("compressible text "), 100))
+	// blocks looking like compressed ones must be read back as is, whether
+	// stored before compression was enabled or after
+	trickyOld := blocks.NewBlock(append(append([]byte{}, compressedMagic...), 5, 'x'))
+	tricky := blocks.NewBlock(append(append([]byte{}, compressedMagic...), 6, 'y'))
+
+	// a repo never compressed doesn't get the compression layer
+	if used, err := blocksCompressed(ctx, d); err != nil || used {
+		t.Fatalf("expected no compressed blocks, got %v, %v", used, err)
+	}
+	if err := blockstore.NewBlockstoreNoPrefix(raw).PutMany(ctx, []blocks.Block{old, trickyOld}); err != nil {
+		t.Fatal(err)
+	}
+
+	index := blockCompressionIndex(d)
+	plain, err := newBlockCompressor(raw, index, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newBlockCompressor(raw, index, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := blockstore.NewBlockstoreNoPrefix(c)
+	bs.HashOnRead(true)
+	if err := bs.PutMany(ctx, []blocks.Block{text, tricky}); err != nil {
+		t.Fatal(err)
+	}
+	if storedSize(text) >= len(text.RawData()) {
+		t.Fatalf("expected the text block to be stored compressed, got %d bytes", storedSize(text))
+	}
+	if storedSize(old) != len(old.RawData()) {
+		t.Fatal("expected the block stored before compression to be left as is")
+	}
+	if used, err := blocksCompressed(ctx, d); err != nil || !used {
+		t.Fatalf("expected compressed blocks, got %v, %v", used, err)
+	}
+
+	check := func() {
+		t.Helper()
+		for _, b := range []blocks.Block{old, text, trickyOld, tricky} {
+			got, err := bs.Get(ctx, b.Cid())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.RawData(), b.RawData()) {
+				t.Fatalf("block %s read back differently", b.Cid())
+			}
+			size, err := bs.GetSize(ctx, b.Cid())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size != len(b.RawData()) {
+				t.Fatalf("expected block %s to be %d bytes, got %d", b.Cid(), len(b.RawData()), size)
+			}
+		}
+	}
+	check()
+
+	n, err := c.migrate(ctx, blockstore.NewGCLocker())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 block migrated, got %d", n)
+	}
+	if storedSize(old) >= len(old.RawData()) {
+		t.Fatal("expected the block stored before compression to be compressed by the migration")
+	}
+	check()
+
+	// compressed blocks stay readable once compression is turned off
+	bs = blockstore.NewBlockstoreNoPrefix(plain)
+	check()
+
+	// removed blocks leave the index
+	if err := bs.DeleteBlock(ctx, text.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bs.GetSize(ctx, text.Cid()); !ipld.IsNotFound(err) {
+		t.Fatalf("expected the removed block not to be found, got %v", err)
+	}
+	if _, compressed, err := c.compressedSize(ctx, dshelp.MultihashToDsKey(text.Cid().Hash())); err != nil || compressed {
+		t.Fatalf("expected the removed block to leave the index, got %v, %v", compressed, err)
+	}
+}
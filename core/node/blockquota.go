@@ -0,0 +1,176 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+// StorageQuotaConfigKey is the config key of the storage quota, a size like
+// "50GB". It is read from the config file again every quotaRefreshInterval,
+// so changing it with 'btfs config' applies without a restart.
+const StorageQuotaConfigKey = "Datastore.StorageQuota"
+
+// quotaRefreshInterval is how often the quota and the size of the repo are
+// read again. The blocks stored in between are counted as they are put.
+const quotaRefreshInterval = 30 * time.Second
+
+// StorageQuota reads StorageQuotaConfigKey through getConfigKey. It returns 0
+// when no quota is set.
+func StorageQuota(getConfigKey func(string) (interface{}, error)) (uint64, error) {
+	val, err := getConfigKey(StorageQuotaConfigKey)
+	if err != nil || val == nil {
+		return 0, nil // not set
+	}
+	s, ok := val.(string)
+	if !ok {
+		return 0, fmt.Errorf("invalid %s %v, must be a size string", StorageQuotaConfigKey, val)
+	}
+	if s == "" {
+		return 0, nil // cleared
+	}
+	quota, err := humanize.ParseBytes(s)
+	if err != nil || quota == 0 {
+		return 0, fmt.Errorf("invalid %s %q, must be a positive size", StorageQuotaConfigKey, s)
+	}
+	return quota, nil
+}
+
+// ErrQuotaExceeded is returned by the writes of blocks which would take the
+// repo above its storage quota.
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// quotaBlockstore refuses to store blocks once they would take the repo
+// above the quota. It wraps the base blockstore, so that adds, block and dag
+// puts, pins and bitswap are all held to it, while the blocks only referenced
+// by the filestore take no room.
+type quotaBlockstore struct {
+	blockstore.Blockstore
+	quota func() (uint64, error)
+	usage func() (uint64, error)
+
+	mu sync.Mutex
+	// read is when limit and used were read, used then grows with the
+	// blocks counted since.
+	read    time.Time
+	limit   uint64
+	used    uint64
+	counted *cid.Set
+}
+
+// NewQuotaBlockstore wraps bs so that putting blocks it doesn't have fails
+// with ErrQuotaExceeded once they would take the size of the repo, given by
+// usage, above quota. A quota of 0 means none. An invalid quota fails here,
+// while one read later on only keeps the last valid one in use.
+func NewQuotaBlockstore(bs blockstore.Blockstore, quota func() (uint64, error), usage func() (uint64, error)) (blockstore.Blockstore, error) {
+	q := &quotaBlockstore{
+		Blockstore: bs,
+		quota:      quota,
+		usage:      usage,
+	}
+	limit, err := quota()
+	if err != nil {
+		return nil, err
+	}
+	var used uint64
+	if limit > 0 {
+		if used, err = usage(); err != nil {
+			return nil, err
+		}
+	}
+	q.read, q.limit, q.used, q.counted = time.Now(), limit, used, cid.NewSet()
+	return q, nil
+}
+
+func (q *quotaBlockstore) Put(ctx context.Context, b blocks.Block) error {
+	if err := q.reserve(ctx, []blocks.Block{b}); err != nil {
+		return err
+	}
+	return q.Blockstore.Put(ctx, b)
+}
+
+func (q *quotaBlockstore) PutMany(ctx context.Context, bs []blocks.Block) error {
+	if err := q.reserve(ctx, bs); err != nil {
+		return err
+	}
+	return q.Blockstore.PutMany(ctx, bs)
+}
+
+// refresh reads the quota and the size of the repo again once they are older
+// than quotaRefreshInterval, and returns the quota. When either can't be
+// read, the last ones stay in use until the next interval.
+func (q *quotaBlockstore) refresh() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if time.Since(q.read) <= quotaRefreshInterval {
+		return q.limit
+	}
+	q.read = time.Now()
+	limit, err := q.quota()
+	if err != nil {
+		logger.Errorf("keeping the storage quota of %d bytes: %s", q.limit, err)
+		return q.limit
+	}
+	var used uint64
+	if limit > 0 {
+		if used, err = q.usage(); err != nil {
+			logger.Errorf("keeping the storage quota and usage: %s", err)
+			return q.limit
+		}
+	}
+	q.limit, q.used, q.counted = limit, used, cid.NewSet()
+	return q.limit
+}
+
+// reserve counts the blocks of bs not stored nor counted yet, unless they
+// would take the repo above the quota. A block put several times before
+// it is stored, as by the batches of an add, is only counted once. Whether
+// the blocks are stored is checked without holding the lock, so that writes
+// don't wait on each other's lookups.
+func (q *quotaBlockstore) reserve(ctx context.Context, bs []blocks.Block) error {
+	if q.refresh() == 0 {
+		return nil
+	}
+
+	missing := make([]blocks.Block, 0, len(bs))
+	for _, b := range bs {
+		has, err := q.Blockstore.Has(ctx, b.Cid())
+		if err != nil {
+			return err
+		}
+		if !has {
+			missing = append(missing, b)
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.limit == 0 {
+		return nil
+	}
+	var size uint64
+	var fresh []cid.Cid
+	for _, b := range missing {
+		c := b.Cid()
+		if !q.counted.Visit(c) {
+			continue
+		}
+		fresh = append(fresh, c)
+		size += uint64(len(b.RawData()))
+	}
+	if q.used+size > q.limit {
+		for _, c := range fresh {
+			q.counted.Remove(c)
+		}
+		return fmt.Errorf("%w: the repo would use %d bytes, the quota is %d bytes", ErrQuotaExceeded, q.used+size, q.limit)
+	}
+	q.used += size
+	return nil
+}
@@ -0,0 +1,104 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+func TestStorageQuota(t *testing.T) {
+	get := func(v interface{}) func(string) (interface{}, error) {
+		return func(string) (interface{}, error) { return v, nil }
+	}
+	for _, v := range []interface{}{nil, ""} {
+		if q, err := StorageQuota(get(v)); err != nil || q != 0 {
+			t.Fatalf("expected no quota for %q, got %d (%v)", v, q, err)
+		}
+	}
+	if q, err := StorageQuota(get("50GB")); err != nil || q != 50000000000 {
+		t.Fatalf("expected 50000000000, got %d (%v)", q, err)
+	}
+	for _, v := range []interface{}{"50 apples", "0B", 50.0} {
+		if _, err := StorageQuota(get(v)); err == nil {
+			t.Fatalf("expected an error for %v", v)
+		}
+	}
+}
+
+func TestQuotaBlockstore(t *testing.T) {
+	ctx := context.Background()
+	under := blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	stored := blocks.NewBlock(bytes.Repeat([]byte("s"), 60))
+	if err := under.Put(ctx, stored); err != nil {
+		t.Fatal(err)
+	}
+	a := blocks.NewBlock(bytes.Repeat([]byte("a"), 30))
+	b := blocks.NewBlock(bytes.Repeat([]byte("b"), 30))
+
+	// the repo holds 60 bytes out of 100
+	quota := uint64(100)
+	var quotaErr error
+	qbs, err := NewQuotaBlockstore(under,
+		func() (uint64, error) { return quota, quotaErr },
+		func() (uint64, error) { return 60, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := qbs.(*quotaBlockstore)
+
+	// stored blocks take no room, and a block repeated in a batch counts once
+	if err := bs.PutMany(ctx, []blocks.Block{stored, a, a}); err != nil {
+		t.Fatal(err)
+	}
+	if bs.used != 90 {
+		t.Fatalf("expected 90 bytes used, got %d", bs.used)
+	}
+	if err := bs.Put(ctx, b); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected %v, got %v", ErrQuotaExceeded, err)
+	}
+	if has, _ := under.Has(ctx, b.Cid()); has {
+		t.Fatal("expected the refused block not to be stored")
+	}
+
+	// a block reserved again before it is stored isn't counted twice
+	c := blocks.NewBlock(bytes.Repeat([]byte("c"), 5))
+	for i := 0; i < 2; i++ {
+		if err := bs.reserve(ctx, []blocks.Block{c}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if bs.used != 95 {
+		t.Fatalf("expected 95 bytes used, got %d", bs.used)
+	}
+
+	// an invalid quota read again keeps the last one, until the next interval
+	quotaErr = errors.New("invalid quota")
+	bs.read = bs.read.Add(-2 * quotaRefreshInterval)
+	if err := bs.Put(ctx, b); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected the last quota to still hold, got %v", err)
+	}
+	quotaErr = nil
+	quota = 0
+	if err := bs.Put(ctx, b); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected the quota not to be read again before the interval, got %v", err)
+	}
+
+	// the quota is read again after the refresh interval
+	bs.read = bs.read.Add(-2 * quotaRefreshInterval)
+	if err := bs.Put(ctx, blocks.NewBlock(bytes.Repeat([]byte("d"), 200))); err != nil {
+		t.Fatalf("expected no quota once cleared, got %v", err)
+	}
+
+	_, err = NewQuotaBlockstore(under,
+		func() (uint64, error) { return 0, errors.New("invalid quota") },
+		func() (uint64, error) { return 0, nil })
+	if err == nil {
+		t.Fatal("expected an invalid quota to fail at startup")
+	}
+}
@@ -0,0 +1,51 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+)
+
+// BlockstoreReadOnlyConfigKey is the config key switching the blockservice
+// to read-only, for nodes acting purely as gateways.
+const BlockstoreReadOnlyConfigKey = "Datastore.BlockstoreReadOnly"
+
+// ErrBlockstoreReadOnly is returned when adding or removing blocks while the
+// blockstore is read-only.
+var ErrBlockstoreReadOnly = errors.New("blockstore is read-only, set " + BlockstoreReadOnlyConfigKey + " to false to allow writes")
+
+// blockstoreReadOnly reads BlockstoreReadOnlyConfigKey through getConfigKey.
+func blockstoreReadOnly(getConfigKey func(string) (interface{}, error)) (bool, error) {
+	val, err := getConfigKey(BlockstoreReadOnlyConfigKey)
+	if err != nil || val == nil {
+		return false, nil // not set
+	}
+	ro, ok := val.(bool)
+	if !ok {
+		return false, fmt.Errorf("invalid %s %v, must be a boolean", BlockstoreReadOnlyConfigKey, val)
+	}
+	return ro, nil
+}
+
+// readOnlyBlockService rejects adding and deleting blocks. Blocks fetched
+// through the exchange are still stored by the wrapped blockservice, so that
+// they don't need to be fetched again.
+type readOnlyBlockService struct {
+	blockservice.BlockService
+}
+
+func (s *readOnlyBlockService) AddBlock(ctx context.Context, o blocks.Block) error {
+	return ErrBlockstoreReadOnly
+}
+
+func (s *readOnlyBlockService) AddBlocks(ctx context.Context, bs []blocks.Block) error {
+	return ErrBlockstoreReadOnly
+}
+
+func (s *readOnlyBlockService) DeleteBlock(ctx context.Context, o cid.Cid) error {
+	return ErrBlockstoreReadOnly
+}
@@ -2,8 +2,11 @@ package node
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/bittorrent/go-btfs/core/fetchlimit"
 	"github.com/bittorrent/go-btfs/core/node/helpers"
 	"github.com/bittorrent/go-btfs/repo"
 	irouting "github.com/bittorrent/go-btfs/routing"
@@ -20,7 +23,6 @@ import (
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	exchange "github.com/ipfs/go-ipfs-exchange-interface"
 	pin "github.com/ipfs/go-ipfs-pinner"
-	"github.com/ipfs/go-ipfs-pinner/dspinner"
 	format "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/go-merkledag"
 	"github.com/ipfs/go-unixfsnode"
@@ -32,8 +34,13 @@ import (
 	"go.uber.org/fx"
 )
 
-// BlockService creates new blockservice which provides an interface to fetch content-addressable blocks
-func BlockService(lc fx.Lifecycle, bs blockstore.Blockstore, rem exchange.Interface) blockservice.BlockService {
+// BlockService creates new blockservice which provides an interface to fetch content-addressable blocks.
+// When BlockstoreReadOnlyConfigKey is set, adding and deleting blocks fails.
+func BlockService(lc fx.Lifecycle, bs blockstore.Blockstore, rem exchange.Interface, repo repo.Repo) (blockservice.BlockService, error) {
+	readOnly, err := blockstoreReadOnly(repo.GetConfigKey)
+	if err != nil {
+		return nil, err
+	}
 	bsvc := blockservice.New(bs, rem)
 
 	lc.Append(fx.Hook{
@@ -42,32 +49,82 @@ func BlockService(lc fx.Lifecycle, bs blockstore.Blockstore, rem exchange.Interf
 		},
 	})
 
-	return bsvc
+	if readOnly {
+		return &readOnlyBlockService{bsvc}, nil
+	}
+	return bsvc, nil
 }
 
-// Pinning creates new pinner which tells GC which blocks should be kept
-func Pinning(bstore blockstore.Blockstore, ds format.DAGService, repo repo.Repo) (pin.Pinner, error) {
+// Pinning creates new pinner which tells GC which blocks should be kept.
+// The pinner backend is selected with PinnerBackendConfigKey.
+func Pinning(lc fx.Lifecycle, bstore blockstore.Blockstore, ds format.DAGService, repo repo.Repo) (pin.Pinner, error) {
 	// internalDag := merkledag.NewDAGService(blockservice.New(bstore, offline.Exchange(bstore)))
 	rootDS := repo.Datastore()
 	// ctx := context.Background()
 	syncFn := func(ctx context.Context) error {
-		if err := rootDS.Sync(ctx, blockstore.BlockPrefix); err != nil {
-			return err
-		}
-		return rootDS.Sync(ctx, filestore.FilestorePrefix)
+		return SyncBlockstore(ctx, rootDS)
 	}
 	syncDs := &syncDagService{ds, syncFn}
 
 	ctx := context.TODO()
 
-	pinning, err := dspinner.New(ctx, rootDS, syncDs)
+	name, backend, err := pinnerBackend(repo.GetConfigKey)
 	if err != nil {
 		return nil, err
 	}
+	pinning, closer, err := backend(ctx, repo, rootDS, syncDs)
+	if err != nil {
+		return nil, fmt.Errorf("pinner backend %s: %w", name, err)
+	}
+	if err := syncPinnerBackend(ctx, repo, rootDS, syncDs, name, pinning); err != nil {
+		if closer != nil {
+			closer()
+		}
+		return nil, err
+	}
+	if closer != nil {
+		lc.Append(fx.Hook{
+			OnStop: func(ctx context.Context) error {
+				return closer()
+			},
+		})
+	}
 
 	return pinning, nil
 }
 
+// SyncBlockstore persists the blocks and filestore entries of rootDS to disk.
+// It returns as soon as ctx is done, skipping the syncs not started yet.
+func SyncBlockstore(ctx context.Context, rootDS datastore.Datastore) error {
+	for _, prefix := range []datastore.Key{blockstore.BlockPrefix, filestore.FilestorePrefix} {
+		if err := syncPrefix(ctx, rootDS, prefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncPrefix syncs prefix in ds, or returns when ctx is done. Datastores
+// don't necessarily honor ctx, so the sync may carry on in the background.
+func syncPrefix(ctx context.Context, ds datastore.Datastore, prefix datastore.Key) error {
+	if err := ctx.Err(); err != nil {
+		logger.Warnf("skipped sync of %s: %s", prefix, err)
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ds.Sync(ctx, prefix)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		logger.Warnf("gave up waiting for sync of %s: %s", prefix, ctx.Err())
+		return ctx.Err()
+	}
+}
+
 var (
 	_ merkledag.SessionMaker = new(syncDagService)
 	_ format.DAGService      = new(syncDagService)
@@ -93,8 +150,14 @@ type fetchersOut struct {
 	UnixfsFetcher fetcher.Factory `name:"unixfsFetcher"`
 }
 
-// FetcherConfig returns a fetcher config that can build new fetcher instances
-func FetcherConfig(bs blockservice.BlockService) fetchersOut {
+// FetcherConfig returns a fetcher config that can build new fetcher instances.
+// The fetchers enforce the limits of FetchLimits.
+func FetcherConfig(bs blockservice.BlockService, repo repo.Repo) (fetchersOut, error) {
+	limits, err := FetchLimits(repo.GetConfigKey)
+	if err != nil {
+		return fetchersOut{}, err
+	}
+
 	ipldFetcher := bsfetcher.NewFetcherConfig(bs)
 	ipldFetcher.PrototypeChooser = dagpb.AddSupportToChooser(func(lnk ipld.Link, lnkCtx ipld.LinkContext) (ipld.NodePrototype, error) {
 		if tlnkNd, ok := lnkCtx.LinkNode.(schema.TypedLinkNode); ok {
@@ -104,7 +167,10 @@ func FetcherConfig(bs blockservice.BlockService) fetchersOut {
 	})
 
 	unixFSFetcher := ipldFetcher.WithReifier(unixfsnode.Reify)
-	return fetchersOut{IPLDFetcher: ipldFetcher, UnixfsFetcher: unixFSFetcher}
+	return fetchersOut{
+		IPLDFetcher:   fetchlimit.NewFactory(ipldFetcher, limits, bs.Blockstore()),
+		UnixfsFetcher: fetchlimit.NewFactory(unixFSFetcher, limits, bs.Blockstore()),
+	}, nil
 }
 
 // Dag creates new DAGService
@@ -112,39 +178,52 @@ func Dag(bs blockservice.BlockService) format.DAGService {
 	return merkledag.NewDAGService(bs)
 }
 
-// OnlineExchange creates new LibP2P backed block exchange (BitSwap)
+// OnlineExchange creates new LibP2P backed block exchange (BitSwap).
+// provide is the initial state of the returned BitswapProvideControl.
+// When BitswapOutboundRateConfigKey is set, the blocks sent to other peers
+// are limited to that rate.
 func OnlineExchange(provide bool) interface{} {
-	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, host host.Host, rt irouting.ProvideManyRouter, bs blockstore.GCBlockstore) exchange.Interface {
-		bitswapNetwork := network.NewFromIpfsHost(host, rt)
-		exch := bitswap.New(helpers.LifecycleCtx(mctx, lc), bitswapNetwork, bs, bitswap.ProvideEnabled(provide))
+	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, host host.Host, rt irouting.ProvideManyRouter, bs blockstore.GCBlockstore, repo repo.Repo, bw *DataBandwidth) (exchange.Interface, *BitswapProvideControl, error) {
+		outboundRate, err := bitswapOutboundRate(repo.GetConfigKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		control := &BitswapProvideControl{}
+		control.SetEnabled(provide)
+		// Providing is always enabled in bitswap itself and gated by the
+		// router, so that it can be toggled without recreating bitswap.
+		var bitswapNetwork network.BitSwapNetwork = network.NewFromIpfsHost(host, &provideToggleRouter{ProvideManyRouter: rt, control: control})
+		if outboundRate > 0 {
+			bitswapNetwork = newRateLimitedNetwork(bitswapNetwork, outboundRate)
+		}
+		bitswapNetwork = newBandwidthNetwork(bitswapNetwork, bw)
+		exch := bitswap.New(helpers.LifecycleCtx(mctx, lc), bitswapNetwork, bs, bitswap.ProvideEnabled(true))
 		lc.Append(fx.Hook{
 			OnStop: func(ctx context.Context) error {
 				return exch.Close()
 			},
 		})
-		return exch
-
+		return exch, control, nil
 	}
 }
 
-// Files loads persisted MFS root
-func Files(mctx helpers.MetricsCtx, lc fx.Lifecycle, repo repo.Repo, dag format.DAGService) (*mfs.Root, error) {
+// Files loads persisted MFS root, with the batch control of its writes
+func Files(mctx helpers.MetricsCtx, lc fx.Lifecycle, repo repo.Repo, dag format.DAGService) (*mfs.Root, *FilesBatch, error) {
 	dsk := datastore.NewKey("/local/filesroot")
-	pf := func(ctx context.Context, c cid.Cid) error {
-		rootDS := repo.Datastore()
-		if err := rootDS.Sync(ctx, blockstore.BlockPrefix); err != nil {
-			return err
-		}
-		if err := rootDS.Sync(ctx, filestore.FilestorePrefix); err != nil {
-			return err
-		}
-
-		if err := rootDS.Put(ctx, dsk, c.Bytes()); err != nil {
-			return err
-		}
-		return rootDS.Sync(ctx, dsk)
+	m := newMfsMetrics(mctx)
+	delay, err := filesSyncDelay(repo.GetConfigKey)
+	if err != nil {
+		return nil, nil, err
 	}
+	publisher := &filesRootPublisher{
+		ds:      repo.Datastore(),
+		key:     dsk,
+		delay:   delay,
+		metrics: m,
+	}
+	pf := publisher.Publish
 
+	loadStart := time.Now()
 	var nd *merkledag.ProtoNode
 	val, err := repo.Datastore().Get(mctx, dsk)
 	ctx := helpers.LifecycleCtx(mctx, lc)
@@ -153,37 +232,49 @@ func Files(mctx helpers.MetricsCtx, lc fx.Lifecycle, repo repo.Repo, dag format.
 	case err == datastore.ErrNotFound || val == nil:
 		nd = unixfs.EmptyDirNode()
 		err := dag.Add(ctx, nd)
-		if err != nil {
-			return nil, fmt.Errorf("failure writing to dagstore: %s", err)
+		if errors.Is(err, ErrBlockstoreReadOnly) {
+			// keep the empty root in memory only, writes to it fail anyway
+			logger.Warn("blockstore is read-only, MFS root is not persisted")
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("failure writing to dagstore: %s", err)
 		}
 	case err == nil:
 		c, err := cid.Cast(val)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		rnd, err := dag.Get(ctx, c)
 		if err != nil {
-			return nil, fmt.Errorf("error loading filesroot from DAG: %s", err)
+			return nil, nil, fmt.Errorf("error loading filesroot from DAG: %s", err)
 		}
 
 		pbnd, ok := rnd.(*merkledag.ProtoNode)
 		if !ok {
-			return nil, merkledag.ErrNotProtobuf
+			return nil, nil, merkledag.ErrNotProtobuf
 		}
 
 		nd = pbnd
 	default:
-		return nil, err
+		return nil, nil, err
 	}
 
 	root, err := mfs.NewRoot(ctx, dag, nd, pf)
+	if err == nil {
+		m.rootLoads.Inc()
+		m.rootLoadSeconds.Observe(time.Since(loadStart).Seconds())
+	}
 
 	lc.Append(fx.Hook{
 		OnStop: func(ctx context.Context) error {
-			return root.Close()
+			// closing the root publishes it one last time
+			err := root.Close()
+			if ferr := publisher.Flush(ctx); err == nil {
+				err = ferr
+			}
+			return err
 		},
 	})
 
-	return root, err
+	return root, &FilesBatch{p: publisher}, err
 }
@@ -0,0 +1,168 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bittorrent/go-btfs/core/node/helpers"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/multiformats/go-multihash"
+)
+
+// slowSyncDatastore blocks syncs until release is closed.
+type slowSyncDatastore struct {
+	datastore.Datastore
+	release chan struct{}
+	synced  chan datastore.Key
+}
+
+func (d *slowSyncDatastore) Sync(ctx context.Context, prefix datastore.Key) error {
+	d.synced <- prefix
+	<-d.release
+	return nil
+}
+
+func TestSyncDagServiceCancel(t *testing.T) {
+	ds := &slowSyncDatastore{
+		Datastore: dssync.MutexWrap(datastore.NewMapDatastore()),
+		release:   make(chan struct{}),
+		synced:    make(chan datastore.Key, 2),
+	}
+	defer close(ds.release)
+
+	s := &syncDagService{syncFn: func(ctx context.Context) error {
+		return SyncBlockstore(ctx, ds)
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Sync(ctx)
+	}()
+
+	if prefix := <-ds.synced; prefix != blockstore.BlockPrefix {
+		t.Fatalf("expected block sync first, got %s", prefix)
+	}
+	cancel()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("sync did not return after cancellation")
+	}
+
+	select {
+	case prefix := <-ds.synced:
+		t.Fatalf("expected no further sync after cancellation, got %s", prefix)
+	default:
+	}
+}
+
+func TestFilesRootPublisherDebounce(t *testing.T) {
+	ctx := context.Background()
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	key := datastore.NewKey("/local/filesroot")
+	p := &filesRootPublisher{
+		ds:      ds,
+		key:     key,
+		delay:   time.Hour,
+		metrics: newMfsMetrics(helpers.MetricsCtx(ctx)),
+	}
+
+	var last cid.Cid
+	for i := 0; i < 3; i++ {
+		h, err := multihash.Sum([]byte{byte(i)}, multihash.SHA2_256, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		last = cid.NewCidV1(cid.DagProtobuf, h)
+		if err := p.Publish(ctx, last); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if has, err := ds.Has(ctx, key); err != nil || has {
+		t.Fatalf("expected root not to be written before flush, got %v (%v)", has, err)
+	}
+
+	if err := p.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	val, err := ds.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := cid.Cast(val); err != nil || !c.Equals(last) {
+		t.Fatalf("expected root %s, got %s (%v)", last, c, err)
+	}
+}
+
+func TestFilesBatch(t *testing.T) {
+	ctx := context.Background()
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	key := datastore.NewKey("/local/filesroot")
+	// without delay every root is written as soon as it is published
+	p := &filesRootPublisher{
+		ds:      ds,
+		key:     key,
+		metrics: newMfsMetrics(helpers.MetricsCtx(ctx)),
+	}
+	batch := &FilesBatch{p: p}
+	root := func(i byte) cid.Cid {
+		h, err := multihash.Sum([]byte{i}, multihash.SHA2_256, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cid.NewCidV1(cid.DagProtobuf, h)
+	}
+	stored := func() cid.Cid {
+		val, err := ds.Get(ctx, key)
+		if err == datastore.ErrNotFound {
+			return cid.Undef
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := cid.Cast(val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	batch.Start()
+	if !batch.Active() {
+		t.Fatal("expected the batch to be active")
+	}
+	for i := byte(0); i < 3; i++ {
+		if err := p.Publish(ctx, root(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c := stored(); c.Defined() {
+		t.Fatalf("expected no root written during the batch, got %s", c)
+	}
+	if err := batch.End(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if c := stored(); !c.Equals(root(2)) {
+		t.Fatalf("expected root %s once the batch ends, got %s", root(2), c)
+	}
+	if batch.Active() {
+		t.Fatal("expected the batch to be over")
+	}
+
+	if err := p.Publish(ctx, root(3)); err != nil {
+		t.Fatal(err)
+	}
+	if c := stored(); !c.Equals(root(3)) {
+		t.Fatalf("expected root %s written right away, got %s", root(3), c)
+	}
+}
@@ -0,0 +1,100 @@
+package node
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bittorrent/go-btfs/core/fetchlimit"
+)
+
+const (
+	// FetchMaxLinksConfigKey is the config key capping the links followed
+	// by a single fetcher traversal. Unset or 0 means unlimited.
+	FetchMaxLinksConfigKey = "Fetcher.MaxLinks"
+	// FetchMaxBlocksConfigKey is the config key capping the blocks loaded
+	// by a single fetcher traversal. Unset or 0 means unlimited.
+	FetchMaxBlocksConfigKey = "Fetcher.MaxBlocks"
+	// FetchMaxBytesConfigKey is the config key capping the bytes of the
+	// blocks loaded by a single fetcher traversal. Unset or 0 means
+	// unlimited.
+	FetchMaxBytesConfigKey = "Fetcher.MaxBytes"
+	// FetchMaxDurationConfigKey is the config key capping how long a single
+	// fetcher traversal loads blocks for, e.g. "30s". Unset or "" means
+	// unlimited.
+	FetchMaxDurationConfigKey = "Fetcher.MaxDuration"
+
+	// gatewayFetchConfigPrefix prefixes the keys of the limits of the
+	// gateway, e.g. "Fetcher.Gateway.MaxLinks".
+	gatewayFetchConfigPrefix = "Fetcher.Gateway."
+)
+
+// FetchLimits reads the fetcher limits through getConfigKey, usually
+// repo.Repo.GetConfigKey.
+func FetchLimits(getConfigKey func(string) (interface{}, error)) (fetchlimit.Limits, error) {
+	return readFetchLimits(getConfigKey, FetchMaxLinksConfigKey, FetchMaxBlocksConfigKey,
+		FetchMaxBytesConfigKey, FetchMaxDurationConfigKey)
+}
+
+// GatewayFetchLimits reads the fetcher limits of the gateway. They are the
+// limits of FetchLimits, tightened by the same keys under Fetcher.Gateway,
+// e.g. Fetcher.Gateway.MaxBlocks, so gateway requests can't load more than
+// CLI operations.
+func GatewayFetchLimits(getConfigKey func(string) (interface{}, error)) (fetchlimit.Limits, error) {
+	l, err := FetchLimits(getConfigKey)
+	if err != nil {
+		return l, err
+	}
+	gw, err := readFetchLimits(getConfigKey,
+		gatewayFetchConfigPrefix+"MaxLinks", gatewayFetchConfigPrefix+"MaxBlocks",
+		gatewayFetchConfigPrefix+"MaxBytes", gatewayFetchConfigPrefix+"MaxDuration")
+	if err != nil {
+		return l, err
+	}
+	return l.Tighten(gw), nil
+}
+
+func readFetchLimits(getConfigKey func(string) (interface{}, error), linksKey, blocksKey, bytesKey, durationKey string) (fetchlimit.Limits, error) {
+	var l fetchlimit.Limits
+	for key, dst := range map[string]*int{
+		linksKey:  &l.MaxLinks,
+		blocksKey: &l.MaxBlocks,
+	} {
+		n, err := fetchLimitInt(getConfigKey, key)
+		if err != nil {
+			return l, err
+		}
+		*dst = int(n)
+	}
+	var err error
+	if l.MaxBytes, err = fetchLimitInt(getConfigKey, bytesKey); err != nil {
+		return l, err
+	}
+
+	val, err := getConfigKey(durationKey)
+	if err != nil || val == nil {
+		return l, nil // not set
+	}
+	s, ok := val.(string)
+	if !ok {
+		return l, fmt.Errorf("invalid %s %v, must be a duration like \"30s\"", durationKey, val)
+	}
+	if s != "" {
+		if l.MaxDuration, err = time.ParseDuration(s); err != nil || l.MaxDuration < 0 {
+			return l, fmt.Errorf("invalid %s %q, must be a duration like \"30s\"", durationKey, s)
+		}
+	}
+	return l, nil
+}
+
+// fetchLimitInt reads the non-negative integer at key, 0 if it is unset.
+func fetchLimitInt(getConfigKey func(string) (interface{}, error), key string) (int64, error) {
+	val, err := getConfigKey(key)
+	if err != nil || val == nil {
+		return 0, nil // not set
+	}
+	n, ok := val.(float64)
+	if !ok || n < 0 || n != float64(int64(n)) {
+		return 0, fmt.Errorf("invalid %s %v, must be a non-negative integer", key, val)
+	}
+	return int64(n), nil
+}
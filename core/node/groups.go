@@ -183,6 +183,7 @@ func Storage(bcfg *BuildCfg, cfg *config.Config) fx.Option {
 		fx.Provide(Datastore),
 		fx.Provide(BaseBlockstoreCtor(cacheOpts, bcfg.NilRepo, cfg.Datastore.HashOnRead)),
 		finalBstore,
+		fx.Invoke(BlockCompressionMigration),
 	)
 }
 
@@ -334,6 +335,7 @@ func Offline(cfg *config.Config) fx.Option {
 
 // Core groups basic BTFS services
 var Core = fx.Options(
+	fx.Provide(NewDataBandwidth),
 	fx.Provide(BlockService),
 	fx.Provide(Dag),
 	fx.Provide(FetcherConfig),
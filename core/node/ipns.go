@@ -7,6 +7,7 @@ import (
 	"github.com/bittorrent/go-btfs/namesys"
 	"github.com/bittorrent/go-btfs/namesys/republisher"
 	"github.com/bittorrent/go-btfs/repo"
+	"github.com/bittorrent/go-btfs/repo/common"
 	irouting "github.com/bittorrent/go-btfs/routing"
 	madns "github.com/multiformats/go-multiaddr-dns"
 
@@ -19,6 +20,16 @@ import (
 
 const DefaultIpnsCacheSize = 128
 
+// IpnsNegativeCacheTTLConfigKey is the config key holding how long a failed
+// name resolution is cached, e.g. "5s". "0s" disables negative caching.
+const IpnsNegativeCacheTTLConfigKey = "Ipns.NegativeCacheTTL"
+
+// ipnsNegativeCacheTTL reads IpnsNegativeCacheTTLConfigKey through
+// getConfigKey.
+func ipnsNegativeCacheTTL(getConfigKey func(string) (interface{}, error)) (time.Duration, error) {
+	return common.DurationConfigKey(getConfigKey, IpnsNegativeCacheTTLConfigKey, namesys.DefaultResolverNegativeCacheTTL)
+}
+
 // RecordValidator provides namesys compatible routing record validator
 func RecordValidator(ps peerstore.Peerstore) record.Validator {
 	return record.NamespacedValidator{
@@ -36,7 +47,11 @@ func Namesys(cacheSize int) func(rt irouting.ProvideManyRouter, rslv *madns.Reso
 		}
 
 		if cacheSize > 0 {
-			opts = append(opts, namesys.WithCache(cacheSize))
+			ttl, err := ipnsNegativeCacheTTL(repo.GetConfigKey)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, namesys.WithCache(cacheSize), namesys.WithNegativeCacheTTL(ttl))
 		}
 
 		return namesys.NewNameSystem(rt, opts...)
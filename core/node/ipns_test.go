@@ -0,0 +1,28 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bittorrent/go-btfs/namesys"
+)
+
+func TestIpnsNegativeCacheTTL(t *testing.T) {
+	get := func(v interface{}) func(string) (interface{}, error) {
+		return func(string) (interface{}, error) { return v, nil }
+	}
+	if d, err := ipnsNegativeCacheTTL(get(nil)); err != nil || d != namesys.DefaultResolverNegativeCacheTTL {
+		t.Fatalf("expected the default when unset, got %s (%v)", d, err)
+	}
+	if d, err := ipnsNegativeCacheTTL(get("1m")); err != nil || d != time.Minute {
+		t.Fatalf("expected 1m, got %s (%v)", d, err)
+	}
+	if d, err := ipnsNegativeCacheTTL(get("0s")); err != nil || d != 0 {
+		t.Fatalf("expected 0s, got %s (%v)", d, err)
+	}
+	for _, v := range []interface{}{"-1s", "soon", 5.0} {
+		if _, err := ipnsNegativeCacheTTL(get(v)); err == nil {
+			t.Fatalf("expected an error for %v", v)
+		}
+	}
+}
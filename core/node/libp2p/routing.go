@@ -60,12 +60,15 @@ type processInitialRoutingOut struct {
 
 	DHT       *ddht.DHT
 	DHTClient routing.Routing `name:"dhtc"`
+	// Routers are the routers operations can target by name.
+	Routers irouting.NamedRouters
 }
 
 type AddrInfoChan chan peer.AddrInfo
 
 func BaseRouting(experimentalDHTClient bool) interface{} {
 	return func(lc fx.Lifecycle, in processInitialRoutingIn) (out processInitialRoutingOut, err error) {
+		named := namedRouters(in.Router)
 		var dr *ddht.DHT
 		if dht, ok := in.Router.(*ddht.DHT); ok {
 			dr = dht
@@ -114,6 +117,7 @@ func BaseRouting(experimentalDHTClient bool) interface{} {
 				DHT:           dr,
 				DHTClient:     expClient,
 				ContentRouter: expClient,
+				Routers:       named,
 			}, nil
 		}
 
@@ -125,10 +129,24 @@ func BaseRouting(experimentalDHTClient bool) interface{} {
 			DHT:           dr,
 			DHTClient:     dr,
 			ContentRouter: in.Router,
+			Routers:       named,
 		}, nil
 	}
 }
 
+// namedRouters returns the routers configured in Routing.Routers when the
+// initial router was built from them, or else the DHT as "dht".
+func namedRouters(initial routing.Routing) irouting.NamedRouters {
+	if c, ok := initial.(*irouting.Composer); ok && c.Named != nil {
+		return c.Named
+	}
+	named := irouting.NamedRouters{}
+	if _, ok := initial.(*ddht.DHT); ok {
+		named["dht"] = initial
+	}
+	return named
+}
+
 type p2pOnlineContentRoutingIn struct {
 	fx.In
 
@@ -0,0 +1,55 @@
+package node
+
+import (
+	"context"
+	"time"
+
+	"github.com/bittorrent/go-btfs/core/node/helpers"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-metrics-interface"
+)
+
+var mfsDurationBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30}
+
+// mfsMetrics are the metrics of the MFS root, exported as btfs_mfs_*.
+type mfsMetrics struct {
+	rootLoads       metrics.Counter
+	rootLoadSeconds metrics.Histogram
+	publishes       metrics.Counter
+	publishErrors   metrics.Counter
+	publishSeconds  metrics.Histogram
+	datastoreSyncs  metrics.Counter
+}
+
+func newMfsMetrics(mctx helpers.MetricsCtx) *mfsMetrics {
+	ctx := metrics.CtxSubScope(mctx, "mfs")
+	return &mfsMetrics{
+		rootLoads: metrics.NewCtx(ctx, "root_loads_total",
+			"Number of times the MFS root was loaded or created").Counter(),
+		rootLoadSeconds: metrics.NewCtx(ctx, "root_load_duration_seconds",
+			"Time taken to load or create the MFS root").Histogram(mfsDurationBuckets),
+		publishes: metrics.NewCtx(ctx, "publish_total",
+			"Number of MFS root CID changes persisted").Counter(),
+		publishErrors: metrics.NewCtx(ctx, "publish_errors_total",
+			"Number of failures persisting the MFS root CID").Counter(),
+		publishSeconds: metrics.NewCtx(ctx, "publish_duration_seconds",
+			"Time taken to persist a new MFS root CID").Histogram(mfsDurationBuckets),
+		datastoreSyncs: metrics.NewCtx(ctx, "datastore_syncs_total",
+			"Number of datastore syncs triggered by MFS root publishes").Counter(),
+	}
+}
+
+// sync syncs prefix in ds and counts it.
+func (m *mfsMetrics) sync(ctx context.Context, ds datastore.Datastore, prefix datastore.Key) error {
+	m.datastoreSyncs.Inc()
+	return ds.Sync(ctx, prefix)
+}
+
+// observePublish records a publish started at start that returned err.
+func (m *mfsMetrics) observePublish(start time.Time, err error) {
+	m.publishes.Inc()
+	if err != nil {
+		m.publishErrors.Inc()
+	}
+	m.publishSeconds.Observe(time.Since(start).Seconds())
+}
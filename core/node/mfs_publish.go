@@ -0,0 +1,147 @@
+package node
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bittorrent/go-btfs/repo/common"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-filestore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+// FilesSyncDelayConfigKey is the config key holding how long MFS root
+// changes are coalesced before they are synced to the datastore, e.g.
+// "250ms". "0s" syncs every change right away.
+const FilesSyncDelayConfigKey = "Datastore.FilesSyncDelay"
+
+// DefaultFilesSyncDelay is used when FilesSyncDelayConfigKey is unset.
+const DefaultFilesSyncDelay = 100 * time.Millisecond
+
+// filesSyncDelay reads FilesSyncDelayConfigKey through getConfigKey.
+func filesSyncDelay(getConfigKey func(string) (interface{}, error)) (time.Duration, error) {
+	return common.DurationConfigKey(getConfigKey, FilesSyncDelayConfigKey, DefaultFilesSyncDelay)
+}
+
+// filesRootPublisher persists the MFS root CID. Roots published within
+// delay of each other are written once, with the last one.
+//
+// Blocks are always synced before the root CID is written, so that a crash
+// can only lose the latest changes, never leave a root pointing at missing
+// blocks.
+type filesRootPublisher struct {
+	ds      datastore.Datastore
+	key     datastore.Key
+	delay   time.Duration
+	metrics *mfsMetrics
+
+	mu      sync.Mutex
+	pending cid.Cid
+	timer   *time.Timer
+	// held keeps published roots pending until the batch ends, see
+	// FilesBatch.
+	held bool
+
+	// flushMu serializes writes of the root.
+	flushMu sync.Mutex
+}
+
+// Publish is the mfs.PubFunc of the root.
+func (p *filesRootPublisher) Publish(ctx context.Context, c cid.Cid) error {
+	p.mu.Lock()
+	if p.held {
+		p.pending = c
+		p.mu.Unlock()
+		return nil
+	}
+	if p.delay <= 0 {
+		p.mu.Unlock()
+		return p.write(ctx, c)
+	}
+	defer p.mu.Unlock()
+	p.pending = c
+	if p.timer == nil {
+		p.timer = time.AfterFunc(p.delay, func() {
+			// mfs contexts may be gone by now, the write is bounded by the
+			// datastore anyway
+			if err := p.Flush(context.Background()); err != nil {
+				logger.Errorf("failed to persist MFS root: %s", err)
+			}
+		})
+	}
+	return nil
+}
+
+// Flush writes the pending root, if any.
+func (p *filesRootPublisher) Flush(ctx context.Context) error {
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+
+	p.mu.Lock()
+	c := p.pending
+	p.pending = cid.Undef
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	p.mu.Unlock()
+
+	if !c.Defined() {
+		return nil
+	}
+	return p.write(ctx, c)
+}
+
+// FilesBatch suspends the writes of the MFS root for bulk operations. While
+// a batch is open, published roots are kept in memory only, and the last one
+// is written once the batch ends, with a single sync. A root still pending
+// when the node stops is written then, one lost to a crash is not.
+type FilesBatch struct {
+	p *filesRootPublisher
+}
+
+// Start opens a batch, or keeps the open one.
+func (b *FilesBatch) Start() {
+	b.p.mu.Lock()
+	defer b.p.mu.Unlock()
+	b.p.held = true
+	if b.p.timer != nil {
+		b.p.timer.Stop()
+		b.p.timer = nil
+	}
+}
+
+// Active reports whether a batch is open.
+func (b *FilesBatch) Active() bool {
+	b.p.mu.Lock()
+	defer b.p.mu.Unlock()
+	return b.p.held
+}
+
+// End closes the batch, if any, and writes the last published root before
+// returning.
+func (b *FilesBatch) End(ctx context.Context) error {
+	b.p.mu.Lock()
+	b.p.held = false
+	b.p.mu.Unlock()
+	return b.p.Flush(ctx)
+}
+
+func (p *filesRootPublisher) write(ctx context.Context, c cid.Cid) (err error) {
+	defer func(start time.Time) { p.metrics.observePublish(start, err) }(time.Now())
+
+	if err := p.metrics.sync(ctx, p.ds, blockstore.BlockPrefix); err != nil {
+		return err
+	}
+	if err := p.metrics.sync(ctx, p.ds, filestore.FilestorePrefix); err != nil {
+		return err
+	}
+
+	if err := p.ds.Put(ctx, p.key, c.Bytes()); err != nil {
+		return err
+	}
+	return p.metrics.sync(ctx, p.ds, p.key)
+}
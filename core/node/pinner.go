@@ -0,0 +1,182 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bittorrent/go-btfs/repo"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	levelds "github.com/ipfs/go-ds-leveldb"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	"github.com/ipfs/go-ipfs-pinner/dspinner"
+	format "github.com/ipfs/go-ipld-format"
+)
+
+// PinnerBackendConfigKey is the config key selecting the pinner backend.
+// Unset means DefaultPinnerBackend.
+const PinnerBackendConfigKey = "Datastore.PinnerBackend"
+
+const (
+	// DefaultPinnerBackend keeps pins in the repo datastore.
+	DefaultPinnerBackend = "dspinner"
+	// LevelDBPinnerBackend keeps pins in a leveldb of their own in the
+	// "pins" directory of the repo, so that large pin sets don't compete
+	// with blocks for the repo datastore.
+	LevelDBPinnerBackend = "leveldb"
+
+	pinsDir = "pins"
+)
+
+// pinsBackendKey records in the repo datastore the pinner backend that was
+// last active, so that the pins are carried over when it changes.
+var pinsBackendKey = datastore.NewKey("/local/pins/backend")
+
+// PinnerBackend builds a pinner for a backend. rootDS is the repo datastore
+// and dserv the DAG service pins are resolved with. Cleanup functions, e.g.
+// to close a datastore, are returned along with the pinner.
+type PinnerBackend func(ctx context.Context, r repo.Repo, rootDS repo.Datastore, dserv format.DAGService) (pin.Pinner, func() error, error)
+
+var pinnerBackends = map[string]PinnerBackend{
+	DefaultPinnerBackend: func(ctx context.Context, r repo.Repo, rootDS repo.Datastore, dserv format.DAGService) (pin.Pinner, func() error, error) {
+		p, err := dspinner.New(ctx, rootDS, dserv)
+		return p, nil, err
+	},
+	LevelDBPinnerBackend: levelDBPinner,
+}
+
+// RegisterPinnerBackend makes a pinner backend selectable with
+// PinnerBackendConfigKey.
+func RegisterPinnerBackend(name string, backend PinnerBackend) error {
+	if _, ok := pinnerBackends[name]; ok {
+		return fmt.Errorf("pinner backend %q already registered", name)
+	}
+	pinnerBackends[name] = backend
+	return nil
+}
+
+// pinnerBackend reads PinnerBackendConfigKey through getConfigKey.
+func pinnerBackend(getConfigKey func(string) (interface{}, error)) (string, PinnerBackend, error) {
+	val, err := getConfigKey(PinnerBackendConfigKey)
+	if err != nil || val == nil || val == "" {
+		return DefaultPinnerBackend, pinnerBackends[DefaultPinnerBackend], nil
+	}
+	name, ok := val.(string)
+	if !ok {
+		return "", nil, fmt.Errorf("invalid %s %v, must be a string", PinnerBackendConfigKey, val)
+	}
+	backend, ok := pinnerBackends[name]
+	if !ok {
+		names := make([]string, 0, len(pinnerBackends))
+		for n := range pinnerBackends {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return "", nil, fmt.Errorf("unknown %s %q, available: %s", PinnerBackendConfigKey, name, strings.Join(names, ", "))
+	}
+	return name, backend, nil
+}
+
+func levelDBPinner(ctx context.Context, r repo.Repo, rootDS repo.Datastore, dserv format.DAGService) (pin.Pinner, func() error, error) {
+	pr, ok := r.(interface{ Path() string })
+	if !ok {
+		return nil, nil, errors.New("the leveldb pinner backend requires an on-disk repo")
+	}
+	ds, err := levelds.NewDatastore(filepath.Join(pr.Path(), pinsDir), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p, err := dspinner.New(ctx, ds, dserv)
+	if err != nil {
+		ds.Close()
+		return nil, nil, err
+	}
+	return p, ds.Close, nil
+}
+
+// syncPinnerBackend makes the pins of p, the pinner of the backend name, the
+// same as the pins of the backend that was active before, if it changed. Repos
+// without a recorded backend used DefaultPinnerBackend. The pins of the
+// previous backend are left in place, so that switching back keeps working.
+func syncPinnerBackend(ctx context.Context, r repo.Repo, rootDS repo.Datastore, dserv format.DAGService, name string, p pin.Pinner) error {
+	last := DefaultPinnerBackend
+	val, err := rootDS.Get(ctx, pinsBackendKey)
+	switch {
+	case err == nil:
+		last = string(val)
+		if last == name {
+			return nil
+		}
+	case !errors.Is(err, datastore.ErrNotFound):
+		return err
+	}
+
+	if last != name {
+		backend, ok := pinnerBackends[last]
+		if !ok {
+			return fmt.Errorf("cannot carry over the pins of the previous pinner backend %q: not available", last)
+		}
+		from, closer, err := backend(ctx, r, rootDS, dserv)
+		if err != nil {
+			return fmt.Errorf("pinner backend %s: %w", last, err)
+		}
+		err = copyPins(ctx, from, p)
+		if closer != nil {
+			if cerr := closer(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			return err
+		}
+		logger.Infof("carried over the pins of pinner backend %s to %s", last, name)
+	}
+
+	if err := rootDS.Put(ctx, pinsBackendKey, []byte(name)); err != nil {
+		return err
+	}
+	return rootDS.Sync(ctx, pinsBackendKey)
+}
+
+// copyPins makes the recursive and direct pins of to those of from.
+func copyPins(ctx context.Context, from, to pin.Pinner) error {
+	for _, mode := range []pin.Mode{pin.Recursive, pin.Direct} {
+		keys := from.RecursiveKeys
+		toKeys := to.RecursiveKeys
+		if mode == pin.Direct {
+			keys, toKeys = from.DirectKeys, to.DirectKeys
+		}
+		want, err := keys(ctx)
+		if err != nil {
+			return err
+		}
+		have, err := toKeys(ctx)
+		if err != nil {
+			return err
+		}
+
+		wanted := cid.NewSet()
+		for _, c := range want {
+			wanted.Add(c)
+		}
+		had := cid.NewSet()
+		for _, c := range have {
+			had.Add(c)
+			if !wanted.Has(c) {
+				to.RemovePinWithMode(c, mode)
+			}
+		}
+		for _, c := range want {
+			if !had.Has(c) {
+				to.PinWithMode(c, mode)
+			}
+		}
+	}
+	return to.Flush(ctx)
+}
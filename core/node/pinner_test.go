@@ -0,0 +1,142 @@
+package node
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/bittorrent/go-btfs/repo"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dssync "github.com/ipfs/go-datastore/sync"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	"github.com/ipfs/go-ipfs-pinner/dspinner"
+	format "github.com/ipfs/go-ipld-format"
+	mdtest "github.com/ipfs/go-merkledag/test"
+)
+
+func TestPinnerBackendSelection(t *testing.T) {
+	config := func(val interface{}) func(string) (interface{}, error) {
+		return func(key string) (interface{}, error) {
+			if key != PinnerBackendConfigKey {
+				t.Fatalf("unexpected config key %s", key)
+			}
+			return val, nil
+		}
+	}
+
+	for _, val := range []interface{}{nil, ""} {
+		name, backend, err := pinnerBackend(config(val))
+		if err != nil || name != DefaultPinnerBackend || backend == nil {
+			t.Errorf("%#v: expected the default backend, got %q, %v", val, name, err)
+		}
+	}
+	name, backend, err := pinnerBackend(config(LevelDBPinnerBackend))
+	if err != nil || name != LevelDBPinnerBackend || backend == nil {
+		t.Errorf("expected the leveldb backend, got %q, %v", name, err)
+	}
+	if _, _, err := pinnerBackend(config("nosuch")); err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Errorf("expected an unknown backend error, got %v", err)
+	}
+	if _, _, err := pinnerBackend(config(1)); err == nil {
+		t.Error("expected an error for a non string backend")
+	}
+	if err := RegisterPinnerBackend(DefaultPinnerBackend, pinnerBackends[DefaultPinnerBackend]); err == nil {
+		t.Error("expected an error registering a backend twice")
+	}
+}
+
+func TestSyncPinnerBackend(t *testing.T) {
+	ctx := context.Background()
+	rootDS := dssync.MutexWrap(datastore.NewMapDatastore())
+	dserv := mdtest.Mock()
+
+	// "other" keeps its pins apart from the default backend
+	const other = "test-other"
+	pinnerBackends[other] = func(ctx context.Context, r repo.Repo, rootDS repo.Datastore, dserv format.DAGService) (pin.Pinner, func() error, error) {
+		p, err := dspinner.New(ctx, namespace.Wrap(rootDS, datastore.NewKey(other)), dserv)
+		return p, nil, err
+	}
+	defer delete(pinnerBackends, other)
+
+	open := func(name string) pin.Pinner {
+		p, _, err := pinnerBackends[name](ctx, nil, rootDS, dserv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := syncPinnerBackend(ctx, nil, rootDS, dserv, name, p); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	expectPins := func(p pin.Pinner, recursive, direct []cid.Cid) {
+		t.Helper()
+		for _, c := range []struct {
+			keys     func(context.Context) ([]cid.Cid, error)
+			expected []cid.Cid
+		}{{p.RecursiveKeys, recursive}, {p.DirectKeys, direct}} {
+			keys, err := c.keys(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := cid.NewSet()
+			for _, k := range keys {
+				got.Add(k)
+			}
+			if got.Len() != len(c.expected) {
+				t.Fatalf("expected pins %v, got %v", c.expected, keys)
+			}
+			for _, k := range c.expected {
+				if !got.Has(k) {
+					t.Fatalf("expected pins %v, got %v", c.expected, keys)
+				}
+			}
+		}
+	}
+	c1 := blocks.NewBlock([]byte("one")).Cid()
+	c2 := blocks.NewBlock([]byte("two")).Cid()
+	c3 := blocks.NewBlock([]byte("three")).Cid()
+
+	// pins of a repo without a recorded backend are carried over
+	p := open(DefaultPinnerBackend)
+	p.PinWithMode(c1, pin.Recursive)
+	p.PinWithMode(c2, pin.Direct)
+	if err := p.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	p = open(other)
+	expectPins(p, []cid.Cid{c1}, []cid.Cid{c2})
+
+	// and so are the changes made in the other backend when switching back
+	if err := p.Unpin(ctx, c1, true); err != nil {
+		t.Fatal(err)
+	}
+	p.PinWithMode(c3, pin.Recursive)
+	if err := p.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	p = open(DefaultPinnerBackend)
+	expectPins(p, []cid.Cid{c3}, []cid.Cid{c2})
+
+	// and forth again
+	if err := p.Unpin(ctx, c2, false); err != nil {
+		t.Fatal(err)
+	}
+	p = open(other)
+	expectPins(p, []cid.Cid{c3}, nil)
+
+	// the pins of a backend that is gone can't be carried over
+	if err := rootDS.Put(ctx, pinsBackendKey, []byte("test-gone")); err != nil {
+		t.Fatal(err)
+	}
+	p, _, err := pinnerBackends[other](ctx, nil, rootDS, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := syncPinnerBackend(ctx, nil, rootDS, dserv, other, p); err == nil {
+		t.Fatal("expected an error switching from an unavailable backend")
+	}
+}
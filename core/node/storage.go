@@ -1,6 +1,8 @@
 package node
 
 import (
+	"context"
+
 	"github.com/bittorrent/go-btfs/core/node/helpers"
 	"github.com/bittorrent/go-btfs/repo"
 	"github.com/bittorrent/go-btfs/thirdparty/cidv0v1"
@@ -26,14 +28,47 @@ func Datastore(repo repo.Repo) datastore.Datastore {
 // BaseBlocks is the lower level blockstore without GC or Filestore layers
 type BaseBlocks blockstore.Blockstore
 
-// BaseBlockstoreCtor creates cached blockstore backed by the provided datastore
+// BaseBlockstoreCtor creates cached blockstore backed by the provided datastore.
+// Blocks are compressed at rest when BlockCompressionConfigKey is set. Repos
+// which never had compressed blocks store them as is, without the overhead
+// of the compression layer. When BlockCacheSizeConfigKey is set, recently read
+// blocks are kept in memory. Writes are held to StorageQuotaConfigKey.
 func BaseBlockstoreCtor(cacheOpts blockstore.CacheOpts, nilRepo bool, hashOnRead bool) func(mctx helpers.MetricsCtx, repo repo.Repo, lc fx.Lifecycle) (bs BaseBlocks, err error) {
 	return func(mctx helpers.MetricsCtx, repo repo.Repo, lc fx.Lifecycle) (bs BaseBlocks, err error) {
+		compress, err := blockCompression(repo.GetConfigKey)
+		if err != nil {
+			return nil, err
+		}
+		cacheSize, err := blockCacheSize(repo.GetConfigKey)
+		if err != nil {
+			return nil, err
+		}
+		d := repo.Datastore()
+		var blocksDs datastore.Batching = blockstoreDatastore(d)
+		// blocks compressed by an earlier run are read whether or not
+		// compression is still enabled
+		used, err := blocksCompressed(mctx, d)
+		if err != nil {
+			return nil, err
+		}
+		if compress || used {
+			blocksDs, err = newBlockCompressor(blocksDs, blockCompressionIndex(d), compress)
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		// hash security
-		bs = blockstore.NewBlockstore(repo.Datastore())
+		bs = blockstore.NewBlockstoreNoPrefix(blocksDs)
 		bs = &verifbs.VerifBS{Blockstore: bs}
 
 		if !nilRepo {
+			bs, err = NewQuotaBlockstore(bs, func() (uint64, error) {
+				return StorageQuota(repo.GetConfigKey)
+			}, repo.GetStorageUsage)
+			if err != nil {
+				return nil, err
+			}
 			bs, err = blockstore.CachedBlockstore(helpers.LifecycleCtx(mctx, lc), bs, cacheOpts)
 			if err != nil {
 				return nil, err
@@ -47,6 +82,18 @@ func BaseBlockstoreCtor(cacheOpts blockstore.CacheOpts, nilRepo bool, hashOnRead
 			bs.HashOnRead(true)
 		}
 
+		// below the GC and filestore layers, which delete through it
+		if cacheSize > 0 {
+			cache := newBlockCache(bs, cacheSize)
+			lc.Append(fx.Hook{
+				OnStop: func(ctx context.Context) error {
+					cache.purge()
+					return nil
+				},
+			})
+			bs = cache
+		}
+
 		return
 	}
 }
@@ -10,9 +10,12 @@ import (
 )
 
 type onceResult struct {
-	value path.Path
-	ttl   time.Duration
-	err   error
+	value    path.Path
+	ttl      time.Duration
+	err      error
+	source   Source
+	records  int
+	sequence uint64
 }
 
 type resolver interface {
@@ -48,6 +51,8 @@ func resolveAsync(ctx context.Context, r resolver, name string, options opts.Res
 		defer close(outCh)
 		var subCh <-chan Result
 		var cancelSub context.CancelFunc
+		// DHT records received for the name which led to subCh
+		var parentRecords int
 		defer func() {
 			if cancelSub != nil {
 				cancelSub()
@@ -68,12 +73,13 @@ func resolveAsync(ctx context.Context, r resolver, name string, options opts.Res
 				}
 				log.Debugf("resolved %s to %s", name, res.value.String())
 				if !strings.HasPrefix(res.value.String(), ipnsPrefix) {
-					emitResult(ctx, outCh, Result{Path: res.value})
+					emitResult(ctx, outCh, Result{Path: res.value, Source: res.source, Records: res.records, Sequence: res.sequence})
 					break
 				}
 
 				if depth == 1 {
-					emitResult(ctx, outCh, Result{Path: res.value, Err: ErrResolveRecursion})
+					emitResult(ctx, outCh, Result{Path: res.value, Err: ErrResolveRecursion, Source: res.source, Records: res.records,
+						Sequence: res.sequence})
 					break
 				}
 
@@ -90,6 +96,7 @@ func resolveAsync(ctx context.Context, r resolver, name string, options opts.Res
 				subCtx, cancelSub = context.WithCancel(ctx)
 				_ = cancelSub
 
+				parentRecords = res.records
 				p := strings.TrimPrefix(res.value.String(), ipnsPrefix)
 				subCh = resolveAsync(subCtx, r, p, subopts)
 			case res, ok := <-subCh:
@@ -100,6 +107,7 @@ func resolveAsync(ctx context.Context, r resolver, name string, options opts.Res
 
 				// We don't bother returning here in case of context timeout as there is
 				// no good reason to do that, and we may still be able to emit a result
+				res.Records += parentRecords
 				emitResult(ctx, outCh, res)
 			case <-ctx.Done():
 				return
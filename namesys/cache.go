@@ -1,12 +1,30 @@
 package namesys
 
 import (
+	"context"
 	"time"
 
 	path "github.com/ipfs/go-path"
 )
 
-func (ns *mpns) cacheGet(name string) (path.Path, bool) {
+type cacheTTLKey struct{}
+
+// ContextWithCacheTTL caps how long a cached result may be reused by the
+// resolutions done with the returned context: older cache entries are
+// ignored, and results are cached for at most ttl, whatever the TTL of their
+// record.
+func ContextWithCacheTTL(ctx context.Context, ttl time.Duration) context.Context {
+	return context.WithValue(ctx, cacheTTLKey{}, ttl)
+}
+
+func cacheTTLFromContext(ctx context.Context) time.Duration {
+	ttl, _ := ctx.Value(cacheTTLKey{}).(time.Duration)
+	return ttl
+}
+
+// cacheGet returns the cached value of name, unless it is older than maxAge
+// when maxAge is set.
+func (ns *mpns) cacheGet(name string, maxAge time.Duration) (path.Path, bool) {
 	// existence of optional mapping defined via IPFS_NS_MAP is checked first
 	if ns.staticMap != nil {
 		val, ok := ns.staticMap[name]
@@ -31,6 +49,10 @@ func (ns *mpns) cacheGet(name string) (path.Path, bool) {
 	}
 
 	if time.Now().Before(entry.eol) {
+		if maxAge > 0 && time.Since(entry.added) > maxAge {
+			// still valid for other resolutions
+			return "", false
+		}
 		return entry.val, true
 	}
 
@@ -40,23 +62,65 @@ func (ns *mpns) cacheGet(name string) (path.Path, bool) {
 }
 
 func (ns *mpns) cacheSet(name string, val path.Path, ttl time.Duration) {
+	if ns.negativeCache != nil {
+		ns.negativeCache.Remove(name)
+	}
 	if ns.cache == nil || ttl <= 0 {
 		return
 	}
+	now := time.Now()
 	ns.cache.Add(name, cacheEntry{
-		val: val,
-		eol: time.Now().Add(ttl),
+		val:   val,
+		eol:   now.Add(ttl),
+		added: now,
 	})
 }
 
 func (ns *mpns) cacheInvalidate(name string) {
-	if ns.cache == nil {
+	if ns.cache != nil {
+		ns.cache.Remove(name)
+	}
+	if ns.negativeCache != nil {
+		ns.negativeCache.Remove(name)
+	}
+}
+
+// negativeCacheGet reports whether a recent lookup of name failed and that
+// failure hasn't expired yet.
+func (ns *mpns) negativeCacheGet(name string) bool {
+	if ns.negativeCache == nil {
+		return false
+	}
+
+	ientry, ok := ns.negativeCache.Get(name)
+	if !ok {
+		return false
+	}
+
+	eol, ok := ientry.(time.Time)
+	if !ok {
+		// should never happen, purely for sanity
+		log.Panicf("unexpected type %T in negative cache for %q.", ientry, name)
+	}
+
+	if time.Now().Before(eol) {
+		return true
+	}
+
+	ns.negativeCache.Remove(name)
+
+	return false
+}
+
+func (ns *mpns) negativeCacheSet(name string) {
+	if ns.negativeCache == nil || ns.negativeCacheTTL <= 0 {
 		return
 	}
-	ns.cache.Remove(name)
+	ns.negativeCache.Add(name, time.Now().Add(ns.negativeCacheTTL))
 }
 
 type cacheEntry struct {
-	val path.Path
-	eol time.Time
+	val   path.Path
+	eol   time.Time
+	added time.Time
 }
@@ -172,3 +172,17 @@ func TestDNSResolution(t *testing.T) {
 	//testResolution(t, r, "www.wealdtech.eth", 2, "/btfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
 	//testResolution(t, r, "www.wealdtech.eth.link", 2, "/btfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
 }
+
+func TestDNSResolutionSource(t *testing.T) {
+	mock := newMockDNS()
+	r := &DNSResolver{lookupTXT: mock.lookupTXT}
+	var last Result
+	for last = range r.ResolveAsync(context.Background(), "dns2.example.com") {
+	}
+	if last.Err != nil {
+		t.Fatal(last.Err)
+	}
+	if last.Source != SourceDNSLink || last.Records != 0 {
+		t.Fatalf("expected a dnslink result without DHT records, got %s with %d", last.Source, last.Records)
+	}
+}
@@ -62,10 +62,27 @@ type NameSystem interface {
 	Publisher
 }
 
+// Source is the resolver which produced a Result.
+type Source string
+
+const (
+	SourceCache    Source = "cache"
+	SourceDHT      Source = "dht"
+	SourceDNSLink  Source = "dnslink"
+	SourceProquint Source = "proquint"
+)
+
 // Result is the return type for Resolver.ResolveAsync.
 type Result struct {
 	Path path.Path
 	Err  error
+	// Source is the resolver of the last name resolved to get Path, and
+	// Records the number of DHT records received along the way.
+	Source  Source
+	Records int
+	// Sequence is the sequence number of the IPNS record of the last name,
+	// only set when Source is SourceDHT.
+	Sequence uint64
 }
 
 // Resolver is an object capable of resolving names.
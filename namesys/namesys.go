@@ -33,9 +33,14 @@ type mpns struct {
 
 	dnsResolver, proquintResolver, ipnsResolver resolver
 	ipnsPublisher                               Publisher
+	// localResolver resolves BTNS names offline.
+	localResolver resolver
 
 	staticMap map[string]path.Path
 	cache     *lru.Cache
+
+	negativeCache    *lru.Cache
+	negativeCacheTTL time.Duration
 }
 type Option func(*mpns) error
 
@@ -51,7 +56,27 @@ func WithCache(size int) Option {
 			return err
 		}
 
+		negativeCache, err := lru.New(size)
+		if err != nil {
+			return err
+		}
+
 		ns.cache = cache
+		ns.negativeCache = negativeCache
+		return nil
+	}
+}
+
+// WithNegativeCacheTTL is an option that sets how long a failed resolution is
+// remembered before the name is looked up again. It only takes effect when a
+// cache is configured with WithCache. A ttl of zero disables negative caching.
+func WithNegativeCacheTTL(ttl time.Duration) Option {
+	return func(ns *mpns) error {
+		if ttl < 0 {
+			return fmt.Errorf("invalid negative cache ttl %s; must be >= 0", ttl)
+		}
+
+		ns.negativeCacheTTL = ttl
 		return nil
 	}
 }
@@ -91,7 +116,8 @@ func NewNameSystem(r routing.ValueStore, opts ...Option) (NameSystem, error) {
 		}
 	}
 	ns := &mpns{
-		staticMap: staticMap,
+		staticMap:        staticMap,
+		negativeCacheTTL: DefaultResolverNegativeCacheTTL,
 	}
 
 	for _, opt := range opts {
@@ -110,6 +136,7 @@ func NewNameSystem(r routing.ValueStore, opts ...Option) (NameSystem, error) {
 	}
 
 	ns.ipnsResolver = NewIpnsResolver(r)
+	ns.localResolver = NewIpnsResolver(localRecords{ds: ns.ds})
 	ns.ipnsPublisher = NewIpnsPublisher(r, ns.ds)
 	ns.proquintResolver = new(ProquintResolver)
 	return ns, nil
@@ -126,6 +153,11 @@ func NewNameSystem(r routing.ValueStore, opts ...Option) (NameSystem, error) {
 // DefaultResolverCacheTTL defines max ttl of a record placed in namesys cache.
 const DefaultResolverCacheTTL = time.Minute
 
+// DefaultResolverNegativeCacheTTL defines how long a failed resolution is kept
+// in the namesys negative cache. It is deliberately much shorter than
+// DefaultResolverCacheTTL so newly published names become visible quickly.
+const DefaultResolverNegativeCacheTTL = 5 * time.Second
+
 // Resolve implements Resolver.
 func (ns *mpns) Resolve(ctx context.Context, name string, options ...opts.ResolveOpt) (path.Path, error) {
 	if strings.HasPrefix(name, "/btfs/") {
@@ -143,7 +175,7 @@ func (ns *mpns) ResolveAsync(ctx context.Context, name string, options ...opts.R
 	if strings.HasPrefix(name, "/btfs/") {
 		p, err := path.ParsePath(name)
 		res := make(chan Result, 1)
-		res <- Result{p, err}
+		res <- Result{Path: p, Err: err}
 		close(res)
 		return res
 	}
@@ -151,7 +183,7 @@ func (ns *mpns) ResolveAsync(ctx context.Context, name string, options ...opts.R
 	if !strings.HasPrefix(name, "/") {
 		p, err := path.ParsePath("/btfs/" + name)
 		res := make(chan Result, 1)
-		res <- Result{p, err}
+		res <- Result{Path: p, Err: err}
 		close(res)
 		return res
 	}
@@ -208,20 +240,36 @@ func (ns *mpns) resolveOnceAsync(ctx context.Context, name string, options opts.
 		cacheKey = string(ipnsKey)
 	}
 
-	if p, ok := ns.cacheGet(cacheKey); ok {
+	maxCacheTTL := cacheTTLFromContext(ctx)
+	if p, ok := ns.cacheGet(cacheKey, maxCacheTTL); ok {
 		var err error
 		if len(segments) > 3 {
 			p, err = path.FromSegments("", strings.TrimRight(p.String(), "/"), segments[3])
 		}
 
-		out <- onceResult{value: p, err: err}
+		out <- onceResult{value: p, err: err, source: SourceCache}
 		close(out)
 		return out
 	}
 
-	if err == nil {
+	offline := offlineFromContext(ctx)
+	if !offline && ns.negativeCacheGet(cacheKey) {
+		log.Debugf("negative cache hit for %s", name)
+		out <- onceResult{err: ErrResolveFailed}
+		close(out)
+		return out
+	}
+
+	if offline && err == nil {
+		res = ns.localResolver
+	} else if err == nil {
 		res = ns.ipnsResolver
 	} else if isd.IsDomain(key) {
+		if offline {
+			out <- onceResult{err: fmt.Errorf("%w: resolving the domain name %s needs the network", ErrNotFoundOffline, key)}
+			close(out)
+			return out
+		}
 		res = ns.dnsResolver
 	} else {
 		res = ns.proquintResolver
@@ -229,6 +277,7 @@ func (ns *mpns) resolveOnceAsync(ctx context.Context, name string, options opts.
 
 	resCh := res.resolveOnceAsync(ctx, key, options)
 	var best onceResult
+	var failed bool
 	go func() {
 		defer close(out)
 		for {
@@ -236,12 +285,23 @@ func (ns *mpns) resolveOnceAsync(ctx context.Context, name string, options opts.
 			case res, ok := <-resCh:
 				if !ok {
 					if best != (onceResult{}) {
-						ns.cacheSet(cacheKey, best.value, best.ttl)
+						ttl := best.ttl
+						if maxCacheTTL > 0 && maxCacheTTL < ttl {
+							ttl = maxCacheTTL
+						}
+						ns.cacheSet(cacheKey, best.value, ttl)
+					} else if failed && ctx.Err() == nil && !offline {
+						// Only remember genuine lookup failures, not
+						// lookups cut short by the caller nor those which
+						// didn't look up the network.
+						ns.negativeCacheSet(cacheKey)
 					}
 					return
 				}
 				if res.err == nil {
 					best = res
+				} else {
+					failed = true
 				}
 				p := res.value
 				err := res.err
@@ -252,7 +312,8 @@ func (ns *mpns) resolveOnceAsync(ctx context.Context, name string, options opts.
 					p, err = path.FromSegments("", strings.TrimRight(p.String(), "/"), segments[3])
 				}
 
-				emitOnceResult(ctx, out, onceResult{value: p, ttl: ttl, err: err})
+				emitOnceResult(ctx, out, onceResult{value: p, ttl: ttl, err: err, source: res.source, records: res.records,
+					sequence: res.sequence})
 			case <-ctx.Done():
 				return
 			}
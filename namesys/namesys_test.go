@@ -2,6 +2,7 @@ package namesys
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
@@ -9,6 +10,7 @@ import (
 	btns "github.com/bittorrent/go-btns"
 	unixfs "github.com/bittorrent/go-unixfs"
 	opts "github.com/bittorrent/interface-go-btfs-core/options/namesys"
+	lru "github.com/hashicorp/golang-lru"
 	ds "github.com/ipfs/go-datastore"
 	dssync "github.com/ipfs/go-datastore/sync"
 	offroute "github.com/ipfs/go-ipfs-routing/offline"
@@ -16,6 +18,7 @@ import (
 	record "github.com/libp2p/go-libp2p-record"
 	ci "github.com/libp2p/go-libp2p/core/crypto"
 	peer "github.com/libp2p/go-libp2p/core/peer"
+	routing "github.com/libp2p/go-libp2p/core/routing"
 	pstoremem "github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
 )
 
@@ -180,3 +183,168 @@ func TestPublishWithTTL(t *testing.T) {
 		t.Fatalf("bad cache ttl: expected %s, got %s", eol, entry.eol)
 	}
 }
+
+type countingResolver struct {
+	calls int
+}
+
+func (r *countingResolver) resolveOnceAsync(ctx context.Context, name string, options opts.ResolveOpts) <-chan onceResult {
+	r.calls++
+	out := make(chan onceResult, 1)
+	out <- onceResult{err: ErrResolveFailed}
+	close(out)
+	return out
+}
+
+func TestNegativeCache(t *testing.T) {
+	negativeCache, err := lru.New(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dnsResolver := &countingResolver{}
+	r := &mpns{
+		dnsResolver:      dnsResolver,
+		negativeCache:    negativeCache,
+		negativeCacheTTL: time.Minute,
+	}
+
+	for i := 0; i < 3; i++ {
+		testResolution(t, r, "/btns/missing.example.com", opts.DefaultDepthLimit, "", ErrResolveFailed)
+	}
+	if dnsResolver.calls != 1 {
+		t.Fatalf("expected 1 lookup with negative cache, got %d", dnsResolver.calls)
+	}
+
+	r.cacheInvalidate("missing.example.com")
+	testResolution(t, r, "/btns/missing.example.com", opts.DefaultDepthLimit, "", ErrResolveFailed)
+	if dnsResolver.calls != 2 {
+		t.Fatalf("expected invalidation to force a new lookup, got %d lookups", dnsResolver.calls)
+	}
+}
+
+type ttlResolver struct {
+	calls int
+}
+
+func (r *ttlResolver) resolveOnceAsync(ctx context.Context, name string, options opts.ResolveOpts) <-chan onceResult {
+	r.calls++
+	out := make(chan onceResult, 1)
+	out <- onceResult{value: path.FromString("/btfs/QmP3ouCnU8NNLsW6261pAx2pNLV2E4dQoisB1sgda12Act"), ttl: time.Hour}
+	close(out)
+	return out
+}
+
+func TestContextWithCacheTTL(t *testing.T) {
+	cache, err := lru.New(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dnsResolver := &ttlResolver{}
+	r := &mpns{
+		dnsResolver: dnsResolver,
+		cache:       cache,
+	}
+	now := time.Now()
+	r.cache.Add("cached.example.com", cacheEntry{
+		val:   path.FromString("/btfs/QmP3ouCnU8NNLsW6261pAx2pNLV2E4dQoisB1sgda12Act"),
+		eol:   now.Add(time.Hour),
+		added: now.Add(-10 * time.Minute),
+	})
+
+	if _, err := r.Resolve(context.Background(), "/btns/cached.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if dnsResolver.calls != 0 {
+		t.Fatalf("expected the cached entry to be used, got %d lookups", dnsResolver.calls)
+	}
+
+	ctx := ContextWithCacheTTL(context.Background(), time.Minute)
+	if _, err := r.Resolve(ctx, "/btns/cached.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if dnsResolver.calls != 1 {
+		t.Fatalf("expected an entry older than the cache ttl to be looked up again, got %d lookups", dnsResolver.calls)
+	}
+	ientry, ok := r.cache.Get("cached.example.com")
+	if !ok {
+		t.Fatal("expected the result to be cached")
+	}
+	if eol := ientry.(cacheEntry).eol; eol.After(time.Now().Add(time.Minute)) {
+		t.Fatalf("expected the result to be cached for at most the cache ttl, expires at %s", eol)
+	}
+}
+
+// networkRouter fails the test when the network is used.
+type networkRouter struct {
+	routing.ValueStore
+	t *testing.T
+}
+
+func (r networkRouter) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	r.t.Errorf("unexpected lookup of %s", key)
+	return nil, routing.ErrNotFound
+}
+
+func TestResolveOffline(t *testing.T) {
+	ctx := context.Background()
+	dst := dssync.MutexWrap(ds.NewMapDatastore())
+	p, err := path.ParsePath(unixfs.EmptyDirNode().Cid().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey := func() (ci.PrivKey, peer.ID) {
+		priv, _, err := ci.GenerateKeyPair(ci.Ed25519, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pid, err := peer.IDFromPrivateKey(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return priv, pid
+	}
+	validator := record.NamespacedValidator{
+		"btns": btns.Validator{},
+		"pk":   record.PublicKeyValidator{},
+	}
+
+	// published by this node
+	ownPriv, ownID := newKey()
+	publisher, err := NewNameSystem(offroute.NewOfflineRouter(dssync.MutexWrap(ds.NewMapDatastore()), validator), WithDatastore(dst))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.Publish(ctx, ownPriv, p); err != nil {
+		t.Fatal(err)
+	}
+	// kept by the routing system
+	otherPriv, otherID := newKey()
+	router, err := NewNameSystem(offroute.NewOfflineRouter(dst, validator))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := router.Publish(ctx, otherPriv, p); err != nil {
+		t.Fatal(err)
+	}
+	_, unknownID := newKey()
+
+	nsys, err := NewNameSystem(networkRouter{t: t}, WithDatastore(dst))
+	if err != nil {
+		t.Fatal(err)
+	}
+	offline := ContextWithOffline(ctx)
+	for _, id := range []peer.ID{ownID, otherID} {
+		got, err := nsys.Resolve(offline, "/btns/"+id.String())
+		if err != nil {
+			t.Fatalf("resolving %s offline: %s", id, err)
+		}
+		if got != p {
+			t.Fatalf("expected %s, got %s", p, got)
+		}
+	}
+	for _, name := range []string{"/btns/" + unknownID.String(), "/btns/example.com"} {
+		if _, err := nsys.Resolve(offline, name); !errors.Is(err, ErrNotFoundOffline) {
+			t.Fatalf("expected ErrNotFoundOffline resolving %s, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package namesys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	ipns "github.com/bittorrent/go-btns"
+	pb "github.com/bittorrent/go-btns/pb"
+	proto "github.com/gogo/protobuf/proto"
+	ds "github.com/ipfs/go-datastore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	recpb "github.com/libp2p/go-libp2p-record/pb"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	routing "github.com/libp2p/go-libp2p/core/routing"
+)
+
+// ErrNotFoundOffline is returned by offline resolutions of names which are
+// neither cached nor have a record stored locally.
+var ErrNotFoundOffline = errors.New("not found offline")
+
+type offlineKey struct{}
+
+// ContextWithOffline makes the resolutions done with the returned context
+// never use the network. Names are resolved from the cache, and BTNS names
+// also from the records stored locally: the ones published by this node and
+// the ones kept by the routing system. Other names fail with
+// ErrNotFoundOffline.
+func ContextWithOffline(ctx context.Context) context.Context {
+	return context.WithValue(ctx, offlineKey{}, true)
+}
+
+func offlineFromContext(ctx context.Context) bool {
+	offline, _ := ctx.Value(offlineKey{}).(bool)
+	return offline
+}
+
+// localRecords is a read only value store over the BTNS records of a
+// datastore.
+type localRecords struct {
+	ds ds.Datastore
+}
+
+var _ routing.ValueStore = localRecords{}
+
+// GetValue returns the record published by this node for key, or else the
+// one kept by the routing system, unless it expired.
+func (r localRecords) GetValue(ctx context.Context, key string, _ ...routing.Option) ([]byte, error) {
+	pid, err := peer.IDFromBytes([]byte(strings.TrimPrefix(key, "/btns/")))
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := r.ds.Get(ctx, IpnsDsKey(pid))
+	if errors.Is(err, ds.ErrNotFound) {
+		val, err = r.stored(ctx, key)
+	}
+	if errors.Is(err, ds.ErrNotFound) {
+		return nil, fmt.Errorf("%w: no record of %s", ErrNotFoundOffline, pid)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entry := new(pb.IpnsEntry)
+	if err := proto.Unmarshal(val, entry); err != nil {
+		return nil, err
+	}
+	if eol, err := ipns.GetEOL(entry); err == nil && time.Now().After(eol) {
+		return nil, fmt.Errorf("%w: the record of %s expired at %s", ErrNotFoundOffline, pid, eol)
+	}
+	return val, nil
+}
+
+// stored returns the record of key kept by the routing system, which was
+// validated when it was stored.
+func (r localRecords) stored(ctx context.Context, key string) ([]byte, error) {
+	buf, err := r.ds.Get(ctx, dshelp.NewKeyFromBinary([]byte(key)))
+	if err != nil {
+		return nil, err
+	}
+	rec := new(recpb.Record)
+	if err := proto.Unmarshal(buf, rec); err != nil {
+		return nil, err
+	}
+	return rec.GetValue(), nil
+}
+
+func (r localRecords) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	val, err := r.GetValue(ctx, key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan []byte, 1)
+	out <- val
+	close(out)
+	return out, nil
+}
+
+func (r localRecords) PutValue(context.Context, string, []byte, ...routing.Option) error {
+	return routing.ErrNotSupported
+}
@@ -27,6 +27,6 @@ func (r *ProquintResolver) resolveOnceAsync(ctx context.Context, name string, op
 		return out
 	}
 	// Return a 0 TTL as caching this result is pointless.
-	out <- onceResult{value: path.FromString(string(proquint.Decode(name)))}
+	out <- onceResult{value: path.FromString(string(proquint.Decode(name))), source: SourceProquint}
 	return out
 }
@@ -86,12 +86,14 @@ func (r *IpnsResolver) resolveOnceAsync(ctx context.Context, name string, option
 	go func() {
 		defer cancel()
 		defer close(out)
+		records := 0
 		for {
 			select {
 			case val, ok := <-vals:
 				if !ok {
 					return
 				}
+				records++
 
 				entry := new(pb.IpnsEntry)
 				err = proto.Unmarshal(val, entry)
@@ -137,7 +139,8 @@ func (r *IpnsResolver) resolveOnceAsync(ctx context.Context, name string, option
 					return
 				}
 
-				emitOnceResult(ctx, out, onceResult{value: p, ttl: ttl})
+				emitOnceResult(ctx, out, onceResult{value: p, ttl: ttl, source: SourceDHT, records: records,
+					sequence: entry.GetSequence()})
 			case <-ctx.Done():
 				return
 			}
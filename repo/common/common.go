@@ -3,6 +3,7 @@ package common
 import (
 	"fmt"
 	"strings"
+	"time"
 )
 
 func MapGetKV(v map[string]interface{}, key string) (interface{}, error) {
@@ -54,3 +55,22 @@ func MapSetKV(v map[string]interface{}, key string, value interface{}) error {
 	}
 	return nil
 }
+
+// DurationConfigKey reads the duration string at key through getConfigKey,
+// usually repo.Repo.GetConfigKey. It returns def when key is unset, and an
+// error when it isn't a non-negative duration.
+func DurationConfigKey(getConfigKey func(string) (interface{}, error), key string, def time.Duration) (time.Duration, error) {
+	val, err := getConfigKey(key)
+	if err != nil || val == nil {
+		return def, nil // not set
+	}
+	s, ok := val.(string)
+	if !ok {
+		return 0, fmt.Errorf("invalid %s %v, must be a duration string", key, val)
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q, must be a non-negative duration", key, s)
+	}
+	return d, nil
+}
@@ -20,6 +20,10 @@ type Composer struct {
 	FindPeersRouter     routing.Routing
 	FindProvidersRouter routing.Routing
 	ProvideRouter       routing.Routing
+
+	// Named holds the routers created from the config by name, those of
+	// the methods and the ones they are composed of.
+	Named NamedRouters
 }
 
 func (c *Composer) Provide(ctx context.Context, cid cid.Cid, provide bool) error {
@@ -34,7 +34,7 @@ func Parse(routers config.Routers, methods config.Methods, extraDHT *ExtraDHTPar
 	}
 
 	createdRouters := make(map[string]routing.Routing)
-	finalRouter := &Composer{}
+	finalRouter := &Composer{Named: NamedRouters(createdRouters)}
 
 	// Create all needed routers from method names
 	for mn, m := range methods {
@@ -104,6 +104,13 @@ func TestParser(t *testing.T) {
 
 	require.Equal(comp.FindPeersRouter, comp.FindProvidersRouter)
 	require.Equal(comp.ProvideRouter, comp.PutValueRouter)
+
+	require.Equal([]string{"r1", "r2"}, comp.Named.Names())
+	r1, err := comp.Named.Get("r1")
+	require.NoError(err)
+	require.Equal(comp.GetValueRouter, r1)
+	_, err = comp.Named.Get("r3")
+	require.EqualError(err, `unknown router "r3", the routers are: r1, r2`)
 }
 
 func TestParserRecursive(t *testing.T) {
@@ -0,0 +1,34 @@
+package routing
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+// NamedRouters are the routers of a node by name, so that an operation can
+// target one of them rather than the composed routing of the node.
+type NamedRouters map[string]routing.Routing
+
+// Get returns the router called name, or an error listing the known ones.
+func (n NamedRouters) Get(name string) (routing.Routing, error) {
+	if r, ok := n[name]; ok {
+		return r, nil
+	}
+	if len(n) == 0 {
+		return nil, fmt.Errorf("unknown router %q, no router is configured", name)
+	}
+	return nil, fmt.Errorf("unknown router %q, the routers are: %s", name, strings.Join(n.Names(), ", "))
+}
+
+// Names returns the names of the routers, sorted.
+func (n NamedRouters) Names() []string {
+	names := make([]string, 0, len(n))
+	for name := range n {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
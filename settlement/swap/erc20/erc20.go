@@ -26,6 +26,7 @@ type Service interface {
 	Allowance(ctx context.Context, issuer common.Address, vault common.Address) (*big.Int, error)
 	Approve(ctx context.Context, address common.Address, value *big.Int) (common.Hash, error)
 	TransferFrom(ctx context.Context, issuer common.Address, vault common.Address, value *big.Int) (common.Hash, error)
+	Decimals(ctx context.Context) (uint8, error)
 }
 
 type erc20Service struct {
@@ -76,6 +77,36 @@ func (c *erc20Service) BalanceOf(ctx context.Context, address common.Address) (*
 	return balance, nil
 }
 
+func (c *erc20Service) Decimals(ctx context.Context) (uint8, error) {
+	callData, err := erc20ABI.Pack("decimals")
+	if err != nil {
+		return 0, err
+	}
+
+	output, err := c.transactionService.Call(ctx, &transaction.TxRequest{
+		To:   &c.address,
+		Data: callData,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	results, err := erc20ABI.Unpack("decimals", output)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(results) != 1 {
+		return 0, errDecodeABI
+	}
+
+	decimals, ok := abi.ConvertType(results[0], new(uint8)).(*uint8)
+	if !ok || decimals == nil {
+		return 0, errDecodeABI
+	}
+	return *decimals, nil
+}
+
 func (c *erc20Service) Deposit(ctx context.Context, value *big.Int) (trx common.Hash, err error) {
 	callData, err := erc20ABI.Pack("deposit")
 	if err != nil {
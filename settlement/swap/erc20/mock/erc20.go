@@ -18,6 +18,7 @@ type Service struct {
 	allowanceFunc    func(ctx context.Context, issuer common.Address, vault common.Address) (*big.Int, error)
 	approveFunc      func(ctx context.Context, address common.Address, value *big.Int) (common.Hash, error)
 	transferFromFunc func(ctx context.Context, issuer common.Address, vault common.Address, value *big.Int) (common.Hash, error)
+	decimalsFunc     func(ctx context.Context) (uint8, error)
 }
 
 func WithAddressFunc(f func(ctx context.Context) common.Address) Option {
@@ -52,6 +53,10 @@ func WithTransferFunc(f func(ctx context.Context, address common.Address, value
 	return optionFunc(func(s *Service) { s.transferFunc = f })
 }
 
+func WithDecimalsFunc(f func(ctx context.Context) (uint8, error)) Option {
+	return optionFunc(func(s *Service) { s.decimalsFunc = f })
+}
+
 func New(opts ...Option) erc20.Service {
 	mock := new(Service)
 	for _, o := range opts {
@@ -116,6 +121,13 @@ func (s *Service) Transfer(ctx context.Context, address common.Address, value *b
 	return common.Hash{}, errors.New("Error")
 }
 
+func (s *Service) Decimals(ctx context.Context) (uint8, error) {
+	if s.decimalsFunc != nil {
+		return s.decimalsFunc(ctx)
+	}
+	return 0, errors.New("Error")
+}
+
 // Option is the option passed to the mock Chequebook service
 type Option interface {
 	apply(*Service)
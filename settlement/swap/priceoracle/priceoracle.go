@@ -3,13 +3,19 @@ package priceoracle
 import (
 	"context"
 	"errors"
+	"math/big"
+	"sync"
+	"time"
+
 	conabi "github.com/bittorrent/go-btfs/chain/abi"
 	"github.com/bittorrent/go-btfs/transaction"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
-	"math/big"
 )
 
+// DefaultCacheTTL is how long prices and rates are cached by default.
+const DefaultCacheTTL = 30 * time.Second
+
 var (
 	errDecodeABI = errors.New("could not decode abi data")
 )
@@ -17,6 +23,16 @@ var (
 type service struct {
 	priceOracleAddress common.Address
 	transactionService transaction.Service
+	cacheTTL           time.Duration
+
+	mu     sync.Mutex
+	prices map[common.Address]cachedValue
+	rates  map[common.Address]cachedValue
+}
+
+type cachedValue struct {
+	value   *big.Int
+	expires time.Time
 }
 
 type Service interface {
@@ -27,6 +43,11 @@ type Service interface {
 
 	// CheckNewPrice retrieves latest available information from oracle
 	CheckNewPrice(token common.Address) (*big.Int, error)
+
+	// Refresh drops the cached price and rate of token, so that the next
+	// calls get them from the oracle. Price sensitive operations call it
+	// first.
+	Refresh(token common.Address)
 }
 
 var (
@@ -38,22 +59,27 @@ var (
 	//mpCurTotalPrice = make(map[common.Address]*big.Int)
 )
 
-func New(priceOracleAddress common.Address, transactionService transaction.Service) Service {
+// New returns a price oracle service which caches the prices and rates it
+// gets for cacheTTL. A cacheTTL of 0 disables the cache.
+func New(priceOracleAddress common.Address, transactionService transaction.Service, cacheTTL time.Duration) Service {
 	return &service{
 		priceOracleAddress: priceOracleAddress,
 		transactionService: transactionService,
+		cacheTTL:           cacheTTL,
+		prices:             make(map[common.Address]cachedValue),
+		rates:              make(map[common.Address]cachedValue),
 	}
 }
 
 func (s *service) CurrentPrice(token common.Address) (price *big.Int, err error) {
-	price, err = s.currentPrice(token)
+	price, err = s.cached(s.prices, token, s.currentPrice)
 	if err != nil {
 		return nil, err
 	}
 	return price, nil
 }
 func (s *service) CurrentRate(token common.Address) (rate *big.Int, err error) {
-	rate, err = s.currentRate(token)
+	rate, err = s.cached(s.rates, token, s.currentRate)
 	if err != nil {
 		return nil, err
 	}
@@ -61,12 +87,12 @@ func (s *service) CurrentRate(token common.Address) (rate *big.Int, err error) {
 	return rate, nil
 }
 func (s *service) CurrentTotalPrice(token common.Address) (totalPrice *big.Int, err error) {
-	price, err := s.currentPrice(token)
+	price, err := s.CurrentPrice(token)
 	if err != nil {
 		return nil, err
 	}
 
-	rate, err := s.currentRate(token)
+	rate, err := s.CurrentRate(token)
 	if err != nil {
 		return nil, err
 	}
@@ -76,13 +102,14 @@ func (s *service) CurrentTotalPrice(token common.Address) (totalPrice *big.Int,
 }
 
 func (s *service) CheckNewPrice(token common.Address) (*big.Int, error) {
-	price, err := s.currentPrice(token)
+	s.Refresh(token)
+	price, err := s.CurrentPrice(token)
 	if err != nil {
 		return nil, err
 	}
 	//fmt.Println("currentPrice ", price)
 
-	rate, err := s.currentRate(token)
+	rate, err := s.CurrentRate(token)
 	if err != nil {
 		return nil, err
 	}
@@ -99,6 +126,36 @@ func (s *service) CheckNewPrice(token common.Address) (*big.Int, error) {
 	return big.NewInt(0).Set(totalPrice), nil
 }
 
+func (s *service) Refresh(token common.Address) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.prices, token)
+	delete(s.rates, token)
+}
+
+// cached returns the value of token in cache, getting it with get when it
+// isn't cached or expired. The value returned is a copy callers may modify.
+func (s *service) cached(cache map[common.Address]cachedValue, token common.Address,
+	get func(common.Address) (*big.Int, error)) (*big.Int, error) {
+	s.mu.Lock()
+	v, ok := cache[token]
+	s.mu.Unlock()
+	if ok && time.Now().Before(v.expires) {
+		return new(big.Int).Set(v.value), nil
+	}
+
+	value, err := get(token)
+	if err != nil {
+		return nil, err
+	}
+	if s.cacheTTL > 0 {
+		s.mu.Lock()
+		cache[token] = cachedValue{value: value, expires: time.Now().Add(s.cacheTTL)}
+		s.mu.Unlock()
+	}
+	return new(big.Int).Set(value), nil
+}
+
 // call priceOracleABI
 func (s *service) currentRate(token common.Address) (*big.Int, error) {
 	callData, err := priceOracleABI.Pack("getRate", token)
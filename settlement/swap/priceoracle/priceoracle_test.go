@@ -0,0 +1,72 @@
+package priceoracle_test
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	conabi "github.com/bittorrent/go-btfs/chain/abi"
+	"github.com/bittorrent/go-btfs/settlement/swap/priceoracle"
+	"github.com/bittorrent/go-btfs/transaction"
+	transactionmock "github.com/bittorrent/go-btfs/transaction/mock"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var priceOracleABI = transaction.ParseABIUnchecked(conabi.MutiOracleAbi)
+
+func TestCurrentRateCache(t *testing.T) {
+	oracle := common.HexToAddress("0x01")
+	token := common.HexToAddress("0x02")
+	rate := big.NewInt(7)
+
+	calls := 0
+	service := priceoracle.New(oracle, transactionmock.New(
+		transactionmock.WithCallFunc(func(ctx context.Context, request *transaction.TxRequest) ([]byte, error) {
+			calls++
+			return priceOracleABI.Methods["getRate"].Outputs.Pack(rate)
+		}),
+	), priceoracle.DefaultCacheTTL)
+
+	for i := 0; i < 3; i++ {
+		got, err := service.CurrentRate(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Cmp(rate) != 0 {
+			t.Fatalf("expected rate %d, got %d", rate, got)
+		}
+		// callers may modify the value they get
+		got.SetInt64(0)
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 oracle call, got %d", calls)
+	}
+
+	service.Refresh(token)
+	if _, err := service.CurrentRate(token); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected the refresh to call the oracle again, got %d calls", calls)
+	}
+}
+
+func TestCurrentRateNoCache(t *testing.T) {
+	calls := 0
+	service := priceoracle.New(common.HexToAddress("0x01"), transactionmock.New(
+		transactionmock.WithCallFunc(func(ctx context.Context, request *transaction.TxRequest) ([]byte, error) {
+			calls++
+			return priceOracleABI.Methods["getRate"].Outputs.Pack(big.NewInt(7))
+		}),
+	), 0)
+
+	for i := 0; i < 2; i++ {
+		if _, err := service.CurrentRate(common.HexToAddress("0x02")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 oracle calls without cache, got %d", calls)
+	}
+}
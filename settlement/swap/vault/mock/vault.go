@@ -36,6 +36,7 @@ type Service struct {
 	bttBalanceFunc            func(context.Context) (*big.Int, error)
 	totalReceivedFunc         func(token common.Address) (*big.Int, error)
 	totalReceivedCountFunc    func(token common.Address) (int, error)
+	tokenDecimalsFunc         func(ctx context.Context, token common.Address) (uint8, error)
 }
 
 // WithVault*Functions set the mock vault functions
@@ -99,6 +100,12 @@ func WithTotalReceivedCountFunc(f func(token common.Address) (int, error)) Optio
 	})
 }
 
+func WithTokenDecimalsFunc(f func(ctx context.Context, token common.Address) (uint8, error)) Option {
+	return optionFunc(func(s *Service) {
+		s.tokenDecimalsFunc = f
+	})
+}
+
 func WithCheckBalanceFunc(f func(bal *big.Int) (err error)) Option {
 	return optionFunc(func(s *Service) {
 		s.checkBalanceFunc = f
@@ -190,6 +197,13 @@ func (s *Service) TokenBalanceOf(ctx context.Context, addr common.Address, token
 	return nil, errors.New("vaultMock.TokenBalanceOf not implemented")
 }
 
+func (s *Service) TokenDecimals(ctx context.Context, token common.Address) (uint8, error) {
+	if s.tokenDecimalsFunc != nil {
+		return s.tokenDecimalsFunc(ctx, token)
+	}
+	return 0, errors.New("vaultMock.TokenDecimals not implemented")
+}
+
 // Balance mocks the vault .Balance function
 func (s *Service) Balance(ctx context.Context) (bal *big.Int, err error) {
 	if s.vaultBalanceFunc != nil {
@@ -75,6 +75,8 @@ type Service interface {
 	WBTTBalanceOf(ctx context.Context, addr common.Address) (*big.Int, error)
 	// TokenBalanceOf retrieve the addr balance
 	TokenBalanceOf(ctx context.Context, addr common.Address, tokenStr string) (*big.Int, error)
+	// TokenDecimals retrieve the decimals of the token
+	TokenDecimals(ctx context.Context, token common.Address) (uint8, error)
 	// BTTBalanceOf retrieve the btt balance of addr
 	BTTBalanceOf(ctx context.Context, address common.Address, block *big.Int) (*big.Int, error)
 	// TotalPaidOut return total pay out of the vault
@@ -562,6 +564,18 @@ func (s *service) TokenBalanceOf(ctx context.Context, addr common.Address, token
 	return s.mpErc20Service[tokenStr].BalanceOf(ctx, addr)
 }
 
+func (s *service) TokenDecimals(ctx context.Context, token common.Address) (uint8, error) {
+	tokenStr, ok := tokencfg.MpTokenStr[token]
+	if !ok {
+		return 0, fmt.Errorf("unknown token %s", token.String())
+	}
+	svc, ok := s.mpErc20Service[tokenStr]
+	if !ok {
+		return 0, fmt.Errorf("no erc20 service for token %s", tokenStr)
+	}
+	return svc.Decimals(ctx)
+}
+
 func (s *service) BTTBalanceOf(ctx context.Context, address common.Address, block *big.Int) (*big.Int, error) {
 	return s.transactionService.BttBalanceAt(ctx, address, block)
 }
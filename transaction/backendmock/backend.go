@@ -19,6 +19,7 @@ type backendMock struct {
 	subscribeFilterLogs func(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error)
 	sendTransaction     func(ctx context.Context, tx *types.Transaction) error
 	suggestGasPrice     func(ctx context.Context) (*big.Int, error)
+	suggestGasTipCap    func(ctx context.Context) (*big.Int, error)
 	estimateGas         func(ctx context.Context, call ethereum.CallMsg) (gas uint64, err error)
 	transactionReceipt  func(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
 	pendingNonceAt      func(ctx context.Context, account common.Address) (uint64, error)
@@ -141,6 +142,9 @@ func (m *backendMock) NonceAt(ctx context.Context, account common.Address, block
 	return 0, errors.New("backendMock.nonceAt not implemented")
 }
 func (m *backendMock) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	if m.suggestGasTipCap != nil {
+		return m.suggestGasTipCap(ctx)
+	}
 	return nil, errors.New("backendMock.SuggestGasTipCap not implemented")
 }
 
@@ -203,6 +207,12 @@ func WithSuggestGasPriceFunc(f func(ctx context.Context) (*big.Int, error)) Opti
 	})
 }
 
+func WithSuggestGasTipCapFunc(f func(ctx context.Context) (*big.Int, error)) Option {
+	return optionFunc(func(s *backendMock) {
+		s.suggestGasTipCap = f
+	})
+}
+
 func WithEstimateGasFunc(f func(ctx context.Context, call ethereum.CallMsg) (gas uint64, err error)) Option {
 	return optionFunc(func(s *backendMock) {
 		s.estimateGas = f